@@ -8,6 +8,7 @@ require (
 	github.com/morebec/go-errors v0.0.0-20230221153630-83018990be24
 	github.com/stretchr/testify v1.9.0
 	github.com/zclconf/go-cty v1.15.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -22,5 +23,4 @@ require (
 	golang.org/x/sys v0.5.0 // indirect
 	golang.org/x/text v0.11.0 // indirect
 	golang.org/x/tools v0.6.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -14,6 +14,8 @@
 
 package specter
 
+import "os"
+
 type PipelineBuilder struct {
 	SourceLoaders      []SourceLoader
 	UnitLoaders        []UnitLoader
@@ -21,6 +23,11 @@ type PipelineBuilder struct {
 	UnitProcessors     []UnitProcessor
 	ArtifactProcessors []ArtifactProcessor
 	ArtifactRegistry   ArtifactRegistry
+	ArtifactOutputRoot string
+	Logger             Logger
+
+	UnsupportedSourceLocationPolicy StrictnessPolicy
+	UnhandledSourcePolicy           StrictnessPolicy
 
 	SourceLoadingStageHooks      SourceLoadingStageHooks
 	UnitLoadingStageHooks        UnitLoadingStageHooks
@@ -40,6 +47,11 @@ func (b PipelineBuilder) WithSourceLoaders(loaders ...SourceLoader) PipelineBuil
 	return b
 }
 
+// WithSourceLoaderRegistry configures a Pipeline instance to load sources through a SourceLoaderRegistry.
+func (b PipelineBuilder) WithSourceLoaderRegistry(r *SourceLoaderRegistry) PipelineBuilder {
+	return b.WithSourceLoaders(r)
+}
+
 // WithUnitLoaders configures the UnitLoader of a Pipeline instance.
 func (b PipelineBuilder) WithUnitLoaders(loaders ...UnitLoader) PipelineBuilder {
 	b.UnitLoaders = loaders
@@ -70,6 +82,33 @@ func (b PipelineBuilder) WithArtifactRegistry(r ArtifactRegistry) PipelineBuilde
 	return b
 }
 
+// WithArtifactOutputRoot restricts the file system of every FileArtifactProcessor of a Pipeline instance to a given
+// root directory using a RootedFileSystem, so that no file artifact can be written outside of it.
+func (b PipelineBuilder) WithArtifactOutputRoot(root string) PipelineBuilder {
+	b.ArtifactOutputRoot = root
+	return b
+}
+
+// WithLogger configures the Logger used by a Pipeline instance to report warnings.
+func (b PipelineBuilder) WithLogger(l Logger) PipelineBuilder {
+	b.Logger = l
+	return b
+}
+
+// WithUnsupportedSourceLocationPolicy configures how a Pipeline instance reports source locations that no
+// SourceLoader supports. Defaults to StrictnessError.
+func (b PipelineBuilder) WithUnsupportedSourceLocationPolicy(p StrictnessPolicy) PipelineBuilder {
+	b.UnsupportedSourceLocationPolicy = p
+	return b
+}
+
+// WithUnhandledSourcePolicy configures how a Pipeline instance reports sources that no UnitLoader supports.
+// Defaults to StrictnessError.
+func (b PipelineBuilder) WithUnhandledSourcePolicy(p StrictnessPolicy) PipelineBuilder {
+	b.UnhandledSourcePolicy = p
+	return b
+}
+
 func (b PipelineBuilder) WithSourceLoadingStageHooks(h SourceLoadingStageHooks) PipelineBuilder {
 	b.SourceLoadingStageHooks = h
 	return b
@@ -99,16 +138,20 @@ func (b PipelineBuilder) Build() Pipeline {
 	return DefaultPipeline{
 		TimeProvider: CurrentTimeProvider,
 		SourceLoadingStage: sourceLoadingStage{
-			SourceLoaders: b.SourceLoaders,
-			Hooks:         b.SourceLoadingStageHooks,
+			SourceLoaders:             b.SourceLoaders,
+			Hooks:                     b.SourceLoadingStageHooks,
+			UnsupportedLocationPolicy: b.UnsupportedSourceLocationPolicy,
+			Logger:                    b.Logger,
 		},
 		UnitPreprocessingStage: unitPreprocessingStage{
 			Preprocessors: b.UnitPreprocessors,
 			Hooks:         b.UnitPreprocessingStageHooks,
 		},
 		UnitLoadingStage: unitLoadingStage{
-			Loaders: b.UnitLoaders,
-			Hooks:   b.UnitLoadingStageHooks,
+			Loaders:               b.UnitLoaders,
+			Hooks:                 b.UnitLoadingStageHooks,
+			UnhandledSourcePolicy: b.UnhandledSourcePolicy,
+			Logger:                b.Logger,
 		},
 		UnitProcessingStage: unitProcessingStage{
 			Processors: b.UnitProcessors,
@@ -116,12 +159,38 @@ func (b PipelineBuilder) Build() Pipeline {
 		},
 		ArtifactProcessingStage: artifactProcessingStage{
 			Registry:   b.ArtifactRegistry,
-			Processors: b.ArtifactProcessors,
+			Processors: b.artifactProcessors(),
 			Hooks:      b.ArtifactProcessingStageHooks,
 		},
 	}
 }
 
+// artifactProcessors returns the configured ArtifactProcessor with their file system restricted to the
+// ArtifactOutputRoot if any.
+func (b PipelineBuilder) artifactProcessors() []ArtifactProcessor {
+	if b.ArtifactOutputRoot == "" {
+		return b.ArtifactProcessors
+	}
+
+	processors := make([]ArtifactProcessor, 0, len(b.ArtifactProcessors))
+	for _, p := range b.ArtifactProcessors {
+		// Processors are copied so that their other settings are kept and the configured ones are left untouched.
+		switch fp := p.(type) {
+		case FileArtifactProcessor:
+			cp := fp
+			cp.FileSystem = NewRootedFileSystem(fp.FileSystem, b.ArtifactOutputRoot)
+			p = cp
+		case *FileArtifactProcessor:
+			cp := *fp
+			cp.FileSystem = NewRootedFileSystem(fp.FileSystem, b.ArtifactOutputRoot)
+			p = &cp
+		}
+		processors = append(processors, p)
+	}
+
+	return processors
+}
+
 func (b PipelineBuilder) WithJSONArtifactRegistry(fileName string, fs FileSystem) PipelineBuilder {
 	return b.WithArtifactRegistry(NewJSONArtifactRegistry(fileName, fs))
 }
@@ -138,6 +207,30 @@ func NewLocalFileSourceLoader() *FileSystemSourceLoader {
 	return NewFileSystemSourceLoader(LocalFileSystem{})
 }
 
+// NewDefaultSourceLoaderRegistry returns a new SourceLoaderRegistry routing plain paths and "file://" URIs to a
+// FileSystemSourceLoader, "-" to a StdinSourceLoader and "inline:" and "data:" locations to an InlineSourceLoader.
+func NewDefaultSourceLoaderRegistry(fs FileSystem) *SourceLoaderRegistry {
+	fsLoader := NewFileSystemSourceLoader(fs)
+	inlineLoader := NewInlineSourceLoader("")
+
+	return NewSourceLoaderRegistry().
+		Register(NoSourceLocationScheme, 10, NewStdinSourceLoader("")).
+		Register(NoSourceLocationScheme, 0, fsLoader).
+		Register("file", 0, fsLoader).
+		Register("inline", 0, inlineLoader).
+		Register("data", 0, inlineLoader)
+}
+
+// NewStdinSourceLoader returns a new StdinSourceLoader reading sources of a given format from os.Stdin.
+func NewStdinSourceLoader(format SourceFormat) *StdinSourceLoader {
+	return &StdinSourceLoader{Reader: os.Stdin, Format: format}
+}
+
+// NewInlineSourceLoader returns a new InlineSourceLoader using a given format for sources that do not specify one.
+func NewInlineSourceLoader(defaultFormat SourceFormat) *InlineSourceLoader {
+	return &InlineSourceLoader{Format: defaultFormat}
+}
+
 // UNIT PROCESSING
 
 func NewUnitProcessorFunc(name string, processFunc func(ctx UnitProcessingContext) ([]Artifact, error)) UnitProcessor {
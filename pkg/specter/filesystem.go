@@ -68,6 +68,13 @@ type FileSystem interface {
 	Remove(path string) error
 }
 
+// FileRenamer can be implemented by FileSystem implementations supporting renaming files, so that files can be
+// replaced atomically by writing a temporary file first.
+type FileRenamer interface {
+	// Rename renames a file, replacing the file at the new path if it exists.
+	Rename(oldPath, newPath string) error
+}
+
 // LocalFileSystem is an implementation of a FileSystem that works on the local file system where this program is running.
 type LocalFileSystem struct{}
 
@@ -79,6 +86,10 @@ func (l LocalFileSystem) Remove(path string) error {
 	return os.Remove(path)
 }
 
+func (l LocalFileSystem) Rename(oldPath, newPath string) error {
+	return os.Rename(oldPath, newPath)
+}
+
 func (l LocalFileSystem) WriteFile(path string, data []byte, perm fs.FileMode) error {
 	return os.WriteFile(path, data, perm)
 }
@@ -102,3 +113,71 @@ func (l LocalFileSystem) StatPath(location string) (fs.FileInfo, error) {
 func (l LocalFileSystem) Abs(location string) (string, error) {
 	return filepath.Abs(location)
 }
+
+func (l LocalFileSystem) EvalSymlinks(path string) (string, error) {
+	return filepath.EvalSymlinks(path)
+}
+
+func (l LocalFileSystem) Lstat(location string) (fs.FileInfo, error) {
+	return os.Lstat(location)
+}
+
+// dirEntry is a fs.DirEntry along with the path at which it was found.
+type dirEntry struct {
+	path string
+	fs.DirEntry
+}
+
+// walkFileTree implements the semantics of filepath.WalkDir for file systems that can stat a path and list
+// the entries of a directory in lexical order.
+func walkFileTree(
+	root string,
+	stat func(location string) (fs.FileInfo, error),
+	readDir func(dirPath string) ([]dirEntry, error),
+	f func(path string, d fs.DirEntry, err error) error,
+) error {
+	var walk func(p string, d fs.DirEntry) error
+	walk = func(p string, d fs.DirEntry) error {
+		if err := f(p, d, nil); err != nil || !d.IsDir() {
+			if err == fs.SkipDir && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+
+		entries, err := readDir(p)
+		if err != nil {
+			// Give the walk function a second chance to handle the error on the directory.
+			if err = f(p, d, err); err != nil {
+				if err == fs.SkipDir {
+					err = nil
+				}
+				return err
+			}
+		}
+
+		for _, e := range entries {
+			if err := walk(e.path, e.DirEntry); err != nil {
+				if err == fs.SkipDir {
+					break
+				}
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	info, err := stat(root)
+	if err != nil {
+		err = f(root, nil, err)
+	} else {
+		err = walk(root, fs.FileInfoToDirEntry(info))
+	}
+
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+
+	return err
+}
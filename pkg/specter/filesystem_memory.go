@@ -0,0 +1,355 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter
+
+import (
+	"github.com/morebec/go-errors/errors"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errMemFSNotDir   = errors.New("not a directory")
+	errMemFSIsDir    = errors.New("is a directory")
+	errMemFSNotEmpty = errors.New("directory not empty")
+)
+
+// MemoryFileSystem is an implementation of a FileSystem that keeps all of its files and directories in memory.
+// It can be used for dry runs, tests or sandboxed generation where nothing should ever touch the disk.
+//
+// Paths are slash separated and rooted at "/". Relative paths are resolved against WorkingDir.
+// The zero value is an empty file system containing only the root directory, and is ready to use.
+// It is safe for concurrent use.
+type MemoryFileSystem struct {
+	// WorkingDir is the directory against which relative paths are resolved. Defaults to "/".
+	WorkingDir string
+
+	// TimeProvider is used to determine the modification time of files and directories.
+	// Defaults to CurrentTimeProvider.
+	TimeProvider TimeProvider
+
+	mu    sync.RWMutex
+	once  sync.Once
+	nodes map[string]*memFSNode
+}
+
+type memFSNode struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (m *MemoryFileSystem) Abs(location string) (string, error) {
+	return m.abs(location), nil
+}
+
+func (m *MemoryFileSystem) Rel(basePath, targetPath string) (string, error) {
+	base := path.Clean(basePath)
+	target := path.Clean(targetPath)
+	if base == target {
+		return ".", nil
+	}
+	if path.IsAbs(base) != path.IsAbs(target) {
+		return "", errors.New("Rel: can't make " + targetPath + " relative to " + basePath)
+	}
+
+	baseParts := splitMemFSPath(base)
+	targetParts := splitMemFSPath(target)
+
+	common := 0
+	for common < len(baseParts) && common < len(targetParts) && baseParts[common] == targetParts[common] {
+		common++
+	}
+
+	var rel []string
+	for i := common; i < len(baseParts); i++ {
+		if baseParts[i] == ".." {
+			return "", errors.New("Rel: can't make " + targetPath + " relative to " + basePath)
+		}
+		rel = append(rel, "..")
+	}
+	rel = append(rel, targetParts[common:]...)
+
+	return strings.Join(rel, "/"), nil
+}
+
+func (m *MemoryFileSystem) StatPath(location string) (fs.FileInfo, error) {
+	p := m.abs(location)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n, ok := m.node(p)
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: location, Err: fs.ErrNotExist}
+	}
+
+	return n.info(p), nil
+}
+
+// WalkDir walks the file tree rooted at dirPath in lexical order, following the semantics of filepath.WalkDir.
+// The walk function may safely modify the file system.
+func (m *MemoryFileSystem) WalkDir(dirPath string, f func(path string, d fs.DirEntry, err error) error) error {
+	return walkFileTree(dirPath, m.StatPath, m.readDir, f)
+}
+
+// readDir returns the entries of a directory sorted by name.
+func (m *MemoryFileSystem) readDir(dirPath string) ([]dirEntry, error) {
+	p := m.abs(dirPath)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n, ok := m.node(p)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: dirPath, Err: fs.ErrNotExist}
+	}
+	if !n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: dirPath, Err: errMemFSNotDir}
+	}
+
+	var entries []dirEntry
+	for childPath, child := range m.nodes {
+		if childPath != "/" && path.Dir(childPath) == p {
+			entries = append(entries, dirEntry{
+				path:     path.Join(dirPath, path.Base(childPath)),
+				DirEntry: fs.FileInfoToDirEntry(child.info(childPath)),
+			})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+func (m *MemoryFileSystem) ReadFile(filePath string) ([]byte, error) {
+	p := m.abs(filePath)
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	n, ok := m.node(p)
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: filePath, Err: fs.ErrNotExist}
+	}
+	if n.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: filePath, Err: errMemFSIsDir}
+	}
+
+	data := make([]byte, len(n.data))
+	copy(data, n.data)
+
+	return data, nil
+}
+
+// WriteFile writes data to a file, creating it with the given permissions if it does not exist.
+// Like os.WriteFile, the parent directory must exist and the permissions of an existing file are left untouched.
+func (m *MemoryFileSystem) WriteFile(filePath string, data []byte, perm fs.FileMode) error {
+	p := m.abs(filePath)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	parent, ok := m.node(path.Dir(p))
+	if !ok {
+		return &fs.PathError{Op: "open", Path: filePath, Err: fs.ErrNotExist}
+	}
+	if !parent.mode.IsDir() {
+		return &fs.PathError{Op: "open", Path: filePath, Err: errMemFSNotDir}
+	}
+
+	content := make([]byte, len(data))
+	copy(content, data)
+
+	if n, ok := m.node(p); ok {
+		if n.mode.IsDir() {
+			return &fs.PathError{Op: "open", Path: filePath, Err: errMemFSIsDir}
+		}
+		n.data = content
+		n.modTime = m.now()
+		return nil
+	}
+
+	m.nodes[p] = &memFSNode{
+		data:    content,
+		mode:    perm.Perm(),
+		modTime: m.now(),
+	}
+
+	return nil
+}
+
+// Mkdir creates a directory along with any necessary parents. Like os.MkdirAll, it does nothing if the
+// directory already exists.
+func (m *MemoryFileSystem) Mkdir(dirPath string, mode fs.FileMode) error {
+	p := m.abs(dirPath)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := "/"
+	for _, part := range splitMemFSPath(p) {
+		current = path.Join(current, part)
+		if n, ok := m.node(current); ok {
+			if !n.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: current, Err: errMemFSNotDir}
+			}
+			continue
+		}
+		m.nodes[current] = &memFSNode{
+			mode:    mode.Perm() | fs.ModeDir,
+			modTime: m.now(),
+		}
+	}
+
+	return nil
+}
+
+// Remove removes a file or an empty directory.
+func (m *MemoryFileSystem) Remove(filePath string) error {
+	p := m.abs(filePath)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := m.node(p)
+	if !ok {
+		return &fs.PathError{Op: "remove", Path: filePath, Err: fs.ErrNotExist}
+	}
+
+	if n.mode.IsDir() {
+		if p == "/" {
+			return &fs.PathError{Op: "remove", Path: filePath, Err: fs.ErrPermission}
+		}
+		for childPath := range m.nodes {
+			if childPath != "/" && path.Dir(childPath) == p {
+				return &fs.PathError{Op: "remove", Path: filePath, Err: errMemFSNotEmpty}
+			}
+		}
+	}
+
+	delete(m.nodes, p)
+
+	return nil
+}
+
+// Rename renames a file, replacing the file at the new path if it exists. Directories cannot be renamed.
+func (m *MemoryFileSystem) Rename(oldPath, newPath string) error {
+	oldP := m.abs(oldPath)
+	newP := m.abs(newPath)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n, ok := m.node(oldP)
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldPath, Err: fs.ErrNotExist}
+	}
+	if n.mode.IsDir() {
+		return &fs.PathError{Op: "rename", Path: oldPath, Err: errMemFSIsDir}
+	}
+
+	parent, ok := m.node(path.Dir(newP))
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: newPath, Err: fs.ErrNotExist}
+	}
+	if !parent.mode.IsDir() {
+		return &fs.PathError{Op: "rename", Path: newPath, Err: errMemFSNotDir}
+	}
+	if target, ok := m.node(newP); ok && target.mode.IsDir() {
+		return &fs.PathError{Op: "rename", Path: newPath, Err: errMemFSIsDir}
+	}
+
+	delete(m.nodes, oldP)
+	m.nodes[newP] = n
+
+	return nil
+}
+
+// node returns the node at a given absolute path. The caller must hold the lock.
+func (m *MemoryFileSystem) node(p string) (*memFSNode, bool) {
+	n, ok := m.nodes[p]
+	return n, ok
+}
+
+// abs resolves a location to a clean absolute path. Since it is called by every operation before
+// acquiring the lock, it also takes care of lazily creating the root directory.
+func (m *MemoryFileSystem) abs(location string) string {
+	m.once.Do(func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.nodes == nil {
+			m.nodes = map[string]*memFSNode{}
+		}
+		if _, ok := m.nodes["/"]; !ok {
+			m.nodes["/"] = &memFSNode{mode: fs.ModeDir | fs.ModePerm, modTime: m.now()}
+		}
+	})
+
+	if path.IsAbs(location) {
+		return path.Clean(location)
+	}
+
+	wd := m.WorkingDir
+	if wd == "" {
+		wd = "/"
+	}
+
+	return path.Join("/", wd, location)
+}
+
+func (m *MemoryFileSystem) now() time.Time {
+	if m.TimeProvider == nil {
+		return CurrentTimeProvider()
+	}
+	return m.TimeProvider()
+}
+
+func (n *memFSNode) info(p string) fs.FileInfo {
+	return memFSFileInfo{
+		name:    path.Base(p),
+		size:    int64(len(n.data)),
+		mode:    n.mode,
+		modTime: n.modTime,
+	}
+}
+
+func splitMemFSPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" || p == "." {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+type memFSFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (i memFSFileInfo) Name() string       { return i.name }
+func (i memFSFileInfo) Size() int64        { return i.size }
+func (i memFSFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i memFSFileInfo) ModTime() time.Time { return i.modTime }
+func (i memFSFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memFSFileInfo) Sys() any           { return nil }
@@ -0,0 +1,307 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter_test
+
+import (
+	"fmt"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+)
+
+var _ specter.FileSystem = (*specter.MemoryFileSystem)(nil)
+var _ specter.FileRenamer = (*specter.MemoryFileSystem)(nil)
+
+func TestMemoryFileSystem_Abs(t *testing.T) {
+	tests := []struct {
+		name       string
+		workingDir string
+		given      string
+		then       string
+	}{
+		{name: "GIVEN an absolute path THEN return it cleaned", given: "/a/b/../c/", then: "/a/c"},
+		{name: "GIVEN a relative path and no working dir THEN resolve against root", given: "a/b", then: "/a/b"},
+		{name: "GIVEN a relative path and a working dir THEN resolve against working dir", workingDir: "/project", given: "./a/b", then: "/project/a/b"},
+		{name: "GIVEN a relative path escaping the root THEN stop at root", given: "../../a", then: "/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mfs := &specter.MemoryFileSystem{WorkingDir: tt.workingDir}
+			got, err := mfs.Abs(tt.given)
+			require.NoError(t, err)
+			assert.Equal(t, tt.then, got)
+		})
+	}
+}
+
+func TestMemoryFileSystem_Rel(t *testing.T) {
+	tests := []struct {
+		name     string
+		basePath string
+		target   string
+		then     string
+		thenErr  bool
+	}{
+		{name: "GIVEN same paths THEN return dot", basePath: "/a/b", target: "/a/b", then: "."},
+		{name: "GIVEN a nested target THEN return the relative path", basePath: "/a", target: "/a/b/c", then: "b/c"},
+		{name: "GIVEN a sibling target THEN return a path going up", basePath: "/a/b", target: "/a/c/d", then: "../c/d"},
+		{name: "GIVEN a base sharing a prefix with the target THEN do not treat it as a parent", basePath: "/a/b", target: "/a/bc", then: "../bc"},
+		{name: "GIVEN an absolute and a relative path THEN return an error", basePath: "/a", target: "b", thenErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mfs := &specter.MemoryFileSystem{}
+			got, err := mfs.Rel(tt.basePath, tt.target)
+			if tt.thenErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.then, got)
+		})
+	}
+}
+
+func TestMemoryFileSystem_WriteFile(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	mfs := &specter.MemoryFileSystem{TimeProvider: func() time.Time { return now }}
+
+	t.Run("GIVEN a missing parent directory THEN return an error", func(t *testing.T) {
+		err := mfs.WriteFile("/missing/file.txt", []byte("hello"), 0644)
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("GIVEN an existing parent directory THEN write the file with its mode and mtime", func(t *testing.T) {
+		require.NoError(t, mfs.Mkdir("/dir", 0755))
+		require.NoError(t, mfs.WriteFile("/dir/file.txt", []byte("hello"), 0640))
+
+		data, err := mfs.ReadFile("/dir/file.txt")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("hello"), data)
+
+		info, err := mfs.StatPath("/dir/file.txt")
+		require.NoError(t, err)
+		assert.Equal(t, "file.txt", info.Name())
+		assert.Equal(t, int64(5), info.Size())
+		assert.Equal(t, fs.FileMode(0640), info.Mode())
+		assert.Equal(t, now, info.ModTime())
+		assert.False(t, info.IsDir())
+	})
+
+	t.Run("GIVEN an existing file THEN overwrite its content but keep its mode", func(t *testing.T) {
+		require.NoError(t, mfs.WriteFile("/dir/file.txt", []byte("world!"), 0777))
+
+		data, err := mfs.ReadFile("/dir/file.txt")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("world!"), data)
+
+		info, err := mfs.StatPath("/dir/file.txt")
+		require.NoError(t, err)
+		assert.Equal(t, fs.FileMode(0640), info.Mode())
+	})
+
+	t.Run("GIVEN a path pointing to a directory THEN return an error", func(t *testing.T) {
+		require.Error(t, mfs.WriteFile("/dir", []byte("hello"), 0644))
+	})
+
+	t.Run("GIVEN a parent that is a file THEN return an error", func(t *testing.T) {
+		require.Error(t, mfs.WriteFile("/dir/file.txt/child", []byte("hello"), 0644))
+	})
+
+	t.Run("written data should not be shared with the caller", func(t *testing.T) {
+		data := []byte("abc")
+		require.NoError(t, mfs.WriteFile("/dir/shared.txt", data, 0644))
+		data[0] = 'z'
+
+		read, err := mfs.ReadFile("/dir/shared.txt")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("abc"), read)
+	})
+}
+
+func TestMemoryFileSystem_ReadFile(t *testing.T) {
+	mfs := &specter.MemoryFileSystem{}
+	require.NoError(t, mfs.Mkdir("/dir", 0755))
+
+	_, err := mfs.ReadFile("/does/not/exist")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	_, err = mfs.ReadFile("/dir")
+	require.Error(t, err)
+}
+
+func TestMemoryFileSystem_Mkdir(t *testing.T) {
+	mfs := &specter.MemoryFileSystem{}
+
+	require.NoError(t, mfs.Mkdir("/a/b/c", 0750))
+	for _, p := range []string{"/a", "/a/b", "/a/b/c"} {
+		info, err := mfs.StatPath(p)
+		require.NoError(t, err)
+		assert.True(t, info.IsDir())
+		assert.Equal(t, fs.ModeDir|0750, info.Mode())
+	}
+
+	// Already existing directories are ignored.
+	require.NoError(t, mfs.Mkdir("/a/b", 0700))
+
+	require.NoError(t, mfs.WriteFile("/a/file", nil, 0644))
+	require.Error(t, mfs.Mkdir("/a/file/sub", 0755))
+}
+
+func TestMemoryFileSystem_Remove(t *testing.T) {
+	mfs := &specter.MemoryFileSystem{}
+	require.NoError(t, mfs.Mkdir("/a/b", 0755))
+	require.NoError(t, mfs.WriteFile("/a/b/file", nil, 0644))
+
+	require.ErrorIs(t, mfs.Remove("/does/not/exist"), fs.ErrNotExist)
+	require.Error(t, mfs.Remove("/a/b"), "non empty directories should not be removed")
+	require.Error(t, mfs.Remove("/"))
+
+	require.NoError(t, mfs.Remove("/a/b/file"))
+	_, err := mfs.StatPath("/a/b/file")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	require.NoError(t, mfs.Remove("/a/b"))
+	_, err = mfs.StatPath("/a/b")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestMemoryFileSystem_Rename(t *testing.T) {
+	mfs := &specter.MemoryFileSystem{}
+	require.NoError(t, mfs.Mkdir("/a/b", 0755))
+	require.NoError(t, mfs.WriteFile("/a/file", []byte("new"), 0600))
+	require.NoError(t, mfs.WriteFile("/a/b/file", []byte("old"), 0644))
+
+	require.ErrorIs(t, mfs.Rename("/does/not/exist", "/a/other"), fs.ErrNotExist)
+	require.ErrorIs(t, mfs.Rename("/a/file", "/does/not/exist"), fs.ErrNotExist)
+	require.Error(t, mfs.Rename("/a/b", "/a/c"), "directories should not be renamed")
+	require.Error(t, mfs.Rename("/a/file", "/a/b"), "directories should not be replaced")
+
+	require.NoError(t, mfs.Rename("/a/file", "/a/b/file"))
+	_, err := mfs.StatPath("/a/file")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	data, err := mfs.ReadFile("/a/b/file")
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+	stat, err := mfs.StatPath("/a/b/file")
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0600), stat.Mode().Perm())
+}
+
+func TestMemoryFileSystem_WalkDir(t *testing.T) {
+	newFS := func() *specter.MemoryFileSystem {
+		mfs := &specter.MemoryFileSystem{}
+		require.NoError(t, mfs.Mkdir("/root/b", 0755))
+		require.NoError(t, mfs.Mkdir("/root/a", 0755))
+		require.NoError(t, mfs.WriteFile("/root/z.txt", nil, 0644))
+		require.NoError(t, mfs.WriteFile("/root/a/2.txt", nil, 0644))
+		require.NoError(t, mfs.WriteFile("/root/a/1.txt", nil, 0644))
+		require.NoError(t, mfs.WriteFile("/root/b/3.txt", nil, 0644))
+		require.NoError(t, mfs.WriteFile("/rootfile", nil, 0644))
+		return mfs
+	}
+
+	t.Run("GIVEN a directory THEN walk in lexical order", func(t *testing.T) {
+		var visited []string
+		err := newFS().WalkDir("/root", func(path string, d fs.DirEntry, err error) error {
+			require.NoError(t, err)
+			visited = append(visited, path)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"/root",
+			"/root/a",
+			"/root/a/1.txt",
+			"/root/a/2.txt",
+			"/root/b",
+			"/root/b/3.txt",
+			"/root/z.txt",
+		}, visited)
+	})
+
+	t.Run("GIVEN SkipDir is returned THEN skip the directory", func(t *testing.T) {
+		var visited []string
+		err := newFS().WalkDir("/root", func(path string, d fs.DirEntry, err error) error {
+			visited = append(visited, path)
+			if path == "/root/a" {
+				return fs.SkipDir
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/root", "/root/a", "/root/b", "/root/b/3.txt", "/root/z.txt"}, visited)
+	})
+
+	t.Run("GIVEN SkipAll is returned THEN stop walking", func(t *testing.T) {
+		var visited []string
+		err := newFS().WalkDir("/root", func(path string, d fs.DirEntry, err error) error {
+			visited = append(visited, path)
+			if path == "/root/a/1.txt" {
+				return fs.SkipAll
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/root", "/root/a", "/root/a/1.txt"}, visited)
+	})
+
+	t.Run("GIVEN a missing root THEN call the function with the error", func(t *testing.T) {
+		err := newFS().WalkDir("/missing", func(path string, d fs.DirEntry, err error) error {
+			assert.Nil(t, d)
+			return err
+		})
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("GIVEN the walk function returns an error THEN return it", func(t *testing.T) {
+		err := newFS().WalkDir("/root", func(path string, d fs.DirEntry, err error) error {
+			return assert.AnError
+		})
+		require.ErrorIs(t, err, assert.AnError)
+	})
+}
+
+func TestMemoryFileSystem_Concurrency(t *testing.T) {
+	mfs := &specter.MemoryFileSystem{}
+	require.NoError(t, mfs.Mkdir("/out", 0755))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p := fmt.Sprintf("/out/%d.txt", i)
+			assert.NoError(t, mfs.WriteFile(p, []byte(p), 0644))
+			_, err := mfs.ReadFile(p)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	count := 0
+	err := mfs.WalkDir("/out", func(path string, d fs.DirEntry, err error) error {
+		if !d.IsDir() {
+			count++
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 50, count)
+}
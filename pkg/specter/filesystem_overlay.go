@@ -0,0 +1,477 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter
+
+import (
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const OverlayFileSystemCommitFailedErrorCode = "specter.overlay_file_system.commit_failed"
+
+type OverlayChangeKind string
+
+const (
+	OverlayWriteChange  OverlayChangeKind = "write"
+	OverlayMkdirChange  OverlayChangeKind = "mkdir"
+	OverlayRemoveChange OverlayChangeKind = "remove"
+)
+
+// OverlayChange represents a change that was recorded by an OverlayFileSystem.
+type OverlayChange struct {
+	Kind OverlayChangeKind
+	Path string
+	Mode fs.FileMode
+}
+
+// OverlayFileSystem is a copy-on-write FileSystem that layers a writable upper FileSystem over a read-only lower one.
+// Reads are served from the upper layer first and fall back to the lower layer, while all writes, removals and
+// directory creations are performed on the upper layer and recorded.
+// The recorded changes can then either be flushed to the lower layer using Commit or thrown away using Discard.
+//
+// This allows, for example, a FileArtifactProcessor to generate its files into a staging overlay so that the result
+// can be validated before it ever reaches the lower file system.
+type OverlayFileSystem struct {
+	Lower FileSystem
+	Upper FileSystem
+
+	mu      sync.RWMutex
+	changes []OverlayChange
+
+	// removed keeps track of the paths of the lower layer that were removed (whiteouts).
+	removed map[string]struct{}
+
+	// created keeps track of the paths created in the upper layer by the recorded changes, parents first.
+	created []string
+
+	// backups keeps the files and directories of the upper layer that existed before being overwritten or removed
+	// by the recorded changes, so that they can be restored by Discard.
+	backups map[string]overlayBackup
+}
+
+type overlayBackup struct {
+	path string
+	data []byte
+	mode fs.FileMode
+}
+
+// NewOverlayFileSystem returns a new OverlayFileSystem on top of a lower FileSystem.
+// If no upper FileSystem is provided, a MemoryFileSystem is used.
+func NewOverlayFileSystem(lower FileSystem, upper FileSystem) *OverlayFileSystem {
+	if upper == nil {
+		upper = &MemoryFileSystem{}
+	}
+	return &OverlayFileSystem{Lower: lower, Upper: upper}
+}
+
+func (o *OverlayFileSystem) Abs(location string) (string, error) {
+	return o.Lower.Abs(location)
+}
+
+func (o *OverlayFileSystem) Rel(basePath, targetPath string) (string, error) {
+	return o.Lower.Rel(basePath, targetPath)
+}
+
+func (o *OverlayFileSystem) StatPath(location string) (fs.FileInfo, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.stat(location)
+}
+
+func (o *OverlayFileSystem) ReadFile(filePath string) ([]byte, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if o.isRemoved(filePath) {
+		return nil, &fs.PathError{Op: "open", Path: filePath, Err: fs.ErrNotExist}
+	}
+
+	data, err := o.Upper.ReadFile(filePath)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return data, err
+	}
+
+	return o.Lower.ReadFile(filePath)
+}
+
+// WalkDir walks the merged view of both layers in lexical order.
+func (o *OverlayFileSystem) WalkDir(dirPath string, f func(path string, d fs.DirEntry, err error) error) error {
+	return walkFileTree(dirPath, o.StatPath, o.readDir, f)
+}
+
+func (o *OverlayFileSystem) WriteFile(filePath string, data []byte, perm fs.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.copyUpParent(filePath); err != nil {
+		return err
+	}
+
+	if err := o.backup(filePath); err != nil {
+		return err
+	}
+	if err := o.Upper.WriteFile(filePath, data, perm); err != nil {
+		return err
+	}
+	o.created = append(o.created, filePath)
+
+	o.unmarkRemoved(filePath)
+	o.record(OverlayWriteChange, filePath, perm)
+
+	return nil
+}
+
+func (o *OverlayFileSystem) Mkdir(dirPath string, mode fs.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.mkdirUpper(dirPath, mode); err != nil {
+		return err
+	}
+
+	// Mkdir creates all missing parents, which also revives removed ones.
+	for p := dirPath; ; p = filepath.Dir(p) {
+		o.unmarkRemoved(p)
+		if filepath.Dir(p) == p {
+			break
+		}
+	}
+	o.record(OverlayMkdirChange, dirPath, mode)
+
+	return nil
+}
+
+func (o *OverlayFileSystem) Remove(filePath string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	info, err := o.stat(filePath)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: filePath, Err: fs.ErrNotExist}
+	}
+
+	if info.IsDir() {
+		entries, err := o.readDirUnlocked(filePath)
+		if err != nil {
+			return err
+		}
+		if len(entries) != 0 {
+			return &fs.PathError{Op: "remove", Path: filePath, Err: errMemFSNotEmpty}
+		}
+	}
+
+	if _, err := o.Upper.StatPath(filePath); err == nil {
+		if err := o.backup(filePath); err != nil {
+			return err
+		}
+		if err := o.Upper.Remove(filePath); err != nil {
+			return err
+		}
+	}
+
+	if _, err := o.Lower.StatPath(filePath); err == nil {
+		o.markRemoved(filePath)
+	}
+
+	o.record(OverlayRemoveChange, filePath, 0)
+
+	return nil
+}
+
+// Changes returns the changes recorded since the last call to Commit or Discard in the order they were performed.
+func (o *OverlayFileSystem) Changes() []OverlayChange {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return append([]OverlayChange(nil), o.changes...)
+}
+
+// Commit flushes the recorded changes to the lower FileSystem in the order they were performed, and then clears them
+// from the upper layer so that the lower layer is read again.
+// If a change cannot be applied, the commit stops and the changes that were not applied yet are kept
+// so that the commit can be retried.
+func (o *OverlayFileSystem) Commit() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for len(o.changes) != 0 {
+		c := o.changes[0]
+		if err := o.apply(c); err != nil {
+			return errors.WrapWithMessage(
+				err,
+				OverlayFileSystemCommitFailedErrorCode,
+				fmt.Sprintf("failed committing %s of %q", c.Kind, c.Path),
+			)
+		}
+		o.changes = o.changes[1:]
+	}
+
+	o.resetUpper()
+	o.changes = nil
+	o.removed = nil
+	o.backups = nil
+
+	return nil
+}
+
+// Discard throws away the recorded changes, restoring the view of the lower FileSystem and the entries of the upper
+// layer that existed before the changes.
+func (o *OverlayFileSystem) Discard() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.resetUpper()
+
+	// Restore the entries of the upper layer that were overwritten or removed, parents first.
+	backups := make([]overlayBackup, 0, len(o.backups))
+	for _, b := range o.backups {
+		backups = append(backups, b)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].path < backups[j].path
+	})
+	for _, b := range backups {
+		var err error
+		if b.mode.IsDir() {
+			err = o.Upper.Mkdir(b.path, b.mode.Perm())
+		} else {
+			err = o.Upper.WriteFile(b.path, b.data, b.mode.Perm())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	o.changes = nil
+	o.removed = nil
+	o.backups = nil
+
+	return nil
+}
+
+// resetUpper removes the paths created in the upper layer by the recorded changes, in reverse order so that files are
+// removed before their directories. Directories still containing entries that existed before are left behind.
+func (o *OverlayFileSystem) resetUpper() {
+	for i := len(o.created) - 1; i >= 0; i-- {
+		_ = o.Upper.Remove(o.created[i])
+	}
+	o.created = nil
+}
+
+// backup keeps a copy of an entry of the upper layer that was not created by the recorded changes.
+func (o *OverlayFileSystem) backup(p string) error {
+	info, err := o.Upper.StatPath(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	key := o.key(p)
+	if _, found := o.backups[key]; found {
+		return nil
+	}
+	for _, c := range o.created {
+		if o.key(c) == key {
+			return nil
+		}
+	}
+
+	b := overlayBackup{path: p, mode: info.Mode()}
+	if !info.IsDir() {
+		if b.data, err = o.Upper.ReadFile(p); err != nil {
+			return err
+		}
+	}
+	if o.backups == nil {
+		o.backups = map[string]overlayBackup{}
+	}
+	o.backups[key] = b
+	return nil
+}
+
+// mkdirUpper creates a directory and its missing parents in the upper layer, keeping track of the created ones.
+func (o *OverlayFileSystem) mkdirUpper(dirPath string, mode fs.FileMode) error {
+	var missing []string
+	for p := dirPath; ; p = filepath.Dir(p) {
+		if _, err := o.Upper.StatPath(p); err == nil {
+			break
+		}
+		missing = append(missing, p)
+		if filepath.Dir(p) == p {
+			break
+		}
+	}
+
+	if err := o.Upper.Mkdir(dirPath, mode); err != nil {
+		return err
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		o.created = append(o.created, missing[i])
+	}
+	return nil
+}
+
+func (o *OverlayFileSystem) apply(c OverlayChange) error {
+	switch c.Kind {
+	case OverlayWriteChange:
+		data, err := o.Upper.ReadFile(c.Path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// The file was removed by a later change.
+				return nil
+			}
+			return err
+		}
+		return o.Lower.WriteFile(c.Path, data, c.Mode)
+	case OverlayMkdirChange:
+		return o.Lower.Mkdir(c.Path, c.Mode)
+	case OverlayRemoveChange:
+		if err := o.Lower.Remove(c.Path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	default:
+		return errors.NewWithMessage(errors.InternalErrorCode, fmt.Sprintf("unknown overlay change %q", c.Kind))
+	}
+}
+
+// copyUpParent ensures the parent directory of a path exists in the upper layer when it exists in the merged view.
+func (o *OverlayFileSystem) copyUpParent(filePath string) error {
+	parent := filepath.Dir(filePath)
+	info, err := o.stat(parent)
+	if err != nil {
+		return &fs.PathError{Op: "open", Path: filePath, Err: fs.ErrNotExist}
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "open", Path: filePath, Err: errMemFSNotDir}
+	}
+
+	if _, err := o.Upper.StatPath(parent); err == nil {
+		return nil
+	}
+
+	return o.mkdirUpper(parent, info.Mode().Perm())
+}
+
+func (o *OverlayFileSystem) stat(location string) (fs.FileInfo, error) {
+	if o.isRemoved(location) {
+		return nil, &fs.PathError{Op: "stat", Path: location, Err: fs.ErrNotExist}
+	}
+
+	info, err := o.Upper.StatPath(location)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return info, err
+	}
+
+	return o.Lower.StatPath(location)
+}
+
+func (o *OverlayFileSystem) readDir(dirPath string) ([]dirEntry, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return o.readDirUnlocked(dirPath)
+}
+
+// readDirUnlocked returns the merged entries of a directory, where the entries of the upper layer take precedence.
+func (o *OverlayFileSystem) readDirUnlocked(dirPath string) ([]dirEntry, error) {
+	entries := map[string]dirEntry{}
+
+	for _, layer := range []FileSystem{o.Lower, o.Upper} {
+		layerEntries, err := listDirectory(layer, dirPath)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		for _, e := range layerEntries {
+			entries[e.Name()] = e
+		}
+	}
+
+	var merged []dirEntry
+	for _, e := range entries {
+		if o.isRemoved(e.path) {
+			continue
+		}
+		merged = append(merged, e)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Name() < merged[j].Name()
+	})
+
+	return merged, nil
+}
+
+func (o *OverlayFileSystem) record(kind OverlayChangeKind, p string, mode fs.FileMode) {
+	o.changes = append(o.changes, OverlayChange{Kind: kind, Path: p, Mode: mode})
+}
+
+func (o *OverlayFileSystem) markRemoved(p string) {
+	if o.removed == nil {
+		o.removed = map[string]struct{}{}
+	}
+	o.removed[o.key(p)] = struct{}{}
+}
+
+func (o *OverlayFileSystem) unmarkRemoved(p string) {
+	delete(o.removed, o.key(p))
+}
+
+func (o *OverlayFileSystem) isRemoved(p string) bool {
+	_, found := o.removed[o.key(p)]
+	return found
+}
+
+func (o *OverlayFileSystem) key(p string) string {
+	abs, err := o.Lower.Abs(p)
+	if err != nil {
+		return filepath.Clean(p)
+	}
+	return abs
+}
+
+// listDirectory returns the direct entries of a directory of a FileSystem using its WalkDir method.
+func listDirectory(fsys FileSystem, dirPath string) ([]dirEntry, error) {
+	var entries []dirEntry
+	err := fsys.WalkDir(dirPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d == nil || filepath.Clean(p) == filepath.Clean(dirPath) {
+			if d != nil && !d.IsDir() {
+				return fs.SkipAll
+			}
+			return nil
+		}
+
+		entries = append(entries, dirEntry{path: p, DirEntry: d})
+		if d.IsDir() {
+			return fs.SkipDir
+		}
+		return nil
+	})
+
+	return entries, err
+}
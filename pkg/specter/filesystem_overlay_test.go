@@ -0,0 +1,236 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter_test
+
+import (
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"testing"
+)
+
+var _ specter.FileSystem = (*specter.OverlayFileSystem)(nil)
+
+func newOverlayLowerFileSystem(t *testing.T) *specter.MemoryFileSystem {
+	lower := &specter.MemoryFileSystem{}
+	require.NoError(t, lower.Mkdir("/project/src", 0755))
+	require.NoError(t, lower.WriteFile("/project/src/main.go", []byte("package main"), 0644))
+	require.NoError(t, lower.WriteFile("/project/README.md", []byte("readme"), 0644))
+	return lower
+}
+
+func TestOverlayFileSystem_ReadsFallBackToLower(t *testing.T) {
+	lower := newOverlayLowerFileSystem(t)
+	o := specter.NewOverlayFileSystem(lower, nil)
+
+	data, err := o.ReadFile("/project/README.md")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("readme"), data)
+
+	info, err := o.StatPath("/project/src")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+}
+
+func TestOverlayFileSystem_WriteFile(t *testing.T) {
+	lower := newOverlayLowerFileSystem(t)
+	o := specter.NewOverlayFileSystem(lower, nil)
+
+	require.NoError(t, o.WriteFile("/project/README.md", []byte("changed"), 0644))
+	require.NoError(t, o.WriteFile("/project/src/gen.go", []byte("generated"), 0644))
+
+	data, err := o.ReadFile("/project/README.md")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("changed"), data)
+
+	// Lower layer must remain untouched.
+	data, err = lower.ReadFile("/project/README.md")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("readme"), data)
+	_, err = lower.StatPath("/project/src/gen.go")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// Writing in a directory that does not exist in any layer fails.
+	require.ErrorIs(t, o.WriteFile("/project/missing/file", nil, 0644), fs.ErrNotExist)
+
+	assert.Equal(t, []specter.OverlayChange{
+		{Kind: specter.OverlayWriteChange, Path: "/project/README.md", Mode: 0644},
+		{Kind: specter.OverlayWriteChange, Path: "/project/src/gen.go", Mode: 0644},
+	}, o.Changes())
+}
+
+func TestOverlayFileSystem_Remove(t *testing.T) {
+	lower := newOverlayLowerFileSystem(t)
+	o := specter.NewOverlayFileSystem(lower, nil)
+
+	require.Error(t, o.Remove("/project/src"), "non empty directories should not be removed")
+	require.ErrorIs(t, o.Remove("/project/missing"), fs.ErrNotExist)
+
+	require.NoError(t, o.Remove("/project/src/main.go"))
+	_, err := o.StatPath("/project/src/main.go")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = o.ReadFile("/project/src/main.go")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// Once its files are removed, the directory can be removed.
+	require.NoError(t, o.Remove("/project/src"))
+
+	// Lower layer must remain untouched.
+	_, err = lower.StatPath("/project/src/main.go")
+	require.NoError(t, err)
+
+	// Writing a removed file revives it.
+	require.NoError(t, o.Mkdir("/project/src", 0755))
+	require.NoError(t, o.WriteFile("/project/src/main.go", []byte("new"), 0644))
+	data, err := o.ReadFile("/project/src/main.go")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new"), data)
+}
+
+func TestOverlayFileSystem_WalkDir(t *testing.T) {
+	lower := newOverlayLowerFileSystem(t)
+	o := specter.NewOverlayFileSystem(lower, nil)
+
+	require.NoError(t, o.Mkdir("/project/docs", 0755))
+	require.NoError(t, o.WriteFile("/project/docs/index.md", nil, 0644))
+	require.NoError(t, o.WriteFile("/project/src/main.go", []byte("changed"), 0644))
+	require.NoError(t, o.Remove("/project/README.md"))
+
+	var visited []string
+	err := o.WalkDir("/project", func(path string, d fs.DirEntry, err error) error {
+		require.NoError(t, err)
+		visited = append(visited, path)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"/project",
+		"/project/docs",
+		"/project/docs/index.md",
+		"/project/src",
+		"/project/src/main.go",
+	}, visited)
+}
+
+func TestOverlayFileSystem_Commit(t *testing.T) {
+	lower := newOverlayLowerFileSystem(t)
+	o := specter.NewOverlayFileSystem(lower, nil)
+
+	require.NoError(t, o.Mkdir("/project/docs", 0755))
+	require.NoError(t, o.WriteFile("/project/docs/index.md", []byte("docs"), 0644))
+	require.NoError(t, o.WriteFile("/project/tmp.txt", []byte("tmp"), 0644))
+	require.NoError(t, o.Remove("/project/tmp.txt"))
+	require.NoError(t, o.Remove("/project/README.md"))
+
+	require.NoError(t, o.Commit())
+	assert.Empty(t, o.Changes())
+
+	data, err := lower.ReadFile("/project/docs/index.md")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("docs"), data)
+
+	_, err = lower.StatPath("/project/README.md")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = lower.StatPath("/project/tmp.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestOverlayFileSystem_Commit_failure(t *testing.T) {
+	lower := &testutils.MockFileSystem{
+		Dirs:         map[string]bool{"/": true},
+		WriteFileErr: assert.AnError,
+	}
+	o := specter.NewOverlayFileSystem(lower, nil)
+	require.NoError(t, o.WriteFile("/file.txt", []byte("data"), 0644))
+
+	err := o.Commit()
+	testutils.RequireErrorWithCode(specter.OverlayFileSystemCommitFailedErrorCode)(t, err)
+	assert.Len(t, o.Changes(), 1, "changes that failed to be committed should be kept")
+}
+
+func TestOverlayFileSystem_Discard(t *testing.T) {
+	lower := newOverlayLowerFileSystem(t)
+	o := specter.NewOverlayFileSystem(lower, nil)
+
+	require.NoError(t, o.Mkdir("/project/docs", 0755))
+	require.NoError(t, o.WriteFile("/project/docs/index.md", []byte("docs"), 0644))
+	require.NoError(t, o.WriteFile("/project/README.md", []byte("changed"), 0644))
+	require.NoError(t, o.Remove("/project/src/main.go"))
+
+	require.NoError(t, o.Discard())
+	assert.Empty(t, o.Changes())
+
+	_, err := o.StatPath("/project/docs")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	data, err := o.ReadFile("/project/README.md")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("readme"), data)
+
+	_, err = o.StatPath("/project/src/main.go")
+	require.NoError(t, err)
+}
+
+func TestOverlayFileSystem_Commit_twice(t *testing.T) {
+	lower := newOverlayLowerFileSystem(t)
+	upper := &specter.MemoryFileSystem{}
+	o := specter.NewOverlayFileSystem(lower, upper)
+
+	require.NoError(t, o.Mkdir("/project/docs", 0755))
+	require.NoError(t, o.WriteFile("/project/docs/index.md", []byte("docs"), 0644))
+	require.NoError(t, o.Commit())
+
+	// Once committed, the upper layer should be cleared so that the lower layer is read again.
+	_, err := upper.StatPath("/project/docs/index.md")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	require.NoError(t, lower.WriteFile("/project/docs/index.md", []byte("edited"), 0644))
+
+	data, err := o.ReadFile("/project/docs/index.md")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("edited"), data)
+
+	// A second commit should only apply the changes recorded since the first one.
+	require.NoError(t, o.WriteFile("/project/README.md", []byte("changed"), 0644))
+	require.NoError(t, o.Commit())
+
+	data, err = lower.ReadFile("/project/docs/index.md")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("edited"), data)
+	data, err = lower.ReadFile("/project/README.md")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("changed"), data)
+}
+
+func TestOverlayFileSystem_Discard_restoresUpperFiles(t *testing.T) {
+	lower := newOverlayLowerFileSystem(t)
+	upper := &specter.MemoryFileSystem{}
+	require.NoError(t, upper.Mkdir("/project", 0755))
+	require.NoError(t, upper.WriteFile("/project/staged.txt", []byte("staged"), 0644))
+	require.NoError(t, upper.WriteFile("/project/README.md", []byte("staged readme"), 0644))
+	o := specter.NewOverlayFileSystem(lower, upper)
+
+	require.NoError(t, o.Remove("/project/staged.txt"))
+	require.NoError(t, o.WriteFile("/project/README.md", []byte("changed"), 0644))
+	require.NoError(t, o.Discard())
+
+	data, err := o.ReadFile("/project/staged.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("staged"), data)
+	data, err = o.ReadFile("/project/README.md")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("staged readme"), data)
+}
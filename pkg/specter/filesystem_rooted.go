@@ -0,0 +1,188 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter
+
+import (
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// PathEscapesRootErrorCode is returned by a RootedFileSystem when a path resolves outside its root.
+const PathEscapesRootErrorCode = "specter.rooted_file_system.path_escapes_root"
+
+// SymlinkEvaluator can be implemented by FileSystem implementations supporting symbolic links so that
+// the paths they contain can be resolved.
+type SymlinkEvaluator interface {
+	// EvalSymlinks returns the path name after the evaluation of any symbolic links.
+	EvalSymlinks(path string) (string, error)
+
+	// Lstat returns file information for the specified location without following symbolic links.
+	Lstat(location string) (fs.FileInfo, error)
+}
+
+// RootedFileSystem is a chroot-like FileSystem that restricts all operations of an underlying FileSystem to the
+// files and directories contained under a root directory.
+//
+// Relative paths are resolved against the root. Any path resolving outside the root, either lexically (e.g. "../../etc")
+// or through symbolic links when the underlying FileSystem is a SymlinkEvaluator, is rejected with an error
+// having the code PathEscapesRootErrorCode.
+type RootedFileSystem struct {
+	FileSystem FileSystem
+	Root       string
+}
+
+func NewRootedFileSystem(fs FileSystem, root string) *RootedFileSystem {
+	return &RootedFileSystem{FileSystem: fs, Root: root}
+}
+
+func (r *RootedFileSystem) Abs(location string) (string, error) {
+	return r.resolve(location)
+}
+
+func (r *RootedFileSystem) Rel(basePath, targetPath string) (string, error) {
+	return r.FileSystem.Rel(basePath, targetPath)
+}
+
+func (r *RootedFileSystem) StatPath(location string) (fs.FileInfo, error) {
+	p, err := r.resolve(location)
+	if err != nil {
+		return nil, err
+	}
+	return r.FileSystem.StatPath(p)
+}
+
+func (r *RootedFileSystem) WalkDir(dirPath string, f func(path string, d fs.DirEntry, err error) error) error {
+	p, err := r.resolve(dirPath)
+	if err != nil {
+		return err
+	}
+	return r.FileSystem.WalkDir(p, f)
+}
+
+func (r *RootedFileSystem) ReadFile(filePath string) ([]byte, error) {
+	p, err := r.resolve(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return r.FileSystem.ReadFile(p)
+}
+
+func (r *RootedFileSystem) WriteFile(filePath string, data []byte, perm fs.FileMode) error {
+	p, err := r.resolve(filePath)
+	if err != nil {
+		return err
+	}
+	return r.FileSystem.WriteFile(p, data, perm)
+}
+
+func (r *RootedFileSystem) Mkdir(dirPath string, mode fs.FileMode) error {
+	p, err := r.resolve(dirPath)
+	if err != nil {
+		return err
+	}
+	return r.FileSystem.Mkdir(p, mode)
+}
+
+func (r *RootedFileSystem) Remove(filePath string) error {
+	p, err := r.resolve(filePath)
+	if err != nil {
+		return err
+	}
+	return r.FileSystem.Remove(p)
+}
+
+// resolve returns the absolute path of a location under the root, or an error if it escapes it.
+func (r *RootedFileSystem) resolve(location string) (string, error) {
+	root, err := r.FileSystem.Abs(r.Root)
+	if err != nil {
+		return "", err
+	}
+
+	p := location
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(root, p)
+	}
+	p = filepath.Clean(p)
+
+	if !isPathWithin(root, p) {
+		return "", newPathEscapesRootError(location, r.Root)
+	}
+
+	evaluator, ok := r.FileSystem.(SymlinkEvaluator)
+	if !ok {
+		return p, nil
+	}
+
+	realRoot, err := evalExistingSymlinks(evaluator, root)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := evalExistingSymlinks(evaluator, p)
+	if err != nil {
+		return "", err
+	}
+	if !isPathWithin(realRoot, realPath) {
+		return "", newPathEscapesRootError(location, r.Root)
+	}
+
+	return p, nil
+}
+
+// evalExistingSymlinks evaluates the symbolic links of the longest existing prefix of a path, since the path itself
+// might not exist yet (e.g. a file about to be written). Dangling symbolic links are rejected, since their target
+// could be created outside the root through them.
+func evalExistingSymlinks(evaluator SymlinkEvaluator, p string) (string, error) {
+	var missing []string
+	for {
+		resolved, err := evaluator.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		if info, err := evaluator.Lstat(p); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			return "", errors.NewWithMessage(
+				PathEscapesRootErrorCode,
+				fmt.Sprintf("path %q is a dangling symbolic link", p),
+			)
+		}
+
+		parent := filepath.Dir(p)
+		if parent == p {
+			return filepath.Join(append([]string{p}, missing...)...), nil
+		}
+		missing = append([]string{filepath.Base(p)}, missing...)
+		p = parent
+	}
+}
+
+func isPathWithin(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func newPathEscapesRootError(location string, root string) error {
+	return errors.NewWithMessage(
+		PathEscapesRootErrorCode,
+		fmt.Sprintf("path %q escapes root %q", location, root),
+	)
+}
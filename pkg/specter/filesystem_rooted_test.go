@@ -0,0 +1,162 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter_test
+
+import (
+	"context"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var _ specter.FileSystem = (*specter.RootedFileSystem)(nil)
+var _ specter.SymlinkEvaluator = specter.LocalFileSystem{}
+
+func TestRootedFileSystem_Abs(t *testing.T) {
+	tests := []struct {
+		name      string
+		given     string
+		then      string
+		thenError require.ErrorAssertionFunc
+	}{
+		{
+			name:  "GIVEN a relative path THEN resolve it against the root",
+			given: "src/main.go",
+			then:  "/out/src/main.go",
+		},
+		{
+			name:  "GIVEN an absolute path under the root THEN return it",
+			given: "/out/src/../main.go",
+			then:  "/out/main.go",
+		},
+		{
+			name:      "GIVEN a relative path escaping the root THEN return an error",
+			given:     "../../etc/passwd",
+			thenError: testutils.RequireErrorWithCode(specter.PathEscapesRootErrorCode),
+		},
+		{
+			name:      "GIVEN an absolute path outside the root THEN return an error",
+			given:     "/etc/passwd",
+			thenError: testutils.RequireErrorWithCode(specter.PathEscapesRootErrorCode),
+		},
+		{
+			name:      "GIVEN a sibling directory sharing the root prefix THEN return an error",
+			given:     "/output/file",
+			thenError: testutils.RequireErrorWithCode(specter.PathEscapesRootErrorCode),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := specter.NewRootedFileSystem(&specter.MemoryFileSystem{}, "/out")
+			got, err := r.Abs(tt.given)
+			if tt.thenError != nil {
+				tt.thenError(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.then, got)
+		})
+	}
+}
+
+func TestRootedFileSystem_Operations(t *testing.T) {
+	mfs := &specter.MemoryFileSystem{}
+	require.NoError(t, mfs.Mkdir("/out", 0755))
+	require.NoError(t, mfs.WriteFile("/secret", []byte("secret"), 0644))
+	r := specter.NewRootedFileSystem(mfs, "/out")
+
+	require.NoError(t, r.Mkdir("dir", 0755))
+	require.NoError(t, r.WriteFile("dir/file.txt", []byte("hello"), 0644))
+
+	data, err := mfs.ReadFile("/out/dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hello"), data)
+
+	escape := testutils.RequireErrorWithCode(specter.PathEscapesRootErrorCode)
+	_, err = r.ReadFile("../secret")
+	escape(t, err)
+	_, err = r.StatPath("../secret")
+	escape(t, err)
+	escape(t, r.WriteFile("../secret", nil, 0644))
+	escape(t, r.Mkdir("../dir", 0755))
+	escape(t, r.Remove("../secret"))
+	escape(t, r.WalkDir("..", nil))
+
+	require.NoError(t, r.Remove("dir/file.txt"))
+}
+
+func TestRootedFileSystem_Symlinks(t *testing.T) {
+	tmp := t.TempDir()
+	root := filepath.Join(tmp, "out")
+	outside := filepath.Join(tmp, "outside")
+	require.NoError(t, os.Mkdir(root, 0755))
+	require.NoError(t, os.Mkdir(outside, 0755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "escape")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "missing"), filepath.Join(root, "dangling")))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "real"), 0755))
+	require.NoError(t, os.Symlink(filepath.Join(root, "real"), filepath.Join(root, "inside")))
+
+	r := specter.NewRootedFileSystem(specter.LocalFileSystem{}, root)
+	escape := testutils.RequireErrorWithCode(specter.PathEscapesRootErrorCode)
+
+	escape(t, r.WriteFile("escape/file.txt", []byte("pwned"), 0644))
+	_, err := os.Stat(filepath.Join(outside, "file.txt"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	escape(t, r.WriteFile("dangling", []byte("pwned"), 0644))
+
+	require.NoError(t, r.WriteFile("inside/file.txt", []byte("ok"), 0644))
+	data, err := os.ReadFile(filepath.Join(root, "real", "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ok"), data)
+}
+
+func TestPipelineBuilder_WithArtifactOutputRoot(t *testing.T) {
+	mfs := &specter.MemoryFileSystem{}
+	require.NoError(t, mfs.Mkdir("/out", 0755))
+
+	p := specter.NewPipeline().
+		WithUnitProcessors(specter.NewUnitProcessorFunc("files", func(ctx specter.UnitProcessingContext) ([]specter.Artifact, error) {
+			return []specter.Artifact{
+				&specter.FileArtifact{Path: "../escaped.txt", Data: []byte("escaped")},
+			}, nil
+		})).
+		WithArtifactProcessors(specter.FileArtifactProcessor{FileSystem: mfs}).
+		WithArtifactOutputRoot("/out").
+		Build()
+
+	_, err := p.Run(context.Background(), specter.RunThrough, nil)
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "escapes root")
+
+	_, err = mfs.StatPath("/escaped.txt")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestPipelineBuilder_WithArtifactOutputRoot_keepsConfiguredProcessors(t *testing.T) {
+	mfs := &specter.MemoryFileSystem{}
+	processor := &specter.FileArtifactProcessor{FileSystem: mfs}
+
+	specter.NewPipeline().
+		WithArtifactProcessors(processor).
+		WithArtifactOutputRoot("/out").
+		Build()
+
+	assert.Same(t, mfs, processor.FileSystem)
+}
@@ -25,6 +25,8 @@ import (
 	"testing"
 )
 
+var _ specter.FileRenamer = specter.LocalFileSystem{}
+
 func TestLocalFileSystem_ReadFile(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -29,6 +29,38 @@ const (
 	StopAfterPreprocessingStage  RunMode = "stop-after-preprocessing-stage"
 )
 
+// StrictnessPolicy indicates how a stage of a Pipeline should react to inputs it cannot handle,
+// such as a source location that no SourceLoader supports or a source that no UnitLoader supports.
+// Stages default to StrictnessError, regardless of whether a Logger is configured.
+type StrictnessPolicy string
+
+const (
+	// StrictnessError reports unhandled inputs as errors failing the stage.
+	StrictnessError StrictnessPolicy = "error"
+
+	// StrictnessWarn reports unhandled inputs as warnings using the Logger of the Pipeline.
+	StrictnessWarn StrictnessPolicy = "warn"
+
+	// StrictnessIgnore silently ignores unhandled inputs.
+	StrictnessIgnore StrictnessPolicy = "ignore"
+)
+
+// Apply reports the error of an unhandled input according to the policy, logging it as a warning if needed.
+// It returns the error only if it should fail, which is the case of StrictnessError and of an unset policy.
+func (p StrictnessPolicy) Apply(logger Logger, err error) error {
+	switch p {
+	case StrictnessIgnore:
+		return nil
+	case StrictnessWarn:
+		if logger != nil {
+			logger.Warning(err.Error())
+		}
+		return nil
+	default:
+		return err
+	}
+}
+
 const SourceLoadingFailedErrorCode = "specter.source_loading_failed"
 const UnitLoadingFailedErrorCode = "specter.unit_loading_failed"
 const UnitPreprocessingFailedErrorCode = "specter.unit_preprocessing_failed"
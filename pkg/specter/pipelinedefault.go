@@ -169,6 +169,11 @@ func (p DefaultPipeline) runUnitPreprocessingStage(ctx *PipelineContext) error {
 type sourceLoadingStage struct {
 	SourceLoaders []SourceLoader
 	Hooks         SourceLoadingStageHooks
+
+	// UnsupportedLocationPolicy defines how to report source locations that no SourceLoader supports.
+	// Defaults to StrictnessError.
+	UnsupportedLocationPolicy StrictnessPolicy
+	Logger                    Logger
 }
 
 func (s sourceLoadingStage) Run(ctx PipelineContext, sourceLocations []string) ([]Source, error) {
@@ -197,6 +202,7 @@ func (s sourceLoadingStage) run(ctx PipelineContext, sourceLocations []string) (
 	ctx.SourceLocations = sourceLocations
 
 	errs := errors.NewGroup(SourceLoadingFailedErrorCode)
+	loaders := NewSourceLoaderRegistryOf(s.SourceLoaders...)
 
 	for _, sl := range sourceLocations {
 		if err := ctx.Err(); err != nil {
@@ -207,7 +213,10 @@ func (s sourceLoadingStage) run(ctx PipelineContext, sourceLocations []string) (
 			return nil, newFailedToRunHookErr(err, "BeforeSourceLocation")
 		}
 
-		sources, err := s.processSourceLocation(ctx, sl)
+		sources, err := loaders.Load(sl)
+		if errors.HasCode(err, UnsupportedSourceLocationErrorCode) {
+			err = applyStrictnessPolicy(s.UnsupportedLocationPolicy, StrictnessError, s.Logger, err)
+		}
 		if err != nil {
 			errs = errs.Append(err)
 			continue
@@ -221,21 +230,6 @@ func (s sourceLoadingStage) run(ctx PipelineContext, sourceLocations []string) (
 	return ctx.Sources, errors.GroupOrNil(errs)
 }
 
-func (s sourceLoadingStage) processSourceLocation(_ PipelineContext, sl string) ([]Source, error) {
-	var sources []Source
-	for _, l := range s.SourceLoaders {
-		if !l.Supports(sl) {
-			continue
-		}
-		loadedSources, err := l.Load(sl)
-		if err != nil {
-			return nil, err
-		}
-		sources = append(sources, loadedSources...)
-	}
-	return sources, nil
-}
-
 type SourceLoadingStageHooksAdapter struct{}
 
 func (_ SourceLoadingStageHooksAdapter) Before(_ PipelineContext) error { return nil }
@@ -253,6 +247,11 @@ func (_ SourceLoadingStageHooksAdapter) OnError(_ PipelineContext, err error) er
 type unitLoadingStage struct {
 	Loaders []UnitLoader
 	Hooks   UnitLoadingStageHooks
+
+	// UnhandledSourcePolicy defines how to report sources that no UnitLoader supports.
+	// Defaults to StrictnessError.
+	UnhandledSourcePolicy StrictnessPolicy
+	Logger                Logger
 }
 
 func (s unitLoadingStage) Run(ctx PipelineContext, sources []Source) ([]Unit, error) {
@@ -302,10 +301,12 @@ func (s unitLoadingStage) run(ctx PipelineContext, sources []Source) ([]Unit, er
 
 func (s unitLoadingStage) runLoader(src Source) ([]Unit, error) {
 	var units []Unit
+	handled := false
 	for _, l := range s.Loaders {
 		if !l.SupportsSource(src) {
 			continue
 		}
+		handled = true
 
 		loadedUnits, err := l.Load(src)
 		if err != nil {
@@ -313,6 +314,15 @@ func (s unitLoadingStage) runLoader(src Source) ([]Unit, error) {
 		}
 		units = append(units, loadedUnits...)
 	}
+
+	if !handled {
+		err := errors.NewWithMessage(
+			UnhandledSourceErrorCode,
+			fmt.Sprintf("no unit loader supports source %q with format %q", src.Location, src.Format),
+		)
+		return nil, applyStrictnessPolicy(s.UnhandledSourcePolicy, StrictnessError, s.Logger, err)
+	}
+
 	return units, nil
 }
 
@@ -554,6 +564,15 @@ func (s artifactProcessingStage) runProcessor(ctx PipelineContext, processor Art
 	return processor.Process(apCtx)
 }
 
+// applyStrictnessPolicy reports an error according to a StrictnessPolicy, or a default one if it is not set.
+// It returns the error only if it should fail the stage.
+func applyStrictnessPolicy(policy StrictnessPolicy, defaultPolicy StrictnessPolicy, logger Logger, err error) error {
+	if policy == "" {
+		policy = defaultPolicy
+	}
+	return policy.Apply(logger, err)
+}
+
 func newFailedToRunHookErr(err error, hookName string) error {
 	return fmt.Errorf("hook %q returned an error: %w", hookName, err)
 }
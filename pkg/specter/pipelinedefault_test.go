@@ -15,6 +15,7 @@
 package specter_test
 
 import (
+	"bytes"
 	"context"
 	"github.com/morebec/specter/pkg/specter"
 	"github.com/morebec/specter/pkg/testutils"
@@ -444,7 +445,7 @@ func Test_sourceLoadingStage_Run(t *testing.T) {
 		assert.False(t, recorder.afterCalled)
 	})
 
-	t.Run("should return the loaded sources", func(t *testing.T) {
+	t.Run("should return the sources loaded by the first supporting loader", func(t *testing.T) {
 		locations := []string{"/path/to/file"}
 		expectedSources := []specter.Source{
 			{
@@ -474,7 +475,75 @@ func Test_sourceLoadingStage_Run(t *testing.T) {
 		sources, err := stage.Run(specter.PipelineContext{Context: context.Background()}, locations)
 
 		require.NoError(t, err)
-		require.Equal(t, expectedSources, sources)
+		require.Equal(t, expectedSources[:1], sources)
+	})
+
+	t.Run("should return an error when no loader supports a location", func(t *testing.T) {
+		stage := specter.DefaultSourceLoadingStage{
+			SourceLoaders: []specter.SourceLoader{
+				specter.FunctionalSourceLoader{
+					SupportsFunc: func(location string) bool { return false },
+				},
+			},
+		}
+
+		sources, err := stage.Run(specter.PipelineContext{Context: context.Background()}, []string{"/path/to/typo"})
+
+		require.Error(t, err)
+		require.ErrorContains(t, err, `no source loader supports location "/path/to/typo"`)
+		require.Nil(t, sources)
+	})
+
+	t.Run("should report unsupported locations according to the strictness policy", func(t *testing.T) {
+		tests := []struct {
+			name        string
+			given       specter.StrictnessPolicy
+			thenError   require.ErrorAssertionFunc
+			thenWarning bool
+		}{
+			{
+				name:  "GIVEN error policy THEN return an error",
+				given: specter.StrictnessError,
+				thenError: func(t require.TestingT, err error, _ ...interface{}) {
+					require.ErrorContains(t, err, `no source loader supports location "/path/to/typo"`)
+				},
+			},
+			{
+				name:        "GIVEN warn policy THEN log a warning",
+				given:       specter.StrictnessWarn,
+				thenError:   require.NoError,
+				thenWarning: true,
+			},
+			{
+				name:      "GIVEN ignore policy THEN do nothing",
+				given:     specter.StrictnessIgnore,
+				thenError: require.NoError,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				buffer := &bytes.Buffer{}
+				stage := specter.DefaultSourceLoadingStage{
+					SourceLoaders: []specter.SourceLoader{
+						specter.FunctionalSourceLoader{
+							SupportsFunc: func(location string) bool { return false },
+						},
+					},
+					UnsupportedLocationPolicy: tt.given,
+					Logger:                    specter.NewDefaultLogger(specter.DefaultLoggerConfig{DisableColors: true, Writer: buffer}),
+				}
+
+				sources, err := stage.Run(specter.PipelineContext{Context: context.Background()}, []string{"/path/to/typo"})
+				tt.thenError(t, err)
+				require.Nil(t, sources)
+
+				if tt.thenWarning {
+					assert.Contains(t, buffer.String(), `no source loader supports location "/path/to/typo"`)
+				} else {
+					assert.Empty(t, buffer.String())
+				}
+			})
+		}
 	})
 }
 
@@ -569,6 +638,89 @@ func Test_unitLoadingStage_Run(t *testing.T) {
 		require.NoError(t, err)
 		require.Equal(t, expectedUnits, units)
 	})
+
+	t.Run("should report unhandled sources according to the strictness policy", func(t *testing.T) {
+		tests := []struct {
+			name        string
+			given       specter.StrictnessPolicy
+			thenError   require.ErrorAssertionFunc
+			thenWarning bool
+		}{
+			{
+				name:  "GIVEN no policy THEN return an error",
+				given: "",
+				thenError: func(t require.TestingT, err error, _ ...interface{}) {
+					require.ErrorContains(t, err, `no unit loader supports source "/path/to/file.txt" with format "txt"`)
+				},
+			},
+			{
+				name:  "GIVEN error policy THEN return an error",
+				given: specter.StrictnessError,
+				thenError: func(t require.TestingT, err error, _ ...interface{}) {
+					require.ErrorContains(t, err, `no unit loader supports source "/path/to/file.txt" with format "txt"`)
+				},
+			},
+			{
+				name:        "GIVEN warn policy THEN log a warning",
+				given:       specter.StrictnessWarn,
+				thenError:   require.NoError,
+				thenWarning: true,
+			},
+			{
+				name:      "GIVEN ignore policy THEN do nothing",
+				given:     specter.StrictnessIgnore,
+				thenError: require.NoError,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				buffer := &bytes.Buffer{}
+				stage := specter.DefaultUnitLoadingStage{
+					Loaders: []specter.UnitLoader{
+						specter.UnitLoaderAdapter{
+							SupportsSourceFunc: func(s specter.Source) bool { return false },
+						},
+					},
+					UnhandledSourcePolicy: tt.given,
+					Logger:                specter.NewDefaultLogger(specter.DefaultLoggerConfig{DisableColors: true, Writer: buffer}),
+				}
+
+				units, err := stage.Run(
+					specter.PipelineContext{Context: context.Background()},
+					[]specter.Source{
+						{Location: "/path/to/file.txt", Format: "txt"},
+					},
+				)
+				tt.thenError(t, err)
+				require.Nil(t, units)
+
+				if tt.thenWarning {
+					assert.Contains(t, buffer.String(), `no unit loader supports source "/path/to/file.txt" with format "txt"`)
+				} else {
+					assert.Empty(t, buffer.String())
+				}
+			})
+		}
+	})
+
+	t.Run("should fail on unhandled sources when no policy and no logger are configured", func(t *testing.T) {
+		stage := specter.DefaultUnitLoadingStage{
+			Loaders: []specter.UnitLoader{
+				specter.UnitLoaderAdapter{
+					SupportsSourceFunc: func(s specter.Source) bool { return false },
+				},
+			},
+		}
+
+		units, err := stage.Run(
+			specter.PipelineContext{Context: context.Background()},
+			[]specter.Source{
+				{Location: "/path/to/file.txt", Format: "txt"},
+			},
+		)
+		require.ErrorContains(t, err, `no unit loader supports source "/path/to/file.txt" with format "txt"`)
+		require.Nil(t, units)
+	})
 }
 
 func Test_unitPreprocessingStage_Run(t *testing.T) {
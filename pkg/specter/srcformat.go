@@ -0,0 +1,188 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"gopkg.in/yaml.v3"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	HCLSourceFormat  SourceFormat = "hcl"
+	JSONSourceFormat SourceFormat = "json"
+	YAMLSourceFormat SourceFormat = "yaml"
+)
+
+// DefaultExtensionFormats maps well known file extensions to their SourceFormat.
+var DefaultExtensionFormats = map[string]SourceFormat{
+	"hcl":  HCLSourceFormat,
+	"json": JSONSourceFormat,
+	"yaml": YAMLSourceFormat,
+	"yml":  YAMLSourceFormat,
+}
+
+// FormatDetector is a service responsible for detecting the SourceFormat of a source from its location and content.
+type FormatDetector interface {
+	// DetectFormat returns the format of a source, or false if it could not be determined.
+	DetectFormat(location string, data []byte) (SourceFormat, bool)
+}
+
+// FormatDetectorFunc implementation of a FormatDetector that relies on a func.
+type FormatDetectorFunc func(location string, data []byte) (SourceFormat, bool)
+
+func (f FormatDetectorFunc) DetectFormat(location string, data []byte) (SourceFormat, bool) {
+	return f(location, data)
+}
+
+// FormatDetectorChain is a FormatDetector that runs multiple detectors in order and returns the first detected format.
+type FormatDetectorChain []FormatDetector
+
+func (c FormatDetectorChain) DetectFormat(location string, data []byte) (SourceFormat, bool) {
+	for _, d := range c {
+		if format, ok := d.DetectFormat(location, data); ok {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+// DefaultFormatDetector returns the FormatDetector used by source loaders when none is configured.
+// In order, it looks for a format hint in the content, a well known extension, sniffs the content and finally
+// falls back to using the raw extension of the location as the format.
+func DefaultFormatDetector() FormatDetectorChain {
+	return FormatDetectorChain{
+		HintFormatDetector(),
+		ExtensionFormatDetector(DefaultExtensionFormats),
+		ContentFormatDetector(),
+		RawExtensionFormatDetector(),
+	}
+}
+
+// ExtensionFormatDetector detects the format of a source from the extension of its location using a map of
+// extensions (without the leading dot) to formats. This allows multiple extensions to share a same format,
+// such as "yml" and "yaml".
+func ExtensionFormatDetector(formats map[string]SourceFormat) FormatDetectorFunc {
+	return func(location string, _ []byte) (SourceFormat, bool) {
+		ext := strings.ToLower(strings.TrimPrefix(path.Ext(location), "."))
+		if ext == "" {
+			return "", false
+		}
+		format, ok := formats[ext]
+		return format, ok
+	}
+}
+
+// RawExtensionFormatDetector uses the extension of a location (without the leading dot) as its format.
+func RawExtensionFormatDetector() FormatDetectorFunc {
+	return func(location string, _ []byte) (SourceFormat, bool) {
+		ext := strings.TrimPrefix(path.Ext(location), ".")
+		return SourceFormat(ext), ext != ""
+	}
+}
+
+var formatHintRegexp = regexp.MustCompile(`specter:format=([A-Za-z0-9_.+-]+)`)
+var shebangFormatRegexp = regexp.MustCompile(`--format[= ]([A-Za-z0-9_.+-]+)`)
+
+// formatHintMaxLines is the number of leading lines of a source in which format hints are looked for.
+const formatHintMaxLines = 5
+
+// HintFormatDetector detects the format of a source from an explicit hint located in its first lines either as
+// a magic comment (e.g. "# specter:format=hcl" or "// specter:format=hcl") or as a shebang
+// (e.g. "#!/usr/bin/env specter --format=hcl").
+func HintFormatDetector() FormatDetectorFunc {
+	return func(_ string, data []byte) (SourceFormat, bool) {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for i := 0; i < formatHintMaxLines && scanner.Scan(); i++ {
+			line := strings.TrimSpace(scanner.Text())
+
+			if i == 0 && strings.HasPrefix(line, "#!") {
+				if m := shebangFormatRegexp.FindStringSubmatch(line); m != nil {
+					return SourceFormat(m[1]), true
+				}
+				continue
+			}
+
+			if !strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "//") && !strings.HasPrefix(line, "/*") {
+				continue
+			}
+
+			if m := formatHintRegexp.FindStringSubmatch(line); m != nil {
+				return SourceFormat(m[1]), true
+			}
+		}
+		return "", false
+	}
+}
+
+// ContentFormatDetector detects the format of a source by sniffing its content for JSON, HCL or YAML.
+// It is conservative: YAML is only detected for documents that are mappings or sequences and HCL for bodies that
+// can be parsed without errors and contain at least one attribute or block.
+func ContentFormatDetector() FormatDetectorFunc {
+	return func(location string, data []byte) (SourceFormat, bool) {
+		trimmed := bytes.TrimSpace(data)
+		if len(trimmed) == 0 {
+			return "", false
+		}
+
+		if (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+			return JSONSourceFormat, true
+		}
+
+		if looksLikeHCL(location, data) {
+			return HCLSourceFormat, true
+		}
+
+		if looksLikeYAML(data) {
+			return YAMLSourceFormat, true
+		}
+
+		return "", false
+	}
+}
+
+func looksLikeHCL(location string, data []byte) bool {
+	file, diags := hclsyntax.ParseConfig(data, location, hcl.InitialPos)
+	if diags.HasErrors() {
+		return false
+	}
+
+	body, ok := file.Body.(*hclsyntax.Body)
+	if !ok {
+		return false
+	}
+
+	return len(body.Attributes) != 0 || len(body.Blocks) != 0
+}
+
+func looksLikeYAML(data []byte) bool {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return false
+	}
+
+	if node.Kind != yaml.DocumentNode || len(node.Content) == 0 {
+		return false
+	}
+
+	kind := node.Content[0].Kind
+	return kind == yaml.MappingNode || kind == yaml.SequenceNode
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter_test
+
+import (
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestDefaultFormatDetector(t *testing.T) {
+	tests := []struct {
+		name         string
+		whenLocation string
+		whenData     string
+		then         specter.SourceFormat
+		thenDetected bool
+	}{
+		{
+			name:         "GIVEN a yml extension THEN detect yaml",
+			whenLocation: "/specs/service.yml",
+			then:         specter.YAMLSourceFormat,
+			thenDetected: true,
+		},
+		{
+			name:         "GIVEN an uppercase extension THEN detect the format",
+			whenLocation: "/specs/service.HCL",
+			then:         specter.HCLSourceFormat,
+			thenDetected: true,
+		},
+		{
+			name:         "GIVEN a magic comment THEN it takes precedence over the extension",
+			whenLocation: "/specs/service.yaml",
+			whenData:     "# specter:format=hcl\nservice \"web\" {}\n",
+			then:         specter.HCLSourceFormat,
+			thenDetected: true,
+		},
+		{
+			name:         "GIVEN a slash magic comment THEN detect the format",
+			whenLocation: "/specs/service",
+			whenData:     "// specter:format=custom\n",
+			then:         "custom",
+			thenDetected: true,
+		},
+		{
+			name:         "GIVEN a shebang THEN detect the format",
+			whenLocation: "/specs/service",
+			whenData:     "#!/usr/bin/env specter --format=hcl\nservice \"web\" {}\n",
+			then:         specter.HCLSourceFormat,
+			thenDetected: true,
+		},
+		{
+			name:         "GIVEN a hint outside of a comment THEN ignore it",
+			whenLocation: "/specs/service.txt",
+			whenData:     "value specter:format=hcl\n",
+			then:         "txt",
+			thenDetected: true,
+		},
+		{
+			name:         "GIVEN an unknown extension with HCL content THEN sniff hcl",
+			whenLocation: "/specs/service.spec",
+			whenData:     "service \"web\" {\n  image = \"nginx\"\n}\n",
+			then:         specter.HCLSourceFormat,
+			thenDetected: true,
+		},
+		{
+			name:         "GIVEN an extensionless file with JSON content THEN sniff json",
+			whenLocation: "/specs/service",
+			whenData:     `{"kind": "service", "id": "web"}`,
+			then:         specter.JSONSourceFormat,
+			thenDetected: true,
+		},
+		{
+			name:         "GIVEN an extensionless file with YAML content THEN sniff yaml",
+			whenLocation: "/specs/service",
+			whenData:     "kind: service\nid: web\n",
+			then:         specter.YAMLSourceFormat,
+			thenDetected: true,
+		},
+		{
+			name:         "GIVEN an unknown extension with plain text THEN fall back to the raw extension",
+			whenLocation: "/specs/notes.txt",
+			whenData:     "some notes",
+			then:         "txt",
+			thenDetected: true,
+		},
+		{
+			name:         "GIVEN an extensionless file with plain text THEN detect nothing",
+			whenLocation: "/specs/notes",
+			whenData:     "some notes",
+			then:         "",
+			thenDetected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, detected := specter.DefaultFormatDetector().DetectFormat(tt.whenLocation, []byte(tt.whenData))
+			assert.Equal(t, tt.then, format)
+			assert.Equal(t, tt.thenDetected, detected)
+		})
+	}
+}
+
+func TestFormatDetectorChain(t *testing.T) {
+	var calls []string
+	detector := func(name string, format specter.SourceFormat) specter.FormatDetector {
+		return specter.FormatDetectorFunc(func(location string, data []byte) (specter.SourceFormat, bool) {
+			calls = append(calls, name)
+			return format, format != ""
+		})
+	}
+
+	chain := specter.FormatDetectorChain{detector("first", ""), detector("second", "hcl"), detector("third", "json")}
+	format, ok := chain.DetectFormat("file", nil)
+
+	require.True(t, ok)
+	assert.Equal(t, specter.HCLSourceFormat, format)
+	assert.Equal(t, []string{"first", "second"}, calls)
+}
+
+func TestSourceLoaders_FormatDetector(t *testing.T) {
+	hclContent := "service \"web\" {}\n"
+
+	t.Run("FileSystemSourceLoader", func(t *testing.T) {
+		l := specter.NewFileSystemSourceLoader(&testutils.MockFileSystem{
+			Files: map[string][]byte{"/specs/service.spec": []byte(hclContent)},
+		})
+		sources, err := l.Load("/specs/service.spec")
+		require.NoError(t, err)
+		assert.Equal(t, specter.HCLSourceFormat, sources[0].Format)
+
+		l.FormatDetector = specter.ExtensionFormatDetector(map[string]specter.SourceFormat{"spec": "custom"})
+		sources, err = l.Load("/specs/service.spec")
+		require.NoError(t, err)
+		assert.Equal(t, specter.SourceFormat("custom"), sources[0].Format)
+	})
+
+	t.Run("StdinSourceLoader", func(t *testing.T) {
+		l := specter.StdinSourceLoader{Reader: strings.NewReader(hclContent)}
+		sources, err := l.Load("-")
+		require.NoError(t, err)
+		assert.Equal(t, specter.HCLSourceFormat, sources[0].Format)
+	})
+
+	t.Run("InlineSourceLoader", func(t *testing.T) {
+		l := specter.InlineSourceLoader{}
+		sources, err := l.Load("inline:" + hclContent)
+		require.NoError(t, err)
+		assert.Equal(t, specter.HCLSourceFormat, sources[0].Format)
+	})
+}
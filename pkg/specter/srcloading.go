@@ -19,7 +19,6 @@ import (
 	"github.com/morebec/go-errors/errors"
 	"io/fs"
 	"os"
-	"path"
 	"strings"
 )
 
@@ -51,12 +50,19 @@ type SourceLoader interface {
 	Load(location string) ([]Source, error)
 }
 
+const fileURIPrefix = "file://"
+
 // FileSystemSourceLoader is an implementation of a SourceLoader that loads files from a FileSystem.
+// Locations can either be plain paths or "file://" URIs.
 type FileSystemSourceLoader struct {
 	fs FileSystem
+
+	// FormatDetector is used to detect the format of loaded files. Defaults to DefaultFormatDetector.
+	FormatDetector FormatDetector
 }
 
 func (l FileSystemSourceLoader) Supports(target string) bool {
+	target = strings.TrimPrefix(target, fileURIPrefix)
 	if target == "" {
 		return false
 	}
@@ -76,6 +82,7 @@ func (l FileSystemSourceLoader) Supports(target string) bool {
 }
 
 func (l FileSystemSourceLoader) Load(location string) ([]Source, error) {
+	location = strings.TrimPrefix(location, fileURIPrefix)
 	if location == "" {
 		// This would indicate that the user forget to call the support method before calling this method.
 		return nil, errors.New("cannot load an empty location")
@@ -138,20 +145,24 @@ func (l FileSystemSourceLoader) loadFile(filePath string) ([]Source, error) {
 		return nil, errors.WrapWithMessage(err, errors.InternalErrorCode, fmt.Sprintf("failed loading file %s", filePath))
 	}
 
-	// Get format
-	exts := strings.SplitAfter(path.Ext(filePath), ".")
-	ext := exts[len(exts)-1]
-	format := SourceFormat(strings.Replace(ext, ".", "", 1))
-
 	return []Source{
 		{
 			Location: filePath,
 			Data:     bytes,
-			Format:   format,
+			Format:   detectFormat(l.FormatDetector, filePath, bytes),
 		},
 	}, nil
 }
 
+// detectFormat detects the format of a source using a FormatDetector, or the DefaultFormatDetector if it is nil.
+func detectFormat(d FormatDetector, location string, data []byte) SourceFormat {
+	if d == nil {
+		d = DefaultFormatDetector()
+	}
+	format, _ := d.DetectFormat(location, data)
+	return format
+}
+
 type FunctionalSourceLoader struct {
 	SupportsFunc func(location string) bool
 	LoadFunc     func(location string) ([]Source, error)
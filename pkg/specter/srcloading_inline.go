@@ -0,0 +1,152 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+const (
+	InlineSourceLocationPrefix  = "inline:"
+	DataURISourceLocationPrefix = "data:"
+)
+
+// InlineSourceLoader is an implementation of a SourceLoader that loads sources whose content is contained in the
+// location itself. It supports two kinds of locations:
+//
+//   - "inline:<content>" where the content is taken literally and has the format of the loader.
+//   - data URIs (RFC 2397) such as "data:application/json;base64,eyJhIjogMX0=" where the format is derived from the
+//     "format" parameter if any (e.g. "data:;format=hcl,..."), otherwise from the subtype of the media type
+//     (e.g. "text/x-hcl", "application/yaml" or "application/vnd.api+json"), and falls back to the format of the loader.
+//
+// When the loader has no format either, it is detected from the content using the FormatDetector.
+type InlineSourceLoader struct {
+	// Format of inline sources and data URIs that do not specify a format.
+	Format SourceFormat
+
+	// FormatDetector is used when no format is known. Defaults to DefaultFormatDetector.
+	FormatDetector FormatDetector
+}
+
+func (l InlineSourceLoader) Supports(location string) bool {
+	return strings.HasPrefix(location, InlineSourceLocationPrefix) || strings.HasPrefix(location, DataURISourceLocationPrefix)
+}
+
+func (l InlineSourceLoader) Load(location string) ([]Source, error) {
+	var data []byte
+	format := l.Format
+
+	switch {
+	case strings.HasPrefix(location, InlineSourceLocationPrefix):
+		data = []byte(strings.TrimPrefix(location, InlineSourceLocationPrefix))
+	case strings.HasPrefix(location, DataURISourceLocationPrefix):
+		var dataFormat SourceFormat
+		var err error
+		data, dataFormat, err = parseDataURI(location)
+		if err != nil {
+			return nil, err
+		}
+		if dataFormat != "" {
+			format = dataFormat
+		}
+	default:
+		return nil, errors.NewWithMessage(
+			UnsupportedSourceLocationErrorCode,
+			fmt.Sprintf("location %q is not an inline source", location),
+		)
+	}
+
+	if format == "" {
+		format = detectFormat(l.FormatDetector, location, data)
+	}
+
+	return []Source{
+		{
+			Location: location,
+			Data:     data,
+			Format:   format,
+		},
+	}, nil
+}
+
+// parseDataURI parses a data URI of the form data:[<mediatype>][;base64],<data>.
+func parseDataURI(location string) ([]byte, SourceFormat, error) {
+	header, content, found := strings.Cut(strings.TrimPrefix(location, DataURISourceLocationPrefix), ",")
+	if !found {
+		return nil, "", errors.NewWithMessage(SourceLoadingFailedErrorCode, "invalid data URI: missing comma")
+	}
+
+	isBase64 := false
+	if strings.HasSuffix(header, ";base64") {
+		isBase64 = true
+		header = strings.TrimSuffix(header, ";base64")
+	}
+
+	var format SourceFormat
+	if header != "" {
+		mediaType := header
+		if strings.HasPrefix(mediaType, ";") {
+			// RFC 2397 allows omitting the type while specifying parameters.
+			mediaType = "text/plain" + mediaType
+		}
+		typ, params, err := mime.ParseMediaType(mediaType)
+		if err != nil {
+			return nil, "", errors.WrapWithMessage(err, SourceLoadingFailedErrorCode, "invalid data URI media type")
+		}
+		format = formatFromMediaType(typ, params)
+	}
+
+	var data []byte
+	if isBase64 {
+		decoded, err := base64.StdEncoding.DecodeString(content)
+		if err != nil {
+			decoded, err = base64.RawStdEncoding.DecodeString(content)
+		}
+		if err != nil {
+			return nil, "", errors.WrapWithMessage(err, SourceLoadingFailedErrorCode, "invalid data URI base64 content")
+		}
+		data = decoded
+	} else {
+		unescaped, err := url.PathUnescape(content)
+		if err != nil {
+			return nil, "", errors.WrapWithMessage(err, SourceLoadingFailedErrorCode, "invalid data URI content")
+		}
+		data = []byte(unescaped)
+	}
+
+	return data, format, nil
+}
+
+func formatFromMediaType(typ string, params map[string]string) SourceFormat {
+	if f, ok := params["format"]; ok && f != "" {
+		return SourceFormat(f)
+	}
+
+	if typ == "text/plain" {
+		return ""
+	}
+
+	_, subtype, _ := strings.Cut(typ, "/")
+	if _, suffix, found := strings.Cut(subtype, "+"); found {
+		subtype = suffix
+	}
+
+	return SourceFormat(strings.TrimPrefix(subtype, "x-"))
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter_test
+
+import (
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestInlineSourceLoader_Supports(t *testing.T) {
+	l := specter.NewInlineSourceLoader("hcl")
+	assert.True(t, l.Supports("inline:service {}"))
+	assert.True(t, l.Supports("data:,hello"))
+	assert.False(t, l.Supports("./file.hcl"))
+	assert.False(t, l.Supports("-"))
+}
+
+func TestInlineSourceLoader_Load(t *testing.T) {
+	tests := []struct {
+		name       string
+		given      string
+		then       specter.Source
+		thenErrors require.ErrorAssertionFunc
+	}{
+		{
+			name:  "GIVEN an inline location THEN return its content with the default format",
+			given: `inline:service "web" {}`,
+			then:  specter.Source{Location: `inline:service "web" {}`, Data: []byte(`service "web" {}`), Format: "hcl"},
+		},
+		{
+			name:  "GIVEN a data URI without media type THEN use the default format",
+			given: "data:,service%20%22web%22%20%7B%7D",
+			then:  specter.Source{Location: "data:,service%20%22web%22%20%7B%7D", Data: []byte(`service "web" {}`), Format: "hcl"},
+		},
+		{
+			name:  "GIVEN a base64 data URI with a media type THEN derive the format from it",
+			given: "data:application/json;base64,eyJhIjogMX0=",
+			then:  specter.Source{Location: "data:application/json;base64,eyJhIjogMX0=", Data: []byte(`{"a": 1}`), Format: "json"},
+		},
+		{
+			name:  "GIVEN a data URI with an experimental media type THEN strip the x- prefix",
+			given: "data:text/x-yaml,a: 1",
+			then:  specter.Source{Location: "data:text/x-yaml,a: 1", Data: []byte(`a: 1`), Format: "yaml"},
+		},
+		{
+			name:  "GIVEN a data URI with a structured syntax suffix THEN use the suffix",
+			given: "data:application/vnd.api+json,{}",
+			then:  specter.Source{Location: "data:application/vnd.api+json,{}", Data: []byte(`{}`), Format: "json"},
+		},
+		{
+			name:  "GIVEN a data URI with a format parameter THEN use it",
+			given: "data:;format=yaml,a: 1",
+			then:  specter.Source{Location: "data:;format=yaml,a: 1", Data: []byte(`a: 1`), Format: "yaml"},
+		},
+		{
+			name:       "GIVEN a data URI without comma THEN return an error",
+			given:      "data:application/json",
+			thenErrors: testutils.RequireErrorWithCode(specter.SourceLoadingFailedErrorCode),
+		},
+		{
+			name:       "GIVEN a data URI with invalid base64 THEN return an error",
+			given:      "data:;base64,***",
+			thenErrors: testutils.RequireErrorWithCode(specter.SourceLoadingFailedErrorCode),
+		},
+		{
+			name:       "GIVEN an unsupported location THEN return an error",
+			given:      "file.hcl",
+			thenErrors: testutils.RequireErrorWithCode(specter.UnsupportedSourceLocationErrorCode),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := specter.NewInlineSourceLoader("hcl")
+			sources, err := l.Load(tt.given)
+			if tt.thenErrors != nil {
+				tt.thenErrors(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []specter.Source{tt.then}, sources)
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter
+
+import (
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// UnsupportedSourceLocationErrorCode is returned when no SourceLoader supports a given source location, or by a
+// SourceLoader asked to load a location it does not support.
+const UnsupportedSourceLocationErrorCode = "specter.source_loading.unsupported_location"
+
+const (
+	// AnySourceLocationScheme can be used to register a SourceLoader for all locations regardless of their scheme.
+	AnySourceLocationScheme = "*"
+
+	// NoSourceLocationScheme can be used to register a SourceLoader for locations without a scheme such as plain paths.
+	NoSourceLocationScheme = ""
+)
+
+// sourceLocationSchemeRegexp matches URI schemes (RFC 3986). Single letter schemes are excluded to avoid
+// mistaking Windows drive letters (e.g. "C:\specs") for schemes.
+var sourceLocationSchemeRegexp = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]+):`)
+
+// SourceLocationScheme returns the lower cased scheme of a source location (e.g. "file" for "file:///specs",
+// "git+file" for "git+file:///repo" or "embed" for "embed://specs") or NoSourceLocationScheme if it has none.
+func SourceLocationScheme(location string) string {
+	m := sourceLocationSchemeRegexp.FindStringSubmatch(location)
+	if m == nil {
+		return NoSourceLocationScheme
+	}
+	return strings.ToLower(m[1])
+}
+
+// SourceLoaderRegistration represents a SourceLoader registered for a given scheme in a SourceLoaderRegistry.
+type SourceLoaderRegistration struct {
+	Scheme   string
+	Priority int
+	Loader   SourceLoader
+
+	order int
+}
+
+// SourceLoaderRegistry is a SourceLoader routing source locations to the SourceLoader registered for their scheme.
+//
+// When multiple loaders are registered for the scheme of a location, they are consulted by descending priority,
+// then by order of registration, and only the first one supporting the location is used to load it.
+// Loaders registered with AnySourceLocationScheme are consulted along with the ones of the scheme.
+//
+// Loading a location that no loader supports results in an error with the code UnsupportedSourceLocationErrorCode.
+type SourceLoaderRegistry struct {
+	mu            sync.RWMutex
+	registrations []SourceLoaderRegistration
+}
+
+func NewSourceLoaderRegistry() *SourceLoaderRegistry {
+	return &SourceLoaderRegistry{}
+}
+
+// NewSourceLoaderRegistryOf returns a SourceLoaderRegistry consulting loaders for all schemes in the given order,
+// as the source loading stage of a Pipeline does. A SourceLoaderRegistry among the loaders keeps routing locations
+// by scheme and priority.
+func NewSourceLoaderRegistryOf(loaders ...SourceLoader) *SourceLoaderRegistry {
+	r := NewSourceLoaderRegistry()
+	for _, l := range loaders {
+		r.Register(AnySourceLocationScheme, 0, l)
+	}
+	return r
+}
+
+// Register registers a SourceLoader for a given scheme with a given priority.
+func (r *SourceLoaderRegistry) Register(scheme string, priority int, loader SourceLoader) *SourceLoaderRegistry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.registrations = append(r.registrations, SourceLoaderRegistration{
+		Scheme:   strings.ToLower(scheme),
+		Priority: priority,
+		Loader:   loader,
+		order:    len(r.registrations),
+	})
+
+	sort.SliceStable(r.registrations, func(i, j int) bool {
+		if r.registrations[i].Priority != r.registrations[j].Priority {
+			return r.registrations[i].Priority > r.registrations[j].Priority
+		}
+		return r.registrations[i].order < r.registrations[j].order
+	})
+
+	return r
+}
+
+// Registrations returns the registrations of this registry in the order they are consulted.
+func (r *SourceLoaderRegistry) Registrations() []SourceLoaderRegistration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]SourceLoaderRegistration(nil), r.registrations...)
+}
+
+// Resolve returns the SourceLoader that should be used to load a given location.
+func (r *SourceLoaderRegistry) Resolve(location string) (SourceLoader, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	scheme := SourceLocationScheme(location)
+	for _, reg := range r.registrations {
+		if reg.Scheme != scheme && reg.Scheme != AnySourceLocationScheme {
+			continue
+		}
+		if reg.Loader.Supports(location) {
+			return reg.Loader, nil
+		}
+	}
+
+	return nil, newUnsupportedSourceLocationError(location)
+}
+
+func (r *SourceLoaderRegistry) Supports(location string) bool {
+	_, err := r.Resolve(location)
+	return err == nil
+}
+
+func (r *SourceLoaderRegistry) Load(location string) ([]Source, error) {
+	l, err := r.Resolve(location)
+	if err != nil {
+		return nil, err
+	}
+	return l.Load(location)
+}
+
+func newUnsupportedSourceLocationError(location string) error {
+	scheme := SourceLocationScheme(location)
+	msg := fmt.Sprintf("no source loader supports location %q", location)
+	if scheme != NoSourceLocationScheme {
+		msg = fmt.Sprintf("%s with scheme %q", msg, scheme)
+	}
+	return errors.NewWithMessage(UnsupportedSourceLocationErrorCode, msg)
+}
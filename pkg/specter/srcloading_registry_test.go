@@ -0,0 +1,149 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter_test
+
+import (
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var _ specter.SourceLoader = (*specter.SourceLoaderRegistry)(nil)
+
+func TestSourceLocationScheme(t *testing.T) {
+	tests := []struct {
+		given string
+		then  string
+	}{
+		{given: "/specs/service.hcl", then: ""},
+		{given: "./specs", then: ""},
+		{given: "-", then: ""},
+		{given: `C:\specs\service.hcl`, then: ""},
+		{given: "file:///specs/service.hcl", then: "file"},
+		{given: "HTTP://example.com/service.hcl", then: "http"},
+		{given: "git+file:///repo//specs", then: "git+file"},
+		{given: "embed://specs", then: "embed"},
+		{given: "inline:service {}", then: "inline"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.given, func(t *testing.T) {
+			assert.Equal(t, tt.then, specter.SourceLocationScheme(tt.given))
+		})
+	}
+}
+
+func TestSourceLoaderRegistry_Load(t *testing.T) {
+	loaderReturning := func(location string, supports bool) specter.SourceLoader {
+		return specter.FunctionalSourceLoader{
+			SupportsFunc: func(string) bool { return supports },
+			LoadFunc: func(string) ([]specter.Source, error) {
+				return []specter.Source{{Location: location}}, nil
+			},
+		}
+	}
+
+	t.Run("GIVEN loaders for different schemes THEN route by scheme", func(t *testing.T) {
+		r := specter.NewSourceLoaderRegistry().
+			Register("http", 0, loaderReturning("http", true)).
+			Register(specter.NoSourceLocationScheme, 0, loaderReturning("path", true))
+
+		sources, err := r.Load("http://example.com/service.hcl")
+		require.NoError(t, err)
+		assert.Equal(t, "http", sources[0].Location)
+
+		sources, err = r.Load("/specs/service.hcl")
+		require.NoError(t, err)
+		assert.Equal(t, "path", sources[0].Location)
+	})
+
+	t.Run("GIVEN multiple loaders for a scheme THEN only the first by priority is used", func(t *testing.T) {
+		r := specter.NewSourceLoaderRegistry().
+			Register("file", 0, loaderReturning("low", true)).
+			Register("file", 10, loaderReturning("unsupported", false)).
+			Register("file", 10, loaderReturning("high", true)).
+			Register("file", 10, loaderReturning("high-registered-later", true))
+
+		sources, err := r.Load("file:///specs")
+		require.NoError(t, err)
+		assert.Equal(t, []specter.Source{{Location: "high"}}, sources)
+	})
+
+	t.Run("GIVEN a wildcard loader THEN it is consulted for all schemes", func(t *testing.T) {
+		r := specter.NewSourceLoaderRegistry().
+			Register(specter.AnySourceLocationScheme, 0, loaderReturning("any", true))
+
+		sources, err := r.Load("embed://specs")
+		require.NoError(t, err)
+		assert.Equal(t, "any", sources[0].Location)
+	})
+
+	t.Run("GIVEN no loader supports a location THEN return an error", func(t *testing.T) {
+		r := specter.NewSourceLoaderRegistry().
+			Register("file", 0, loaderReturning("file", true))
+
+		assert.False(t, r.Supports("http://example.com"))
+		_, err := r.Load("http://example.com")
+		testutils.RequireErrorWithCode(specter.UnsupportedSourceLocationErrorCode)(t, err)
+		assert.ErrorContains(t, err, `scheme "http"`)
+	})
+}
+
+func TestNewDefaultSourceLoaderRegistry(t *testing.T) {
+	mfs := &specter.MemoryFileSystem{}
+	require.NoError(t, mfs.Mkdir("/specs", 0755))
+	require.NoError(t, mfs.WriteFile("/specs/service.hcl", []byte(`service "web" {}`), 0644))
+
+	r := specter.NewDefaultSourceLoaderRegistry(mfs)
+
+	for _, location := range []string{"/specs/service.hcl", "file:///specs/service.hcl"} {
+		sources, err := r.Load(location)
+		require.NoError(t, err)
+		assert.Equal(t, []specter.Source{
+			{Location: "/specs/service.hcl", Data: []byte(`service "web" {}`), Format: specter.HCLSourceFormat},
+		}, sources)
+	}
+
+	sources, err := r.Load(`inline:service "web" {}`)
+	require.NoError(t, err)
+	assert.Equal(t, specter.HCLSourceFormat, sources[0].Format)
+
+	_, err = r.Load("/specs/typo.hcl")
+	testutils.RequireErrorWithCode(specter.UnsupportedSourceLocationErrorCode)(t, err)
+}
+
+func TestNewSourceLoaderRegistryOf(t *testing.T) {
+	mfs := &specter.MemoryFileSystem{}
+	require.NoError(t, mfs.Mkdir("/specs", 0755))
+	require.NoError(t, mfs.WriteFile("/specs/service.hcl", []byte(`service "web" {}`), 0644))
+
+	r := specter.NewSourceLoaderRegistryOf(
+		specter.NewInlineSourceLoader("yaml"),
+		specter.NewInlineSourceLoader("hcl"),
+		specter.NewDefaultSourceLoaderRegistry(mfs),
+	)
+
+	sources, err := r.Load("inline:a: 1")
+	require.NoError(t, err)
+	assert.Equal(t, specter.SourceFormat("yaml"), sources[0].Format, "loaders should be consulted in order")
+
+	sources, err = r.Load("file:///specs/service.hcl")
+	require.NoError(t, err)
+	assert.Equal(t, "/specs/service.hcl", sources[0].Location)
+
+	_, err = r.Load("http://example.com/service.hcl")
+	testutils.RequireErrorWithCode(specter.UnsupportedSourceLocationErrorCode)(t, err)
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter
+
+import (
+	"github.com/morebec/go-errors/errors"
+	"io"
+)
+
+// StdinSourceLocation is the location designating the standard input.
+const StdinSourceLocation = "-"
+
+// StdinSourceLoader is an implementation of a SourceLoader that reads a source from the standard input when
+// given the location "-". Since the standard input does not carry any information about the format of its content,
+// the format should be declared upfront, otherwise it is detected from the content using the FormatDetector.
+//
+// This is useful for piping and editor integrations where a buffer must be loaded without being written to disk first.
+type StdinSourceLoader struct {
+	// Reader from which the source is read, usually os.Stdin.
+	Reader io.Reader
+
+	// Format of the source read from the Reader.
+	Format SourceFormat
+
+	// FormatDetector is used when no Format is declared. Defaults to DefaultFormatDetector.
+	FormatDetector FormatDetector
+}
+
+func (l StdinSourceLoader) Supports(location string) bool {
+	return location == StdinSourceLocation
+}
+
+func (l StdinSourceLoader) Load(location string) ([]Source, error) {
+	if !l.Supports(location) {
+		return nil, errors.NewWithMessage(UnsupportedSourceLocationErrorCode, "cannot load location "+location+" from stdin")
+	}
+
+	data, err := io.ReadAll(l.Reader)
+	if err != nil {
+		return nil, errors.WrapWithMessage(err, errors.InternalErrorCode, "failed reading source from stdin")
+	}
+
+	format := l.Format
+	if format == "" {
+		format = detectFormat(l.FormatDetector, location, data)
+	}
+
+	return []Source{
+		{
+			Location: location,
+			Data:     data,
+			Format:   format,
+		},
+	}, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter_test
+
+import (
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestStdinSourceLoader_Supports(t *testing.T) {
+	l := specter.NewStdinSourceLoader("hcl")
+	assert.True(t, l.Supports("-"))
+	assert.False(t, l.Supports(""))
+	assert.False(t, l.Supports("./file.hcl"))
+}
+
+func TestStdinSourceLoader_Load(t *testing.T) {
+	t.Run("GIVEN data on stdin THEN return a source with the declared format", func(t *testing.T) {
+		l := specter.StdinSourceLoader{Reader: strings.NewReader(`service "web" {}`), Format: "hcl"}
+		sources, err := l.Load("-")
+		require.NoError(t, err)
+		assert.Equal(t, []specter.Source{
+			{Location: "-", Data: []byte(`service "web" {}`), Format: "hcl"},
+		}, sources)
+	})
+
+	t.Run("GIVEN an unsupported location THEN return an error", func(t *testing.T) {
+		l := specter.StdinSourceLoader{Reader: strings.NewReader(""), Format: "hcl"}
+		_, err := l.Load("file.hcl")
+		testutils.RequireErrorWithCode(specter.UnsupportedSourceLocationErrorCode)(t, err)
+	})
+
+	t.Run("GIVEN stdin cannot be read THEN return an error", func(t *testing.T) {
+		l := specter.StdinSourceLoader{Reader: iotest.ErrReader(assert.AnError), Format: "hcl"}
+		_, err := l.Load("-")
+		require.ErrorIs(t, err, assert.AnError)
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter
+
+import "fmt"
+
+// SourcePos represents a position in a Source.
+type SourcePos struct {
+	// Line is the 1-based line number.
+	Line int
+
+	// Column is the 1-based column number.
+	Column int
+
+	// Byte is the 0-based byte offset.
+	Byte int
+}
+
+// SourceRange represents a range of a Source, such as the definition of a Unit or of one of its attributes.
+type SourceRange struct {
+	// Filename is the location of the Source.
+	Filename string
+	Start    SourcePos
+	End      SourcePos
+}
+
+// IsZero indicates if this range is undefined.
+func (r SourceRange) IsZero() bool {
+	return r == SourceRange{}
+}
+
+// String returns the range in the conventional "file:line:column" form pointing to its start.
+func (r SourceRange) String() string {
+	return fmt.Sprintf("%s:%d:%d", r.Filename, r.Start.Line, r.Start.Column)
+}
+
+// HasSourceRange can be implemented by units, or parts of units, that know the exact range of the Source
+// in which they were defined.
+type HasSourceRange interface {
+	SourceRange() SourceRange
+}
+
+// UnitSourceRange returns the SourceRange of a Unit when it implements HasSourceRange, or a zero SourceRange otherwise.
+func UnitSourceRange(u Unit) SourceRange {
+	if r, ok := u.(HasSourceRange); ok {
+		return r.SourceRange()
+	}
+	return SourceRange{}
+}
+
+// UnitLocation returns a human-readable location of a Unit to be used in messages. It is the start of its
+// SourceRange (e.g. "file.hcl:12:3") when the unit implements HasSourceRange, or the location of its Source otherwise.
+func UnitLocation(u Unit) string {
+	if r := UnitSourceRange(u); !r.IsZero() {
+		return r.String()
+	}
+	return u.Source().Location
+}
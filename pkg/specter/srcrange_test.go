@@ -0,0 +1,70 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specter_test
+
+import (
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+type unitWithRange struct {
+	specter.Unit
+	srcRange specter.SourceRange
+}
+
+func (u unitWithRange) SourceRange() specter.SourceRange {
+	return u.srcRange
+}
+
+func TestUnitLocation(t *testing.T) {
+	src := specter.Source{Location: "/specs/billing.hcl"}
+	tests := []struct {
+		name  string
+		given specter.Unit
+		then  string
+	}{
+		{
+			name:  "GIVEN a unit without range THEN return the location of its source",
+			given: testutils.NewUnitStub("billing", "service", src),
+			then:  "/specs/billing.hcl",
+		},
+		{
+			name: "GIVEN a unit with an undefined range THEN return the location of its source",
+			given: unitWithRange{
+				Unit: testutils.NewUnitStub("billing", "service", src),
+			},
+			then: "/specs/billing.hcl",
+		},
+		{
+			name: "GIVEN a unit with a range THEN return the start of its range",
+			given: unitWithRange{
+				Unit: testutils.NewUnitStub("billing", "service", src),
+				srcRange: specter.SourceRange{
+					Filename: "/specs/billing.hcl",
+					Start:    specter.SourcePos{Line: 12, Column: 3, Byte: 120},
+					End:      specter.SourcePos{Line: 14, Column: 1, Byte: 150},
+				},
+			},
+			then: "/specs/billing.hcl:12:3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.then, specter.UnitLocation(tt.given))
+		})
+	}
+}
@@ -17,6 +17,9 @@ package specter
 // UnsupportedSourceErrorCode ErrorSeverity code returned by a UnitLoader when a given loader does not support a certain source.
 const UnsupportedSourceErrorCode = "specter.spec_loading.unsupported_source"
 
+// UnhandledSourceErrorCode is returned by the unit loading stage when no UnitLoader supports a given source.
+const UnhandledSourceErrorCode = "specter.unit_loading.unhandled_source"
+
 type UnitKind string
 
 type UnitID string
@@ -0,0 +1,175 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterlsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const InvalidMessageErrorCode = "specterlsp.invalid_message"
+
+// MalformedMessageErrorCode is returned when a message was read but could not be decoded. Unlike other errors, the
+// following messages can still be read.
+const MalformedMessageErrorCode = "specterlsp.malformed_message"
+
+// JSON-RPC error codes.
+const (
+	ParseErrorCode     = -32700
+	InvalidRequestCode = -32600
+	MethodNotFoundCode = -32601
+	InvalidParamsCode  = -32602
+	InternalErrorCode  = -32603
+)
+
+// Message represents a JSON-RPC 2.0 message. It is a request when it has both an ID and a Method, a notification when
+// it only has a Method, and a response otherwise.
+type Message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *ResponseError   `json:"error,omitempty"`
+}
+
+// IsRequest indicates if this message is a request expecting a response.
+func (m Message) IsRequest() bool {
+	return m.Method != "" && m.ID != nil
+}
+
+// IsResponse indicates if this message is a response to a request.
+func (m Message) IsResponse() bool {
+	return m.Method == "" && m.ID != nil && (m.Result != nil || m.Error != nil)
+}
+
+// IsNotification indicates if this message is a notification not expecting a response.
+func (m Message) IsNotification() bool {
+	return m.Method != "" && m.ID == nil
+}
+
+// ResponseError represents the error of a JSON-RPC response.
+type ResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// Conn reads and writes JSON-RPC messages framed with a Content-Length header, as done by the
+// Language Server Protocol. It is safe to write messages concurrently.
+type Conn struct {
+	reader *bufio.Reader
+	writer io.Writer
+	mu     sync.Mutex
+}
+
+func NewConn(r io.Reader, w io.Writer) *Conn {
+	return &Conn{reader: bufio.NewReader(r), writer: w}
+}
+
+// ReadMessage reads the next message. It returns io.EOF when there are no more messages, and an error with
+// MalformedMessageErrorCode when the message could not be decoded.
+func (c *Conn) ReadMessage() (Message, error) {
+	header, err := textproto.NewReader(c.reader).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return Message{}, io.EOF
+		}
+		var protocolErr textproto.ProtocolError
+		if errors.As(err, &protocolErr) {
+			return Message{}, errors.WrapWithMessage(err, MalformedMessageErrorCode, "malformed message header")
+		}
+		return Message{}, errors.WrapWithMessage(err, InvalidMessageErrorCode, "failed reading message header")
+	}
+
+	length, err := strconv.Atoi(strings.TrimSpace(header.Get("Content-Length")))
+	if err != nil || length < 0 {
+		return Message{}, errors.NewWithMessage(MalformedMessageErrorCode, "missing or invalid Content-Length header")
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return Message{}, io.EOF
+		}
+		return Message{}, errors.WrapWithMessage(err, InvalidMessageErrorCode, "failed reading message content")
+	}
+
+	var m Message
+	if err := json.Unmarshal(data, &m); err != nil {
+		return Message{}, errors.WrapWithMessage(err, MalformedMessageErrorCode, "failed decoding message content")
+	}
+	return m, nil
+}
+
+// WriteMessage writes a message.
+func (c *Conn) WriteMessage(m Message) error {
+	m.JSONRPC = "2.0"
+	data, err := json.Marshal(m)
+	if err != nil {
+		return errors.WrapWithMessage(err, InvalidMessageErrorCode, "failed encoding message")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := fmt.Fprintf(c.writer, "Content-Length: %d\r\n\r\n", len(data)); err != nil {
+		return err
+	}
+	_, err = c.writer.Write(data)
+	return err
+}
+
+// Notify writes a notification.
+func (c *Conn) Notify(method string, params any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return errors.WrapWithMessage(err, InvalidMessageErrorCode, "failed encoding notification params")
+	}
+	return c.WriteMessage(Message{Method: method, Params: data})
+}
+
+// Call writes a request.
+func (c *Conn) Call(id int, method string, params any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return errors.WrapWithMessage(err, InvalidMessageErrorCode, "failed encoding request params")
+	}
+	rawID := json.RawMessage(strconv.Itoa(id))
+	return c.WriteMessage(Message{ID: &rawID, Method: method, Params: data})
+}
+
+// Reply writes the response to a request.
+func (c *Conn) Reply(id *json.RawMessage, result any, respErr *ResponseError) error {
+	m := Message{ID: id, Error: respErr}
+	if respErr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return errors.WrapWithMessage(err, InvalidMessageErrorCode, "failed encoding response result")
+		}
+		m.Result = data
+	}
+	return c.WriteMessage(m)
+}
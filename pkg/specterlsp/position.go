@@ -0,0 +1,107 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterlsp
+
+import (
+	"bytes"
+	"github.com/morebec/specter/pkg/specter"
+	"net/url"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// uriToPath returns the path of a file URI, or the URI itself when it is not a file URI.
+func uriToPath(uri DocumentURI) string {
+	if uri == "" {
+		return ""
+	}
+	u, err := url.Parse(string(uri))
+	if err != nil || u.Scheme != "file" {
+		return string(uri)
+	}
+	return u.Path
+}
+
+func pathToURI(path string) DocumentURI {
+	return DocumentURI((&url.URL{Scheme: "file", Path: path}).String())
+}
+
+// lspPosition converts a specter.SourcePos to a Position. When the data of the source is known, the character offset
+// is computed in UTF-16 code units as required by the protocol, otherwise the column is used as is.
+func lspPosition(data []byte, pos specter.SourcePos) Position {
+	line := pos.Line - 1
+	if line < 0 {
+		line = 0
+	}
+
+	if data == nil || pos.Byte < 0 || pos.Byte > len(data) || (pos.Byte == 0 && pos.Line > 1) {
+		character := pos.Column - 1
+		if character < 0 {
+			character = 0
+		}
+		return Position{Line: line, Character: character}
+	}
+
+	lineStart := bytes.LastIndexByte(data[:pos.Byte], '\n') + 1
+	return Position{Line: line, Character: utf16Len(data[lineStart:pos.Byte])}
+}
+
+// byteOffset converts a Position to a byte offset in some data.
+func byteOffset(data []byte, pos Position) int {
+	offset := 0
+	for line := 0; line < pos.Line; line++ {
+		i := bytes.IndexByte(data[offset:], '\n')
+		if i < 0 {
+			return len(data)
+		}
+		offset += i + 1
+	}
+
+	for character := 0; character < pos.Character && offset < len(data) && data[offset] != '\n'; {
+		r, size := utf8.DecodeRune(data[offset:])
+		character += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+func utf16Len(data []byte) int {
+	n := 0
+	for _, r := range string(data) {
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return n
+}
+
+// tokenDelimiters are the characters delimiting the tokens that can reference a unit.
+const tokenDelimiters = " \t\r\n\"'`[]{}(),=:#"
+
+// tokenAt returns the token surrounding a byte offset of a text, e.g. "billing" in `depends_on = ["billing"]`.
+func tokenAt(text string, offset int) string {
+	if offset < 0 || offset > len(text) {
+		return ""
+	}
+
+	start := offset
+	for start > 0 && !strings.ContainsRune(tokenDelimiters, rune(text[start-1])) {
+		start--
+	}
+	end := offset
+	for end < len(text) && !strings.ContainsRune(tokenDelimiters, rune(text[end])) {
+		end++
+	}
+	return text[start:end]
+}
@@ -0,0 +1,617 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterlsp
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const ServerName = "specter-lsp"
+
+// DiagnosticSource is the source of the diagnostics published by the Server.
+const DiagnosticSource = "specter"
+
+// Server is a Language Server Protocol server providing editor feedback for the DSLs of a specter pipeline:
+//   - diagnostics when documents are opened or saved, made of the errors of the UnitLoaders and Preprocessors and the
+//     results of the Linters;
+//   - go-to-definition of the units referenced by their ID, e.g. in "depends_on = ["billing"]" or "service.billing";
+//   - document symbols for the units of a document and their attributes.
+//
+// On every analysis, the sources of the workspace are loaded with the SourceLoaders, and the content of the documents
+// opened in the editor takes precedence over the one of the sources.
+type Server struct {
+	SourceLoaders []specter.SourceLoader
+	UnitLoaders   []specter.UnitLoader
+
+	// Preprocessors are run on the loaded units before linting, e.g. to resolve the references between units.
+	Preprocessors []specter.UnitPreprocessor
+
+	Linters []specterutils.UnitLinter
+
+	// Locations of the sources of the workspace. Defaults to the root of the workspace provided by the client.
+	Locations []string
+
+	// FormatDetector is used to detect the format of the open documents that are not part of the Locations.
+	// Defaults to specter.DefaultFormatDetector.
+	FormatDetector specter.FormatDetector
+
+	conn      *Conn
+	rootPath  string
+	documents map[string]string
+	analyzed  bool
+	units     []specter.Unit
+	sources   map[string]specter.Source
+	published map[string]struct{}
+}
+
+// NewServer returns a Server reusing the SourceLoaders, UnitLoaders and UnitPreprocessors of a pipeline. Its
+// UnitPreprocessors and UnitProcessors that are also a specterutils.UnitLinter, such as a specterutils.LintingProcessor,
+// are used as Linters instead.
+func NewServer(b specter.PipelineBuilder) *Server {
+	s := &Server{
+		SourceLoaders: b.SourceLoaders,
+		UnitLoaders:   b.UnitLoaders,
+	}
+	for _, p := range b.UnitPreprocessors {
+		if _, ok := p.(specterutils.UnitLinter); !ok {
+			s.Preprocessors = append(s.Preprocessors, p)
+		}
+	}
+
+	var candidates []any
+	for _, p := range b.UnitPreprocessors {
+		candidates = append(candidates, p)
+	}
+	for _, p := range b.UnitProcessors {
+		candidates = append(candidates, p)
+	}
+
+	var seen []any
+	for _, c := range candidates {
+		l, ok := c.(specterutils.UnitLinter)
+		if !ok || containsComparable(seen, c) {
+			continue
+		}
+		seen = append(seen, c)
+		s.Linters = append(s.Linters, l)
+	}
+
+	return s
+}
+
+// containsComparable indicates if a value is in a list, considering only comparable values since the same processor
+// can be registered both as a UnitPreprocessor and a UnitProcessor.
+func containsComparable(values []any, v any) bool {
+	if !reflect.TypeOf(v).Comparable() {
+		return false
+	}
+	for _, c := range values {
+		if reflect.TypeOf(c) == reflect.TypeOf(v) && c == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeStdio serves the client connected to the standard input and output of the process.
+func (s *Server) ServeStdio(ctx context.Context) error {
+	return s.Serve(ctx, os.Stdin, os.Stdout)
+}
+
+// Serve serves a client reading messages from a reader and writing messages to a writer until the client
+// sends the exit notification, the reader is closed or the context is canceled. Malformed and invalid messages are
+// replied to with an error, without interrupting the session.
+func (s *Server) Serve(ctx context.Context, r io.Reader, w io.Writer) error {
+	s.conn = NewConn(r, w)
+	s.documents = map[string]string{}
+	s.published = map[string]struct{}{}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		m, err := s.conn.ReadMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if errors.HasCode(err, MalformedMessageErrorCode) {
+			if err := s.conn.Reply(nullID(), nil, &ResponseError{Code: ParseErrorCode, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if m.JSONRPC != "2.0" || (!m.IsRequest() && !m.IsNotification() && !m.IsResponse()) {
+			id := m.ID
+			if id == nil {
+				id = nullID()
+			}
+			if err := s.conn.Reply(id, nil, &ResponseError{Code: InvalidRequestCode, Message: "invalid request"}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		exit, err := s.handle(m)
+		if err != nil {
+			return err
+		}
+		if exit {
+			return nil
+		}
+	}
+}
+
+// nullID returns the ID of the responses to messages whose ID could not be determined.
+func nullID() *json.RawMessage {
+	id := json.RawMessage("null")
+	return &id
+}
+
+// handle handles a message and indicates if the server should exit.
+func (s *Server) handle(m Message) (bool, error) {
+	switch m.Method {
+	case MethodInitialize:
+		var params InitializeParams
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return false, s.replyInvalidParams(m, err)
+		}
+		s.rootPath = uriToPath(params.RootURI)
+		if s.rootPath == "" {
+			s.rootPath = params.RootPath
+		}
+		if s.rootPath == "" && len(params.WorkspaceFolders) != 0 {
+			s.rootPath = uriToPath(params.WorkspaceFolders[0].URI)
+		}
+		return false, s.conn.Reply(m.ID, InitializeResult{
+			Capabilities: ServerCapabilities{
+				TextDocumentSync: TextDocumentSyncOptions{
+					OpenClose: true,
+					Change:    TextDocumentSyncFull,
+					Save:      SaveOptions{IncludeText: true},
+				},
+				DefinitionProvider:     true,
+				DocumentSymbolProvider: true,
+			},
+			ServerInfo: &ServerInfo{Name: ServerName},
+		}, nil)
+
+	case MethodShutdown:
+		return false, s.conn.Reply(m.ID, nil, nil)
+
+	case MethodExit:
+		return true, nil
+
+	case MethodDidOpen:
+		var params DidOpenTextDocumentParams
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return false, nil
+		}
+		path := uriToPath(params.TextDocument.URI)
+		s.documents[path] = params.TextDocument.Text
+		return false, s.publishDiagnostics(path)
+
+	case MethodDidChange:
+		var params DidChangeTextDocumentParams
+		if err := json.Unmarshal(m.Params, &params); err != nil || len(params.ContentChanges) == 0 {
+			return false, nil
+		}
+		// Documents are fully synced, the last change contains the whole content of the document.
+		s.documents[uriToPath(params.TextDocument.URI)] = params.ContentChanges[len(params.ContentChanges)-1].Text
+		return false, nil
+
+	case MethodDidSave:
+		var params DidSaveTextDocumentParams
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return false, nil
+		}
+		path := uriToPath(params.TextDocument.URI)
+		if params.Text != nil {
+			s.documents[path] = *params.Text
+		}
+		return false, s.publishDiagnostics(path)
+
+	case MethodDidClose:
+		var params DidCloseTextDocumentParams
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return false, nil
+		}
+		delete(s.documents, uriToPath(params.TextDocument.URI))
+		return false, nil
+
+	case MethodDefinition:
+		var params TextDocumentPositionParams
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return false, s.replyInvalidParams(m, err)
+		}
+		return false, s.conn.Reply(m.ID, s.definition(params), nil)
+
+	case MethodDocumentSymbol:
+		var params DocumentSymbolParams
+		if err := json.Unmarshal(m.Params, &params); err != nil {
+			return false, s.replyInvalidParams(m, err)
+		}
+		return false, s.conn.Reply(m.ID, s.documentSymbols(params), nil)
+	}
+
+	if m.IsRequest() {
+		return false, s.conn.Reply(m.ID, nil, &ResponseError{
+			Code:    MethodNotFoundCode,
+			Message: "method not found: " + m.Method,
+		})
+	}
+
+	return false, nil
+}
+
+func (s *Server) replyInvalidParams(m Message, err error) error {
+	if !m.IsRequest() {
+		return nil
+	}
+	return s.conn.Reply(m.ID, nil, &ResponseError{Code: InvalidParamsCode, Message: err.Error()})
+}
+
+// publishDiagnostics analyzes the workspace and publishes the diagnostics of all its documents. Diagnostics
+// that cannot be related to a document are published for the document that triggered the analysis.
+func (s *Server) publishDiagnostics(trigger string) error {
+	diagnostics := s.analyze(trigger)
+
+	var paths []string
+	for path := range diagnostics {
+		paths = append(paths, path)
+	}
+	// Clear the diagnostics previously published for documents that no longer have any.
+	for path := range s.published {
+		if _, ok := diagnostics[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	s.published = map[string]struct{}{}
+	for _, path := range paths {
+		diags := diagnostics[path]
+		if diags == nil {
+			diags = []Diagnostic{}
+		}
+		if len(diags) != 0 {
+			s.published[path] = struct{}{}
+		}
+
+		if err := s.conn.Notify(MethodPublishDiagnostics, PublishDiagnosticsParams{
+			URI:         pathToURI(path),
+			Diagnostics: diags,
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// analyze loads and lints the units of the workspace and returns the diagnostics by document path.
+func (s *Server) analyze(trigger string) map[string][]Diagnostic {
+	diagnostics := map[string][]Diagnostic{}
+	if trigger != "" {
+		diagnostics[trigger] = nil
+	}
+
+	sources, err := s.loadSources()
+	if err != nil {
+		for _, e := range specterutils.FlattenErrors(err) {
+			s.appendDiagnostic(diagnostics, trigger, specter.SourceRange{}, DiagnosticSeverityError, e.Error())
+		}
+	}
+
+	s.sources = map[string]specter.Source{}
+	var units []specter.Unit
+	for _, src := range sources {
+		s.sources[src.Location] = src
+		if _, ok := diagnostics[src.Location]; !ok {
+			diagnostics[src.Location] = nil
+		}
+
+		loaded, err := s.loadUnits(src)
+		units = append(units, loaded...)
+		for _, e := range specterutils.FlattenErrors(err) {
+			r, msg := diagnosticOfError(e)
+			if r.Filename == "" {
+				r.Filename = src.Location
+			}
+			s.appendDiagnostic(diagnostics, src.Location, r, DiagnosticSeverityError, msg)
+		}
+	}
+
+	units, err = s.preprocessUnits(units)
+	for _, e := range specterutils.FlattenErrors(err) {
+		r, msg := diagnosticOfError(e)
+		s.appendDiagnostic(diagnostics, trigger, r, DiagnosticSeverityError, msg)
+	}
+
+	for _, l := range s.Linters {
+		for _, result := range l.Lint(units) {
+			severity := DiagnosticSeverityError
+			if result.Severity == specterutils.WarningSeverity {
+				severity = DiagnosticSeverityWarning
+			}
+			s.appendDiagnostic(diagnostics, trigger, result.Range, severity, result.Message)
+		}
+	}
+
+	s.units = units
+	s.analyzed = true
+
+	return diagnostics
+}
+
+func (s *Server) appendDiagnostic(
+	diagnostics map[string][]Diagnostic,
+	fallbackPath string,
+	r specter.SourceRange,
+	severity DiagnosticSeverity,
+	msg string,
+) {
+	path := r.Filename
+	if path == "" {
+		path = fallbackPath
+	}
+	if path == "" {
+		return
+	}
+
+	diagnostics[path] = append(diagnostics[path], Diagnostic{
+		Range:    s.lspRange(r),
+		Severity: severity,
+		Source:   DiagnosticSource,
+		Message:  msg,
+	})
+}
+
+// loadSources loads the sources of the workspace, along with the open documents.
+func (s *Server) loadSources() ([]specter.Source, error) {
+	locations := s.Locations
+	if len(locations) == 0 && s.rootPath != "" {
+		locations = []string{s.rootPath}
+	}
+
+	errs := errors.NewGroup(specter.SourceLoadingFailedErrorCode)
+	var sources []specter.Source
+	loaded := map[string]bool{}
+	for _, location := range locations {
+		loader := s.sourceLoader(location)
+		if loader == nil {
+			continue
+		}
+		locSources, err := loader.Load(location)
+		if err != nil {
+			errs = errs.Append(err)
+			continue
+		}
+		for _, src := range locSources {
+			if text, ok := s.documents[src.Location]; ok {
+				src.Data = []byte(text)
+			}
+			loaded[src.Location] = true
+			sources = append(sources, src)
+		}
+	}
+
+	var documents []string
+	for path := range s.documents {
+		if !loaded[path] {
+			documents = append(documents, path)
+		}
+	}
+	sort.Strings(documents)
+
+	detector := s.FormatDetector
+	if detector == nil {
+		detector = specter.DefaultFormatDetector()
+	}
+	for _, path := range documents {
+		data := []byte(s.documents[path])
+		format, _ := detector.DetectFormat(path, data)
+		sources = append(sources, specter.Source{Location: path, Data: data, Format: format})
+	}
+
+	return sources, errors.GroupOrNil(errs)
+}
+
+func (s *Server) sourceLoader(location string) specter.SourceLoader {
+	for _, l := range s.SourceLoaders {
+		if l.Supports(location) {
+			return l
+		}
+	}
+	return nil
+}
+
+// loadUnits loads the units of a source with all the UnitLoaders supporting it, like a pipeline.
+func (s *Server) loadUnits(src specter.Source) ([]specter.Unit, error) {
+	errs := errors.NewGroup(specter.UnitLoadingFailedErrorCode)
+	var units []specter.Unit
+	for _, l := range s.UnitLoaders {
+		if !l.SupportsSource(src) {
+			continue
+		}
+		loaded, err := l.Load(src)
+		if err != nil {
+			errs = errs.Append(err)
+			continue
+		}
+		units = append(units, loaded...)
+	}
+	return units, errors.GroupOrNil(errs)
+}
+
+// preprocessUnits runs the Preprocessors on the units, such as a specterutils.HCLReferenceResolutionProcessor.
+// Since a failing preprocessor returns no units, its errors are reported and the units are kept as they were before it.
+func (s *Server) preprocessUnits(units []specter.Unit) ([]specter.Unit, error) {
+	ctx := specter.PipelineContext{Context: context.Background()}
+	errs := errors.NewGroup(specter.UnitPreprocessingFailedErrorCode)
+	for _, p := range s.Preprocessors {
+		preprocessed, err := p.Preprocess(ctx, units)
+		if err != nil {
+			errs = errs.Append(err)
+			continue
+		}
+		units = preprocessed
+	}
+	return units, errors.GroupOrNil(errs)
+}
+
+// definition returns the location of the unit referenced at a given position of a document, or nil if there is none.
+func (s *Server) definition(params TextDocumentPositionParams) *Location {
+	if !s.analyzed {
+		s.analyze("")
+	}
+
+	path := uriToPath(params.TextDocument.URI)
+	text, ok := s.documents[path]
+	if !ok {
+		text = string(s.sources[path].Data)
+	}
+
+	token := tokenAt(text, byteOffset([]byte(text), params.Position))
+	if token == "" {
+		return nil
+	}
+
+	u := s.referencedUnit(token)
+	if u == nil {
+		return nil
+	}
+
+	r := specter.UnitSourceRange(u)
+	if r.Filename == "" {
+		r.Filename = u.Source().Location
+	}
+	return &Location{URI: pathToURI(r.Filename), Range: s.lspRange(r)}
+}
+
+// referencedUnit returns the unit referenced by a token, either by its ID optionally followed by a version
+// constraint (e.g. "billing" or "billing@^2"), or by a traversal starting with its kind (e.g. "service.billing.image").
+func (s *Server) referencedUnit(token string) specter.Unit {
+	id, _ := specterutils.ParseUnitDependency(specter.UnitID(token))
+	for _, u := range s.units {
+		if u.ID() == id {
+			return u
+		}
+	}
+
+	kind, rest, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil
+	}
+	unitID, _, _ := strings.Cut(rest, ".")
+	for _, u := range s.units {
+		if string(u.Kind()) == kind && string(u.ID()) == unitID {
+			return u
+		}
+	}
+	return nil
+}
+
+// documentSymbols returns the symbols of the units defined in a document.
+func (s *Server) documentSymbols(params DocumentSymbolParams) []DocumentSymbol {
+	if !s.analyzed {
+		s.analyze("")
+	}
+
+	path := uriToPath(params.TextDocument.URI)
+	symbols := []DocumentSymbol{}
+	for _, u := range s.units {
+		if u.Source().Location != path {
+			continue
+		}
+
+		r := s.lspRange(specter.UnitSourceRange(u))
+		symbol := DocumentSymbol{
+			Name:           string(u.ID()),
+			Detail:         string(u.Kind()),
+			Kind:           SymbolKindClass,
+			Range:          r,
+			SelectionRange: r,
+		}
+		if gu, ok := u.(*specterutils.GenericUnit); ok {
+			symbol.Children = s.attributeSymbols(gu.Attributes)
+		}
+		symbols = append(symbols, symbol)
+	}
+	return symbols
+}
+
+func (s *Server) attributeSymbols(attrs []specterutils.GenericUnitAttribute) []DocumentSymbol {
+	var symbols []DocumentSymbol
+	for _, a := range attrs {
+		if a.Range.IsZero() {
+			continue
+		}
+
+		r := s.lspRange(a.Range)
+		symbol := DocumentSymbol{Name: a.Name, Kind: SymbolKindProperty, Range: r, SelectionRange: r}
+		if obj, ok := a.Value.(specterutils.ObjectValue); ok {
+			symbol.Name = string(obj.Type)
+			symbol.Detail = a.Name
+			symbol.Kind = SymbolKindField
+			symbol.Children = s.attributeSymbols(obj.Attributes)
+		}
+		symbols = append(symbols, symbol)
+	}
+	return symbols
+}
+
+// lspRange converts a specter.SourceRange to a Range using the data of its source when available.
+func (s *Server) lspRange(r specter.SourceRange) Range {
+	var data []byte
+	if text, ok := s.documents[r.Filename]; ok {
+		data = []byte(text)
+	} else if src, ok := s.sources[r.Filename]; ok {
+		data = src.Data
+	}
+	return Range{Start: lspPosition(data, r.Start), End: lspPosition(data, r.End)}
+}
+
+// diagnosticOfError returns the range and message of a diagnostic representing an error.
+func diagnosticOfError(err error) (specter.SourceRange, string) {
+	var d specterutils.HCLDiagnostic
+	if errors.As(err, &d) {
+		msg := d.Summary
+		if d.Detail != "" {
+			msg += ": " + d.Detail
+		}
+		return d.Range, msg
+	}
+
+	var r specter.HasSourceRange
+	if errors.As(err, &r) {
+		return r.SourceRange(), err.Error()
+	}
+
+	return specter.SourceRange{}, err.Error()
+}
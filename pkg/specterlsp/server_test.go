@@ -0,0 +1,451 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterlsp_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterlsp"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const billingHCL = `service "billing" {
+  image = "billing:1.0"
+}
+`
+
+const ordersHCL = `service "orders" {
+  image = "orders:1.0"
+  depends_on = ["billing"]
+}
+`
+
+// testClient is an in-process LSP client connected to a specterlsp.Server.
+type testClient struct {
+	t        *testing.T
+	conn     *specterlsp.Conn
+	writer   io.Writer
+	messages chan specterlsp.Message
+	nextID   int
+}
+
+func startServer(t *testing.T, s *specterlsp.Server) *testClient {
+	t.Helper()
+
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	c := &testClient{
+		t:        t,
+		conn:     specterlsp.NewConn(clientReader, clientWriter),
+		writer:   clientWriter,
+		messages: make(chan specterlsp.Message, 100),
+	}
+
+	go func() {
+		_ = s.Serve(context.Background(), serverReader, serverWriter)
+		_ = serverWriter.Close()
+	}()
+
+	go func() {
+		defer close(c.messages)
+		for {
+			m, err := c.conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			c.messages <- m
+		}
+	}()
+
+	t.Cleanup(func() {
+		_ = c.conn.Notify(specterlsp.MethodExit, nil)
+		_ = clientWriter.Close()
+	})
+
+	return c
+}
+
+func (c *testClient) next() specterlsp.Message {
+	c.t.Helper()
+	select {
+	case m := <-c.messages:
+		return m
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out waiting for a message from the server")
+		return specterlsp.Message{}
+	}
+}
+
+// call sends a request and decodes its result, skipping the notifications received before the response.
+func (c *testClient) call(method string, params any, result any) *specterlsp.ResponseError {
+	c.t.Helper()
+	c.nextID++
+	require.NoError(c.t, c.conn.Call(c.nextID, method, params))
+
+	for {
+		m := c.next()
+		if m.Method != "" {
+			continue
+		}
+		if m.Error != nil {
+			return m.Error
+		}
+		if result != nil {
+			require.NoError(c.t, json.Unmarshal(m.Result, result))
+		}
+		return nil
+	}
+}
+
+// send writes a raw message content, framed with a Content-Length header.
+func (c *testClient) send(content string) {
+	c.t.Helper()
+	_, err := io.WriteString(c.writer, fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(content), content))
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) notify(method string, params any) {
+	c.t.Helper()
+	require.NoError(c.t, c.conn.Notify(method, params))
+}
+
+// diagnostics returns the diagnostics published for the next n documents, by document URI.
+func (c *testClient) diagnostics(n int) map[specterlsp.DocumentURI][]specterlsp.Diagnostic {
+	c.t.Helper()
+	diagnostics := map[specterlsp.DocumentURI][]specterlsp.Diagnostic{}
+	for len(diagnostics) < n {
+		m := c.next()
+		require.Equal(c.t, specterlsp.MethodPublishDiagnostics, m.Method)
+		var params specterlsp.PublishDiagnosticsParams
+		require.NoError(c.t, json.Unmarshal(m.Params, &params))
+		diagnostics[params.URI] = params.Diagnostics
+	}
+	return diagnostics
+}
+
+func newTestServer(t *testing.T, files map[string]string, linters ...specterutils.UnitLinter) *specterlsp.Server {
+	t.Helper()
+	fs := &specter.MemoryFileSystem{}
+	require.NoError(t, fs.Mkdir("/workspace", 0755))
+	for path, data := range files {
+		require.NoError(t, fs.WriteFile(path, []byte(data), 0644))
+	}
+
+	loader := specterutils.NewHCLGenericUnitLoader()
+	loader.DeferUnitReferences = true
+
+	s := specterlsp.NewServer(specter.NewPipeline().
+		WithSourceLoaders(specter.NewFileSystemSourceLoader(fs)).
+		WithUnitLoaders(loader).
+		WithUnitPreprocessors(specterutils.NewHCLReferenceResolutionProcessor()).
+		WithUnitProcessors(specterutils.NewLintingProcessor(linters...)),
+	)
+	return s
+}
+
+func initialize(t *testing.T, c *testClient) specterlsp.InitializeResult {
+	t.Helper()
+	var result specterlsp.InitializeResult
+	require.Nil(t, c.call(specterlsp.MethodInitialize, specterlsp.InitializeParams{RootURI: "file:///workspace"}, &result))
+	c.notify(specterlsp.MethodInitialized, struct{}{})
+	return result
+}
+
+func TestNewServer(t *testing.T) {
+	linting := specterutils.NewLintingProcessor(specterutils.UnitsMustHaveIDs(specterutils.ErrorSeverity))
+	references := specterutils.NewHCLReferenceResolutionProcessor()
+	s := specterlsp.NewServer(specter.NewPipeline().
+		WithUnitPreprocessors(references, linting).
+		WithUnitProcessors(linting, specter.NewUnitProcessorFunc("noop", nil)),
+	)
+
+	require.Len(t, s.Linters, 1)
+	assert.Equal(t, linting, s.Linters[0])
+	assert.Equal(t, []specter.UnitPreprocessor{references}, s.Preprocessors)
+}
+
+func TestServer_Initialize(t *testing.T) {
+	c := startServer(t, newTestServer(t, nil))
+
+	result := initialize(t, c)
+
+	assert.Equal(t, specterlsp.ServerCapabilities{
+		TextDocumentSync: specterlsp.TextDocumentSyncOptions{
+			OpenClose: true,
+			Change:    specterlsp.TextDocumentSyncFull,
+			Save:      specterlsp.SaveOptions{IncludeText: true},
+		},
+		DefinitionProvider:     true,
+		DocumentSymbolProvider: true,
+	}, result.Capabilities)
+	assert.Equal(t, specterlsp.ServerName, result.ServerInfo.Name)
+
+	err := c.call("workspace/unknown", struct{}{}, nil)
+	require.NotNil(t, err)
+	assert.Equal(t, specterlsp.MethodNotFoundCode, err.Code)
+
+	require.Nil(t, c.call(specterlsp.MethodShutdown, nil, nil))
+}
+
+func TestServer_invalidMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		given    string
+		thenCode int
+	}{
+		{
+			name:     "GIVEN a malformed message THEN reply with a parse error",
+			given:    `{"jsonrpc": "2.0", "id": 1, "method": `,
+			thenCode: specterlsp.ParseErrorCode,
+		},
+		{
+			name:     "GIVEN a message that is not a request THEN reply with an invalid request error",
+			given:    `{"jsonrpc": "2.0", "id": 1}`,
+			thenCode: specterlsp.InvalidRequestCode,
+		},
+		{
+			name:     "GIVEN a message with an unsupported JSON-RPC version THEN reply with an invalid request error",
+			given:    `{"jsonrpc": "1.0", "id": 1, "method": "initialize"}`,
+			thenCode: specterlsp.InvalidRequestCode,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := startServer(t, newTestServer(t, nil))
+
+			c.send(tt.given)
+			m := c.next()
+			require.NotNil(t, m.Error)
+			assert.Equal(t, tt.thenCode, m.Error.Code)
+
+			result := initialize(t, c)
+			assert.Equal(t, specterlsp.ServerName, result.ServerInfo.Name)
+		})
+	}
+}
+
+func TestServer_Diagnostics(t *testing.T) {
+	tests := []struct {
+		name          string
+		given         map[string]string
+		givenLinters  []specterutils.UnitLinter
+		whenSavedText string
+		then          []specterlsp.Diagnostic
+	}{
+		{
+			name:          "GIVEN a valid document WHEN saved THEN should publish no diagnostics",
+			given:         map[string]string{"/workspace/billing.hcl": billingHCL},
+			whenSavedText: ordersHCL,
+			then:          []specterlsp.Diagnostic{},
+		},
+		{
+			name:          "GIVEN a document with a syntax error WHEN saved THEN should publish the loader diagnostic",
+			given:         map[string]string{"/workspace/billing.hcl": billingHCL},
+			whenSavedText: "service \"orders\" {\n  image = \n}\n",
+			then: []specterlsp.Diagnostic{
+				{
+					Range: specterlsp.Range{
+						Start: specterlsp.Position{Line: 1, Character: 10},
+						End:   specterlsp.Position{Line: 2, Character: 0},
+					},
+					Severity: specterlsp.DiagnosticSeverityError,
+					Source:   specterlsp.DiagnosticSource,
+					Message:  "Invalid expression: Expected the start of an expression, but found an invalid expression token.",
+				},
+			},
+		},
+		{
+			name:          "GIVEN a reference to a missing unit WHEN saved THEN should publish the preprocessor diagnostic",
+			given:         map[string]string{"/workspace/billing.hcl": billingHCL},
+			whenSavedText: "service \"orders\" {\n  image = service.shipping.image\n}\n",
+			then: []specterlsp.Diagnostic{
+				{
+					Range: specterlsp.Range{
+						Start: specterlsp.Position{Line: 1, Character: 17},
+						End:   specterlsp.Position{Line: 1, Character: 26},
+					},
+					Severity: specterlsp.DiagnosticSeverityError,
+					Source:   specterlsp.DiagnosticSource,
+				},
+			},
+		},
+		{
+			name:  "GIVEN a linter result without a range WHEN saved THEN should publish it at the start of the saved document",
+			given: map[string]string{"/workspace/billing.hcl": billingHCL},
+			givenLinters: []specterutils.UnitLinter{
+				specterutils.UnitsIDsMustBeUnique(specterutils.WarningSeverity),
+			},
+			whenSavedText: strings.Replace(ordersHCL, `"orders"`, `"billing"`, 1),
+			then: []specterlsp.Diagnostic{
+				{
+					Severity: specterlsp.DiagnosticSeverityWarning,
+					Source:   specterlsp.DiagnosticSource,
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := map[string]string{"/workspace/orders.hcl": ordersHCL}
+			for path, data := range tt.given {
+				files[path] = data
+			}
+			c := startServer(t, newTestServer(t, files, tt.givenLinters...))
+			initialize(t, c)
+
+			c.notify(specterlsp.MethodDidOpen, specterlsp.DidOpenTextDocumentParams{
+				TextDocument: specterlsp.TextDocumentItem{URI: "file:///workspace/orders.hcl", Text: ordersHCL},
+			})
+			c.diagnostics(2)
+
+			c.notify(specterlsp.MethodDidChange, specterlsp.DidChangeTextDocumentParams{
+				TextDocument:   specterlsp.TextDocumentIdentifier{URI: "file:///workspace/orders.hcl"},
+				ContentChanges: []specterlsp.TextDocumentContentChangeEvent{{Text: tt.whenSavedText}},
+			})
+			c.notify(specterlsp.MethodDidSave, specterlsp.DidSaveTextDocumentParams{
+				TextDocument: specterlsp.TextDocumentIdentifier{URI: "file:///workspace/orders.hcl"},
+			})
+			diagnostics := c.diagnostics(2)
+
+			got := diagnostics["file:///workspace/orders.hcl"]
+			require.Len(t, got, len(tt.then))
+			for i, d := range tt.then {
+				assert.Equal(t, d.Range, got[i].Range)
+				assert.Equal(t, d.Severity, got[i].Severity)
+				assert.Equal(t, d.Source, got[i].Source)
+				if d.Message != "" {
+					assert.Equal(t, d.Message, got[i].Message)
+				}
+			}
+		})
+	}
+}
+
+func TestServer_Definition(t *testing.T) {
+	tests := []struct {
+		name  string
+		given string
+		when  specterlsp.Position
+		then  *specterlsp.Location
+	}{
+		{
+			name:  "GIVEN a dependency WHEN requesting its definition THEN should return the location of the unit",
+			given: ordersHCL,
+			when:  specterlsp.Position{Line: 2, Character: 19},
+			then: &specterlsp.Location{
+				URI: "file:///workspace/billing.hcl",
+				Range: specterlsp.Range{
+					Start: specterlsp.Position{Line: 0, Character: 0},
+					End:   specterlsp.Position{Line: 2, Character: 1},
+				},
+			},
+		},
+		{
+			name:  "GIVEN a dependency with a version constraint WHEN requesting its definition THEN should ignore the constraint",
+			given: strings.Replace(ordersHCL, `"billing"`, `"billing@^2"`, 1),
+			when:  specterlsp.Position{Line: 2, Character: 17},
+			then: &specterlsp.Location{
+				URI: "file:///workspace/billing.hcl",
+				Range: specterlsp.Range{
+					Start: specterlsp.Position{Line: 0, Character: 0},
+					End:   specterlsp.Position{Line: 2, Character: 1},
+				},
+			},
+		},
+		{
+			name:  "GIVEN a reference by kind and ID WHEN requesting its definition THEN should return the location of the unit",
+			given: strings.Replace(ordersHCL, `"orders:1.0"`, `service.billing.image`, 1),
+			when:  specterlsp.Position{Line: 1, Character: 12},
+			then: &specterlsp.Location{
+				URI: "file:///workspace/billing.hcl",
+				Range: specterlsp.Range{
+					Start: specterlsp.Position{Line: 0, Character: 0},
+					End:   specterlsp.Position{Line: 2, Character: 1},
+				},
+			},
+		},
+		{
+			name:  "GIVEN a position not referencing a unit WHEN requesting its definition THEN should return nothing",
+			given: ordersHCL,
+			when:  specterlsp.Position{Line: 1, Character: 3},
+			then:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := startServer(t, newTestServer(t, map[string]string{
+				"/workspace/billing.hcl": billingHCL,
+				"/workspace/orders.hcl":  tt.given,
+			}))
+			initialize(t, c)
+
+			var got *specterlsp.Location
+			require.Nil(t, c.call(specterlsp.MethodDefinition, specterlsp.TextDocumentPositionParams{
+				TextDocument: specterlsp.TextDocumentIdentifier{URI: "file:///workspace/orders.hcl"},
+				Position:     tt.when,
+			}, &got))
+
+			assert.Equal(t, tt.then, got)
+		})
+	}
+}
+
+func TestServer_DocumentSymbols(t *testing.T) {
+	c := startServer(t, newTestServer(t, map[string]string{
+		"/workspace/billing.hcl": billingHCL,
+		"/workspace/orders.hcl":  ordersHCL,
+	}))
+	initialize(t, c)
+
+	var got []specterlsp.DocumentSymbol
+	require.Nil(t, c.call(specterlsp.MethodDocumentSymbol, specterlsp.DocumentSymbolParams{
+		TextDocument: specterlsp.TextDocumentIdentifier{URI: "file:///workspace/billing.hcl"},
+	}, &got))
+
+	unitRange := specterlsp.Range{
+		Start: specterlsp.Position{Line: 0, Character: 0},
+		End:   specterlsp.Position{Line: 2, Character: 1},
+	}
+	imageRange := specterlsp.Range{
+		Start: specterlsp.Position{Line: 1, Character: 2},
+		End:   specterlsp.Position{Line: 1, Character: 23},
+	}
+	assert.Equal(t, []specterlsp.DocumentSymbol{
+		{
+			Name:           "billing",
+			Detail:         "service",
+			Kind:           specterlsp.SymbolKindClass,
+			Range:          unitRange,
+			SelectionRange: unitRange,
+			Children: []specterlsp.DocumentSymbol{
+				{
+					Name:           "image",
+					Kind:           specterlsp.SymbolKindProperty,
+					Range:          imageRange,
+					SelectionRange: imageRange,
+				},
+			},
+		},
+	}, got)
+}
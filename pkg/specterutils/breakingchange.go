@@ -0,0 +1,609 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"context"
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
+	"sort"
+	"strings"
+)
+
+const ChangeDetectionFailedErrorCode = "specter.change_detection_failed"
+
+const VersionBumpMismatchErrorCode = "specter.version_bump_mismatch"
+
+const UnitChangeReportArtifactID = "_unit_change_report"
+
+// UnitChangeType represents the type of change of a unit or an attribute between two snapshots.
+type UnitChangeType string
+
+const (
+	UnitAdded    UnitChangeType = "added"
+	UnitRemoved  UnitChangeType = "removed"
+	UnitModified UnitChangeType = "modified"
+)
+
+// ChangeClass indicates if a change is compatible with the previous definition of a unit.
+type ChangeClass string
+
+const (
+	NonBreakingChange ChangeClass = "non_breaking"
+	BreakingChange    ChangeClass = "breaking"
+)
+
+// AttributeChange represents the change of an attribute of a unit. The Path of nested attributes follows the
+// format of the paths of UnitMutation, e.g. "env:prod.replicas". Repeated blocks or keys sharing the same segment
+// are told apart by their index, e.g. "healthcheck[1].path".
+type AttributeChange struct {
+	Path  string
+	Type  UnitChangeType
+	Class ChangeClass
+
+	// Description of the change, optional.
+	Description string
+}
+
+// String returns a human readable summary of the change, e.g. `modified "path" (renamed)`.
+func (c AttributeChange) String() string {
+	s := fmt.Sprintf("%s %q", c.Type, c.Path)
+	if c.Description != "" {
+		s += " (" + c.Description + ")"
+	}
+	return s
+}
+
+// UnitChange represents the change of a unit between a previous snapshot and the current units.
+type UnitChange struct {
+	ID   specter.UnitID
+	Kind specter.UnitKind
+	Type UnitChangeType
+
+	// Class of the change, breaking if any of its attribute changes is breaking.
+	Class ChangeClass
+
+	// Previous definition of the unit, nil when added.
+	Previous specter.Unit
+
+	// Current definition of the unit, nil when removed.
+	Current specter.Unit
+
+	// Attributes that changed, for modified units.
+	Attributes []AttributeChange
+}
+
+// UnitChangeReport is a specter.Artifact listing the changes of units between a previous snapshot and the current units,
+// ordered by kind and ID. Unchanged units are not part of the report.
+type UnitChangeReport struct {
+	Changes []UnitChange
+}
+
+func (r UnitChangeReport) ID() specter.ArtifactID {
+	return UnitChangeReportArtifactID
+}
+
+// HasBreakingChanges indicates if any of the changes is breaking.
+func (r UnitChangeReport) HasBreakingChanges() bool {
+	for _, c := range r.Changes {
+		if c.Class == BreakingChange {
+			return true
+		}
+	}
+	return false
+}
+
+func GetUnitChangeReportFromContext(ctx specter.UnitProcessingContext) UnitChangeReport {
+	return specter.GetContextArtifact[UnitChangeReport](ctx, UnitChangeReportArtifactID)
+}
+
+// UnitBaselineProvider provides the units of a previous snapshot to compare the current units against,
+// such as the units stored from the last run or loaded from another path.
+type UnitBaselineProvider interface {
+	BaselineUnits(ctx context.Context) (specter.UnitGroup, error)
+}
+
+// UnitBaselineProviderFunc is a UnitBaselineProvider relying on a func.
+type UnitBaselineProviderFunc func(ctx context.Context) (specter.UnitGroup, error)
+
+func (f UnitBaselineProviderFunc) BaselineUnits(ctx context.Context) (specter.UnitGroup, error) {
+	return f(ctx)
+}
+
+// StaticUnitBaseline returns a UnitBaselineProvider always providing the same units.
+func StaticUnitBaseline(units ...specter.Unit) UnitBaselineProvider {
+	return UnitBaselineProviderFunc(func(context.Context) (specter.UnitGroup, error) {
+		return units, nil
+	})
+}
+
+var _ UnitBaselineProvider = SourceUnitBaselineProvider{}
+
+// SourceUnitBaselineProvider is a UnitBaselineProvider loading the units of a previous snapshot from source locations,
+// such as a directory in which another ref of a repository was checked out.
+// The units are loaded by a specter.Pipeline stopping after the unit loading stage, so they are not preprocessed.
+type SourceUnitBaselineProvider struct {
+	SourceLoaders []specter.SourceLoader
+	UnitLoaders   []specter.UnitLoader
+	Locations     []string
+
+	// UnhandledSourcePolicy indicates how sources that no UnitLoader supports are reported.
+	// Defaults to specter.StrictnessError.
+	UnhandledSourcePolicy specter.StrictnessPolicy
+}
+
+func (p SourceUnitBaselineProvider) BaselineUnits(ctx context.Context) (specter.UnitGroup, error) {
+	result, err := specter.NewPipeline().
+		WithSourceLoaders(p.SourceLoaders...).
+		WithUnitLoaders(p.UnitLoaders...).
+		WithUnhandledSourcePolicy(p.UnhandledSourcePolicy).
+		Build().
+		Run(ctx, specter.StopAfterUnitLoadingStage, p.Locations)
+	if err != nil {
+		return nil, errors.WrapWithMessage(err, ChangeDetectionFailedErrorCode, fmt.Sprintf("failed loading baseline units from %q", p.Locations))
+	}
+	return result.Units, nil
+}
+
+// UnitChangeRule classifies the changes of units of certain kinds between two snapshots.
+type UnitChangeRule interface {
+	// Supports indicates if this rule supports units of a given kind.
+	Supports(kind specter.UnitKind) bool
+
+	// Compare returns the attribute changes between the previous and current definitions of a unit.
+	Compare(previous, current specter.Unit) ([]AttributeChange, error)
+}
+
+// NewUnitChangeRule returns a UnitChangeRule for units of a kind relying on a func.
+func NewUnitChangeRule(kind specter.UnitKind, compare func(previous, current specter.Unit) ([]AttributeChange, error)) UnitChangeRule {
+	return unitChangeRuleAdapter{kind: kind, compare: compare}
+}
+
+type unitChangeRuleAdapter struct {
+	kind    specter.UnitKind
+	compare func(previous, current specter.Unit) ([]AttributeChange, error)
+}
+
+func (r unitChangeRuleAdapter) Supports(kind specter.UnitKind) bool {
+	return kind == r.kind
+}
+
+func (r unitChangeRuleAdapter) Compare(previous, current specter.Unit) ([]AttributeChange, error) {
+	if r.compare == nil {
+		return nil, nil
+	}
+	return r.compare(previous, current)
+}
+
+var _ UnitChangeRule = GenericUnitChangeRule{}
+
+// GenericUnitChangeRule is a UnitChangeRule comparing the attributes of GenericUnit.
+// Added attributes are non-breaking, while removed and modified attributes are breaking unless they are listed
+// as NonBreakingAttributes. Other types of units are considered unchanged.
+type GenericUnitChangeRule struct {
+	// Kinds supported by this rule. All kinds are supported when empty.
+	Kinds []specter.UnitKind
+
+	// NonBreakingAttributes are the paths of attributes whose changes are non-breaking, e.g. "description".
+	// The changes of attributes nested under these paths are also non-breaking.
+	NonBreakingAttributes []string
+
+	// IgnoredAttributes are the paths of attributes whose changes are ignored. Defaults to the DefaultVersionAttribute
+	// when nil.
+	IgnoredAttributes []string
+}
+
+func NewGenericUnitChangeRule(kinds ...specter.UnitKind) GenericUnitChangeRule {
+	return GenericUnitChangeRule{
+		Kinds:             kinds,
+		IgnoredAttributes: []string{DefaultVersionAttribute},
+	}
+}
+
+// WithNonBreakingAttributes marks the changes of attributes as non-breaking.
+func (r GenericUnitChangeRule) WithNonBreakingAttributes(paths ...string) GenericUnitChangeRule {
+	r.NonBreakingAttributes = append(append([]string(nil), r.NonBreakingAttributes...), paths...)
+	return r
+}
+
+func (r GenericUnitChangeRule) Supports(kind specter.UnitKind) bool {
+	if len(r.Kinds) == 0 {
+		return true
+	}
+	for _, k := range r.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (r GenericUnitChangeRule) Compare(previous, current specter.Unit) ([]AttributeChange, error) {
+	prev, ok := previous.(*GenericUnit)
+	if !ok {
+		return nil, nil
+	}
+	cur, ok := current.(*GenericUnit)
+	if !ok {
+		return nil, nil
+	}
+
+	var changes []AttributeChange
+	for _, c := range diffGenericUnitAttributes("", prev.Attributes, cur.Attributes) {
+		if matchesAttributePath(c.Path, r.ignoredAttributes()) {
+			continue
+		}
+		if c.Type == UnitAdded || matchesAttributePath(c.Path, r.NonBreakingAttributes) {
+			c.Class = NonBreakingChange
+		} else {
+			c.Class = BreakingChange
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+func (r GenericUnitChangeRule) ignoredAttributes() []string {
+	if r.IgnoredAttributes == nil {
+		return []string{DefaultVersionAttribute}
+	}
+	return r.IgnoredAttributes
+}
+
+// matchesAttributePath indicates if a path is one of paths, an indexed occurrence of one of them or nested under one
+// of them.
+func matchesAttributePath(path string, paths []string) bool {
+	for _, p := range paths {
+		if path == p || strings.HasPrefix(path, p+UnitAttributePathSeparator) || strings.HasPrefix(path, p+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+// diffGenericUnitAttributes returns the unclassified changes between two lists of attributes, ordered by path.
+// Attributes sharing the same path segment in either list, such as repeated unlabeled blocks, are matched by
+// their index among the attributes of that segment.
+func diffGenericUnitAttributes(prefix string, previous, current []GenericUnitAttribute) []AttributeChange {
+	repeated := map[string]bool{}
+	for _, attrs := range [][]GenericUnitAttribute{previous, current} {
+		seen := map[string]bool{}
+		for _, a := range attrs {
+			segment := genericUnitAttributePathSegment(a)
+			if seen[segment] {
+				repeated[segment] = true
+			}
+			seen[segment] = true
+		}
+	}
+	prevByKey := genericUnitAttributesByKey(previous, repeated)
+	curByKey := genericUnitAttributesByKey(current, repeated)
+
+	keys := map[string]struct{}{}
+	for k := range prevByKey {
+		keys[k] = struct{}{}
+	}
+	for k := range curByKey {
+		keys[k] = struct{}{}
+	}
+	sortedKeys := make([]string, 0, len(keys))
+	for k := range keys {
+		sortedKeys = append(sortedKeys, k)
+	}
+	sort.Strings(sortedKeys)
+
+	var changes []AttributeChange
+	for _, key := range sortedKeys {
+		path := key
+		if prefix != "" {
+			path = prefix + UnitAttributePathSeparator + key
+		}
+
+		prev, inPrev := prevByKey[key]
+		cur, inCur := curByKey[key]
+		switch {
+		case !inPrev:
+			changes = append(changes, AttributeChange{Path: path, Type: UnitAdded})
+		case !inCur:
+			changes = append(changes, AttributeChange{Path: path, Type: UnitRemoved})
+		default:
+			prevObj, prevIsObj := prev.Value.(ObjectValue)
+			curObj, curIsObj := cur.Value.(ObjectValue)
+			if prevIsObj && curIsObj {
+				changes = append(changes, diffGenericUnitAttributes(path, prevObj.Attributes, curObj.Attributes)...)
+				continue
+			}
+			if !genericAttributeValuesEqual(prev.Value, cur.Value) {
+				changes = append(changes, AttributeChange{Path: path, Type: UnitModified})
+			}
+		}
+	}
+	return changes
+}
+
+// genericUnitAttributesByKey indexes attributes by their path segment, suffixed by their index among the attributes
+// of the same segment for repeated segments, e.g. "healthcheck[1]".
+func genericUnitAttributesByKey(attrs []GenericUnitAttribute, repeated map[string]bool) map[string]GenericUnitAttribute {
+	byKey := map[string]GenericUnitAttribute{}
+	occurrences := map[string]int{}
+	for _, a := range attrs {
+		key := genericUnitAttributePathSegment(a)
+		if repeated[key] {
+			index := occurrences[key]
+			occurrences[key]++
+			key = fmt.Sprintf("%s[%d]", key, index)
+		}
+		byKey[key] = a
+	}
+	return byKey
+}
+
+// genericUnitAttributePathSegment returns the segment of the path of an attribute, as expected by UnitMutation.
+func genericUnitAttributePathSegment(a GenericUnitAttribute) string {
+	if obj, ok := a.Value.(ObjectValue); ok {
+		if a.Name == "" || a.Name == string(obj.Type) {
+			return string(obj.Type)
+		}
+		return string(obj.Type) + UnitAttributePathLabelSeparator + a.Name
+	}
+	return a.Name
+}
+
+func genericAttributeValuesEqual(a, b AttributeValue) bool {
+	av, ok := a.(GenericValue)
+	if !ok {
+		return false
+	}
+	bv, ok := b.(GenericValue)
+	if !ok {
+		return false
+	}
+	if av.Value == cty.NilVal || bv.Value == cty.NilVal {
+		return av.Value == bv.Value
+	}
+	if !av.IsWhollyKnown() || !bv.IsWhollyKnown() || !av.Type().Equals(bv.Type()) {
+		return av.RawEquals(bv.Value)
+	}
+	eq := av.Equals(bv.Value)
+	return eq.IsKnown() && eq.True()
+}
+
+var _ specter.UnitProcessor = BreakingChangeDetectionProcessor{}
+
+// BreakingChangeDetectionProcessor is a specter.UnitProcessor comparing the current units against the units of a previous
+// snapshot. Units are matched by kind and ID, and the changes of matched units are classified by the first UnitChangeRule
+// supporting their kind. Added units are non-breaking, while removed units are breaking.
+//
+// The resulting UnitChangeReport is available as a specter.Artifact under the key UnitChangeReportArtifactID.
+// A helper function GetUnitChangeReportFromContext can be used in other processors to get access to it.
+//
+// When CheckVersionBumps is enabled, modified units must have their version bumped according to the class of their
+// change: breaking changes require a major bump (or a minor bump before 1.0.0), while non-breaking changes require any
+// bump. Units without a version in either snapshot are not checked.
+type BreakingChangeDetectionProcessor struct {
+	Baseline          UnitBaselineProvider
+	Rules             []UnitChangeRule
+	CheckVersionBumps bool
+}
+
+// NewBreakingChangeDetectionProcessor returns a BreakingChangeDetectionProcessor checking version bumps.
+// Without rules, the changes of units are classified by a GenericUnitChangeRule.
+func NewBreakingChangeDetectionProcessor(baseline UnitBaselineProvider, rules ...UnitChangeRule) *BreakingChangeDetectionProcessor {
+	if len(rules) == 0 {
+		rules = []UnitChangeRule{NewGenericUnitChangeRule()}
+	}
+	return &BreakingChangeDetectionProcessor{
+		Baseline:          baseline,
+		Rules:             rules,
+		CheckVersionBumps: true,
+	}
+}
+
+func (p BreakingChangeDetectionProcessor) Name() string {
+	return "breaking_change_detection_processor"
+}
+
+func (p BreakingChangeDetectionProcessor) Process(ctx specter.UnitProcessingContext) ([]specter.Artifact, error) {
+	var baseline specter.UnitGroup
+	if p.Baseline != nil {
+		var err error
+		baseline, err = p.Baseline.BaselineUnits(ctx)
+		if err != nil {
+			return nil, errors.WrapWithMessage(err, ChangeDetectionFailedErrorCode, "failed loading baseline units")
+		}
+	}
+
+	report, err := p.Compare(baseline, ctx.Units)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.CheckVersionBumps {
+		if err := CheckVersionBumps(report); err != nil {
+			return nil, err
+		}
+	}
+
+	return []specter.Artifact{report}, nil
+}
+
+// Compare returns the changes between previous and current units.
+func (p BreakingChangeDetectionProcessor) Compare(previous, current specter.UnitGroup) (UnitChangeReport, error) {
+	type unitKey struct {
+		kind specter.UnitKind
+		id   specter.UnitID
+	}
+
+	previousByKey := map[unitKey]specter.Unit{}
+	for _, u := range previous {
+		previousByKey[unitKey{u.Kind(), u.ID()}] = u
+	}
+
+	var report UnitChangeReport
+	errs := errors.NewGroup(ChangeDetectionFailedErrorCode)
+	matched := map[unitKey]struct{}{}
+	for _, cur := range current {
+		key := unitKey{cur.Kind(), cur.ID()}
+		prev, found := previousByKey[key]
+		if !found {
+			report.Changes = append(report.Changes, UnitChange{
+				ID:      cur.ID(),
+				Kind:    cur.Kind(),
+				Type:    UnitAdded,
+				Class:   NonBreakingChange,
+				Current: cur,
+			})
+			continue
+		}
+		matched[key] = struct{}{}
+
+		rule := p.rule(cur.Kind())
+		if rule == nil {
+			continue
+		}
+		attributes, err := rule.Compare(prev, cur)
+		if err != nil {
+			errs = errs.Append(errors.WrapWithMessage(
+				err,
+				ChangeDetectionFailedErrorCode,
+				fmt.Sprintf("failed comparing unit %q of kind %q", cur.ID(), cur.Kind()),
+			))
+			continue
+		}
+		if len(attributes) == 0 {
+			continue
+		}
+
+		class := NonBreakingChange
+		for _, a := range attributes {
+			if a.Class == BreakingChange {
+				class = BreakingChange
+			}
+		}
+		report.Changes = append(report.Changes, UnitChange{
+			ID:         cur.ID(),
+			Kind:       cur.Kind(),
+			Type:       UnitModified,
+			Class:      class,
+			Previous:   prev,
+			Current:    cur,
+			Attributes: attributes,
+		})
+	}
+	if err := errors.GroupOrNil(errs); err != nil {
+		return UnitChangeReport{}, err
+	}
+
+	for _, prev := range previous {
+		if _, ok := matched[unitKey{prev.Kind(), prev.ID()}]; ok {
+			continue
+		}
+		report.Changes = append(report.Changes, UnitChange{
+			ID:       prev.ID(),
+			Kind:     prev.Kind(),
+			Type:     UnitRemoved,
+			Class:    BreakingChange,
+			Previous: prev,
+		})
+	}
+
+	sort.SliceStable(report.Changes, func(i, j int) bool {
+		if report.Changes[i].Kind != report.Changes[j].Kind {
+			return report.Changes[i].Kind < report.Changes[j].Kind
+		}
+		return report.Changes[i].ID < report.Changes[j].ID
+	})
+
+	return report, nil
+}
+
+func (p BreakingChangeDetectionProcessor) rule(kind specter.UnitKind) UnitChangeRule {
+	for _, r := range p.Rules {
+		if r.Supports(kind) {
+			return r
+		}
+	}
+	return nil
+}
+
+// CheckVersionBumps ensures that the versions of modified units were bumped according to the class of their changes.
+// Units without a version in either snapshot are ignored.
+func CheckVersionBumps(report UnitChangeReport) error {
+	errs := errors.NewGroup(VersionBumpMismatchErrorCode)
+	for _, c := range report.Changes {
+		if c.Type != UnitModified {
+			continue
+		}
+		if err := checkVersionBump(c); err != nil {
+			errs = errs.Append(err)
+		}
+	}
+	return errors.GroupOrNil(errs)
+}
+
+func checkVersionBump(c UnitChange) error {
+	previousVersion, ok := UnitVersionOf(c.Previous)
+	if !ok {
+		return nil
+	}
+	currentVersion, ok := UnitVersionOf(c.Current)
+	if !ok {
+		return nil
+	}
+
+	prev, err := previousVersion.SemVer()
+	if err != nil {
+		return errors.WrapWithMessage(err, VersionBumpMismatchErrorCode, fmt.Sprintf("unit %q of kind %q has an invalid previous version", c.ID, c.Kind))
+	}
+	cur, err := currentVersion.SemVer()
+	if err != nil {
+		return errors.WrapWithMessage(err, VersionBumpMismatchErrorCode, fmt.Sprintf("unit %q of kind %q at %q has an invalid version", c.ID, c.Kind, specter.UnitLocation(c.Current)))
+	}
+
+	bumped := cur.Compare(prev) > 0
+	if c.Class == BreakingChange {
+		bumped = cur.Major > prev.Major || (prev.Major == 0 && cur.Major == 0 && cur.Minor > prev.Minor)
+	}
+	if bumped {
+		return nil
+	}
+
+	required := "a version bump"
+	if c.Class == BreakingChange {
+		required = "a major version bump"
+	}
+	var changes []string
+	for _, a := range c.Attributes {
+		if c.Class == NonBreakingChange || a.Class == BreakingChange {
+			changes = append(changes, a.String())
+		}
+	}
+	return errors.NewWithMessage(
+		VersionBumpMismatchErrorCode,
+		fmt.Sprintf(
+			"unit %q of kind %q at %q has %s changes requiring %s, but its version went from %q to %q: %s",
+			c.ID,
+			c.Kind,
+			specter.UnitLocation(c.Current),
+			strings.ReplaceAll(string(c.Class), "_", "-"),
+			required,
+			previousVersion,
+			currentVersion,
+			strings.Join(changes, ", "),
+		),
+	)
+}
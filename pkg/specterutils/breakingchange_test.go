@@ -0,0 +1,398 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"context"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBreakingChangeDetectionProcessor_Process(t *testing.T) {
+	const previous = `api "billing" {
+  version = "1.2.0"
+  description = "Billing API"
+  path = "/billing"
+
+  endpoint "create" {
+    method = "POST"
+  }
+}
+
+api "orders" {
+  version = "1.0.0"
+  path = "/orders"
+}
+
+api "shipping" {
+  version = "0.3.0"
+  path = "/shipping"
+}
+`
+
+	type changeSummary struct {
+		ID         specter.UnitID
+		Type       specterutils.UnitChangeType
+		Class      specterutils.ChangeClass
+		Attributes []string
+	}
+
+	tests := []struct {
+		name      string
+		given     string
+		then      []changeSummary
+		thenError require.ErrorAssertionFunc
+	}{
+		{
+			name:  "GIVEN unchanged units WHEN processed THEN should report no changes",
+			given: previous,
+			then:  nil,
+		},
+		{
+			name: "GIVEN added, removed and modified units with matching version bumps WHEN processed THEN should classify their changes",
+			given: `api "billing" {
+  version = "2.0.0"
+  description = "The billing API"
+  path = "/billing"
+  timeout = 30
+
+  endpoint "create" {
+    method = "PUT"
+  }
+}
+
+api "orders" {
+  version = "1.1.0"
+  path = "/orders"
+  timeout = 10
+}
+
+api "shipping" {
+  version = "0.4.0"
+  path = "/shipments"
+}
+
+api "payments" {
+  version = "1.0.0"
+}
+`,
+			then: []changeSummary{
+				{
+					ID:         "billing",
+					Type:       specterutils.UnitModified,
+					Class:      specterutils.BreakingChange,
+					Attributes: []string{"description:non_breaking", "endpoint:create.method:breaking", "timeout:non_breaking"},
+				},
+				{
+					ID:         "orders",
+					Type:       specterutils.UnitModified,
+					Class:      specterutils.NonBreakingChange,
+					Attributes: []string{"timeout:non_breaking"},
+				},
+				{ID: "payments", Type: specterutils.UnitAdded, Class: specterutils.NonBreakingChange},
+				{
+					ID:         "shipping",
+					Type:       specterutils.UnitModified,
+					Class:      specterutils.BreakingChange,
+					Attributes: []string{"path:breaking"},
+				},
+			},
+		},
+		{
+			name: "GIVEN a removed unit WHEN processed THEN should report a breaking change",
+			given: `api "billing" {
+  version = "1.2.0"
+  description = "Billing API"
+  path = "/billing"
+
+  endpoint "create" {
+    method = "POST"
+  }
+}
+
+api "orders" {
+  version = "1.0.0"
+  path = "/orders"
+}
+`,
+			then: []changeSummary{
+				{ID: "shipping", Type: specterutils.UnitRemoved, Class: specterutils.BreakingChange},
+			},
+		},
+		{
+			name: "GIVEN a breaking change without a major version bump WHEN processed THEN should return an error",
+			given: `api "billing" {
+  version = "1.3.0"
+  description = "Billing API"
+  path = "/billing"
+}
+
+api "orders" {
+  version = "1.0.0"
+  path = "/orders"
+}
+
+api "shipping" {
+  version = "0.3.0"
+  path = "/shipping"
+}
+`,
+			thenError: testutils.RequireErrorWithCode(specterutils.VersionBumpMismatchErrorCode),
+		},
+		{
+			name: "GIVEN a non-breaking change without a version bump WHEN processed THEN should return an error",
+			given: `api "billing" {
+  version = "1.2.0"
+  description = "The billing API"
+  path = "/billing"
+
+  endpoint "create" {
+    method = "POST"
+  }
+}
+
+api "orders" {
+  version = "1.0.0"
+  path = "/orders"
+}
+
+api "shipping" {
+  version = "0.3.0"
+  path = "/shipping"
+}
+`,
+			thenError: testutils.RequireErrorWithCode(specterutils.VersionBumpMismatchErrorCode),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &specter.MemoryFileSystem{}
+			baseline := loadGenericUnits(t, fs, "/previous/apis.hcl", previous)
+			current := loadGenericUnits(t, fs, "/specs/apis.hcl", tt.given)
+
+			p := specterutils.NewBreakingChangeDetectionProcessor(
+				specterutils.StaticUnitBaseline(unitsOf(baseline)...),
+				specterutils.NewGenericUnitChangeRule("api").WithNonBreakingAttributes("description"),
+			)
+			artifacts, err := p.Process(specter.UnitProcessingContext{
+				Context: context.Background(),
+				Units:   unitsOf(current),
+			})
+			if tt.thenError != nil {
+				tt.thenError(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, artifacts, 1)
+
+			ctx := specter.UnitProcessingContext{Artifacts: artifacts}
+			report := specterutils.GetUnitChangeReportFromContext(ctx)
+
+			var got []changeSummary
+			for _, c := range report.Changes {
+				s := changeSummary{ID: c.ID, Type: c.Type, Class: c.Class}
+				for _, a := range c.Attributes {
+					s.Attributes = append(s.Attributes, a.Path+":"+string(a.Class))
+				}
+				got = append(got, s)
+			}
+			assert.Equal(t, tt.then, got)
+		})
+	}
+}
+
+func TestGenericUnitChangeRule_Compare(t *testing.T) {
+	tests := []struct {
+		name          string
+		givenRule     specterutils.GenericUnitChangeRule
+		givenPrevious string
+		givenCurrent  string
+		then          []string
+	}{
+		{
+			name:      "GIVEN a zero value rule WHEN the version changes THEN should ignore it",
+			givenRule: specterutils.GenericUnitChangeRule{},
+			givenPrevious: `api "billing" {
+  version = "1.0.0"
+  path = "/billing"
+}`,
+			givenCurrent: `api "billing" {
+  version = "2.0.0"
+  path = "/invoices"
+}`,
+			then: []string{`modified "path":breaking`},
+		},
+		{
+			name:      "GIVEN a rule without ignored attributes WHEN the version changes THEN should report it",
+			givenRule: specterutils.GenericUnitChangeRule{IgnoredAttributes: []string{}},
+			givenPrevious: `api "billing" {
+  version = "1.0.0"
+}`,
+			givenCurrent: `api "billing" {
+  version = "2.0.0"
+}`,
+			then: []string{`modified "version":breaking`},
+		},
+		{
+			name:      "GIVEN repeated unlabeled blocks WHEN compared THEN should match them by index",
+			givenRule: specterutils.NewGenericUnitChangeRule().WithNonBreakingAttributes("healthcheck"),
+			givenPrevious: `api "billing" {
+  healthcheck {
+    path = "/health"
+  }
+  healthcheck {
+    path = "/ready"
+  }
+}`,
+			givenCurrent: `api "billing" {
+  healthcheck {
+    path = "/health"
+  }
+  healthcheck {
+    path = "/live"
+  }
+  healthcheck {
+    path = "/ready"
+  }
+}`,
+			then: []string{
+				`modified "healthcheck[1].path":non_breaking`,
+				`added "healthcheck[2]":non_breaking`,
+			},
+		},
+		{
+			name:      "GIVEN a block becoming repeated WHEN compared THEN should match its first occurrence",
+			givenRule: specterutils.NewGenericUnitChangeRule(),
+			givenPrevious: `api "billing" {
+  healthcheck {
+    path = "/health"
+  }
+}`,
+			givenCurrent: `api "billing" {
+  healthcheck {
+    path = "/health"
+  }
+  healthcheck {
+    path = "/ready"
+  }
+}`,
+			then: []string{`added "healthcheck[1]":non_breaking`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &specter.MemoryFileSystem{}
+			previous := loadGenericUnits(t, fs, "/previous/apis.hcl", tt.givenPrevious)
+			current := loadGenericUnits(t, fs, "/specs/apis.hcl", tt.givenCurrent)
+
+			changes, err := tt.givenRule.Compare(previous[0], current[0])
+			require.NoError(t, err)
+
+			var got []string
+			for _, c := range changes {
+				got = append(got, c.String()+":"+string(c.Class))
+			}
+			assert.Equal(t, tt.then, got)
+		})
+	}
+}
+
+func TestCheckVersionBumps(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	previous := loadGenericUnits(t, fs, "/previous/apis.hcl", `api "billing" {
+  version = "1.0.0"
+  path = "/billing"
+}`)
+	current := loadGenericUnits(t, fs, "/specs/apis.hcl", `api "billing" {
+  version = "1.1.0"
+  path = "/invoices"
+}`)
+
+	report := specterutils.UnitChangeReport{Changes: []specterutils.UnitChange{{
+		ID:       "billing",
+		Kind:     "api",
+		Type:     specterutils.UnitModified,
+		Class:    specterutils.BreakingChange,
+		Previous: previous[0],
+		Current:  current[0],
+		Attributes: []specterutils.AttributeChange{
+			{Path: "path", Type: specterutils.UnitModified, Class: specterutils.BreakingChange, Description: "renamed"},
+			{Path: "timeout", Type: specterutils.UnitAdded, Class: specterutils.NonBreakingChange},
+		},
+	}}}
+
+	err := specterutils.CheckVersionBumps(report)
+	testutils.RequireErrorWithCode(specterutils.VersionBumpMismatchErrorCode)(t, err)
+	assert.ErrorContains(t, err, `has breaking changes requiring a major version bump, but its version went from "1.0.0" to "1.1.0": modified "path" (renamed)`)
+}
+
+func TestBreakingChangeDetectionProcessor_customRule(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	baseline := loadGenericUnits(t, fs, "/previous/apis.yaml", "kind: api\nid: billing\nversion: 1.0.0\nstatus: beta\n")
+	current := loadGenericUnits(t, fs, "/specs/apis.yaml", "kind: api\nid: billing\nversion: 1.0.0\nstatus: stable\n")
+
+	rule := specterutils.NewUnitChangeRule("api", func(previous, current specter.Unit) ([]specterutils.AttributeChange, error) {
+		return []specterutils.AttributeChange{
+			{Path: "status", Type: specterutils.UnitModified, Class: specterutils.NonBreakingChange, Description: "promoted"},
+		}, nil
+	})
+	p := specterutils.NewBreakingChangeDetectionProcessor(specterutils.StaticUnitBaseline(unitsOf(baseline)...), rule)
+	p.CheckVersionBumps = false
+
+	artifacts, err := p.Process(specter.UnitProcessingContext{Context: context.Background(), Units: unitsOf(current)})
+	require.NoError(t, err)
+
+	report := artifacts[0].(specterutils.UnitChangeReport)
+	require.Len(t, report.Changes, 1)
+	assert.Equal(t, "promoted", report.Changes[0].Attributes[0].Description)
+	assert.False(t, report.HasBreakingChanges())
+
+	err = specterutils.CheckVersionBumps(report)
+	testutils.RequireErrorWithCode(specterutils.VersionBumpMismatchErrorCode)(t, err)
+}
+
+func TestSourceUnitBaselineProvider_BaselineUnits(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	require.NoError(t, fs.Mkdir("/previous", 0755))
+	require.NoError(t, fs.WriteFile("/previous/apis.hcl", []byte(`api "billing" {}`), 0600))
+
+	p := specterutils.SourceUnitBaselineProvider{
+		SourceLoaders: []specter.SourceLoader{specter.NewFileSystemSourceLoader(fs)},
+		UnitLoaders:   []specter.UnitLoader{specterutils.NewHCLGenericUnitLoader()},
+		Locations:     []string{"/previous"},
+	}
+	units, err := p.BaselineUnits(context.Background())
+	require.NoError(t, err)
+	require.Len(t, units, 1)
+	assert.Equal(t, specter.UnitID("billing"), units[0].ID())
+
+	// Sources that no unit loader supports are reported according to the policy.
+	require.NoError(t, fs.WriteFile("/previous/README.md", []byte(`# Previous`), 0600))
+	_, err = p.BaselineUnits(context.Background())
+	testutils.RequireErrorWithCode(specterutils.ChangeDetectionFailedErrorCode)(t, err)
+	p.UnhandledSourcePolicy = specter.StrictnessIgnore
+	units, err = p.BaselineUnits(context.Background())
+	require.NoError(t, err)
+	require.Len(t, units, 1)
+
+	p.Locations = []string{"git://previous"}
+	p.SourceLoaders = nil
+	_, err = p.BaselineUnits(context.Background())
+	testutils.RequireErrorWithCode(specterutils.ChangeDetectionFailedErrorCode)(t, err)
+}
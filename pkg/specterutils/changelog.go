@@ -0,0 +1,273 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"sort"
+)
+
+const ChangelogGenerationFailedErrorCode = "specter.changelog_generation_failed"
+
+// DefaultChangelogTitle is the title of changelogs when none is specified.
+const DefaultChangelogTitle = "Changelog"
+
+// UnreleasedVersion is the version under which the changes of units without a version are listed.
+const UnreleasedVersion = "Unreleased"
+
+var _ specter.UnitProcessor = ChangelogProcessor{}
+
+// ChangelogProcessor is a specter.UnitProcessor emitting a Markdown changelog in the style of Keep a Changelog as a
+// specter.FileArtifact. Entries are grouped by unit version, then by Added, Changed and Removed sections listing the
+// units and their attributes by kind and ID.
+//
+// The changes are taken from the UnitChangeReport of the context, as emitted by a BreakingChangeDetectionProcessor
+// registered before this processor. When the context has no report, the units are compared against the Baseline using
+// the Rules, as a BreakingChangeDetectionProcessor would, without checking version bumps.
+type ChangelogProcessor struct {
+	// Path of the generated changelog.
+	Path string
+
+	// Title of the generated changelog. Defaults to DefaultChangelogTitle.
+	Title string
+
+	Baseline UnitBaselineProvider
+	Rules    []UnitChangeRule
+}
+
+func NewChangelogProcessor(path string) *ChangelogProcessor {
+	return &ChangelogProcessor{Path: path, Title: DefaultChangelogTitle}
+}
+
+// WithBaseline compares the units against a baseline when the context has no UnitChangeReport.
+func (p *ChangelogProcessor) WithBaseline(baseline UnitBaselineProvider, rules ...UnitChangeRule) *ChangelogProcessor {
+	p.Baseline = baseline
+	p.Rules = rules
+	return p
+}
+
+func (p ChangelogProcessor) Name() string {
+	return "changelog_processor"
+}
+
+func (p ChangelogProcessor) Process(ctx specter.UnitProcessingContext) ([]specter.Artifact, error) {
+	report, found := ctx.Artifact(UnitChangeReportArtifactID).(UnitChangeReport)
+	if !found {
+		if p.Baseline == nil {
+			return nil, errors.NewWithMessage(
+				ChangelogGenerationFailedErrorCode,
+				fmt.Sprintf("no unit change report found in context under %q and no baseline configured", UnitChangeReportArtifactID),
+			)
+		}
+
+		detector := NewBreakingChangeDetectionProcessor(p.Baseline, p.Rules...)
+		baseline, err := p.Baseline.BaselineUnits(ctx)
+		if err != nil {
+			return nil, errors.WrapWithMessage(err, ChangelogGenerationFailedErrorCode, "failed loading baseline units")
+		}
+		report, err = detector.Compare(baseline, ctx.Units)
+		if err != nil {
+			return nil, errors.WrapWithMessage(err, ChangelogGenerationFailedErrorCode, "failed comparing units")
+		}
+	}
+
+	title := p.Title
+	if title == "" {
+		title = DefaultChangelogTitle
+	}
+
+	return []specter.Artifact{
+		&specter.FileArtifact{
+			Path:      p.Path,
+			Data:      RenderChangelog(title, report),
+			FileMode:  0644,
+			WriteMode: specter.RecreateMode,
+		},
+	}, nil
+}
+
+// RenderChangelog renders the changes of a UnitChangeReport as a Markdown changelog in the style of Keep a Changelog.
+// Changes are grouped by version, the UnreleasedVersion first followed by the other versions from the most recent,
+// then by kind of unit and finally by section (Added, Changed and Removed), listing the units in the order of the
+// report, by kind and ID.
+func RenderChangelog(title string, report UnitChangeReport) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# %s\n\n", title)
+	buf.WriteString("All notable changes to the specifications are documented in this file.\n\n")
+	buf.WriteString("The format is based on [Keep a Changelog](https://keepachangelog.com/en/1.1.0/).\n")
+
+	if len(report.Changes) == 0 {
+		buf.WriteString("\nNo changes.\n")
+		return buf.Bytes()
+	}
+
+	for _, release := range changelogReleases(report) {
+		fmt.Fprintf(&buf, "\n## [%s]\n", release.version)
+		for _, kind := range release.kinds {
+			fmt.Fprintf(&buf, "\n### %s\n", kind.kind)
+			for _, section := range kind.sections() {
+				fmt.Fprintf(&buf, "\n#### %s\n\n", section.title)
+				for _, entry := range section.entries {
+					fmt.Fprintf(&buf, "- %s\n", entry)
+				}
+			}
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// changelogVersion returns the version under which the change of a unit is listed.
+func changelogVersion(c UnitChange) string {
+	u := c.Current
+	if u == nil {
+		u = c.Previous
+	}
+	if v, ok := UnitVersionOf(u); ok {
+		return string(v)
+	}
+	return UnreleasedVersion
+}
+
+type changelogSection struct {
+	title   string
+	entries []string
+}
+
+// changelogRelease lists the changes of the units of a version, by kind.
+type changelogRelease struct {
+	version string
+	kinds   []*changelogKind
+}
+
+// changelogKind lists the changes of the units of a kind within a release.
+type changelogKind struct {
+	kind    specter.UnitKind
+	added   changelogSection
+	changed changelogSection
+	removed changelogSection
+}
+
+// changelogReleases groups the changes of a report by version, the UnreleasedVersion first followed by the other
+// versions from the most recent. Versions that are not semantic versions are listed last, in lexical order.
+func changelogReleases(report UnitChangeReport) []*changelogRelease {
+	var releases []*changelogRelease
+	byVersion := map[string]*changelogRelease{}
+	for _, c := range report.Changes {
+		version := changelogVersion(c)
+		release, found := byVersion[version]
+		if !found {
+			release = &changelogRelease{version: version}
+			byVersion[version] = release
+			releases = append(releases, release)
+		}
+		release.add(c)
+	}
+
+	sort.SliceStable(releases, func(i, j int) bool {
+		return changelogVersionLess(releases[j].version, releases[i].version)
+	})
+	return releases
+}
+
+// changelogVersionLess indicates if a version is older than another. The UnreleasedVersion is the most recent, while
+// versions that are not semantic versions are older than all semantic versions.
+func changelogVersionLess(a, b string) bool {
+	if a == UnreleasedVersion || b == UnreleasedVersion {
+		return b == UnreleasedVersion && a != UnreleasedVersion
+	}
+	av, aErr := ParseSemVer(a)
+	bv, bErr := ParseSemVer(b)
+	switch {
+	case aErr != nil && bErr != nil:
+		return a > b
+	case aErr != nil:
+		return true
+	case bErr != nil:
+		return false
+	}
+	return av.Compare(bv) < 0
+}
+
+// add lists the change of a unit in the sections of its kind.
+func (r *changelogRelease) add(c UnitChange) {
+	var k *changelogKind
+	for _, candidate := range r.kinds {
+		if candidate.kind == c.Kind {
+			k = candidate
+			break
+		}
+	}
+	if k == nil {
+		k = &changelogKind{
+			kind:    c.Kind,
+			added:   changelogSection{title: "Added"},
+			changed: changelogSection{title: "Changed"},
+			removed: changelogSection{title: "Removed"},
+		}
+		r.kinds = append(r.kinds, k)
+	}
+	k.add(c)
+}
+
+func (k *changelogKind) add(c UnitChange) {
+	unit := fmt.Sprintf("`%s`", c.ID)
+	switch c.Type {
+	case UnitAdded:
+		k.added.entries = append(k.added.entries, changelogEntry(unit+".", c.Class))
+		return
+	case UnitRemoved:
+		k.removed.entries = append(k.removed.entries, changelogEntry(unit+".", c.Class))
+		return
+	}
+
+	for _, a := range c.Attributes {
+		entry := fmt.Sprintf("%s: `%s`", unit, a.Path)
+		if a.Description != "" {
+			entry += " (" + a.Description + ")"
+		}
+		entry = changelogEntry(entry, a.Class)
+
+		switch a.Type {
+		case UnitAdded:
+			k.added.entries = append(k.added.entries, entry)
+		case UnitRemoved:
+			k.removed.entries = append(k.removed.entries, entry)
+		default:
+			k.changed.entries = append(k.changed.entries, entry)
+		}
+	}
+}
+
+// sections returns the non-empty sections of the kind, in the order of Keep a Changelog.
+func (k *changelogKind) sections() []changelogSection {
+	var sections []changelogSection
+	for _, s := range []changelogSection{k.added, k.changed, k.removed} {
+		if len(s.entries) != 0 {
+			sections = append(sections, s)
+		}
+	}
+	return sections
+}
+
+func changelogEntry(entry string, class ChangeClass) string {
+	if class == BreakingChange {
+		return "**Breaking:** " + entry
+	}
+	return entry
+}
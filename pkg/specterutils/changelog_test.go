@@ -0,0 +1,252 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"context"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"testing"
+)
+
+var _ specter.UnitProcessor = (*specterutils.ChangelogProcessor)(nil)
+
+func TestChangelogProcessor_Process(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	baseline := loadGenericUnits(t, fs, "/previous/apis.hcl", `api "billing" {
+  version = "1.2.0"
+  description = "Billing API"
+  deprecated = false
+
+  endpoint "create" {
+    method = "POST"
+  }
+}
+
+api "shipping" {
+  version = "0.3.0"
+}
+`)
+	current := loadGenericUnits(t, fs, "/specs/apis.hcl", `api "billing" {
+  version = "2.0.0"
+  description = "The billing API"
+  timeout = 30
+
+  endpoint "create" {
+    method = "PUT"
+  }
+}
+
+api "payments" {}
+
+worker "invoicing" {
+  version = "1.0.0"
+}
+`)
+
+	tests := []struct {
+		name      string
+		given     *specterutils.ChangelogProcessor
+		givenCtx  func(ctx specter.UnitProcessingContext) specter.UnitProcessingContext
+		thenError require.ErrorAssertionFunc
+	}{
+		{
+			name:  "GIVEN a unit change report in context WHEN processed THEN should render it",
+			given: specterutils.NewChangelogProcessor("CHANGELOG.md"),
+			givenCtx: func(ctx specter.UnitProcessingContext) specter.UnitProcessingContext {
+				detector := specterutils.NewBreakingChangeDetectionProcessor(
+					specterutils.StaticUnitBaseline(unitsOf(baseline)...),
+					specterutils.NewGenericUnitChangeRule().WithNonBreakingAttributes("description"),
+				)
+				artifacts, err := detector.Process(ctx)
+				require.NoError(t, err)
+				ctx.Artifacts = append(ctx.Artifacts, artifacts...)
+				return ctx
+			},
+		},
+		{
+			name: "GIVEN a baseline and no report in context WHEN processed THEN should compare the units against the baseline",
+			given: specterutils.NewChangelogProcessor("CHANGELOG.md").WithBaseline(
+				specterutils.StaticUnitBaseline(unitsOf(baseline)...),
+				specterutils.NewGenericUnitChangeRule().WithNonBreakingAttributes("description"),
+			),
+		},
+		{
+			name:      "GIVEN no baseline and no report in context WHEN processed THEN should return an error",
+			given:     specterutils.NewChangelogProcessor("CHANGELOG.md"),
+			thenError: testutils.RequireErrorWithCode(specterutils.ChangelogGenerationFailedErrorCode),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := specter.UnitProcessingContext{Context: context.Background(), Units: unitsOf(current)}
+			if tt.givenCtx != nil {
+				ctx = tt.givenCtx(ctx)
+			}
+
+			artifacts, err := tt.given.Process(ctx)
+			if tt.thenError != nil {
+				tt.thenError(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, artifacts, 1)
+
+			file := artifacts[0].(*specter.FileArtifact)
+			assert.Equal(t, "CHANGELOG.md", file.Path)
+			assert.Equal(t, specter.RecreateMode, file.WriteMode)
+			assert.Equal(t, `# Changelog
+
+All notable changes to the specifications are documented in this file.
+
+The format is based on [Keep a Changelog](https://keepachangelog.com/en/1.1.0/).
+
+## [Unreleased]
+
+### api
+
+#### Added
+
+- `+"`payments`"+`.
+
+## [2.0.0]
+
+### api
+
+#### Added
+
+- `+"`billing`: `timeout`"+`
+
+#### Changed
+
+- `+"`billing`: `description`"+`
+- **Breaking:** `+"`billing`: `endpoint:create.method`"+`
+
+#### Removed
+
+- **Breaking:** `+"`billing`: `deprecated`"+`
+
+## [1.0.0]
+
+### worker
+
+#### Added
+
+- `+"`invoicing`"+`.
+
+## [0.3.0]
+
+### api
+
+#### Removed
+
+- **Breaking:** `+"`shipping`"+`.
+`, string(file.Data))
+		})
+	}
+}
+
+func TestRenderChangelog(t *testing.T) {
+	assert.Equal(t, `# Release notes
+
+All notable changes to the specifications are documented in this file.
+
+The format is based on [Keep a Changelog](https://keepachangelog.com/en/1.1.0/).
+
+No changes.
+`, string(specterutils.RenderChangelog("Release notes", specterutils.UnitChangeReport{})))
+
+	unit := func(kind specter.UnitKind, id specter.UnitID, version string) specter.Unit {
+		u := specterutils.NewGenericUnit(id, kind, specter.Source{})
+		if version != "" {
+			u.Attributes = append(u.Attributes, specterutils.GenericUnitAttribute{
+				Name:  specterutils.DefaultVersionAttribute,
+				Value: specterutils.GenericValue{Value: cty.StringVal(version)},
+			})
+		}
+		return u
+	}
+	billing := unit("api", "billing", "1.10.0")
+	data := specterutils.RenderChangelog("Changelog", specterutils.UnitChangeReport{Changes: []specterutils.UnitChange{
+		{
+			ID:       "billing",
+			Kind:     "api",
+			Type:     specterutils.UnitModified,
+			Class:    specterutils.NonBreakingChange,
+			Previous: billing,
+			Current:  billing,
+			Attributes: []specterutils.AttributeChange{
+				{Path: "status", Type: specterutils.UnitModified, Class: specterutils.NonBreakingChange, Description: "promoted to stable"},
+			},
+		},
+		{ID: "invoices", Kind: "api", Type: specterutils.UnitAdded, Class: specterutils.NonBreakingChange, Current: unit("api", "invoices", "1.9.0")},
+		{ID: "legacy", Kind: "api", Type: specterutils.UnitAdded, Class: specterutils.NonBreakingChange, Current: unit("api", "legacy", "v1")},
+		{ID: "orders", Kind: "api", Type: specterutils.UnitAdded, Class: specterutils.NonBreakingChange, Current: unit("api", "orders", "1.10.0")},
+		{ID: "payments", Kind: "api", Type: specterutils.UnitAdded, Class: specterutils.NonBreakingChange, Current: unit("api", "payments", "")},
+		{ID: "invoicing", Kind: "worker", Type: specterutils.UnitRemoved, Class: specterutils.BreakingChange, Previous: unit("worker", "invoicing", "1.10.0")},
+	}})
+	assert.Equal(t, `# Changelog
+
+All notable changes to the specifications are documented in this file.
+
+The format is based on [Keep a Changelog](https://keepachangelog.com/en/1.1.0/).
+
+## [Unreleased]
+
+### api
+
+#### Added
+
+- `+"`payments`"+`.
+
+## [1.10.0]
+
+### api
+
+#### Added
+
+- `+"`orders`"+`.
+
+#### Changed
+
+- `+"`billing`: `status`"+` (promoted to stable)
+
+### worker
+
+#### Removed
+
+- **Breaking:** `+"`invoicing`"+`.
+
+## [1.9.0]
+
+### api
+
+#### Added
+
+- `+"`invoices`"+`.
+
+## [v1]
+
+### api
+
+#### Added
+
+- `+"`legacy`"+`.
+`, string(data))
+}
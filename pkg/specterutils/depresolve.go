@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"github.com/morebec/go-errors/errors"
 	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
 	"strings"
 )
 
@@ -79,26 +80,107 @@ func (p DependencyResolutionProcessor) Process(ctx specter.UnitProcessingContext
 
 func (p DependencyResolutionProcessor) resolveDependencies(units []specter.Unit) (ResolvedDependencies, error) {
 	var nodes []dependencyNode
+	constraints := map[specter.UnitID][]unitDependencyConstraint{}
 	for _, u := range units {
-		node := dependencyNode{Unit: u, Dependencies: nil}
-		for _, provider := range p.providers {
-			if !provider.Supports(u) {
-				continue
+		var ids []specter.UnitID
+		for _, d := range provideDependencies(u, p.providers) {
+			id, constraint := ParseUnitDependency(d)
+			ids = append(ids, id)
+			if constraint != "" {
+				constraints[u.ID()] = append(constraints[u.ID()], unitDependencyConstraint{ID: id, Constraint: constraint})
 			}
-			deps := provider.Provide(u)
-			node.Dependencies = newDependencySet(deps...)
-			break
 		}
-		nodes = append(nodes, node)
+		nodes = append(nodes, dependencyNode{Unit: u, Dependencies: newDependencySet(ids...)})
 	}
 
 	deps, err := newDependencyGraph(nodes...).resolve()
 	if err != nil {
 		return nil, errors.WrapWithMessage(err, DependencyResolutionFailed, "failed resolving dependencies")
 	}
+
+	if err := checkDependencyConstraints(deps, constraints); err != nil {
+		return nil, errors.WrapWithMessage(err, DependencyResolutionFailed, "failed resolving dependencies")
+	}
+
 	return deps, nil
 }
 
+// provideDependencies returns the dependencies of a unit according to the first provider supporting it.
+func provideDependencies(u specter.Unit, providers []DependencyProvider) []specter.UnitID {
+	for _, provider := range providers {
+		if provider.Supports(u) {
+			return provider.Provide(u)
+		}
+	}
+	return nil
+}
+
+// UnitDependencyConstraintSeparator separates the ID of a dependency from its version constraint, e.g. "billing@^2".
+const UnitDependencyConstraintSeparator = "@"
+
+// ParseUnitDependency splits a dependency such as "billing@^2" into the ID of the unit it depends on
+// and a VersionConstraint on its version. The constraint is empty when the dependency does not pin a version.
+func ParseUnitDependency(d specter.UnitID) (specter.UnitID, string) {
+	id, constraint, _ := strings.Cut(string(d), UnitDependencyConstraintSeparator)
+	return specter.UnitID(strings.TrimSpace(id)), strings.TrimSpace(constraint)
+}
+
+type unitDependencyConstraint struct {
+	ID         specter.UnitID
+	Constraint string
+}
+
+// checkDependencyConstraints ensures that the versions of resolved units satisfy the constraints of their dependents.
+func checkDependencyConstraints(units ResolvedDependencies, constraints map[specter.UnitID][]unitDependencyConstraint) error {
+	if len(constraints) == 0 {
+		return nil
+	}
+
+	unitByID := map[specter.UnitID]specter.Unit{}
+	for _, u := range units {
+		unitByID[u.ID()] = u
+	}
+
+	errs := errors.NewGroup(DependencyResolutionFailed)
+	for _, u := range units {
+		for _, c := range constraints[u.ID()] {
+			dependency := unitByID[c.ID]
+			version, ok := UnitVersionOf(dependency)
+			if !ok {
+				errs = errs.Append(errors.NewWithMessage(
+					DependencyResolutionFailed,
+					fmt.Sprintf("unit %q depends on %q with version %q, but it has no version", u.ID(), c.ID, c.Constraint),
+				))
+				continue
+			}
+
+			satisfied, err := version.Satisfies(c.Constraint)
+			if err != nil {
+				errs = errs.Append(errors.WrapWithMessage(
+					err,
+					DependencyResolutionFailed,
+					fmt.Sprintf("unit %q depends on %q with version %q", u.ID(), c.ID, c.Constraint),
+				))
+				continue
+			}
+			if !satisfied {
+				errs = errs.Append(errors.NewWithMessage(
+					DependencyResolutionFailed,
+					fmt.Sprintf(
+						"unit %q depends on %q with version %q, but found version %q",
+						u.ID(),
+						c.ID,
+						c.Constraint,
+						version,
+					),
+				))
+			}
+		}
+	}
+
+	return errors.GroupOrNil(errs)
+}
+
 type dependencySet map[specter.UnitID]struct{}
 
 func newDependencySet(dependencies ...specter.UnitID) dependencySet {
@@ -238,3 +320,57 @@ func (h HasDependenciesProvider) Provide(u specter.Unit) []specter.UnitID {
 	}
 	return d.Dependencies()
 }
+
+// DefaultDependsOnAttribute is the attribute of a GenericUnit listing its dependencies.
+const DefaultDependsOnAttribute = "depends_on"
+
+var _ DependencyProvider = DependsOnDependencyProvider{}
+
+// DependsOnDependencyProvider is a DependencyProvider returning the dependencies listed in an attribute of a
+// GenericUnit, e.g. depends_on = ["billing", "orders@^2"]. A dependency can pin the version of the unit it depends
+// on with a VersionConstraint, which is checked by the DependencyResolutionProcessor.
+type DependsOnDependencyProvider struct {
+	// Attribute listing the dependencies. Defaults to DefaultDependsOnAttribute.
+	Attribute string
+}
+
+func NewDependsOnDependencyProvider() DependsOnDependencyProvider {
+	return DependsOnDependencyProvider{Attribute: DefaultDependsOnAttribute}
+}
+
+func (p DependsOnDependencyProvider) Supports(u specter.Unit) bool {
+	gu, ok := u.(*GenericUnit)
+	return ok && gu.HasAttribute(p.attribute())
+}
+
+func (p DependsOnDependencyProvider) Provide(u specter.Unit) []specter.UnitID {
+	gu, ok := u.(*GenericUnit)
+	if !ok {
+		return nil
+	}
+	attr := gu.Attribute(p.attribute())
+	if attr == nil {
+		return nil
+	}
+	v, ok := attr.Value.(GenericValue)
+	if !ok || v.IsNull() || !v.IsWhollyKnown() || !v.CanIterateElements() {
+		return nil
+	}
+
+	var deps []specter.UnitID
+	for it := v.ElementIterator(); it.Next(); {
+		_, e := it.Element()
+		if e.IsNull() || e.Type() != cty.String {
+			continue
+		}
+		deps = append(deps, specter.UnitID(e.AsString()))
+	}
+	return deps
+}
+
+func (p DependsOnDependencyProvider) attribute() string {
+	if p.Attribute == "" {
+		return DefaultDependsOnAttribute
+	}
+	return p.Attribute
+}
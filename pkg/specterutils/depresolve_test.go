@@ -289,3 +289,102 @@ func TestHasDependenciesProvider_Provide(t *testing.T) {
 		})
 	}
 }
+
+func TestDependencyResolutionProcessor_Preprocess_versionConstraints(t *testing.T) {
+	tests := []struct {
+		name      string
+		given     string
+		then      []specter.UnitID
+		thenError require.ErrorAssertionFunc
+	}{
+		{
+			name: "GIVEN dependencies satisfying their version constraints WHEN resolved THEN returns resolved dependencies",
+			given: `service "orders" {
+  depends_on = ["billing@^2", "shipping"]
+}
+
+service "billing" {
+  version = "2.3.0"
+}
+
+service "shipping" {
+  version = "1.0.0"
+}
+`,
+			then: []specter.UnitID{"billing", "shipping", "orders"},
+		},
+		{
+			name: "GIVEN a dependency not satisfying its version constraint WHEN resolved THEN returns an error",
+			given: `service "orders" {
+  depends_on = ["billing@>=2.0 <3"]
+}
+
+service "billing" {
+  version = "3.0.0"
+}
+`,
+			thenError: testutils.RequireErrorWithCode(specterutils.DependencyResolutionFailed),
+		},
+		{
+			name: "GIVEN a pinned dependency without a version WHEN resolved THEN returns an error",
+			given: `service "orders" {
+  depends_on = ["billing@^2"]
+}
+
+service "billing" {}
+`,
+			thenError: testutils.RequireErrorWithCode(specterutils.DependencyResolutionFailed),
+		},
+		{
+			name: "GIVEN a dependency with an invalid version constraint WHEN resolved THEN returns an error",
+			given: `service "orders" {
+  depends_on = ["billing@latest"]
+}
+
+service "billing" {
+  version = "2.0.0"
+}
+`,
+			thenError: testutils.RequireErrorWithCode(specterutils.DependencyResolutionFailed),
+		},
+		{
+			name: "GIVEN a pinned dependency on a missing unit WHEN resolved THEN returns an error",
+			given: `service "orders" {
+  depends_on = ["billing@^2"]
+}
+`,
+			thenError: testutils.RequireErrorWithCode(specterutils.DependencyResolutionFailed),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &specter.MemoryFileSystem{}
+			units := loadGenericUnits(t, fs, "/specs/services.hcl", tt.given)
+
+			p := specterutils.NewDependencyResolutionProcessor(specterutils.NewDependsOnDependencyProvider())
+			got, err := p.Preprocess(specter.PipelineContext{}, unitsOf(units))
+			if tt.thenError != nil {
+				tt.thenError(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var ids []specter.UnitID
+			for _, u := range got {
+				ids = append(ids, u.ID())
+			}
+			assert.ElementsMatch(t, tt.then[:len(tt.then)-1], ids[:len(ids)-1])
+			assert.Equal(t, tt.then[len(tt.then)-1], ids[len(ids)-1])
+		})
+	}
+}
+
+func TestParseUnitDependency(t *testing.T) {
+	id, constraint := specterutils.ParseUnitDependency("billing@>=2.0 <3")
+	assert.Equal(t, specter.UnitID("billing"), id)
+	assert.Equal(t, ">=2.0 <3", constraint)
+
+	id, constraint = specterutils.ParseUnitDependency("billing")
+	assert.Equal(t, specter.UnitID("billing"), id)
+	assert.Equal(t, "", constraint)
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import "github.com/morebec/go-errors/errors"
+
+// FlattenErrors returns the errors contained in groups of errors, recursively.
+func FlattenErrors(err error) []error {
+	if err == nil {
+		return nil
+	}
+
+	var group errors.Group
+	if !errors.As(err, &group) {
+		return []error{err}
+	}
+
+	var errs []error
+	for _, e := range group.Errors {
+		errs = append(errs, FlattenErrors(e)...)
+	}
+	return errs
+}
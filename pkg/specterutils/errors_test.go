@@ -0,0 +1,57 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFlattenErrors(t *testing.T) {
+	first := errors.New("first")
+	second := errors.New("second")
+	third := errors.New("third")
+
+	tests := []struct {
+		name  string
+		given error
+		then  []error
+	}{
+		{
+			name:  "GIVEN no error THEN return nothing",
+			given: nil,
+			then:  nil,
+		},
+		{
+			name:  "GIVEN an error THEN return it",
+			given: first,
+			then:  []error{first},
+		},
+		{
+			name: "GIVEN nested groups of errors THEN return their errors in order",
+			given: errors.NewGroup("outer").
+				Append(first).
+				Append(errors.NewGroup("inner").Append(second).Append(third)),
+			then: []error{first, second, third},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.then, specterutils.FlattenErrors(tt.given))
+		})
+	}
+}
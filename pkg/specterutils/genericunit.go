@@ -20,6 +20,9 @@ import (
 	"github.com/zclconf/go-cty/cty"
 )
 
+var _ specter.HasSourceRange = (*GenericUnit)(nil)
+var _ specter.HasSourceRange = GenericUnitAttribute{}
+
 // GenericUnit is a generic implementation of a Unit that saves its attributes in a list of attributes for introspection.
 // these can be useful for loaders that are looser in what they allow.
 type GenericUnit struct {
@@ -27,6 +30,15 @@ type GenericUnit struct {
 	typ        specter.UnitKind
 	source     specter.Source
 	Attributes []GenericUnitAttribute
+
+	// Range of the source in which the unit is defined, when known.
+	Range specter.SourceRange
+
+	// references to other units made by the attributes of this unit.
+	references []UnitReference
+
+	// deferred attributes whose value depends on other units and remains to be evaluated.
+	deferred []hclDeferredAttribute
 }
 
 func NewGenericUnit(name specter.UnitID, typ specter.UnitKind, source specter.Source) *GenericUnit {
@@ -54,6 +66,15 @@ func (u *GenericUnit) Source() specter.Source {
 	return u.source
 }
 
+func (u *GenericUnit) SourceRange() specter.SourceRange {
+	return u.Range
+}
+
+// References returns the references made to other units by the attributes of this unit.
+func (u *GenericUnit) References() []UnitReference {
+	return u.references
+}
+
 // Attribute returns an attribute by its name or nil if it was not found.
 func (u *GenericUnit) Attribute(name string) *GenericUnitAttribute {
 	for _, a := range u.Attributes {
@@ -83,6 +104,17 @@ type AttributeType string
 type GenericUnitAttribute struct {
 	Name  string
 	Value AttributeValue
+
+	// Range of the source in which the attribute is defined, when known.
+	Range specter.SourceRange
+}
+
+func (a GenericUnitAttribute) SourceRange() specter.SourceRange {
+	return a.Range
+}
+
+func (a GenericUnitAttribute) String() string {
+	return fmt.Sprintf("{%s %v}", a.Name, a.Value)
 }
 
 type AttributeValue interface {
@@ -116,3 +148,16 @@ type ObjectValue struct {
 func (o ObjectValue) String() string {
 	return fmt.Sprintf("ObjectValue{Kind: %s, Attributes: %v}", o.Type, o.Attributes)
 }
+
+// setAttributeValueAt sets the value of an attribute located at a given path of indexes in the attributes of this unit
+// and its nested blocks.
+func (u *GenericUnit) setAttributeValueAt(path []int, value cty.Value) {
+	attrs := u.Attributes
+	for i, index := range path {
+		if i == len(path)-1 {
+			attrs[index].Value = GenericValue{value}
+			return
+		}
+		attrs = attrs[index].Value.(ObjectValue).Attributes
+	}
+}
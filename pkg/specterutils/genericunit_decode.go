@@ -0,0 +1,262 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/gocty"
+	"reflect"
+	"strings"
+)
+
+const GenericUnitDecodingFailedErrorCode = "specter.generic_unit.decoding_failed"
+
+// GenericUnitTagName is the name of the struct tag used by DecodeGenericUnit.
+const GenericUnitTagName = "specter"
+
+// DecodeGenericUnit decodes the attributes of a GenericUnit into a struct pointed to by target according to
+// the "specter" tags of its fields, in a way similar to gohcl:
+//
+//	type Service struct {
+//		Name     string     `specter:",label"`             // ID of the unit, or label of a nested block.
+//		Image    string     `specter:"image"`              // Required attribute.
+//		Replicas int        `specter:"replicas,optional"`  // Optional attribute.
+//		Env      *Env       `specter:"env,block"`          // Optional nested block.
+//		Ports    []Port     `specter:"port,block"`         // Repeated nested blocks.
+//		Raw      cty.Value  `specter:"metadata,optional"`  // Raw value.
+//	}
+//
+// Attribute values are converted to the type of their field using cty conversion rules (e.g. the string "3" can be
+// decoded into an int). Nested blocks can be decoded into a struct (exactly one block), a pointer to a struct
+// (at most one block) or a slice of structs or pointers to structs. Attributes without a corresponding field are ignored.
+//
+// All failures are reported at once, each including the ID of the unit, its source location and the path of
+// the offending field.
+func DecodeGenericUnit(u *GenericUnit, target any) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.NewWithMessage(
+			GenericUnitDecodingFailedErrorCode,
+			fmt.Sprintf("cannot decode unit %q: target should be a non-nil pointer to a struct, got %T", u.ID(), target),
+		)
+	}
+
+	d := genericUnitDecoder{
+		unit: u,
+		errs: errors.NewGroup(GenericUnitDecodingFailedErrorCode),
+	}
+	d.decodeBody("", string(u.ID()), u.Attributes, rv.Elem())
+
+	return errors.GroupOrNil(d.errs)
+}
+
+type genericUnitDecoder struct {
+	unit *GenericUnit
+	errs errors.Group
+}
+
+type genericUnitFieldTag struct {
+	name     string
+	optional bool
+	block    bool
+	label    bool
+}
+
+func parseGenericUnitFieldTag(tag string) genericUnitFieldTag {
+	parts := strings.Split(tag, ",")
+	t := genericUnitFieldTag{name: parts[0]}
+	for _, opt := range parts[1:] {
+		switch opt {
+		case "optional":
+			t.optional = true
+		case "block":
+			t.block = true
+		case "label":
+			t.label = true
+		}
+	}
+	return t
+}
+
+func (d *genericUnitDecoder) decodeBody(path string, label string, attrs []GenericUnitAttribute, v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		rawTag, ok := field.Tag.Lookup(GenericUnitTagName)
+		if !ok || rawTag == "-" || !field.IsExported() {
+			continue
+		}
+		tag := parseGenericUnitFieldTag(rawTag)
+		fv := v.Field(i)
+
+		switch {
+		case tag.label:
+			if fv.Kind() != reflect.String {
+				d.fail(path, fmt.Sprintf("label field %q should be a string", field.Name))
+				continue
+			}
+			fv.SetString(label)
+
+		case tag.block:
+			d.decodeBlocks(joinGenericUnitPath(path, tag.name), tag.name, attrs, fv)
+
+		default:
+			d.decodeAttribute(joinGenericUnitPath(path, tag.name), tag, attrs, fv)
+		}
+	}
+}
+
+func (d *genericUnitDecoder) decodeAttribute(
+	path string,
+	tag genericUnitFieldTag,
+	attrs []GenericUnitAttribute,
+	fv reflect.Value,
+) {
+	var attr *GenericUnitAttribute
+	for i := range attrs {
+		if attrs[i].Name != tag.name {
+			continue
+		}
+		if _, isBlock := attrs[i].Value.(ObjectValue); isBlock {
+			continue
+		}
+		attr = &attrs[i]
+		break
+	}
+
+	if attr == nil {
+		if !tag.optional {
+			d.fail(path, "missing required attribute")
+		}
+		return
+	}
+
+	gv, ok := attr.Value.(GenericValue)
+	if !ok {
+		d.fail(path, fmt.Sprintf("unsupported attribute value %T", attr.Value))
+		return
+	}
+
+	if err := decodeCtyValue(gv.Value, fv); err != nil {
+		d.fail(path, err.Error())
+	}
+}
+
+func (d *genericUnitDecoder) decodeBlocks(path string, typ string, attrs []GenericUnitAttribute, fv reflect.Value) {
+	var blocks []GenericUnitAttribute
+	for _, a := range attrs {
+		if obj, ok := a.Value.(ObjectValue); ok && string(obj.Type) == typ {
+			blocks = append(blocks, a)
+		}
+	}
+
+	switch {
+	case fv.Kind() == reflect.Struct:
+		if len(blocks) != 1 {
+			d.fail(path, fmt.Sprintf("exactly one %q block is required, got %d", typ, len(blocks)))
+			return
+		}
+		d.decodeBlock(path, blocks[0], fv)
+
+	case fv.Kind() == reflect.Pointer && fv.Type().Elem().Kind() == reflect.Struct:
+		if len(blocks) == 0 {
+			return
+		}
+		if len(blocks) > 1 {
+			d.fail(path, fmt.Sprintf("at most one %q block is allowed, got %d", typ, len(blocks)))
+			return
+		}
+		fv.Set(reflect.New(fv.Type().Elem()))
+		d.decodeBlock(path, blocks[0], fv.Elem())
+
+	case fv.Kind() == reflect.Slice:
+		elemType := fv.Type().Elem()
+		isPtr := elemType.Kind() == reflect.Pointer
+		if isPtr {
+			elemType = elemType.Elem()
+		}
+		if elemType.Kind() != reflect.Struct {
+			d.fail(path, fmt.Sprintf("block field should be a struct, a pointer to a struct or a slice of those, got %s", fv.Type()))
+			return
+		}
+
+		if len(blocks) == 0 {
+			return
+		}
+
+		slice := reflect.MakeSlice(fv.Type(), 0, len(blocks))
+		for i, b := range blocks {
+			elem := reflect.New(elemType)
+			d.decodeBlock(fmt.Sprintf("%s[%d]", path, i), b, elem.Elem())
+			if isPtr {
+				slice = reflect.Append(slice, elem)
+			} else {
+				slice = reflect.Append(slice, elem.Elem())
+			}
+		}
+		fv.Set(slice)
+
+	default:
+		d.fail(path, fmt.Sprintf("block field should be a struct, a pointer to a struct or a slice of those, got %s", fv.Type()))
+	}
+}
+
+func (d *genericUnitDecoder) decodeBlock(path string, block GenericUnitAttribute, v reflect.Value) {
+	obj := block.Value.(ObjectValue)
+	d.decodeBody(path, block.Name, obj.Attributes, v)
+}
+
+func (d *genericUnitDecoder) fail(path string, msg string) {
+	d.errs = d.errs.Append(errors.NewWithMessage(
+		GenericUnitDecodingFailedErrorCode,
+		fmt.Sprintf("unit %q at %q: %s: %s", d.unit.ID(), specter.UnitLocation(d.unit), path, msg),
+	))
+}
+
+var ctyValueType = reflect.TypeOf(cty.Value{})
+
+// decodeCtyValue converts a cty.Value to the type of a field and assigns it.
+func decodeCtyValue(val cty.Value, fv reflect.Value) error {
+	if fv.Type() == ctyValueType {
+		fv.Set(reflect.ValueOf(val))
+		return nil
+	}
+
+	if val.IsNull() {
+		fv.Set(reflect.Zero(fv.Type()))
+		return nil
+	}
+
+	if ty, err := gocty.ImpliedType(fv.Interface()); err == nil {
+		converted, err := convert.Convert(val, ty)
+		if err != nil {
+			return err
+		}
+		val = converted
+	}
+
+	return gocty.FromCtyValue(val, fv.Addr().Interface())
+}
+
+func joinGenericUnitPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
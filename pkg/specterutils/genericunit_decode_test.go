@@ -0,0 +1,152 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"testing"
+)
+
+type decodedServicePort struct {
+	Name     string `specter:",label"`
+	Port     int    `specter:"port"`
+	Protocol string `specter:"protocol,optional"`
+}
+
+type decodedServiceHealthCheck struct {
+	Path string `specter:"path"`
+}
+
+type decodedService struct {
+	ID          string                     `specter:",label"`
+	Image       string                     `specter:"image"`
+	Replicas    int                        `specter:"replicas,optional"`
+	Tags        []string                   `specter:"tags,optional"`
+	Env         map[string]string          `specter:"env,optional"`
+	Metadata    cty.Value                  `specter:"metadata,optional"`
+	HealthCheck *decodedServiceHealthCheck `specter:"health_check,block"`
+	Ports       []decodedServicePort       `specter:"port,block"`
+	Ignored     string
+}
+
+func TestDecodeGenericUnit(t *testing.T) {
+	src := specter.Source{Location: "/specs/billing.hcl"}
+	newUnit := func(attrs ...specterutils.GenericUnitAttribute) *specterutils.GenericUnit {
+		u := specterutils.NewGenericUnit("billing", "service", src)
+		u.Attributes = attrs
+		return u
+	}
+	attr := func(name string, v cty.Value) specterutils.GenericUnitAttribute {
+		return specterutils.GenericUnitAttribute{Name: name, Value: specterutils.GenericValue{Value: v}}
+	}
+	block := func(typ string, label string, attrs ...specterutils.GenericUnitAttribute) specterutils.GenericUnitAttribute {
+		return specterutils.GenericUnitAttribute{Name: label, Value: specterutils.ObjectValue{
+			Type:       specterutils.AttributeType(typ),
+			Attributes: attrs,
+		}}
+	}
+
+	tests := []struct {
+		name          string
+		given         *specterutils.GenericUnit
+		then          decodedService
+		thenError     require.ErrorAssertionFunc
+		thenErrorText []string
+	}{
+		{
+			name: "GIVEN a unit with attributes and blocks THEN decode them",
+			given: newUnit(
+				attr("image", cty.StringVal("billing:latest")),
+				attr("replicas", cty.StringVal("3")),
+				attr("tags", cty.TupleVal([]cty.Value{cty.StringVal("a"), cty.StringVal("b")})),
+				attr("env", cty.ObjectVal(map[string]cty.Value{"LOG_LEVEL": cty.StringVal("debug")})),
+				attr("metadata", cty.NumberIntVal(1)),
+				attr("unknown", cty.True),
+				block("health_check", "", attr("path", cty.StringVal("/health"))),
+				block("port", "http", attr("port", cty.NumberIntVal(80))),
+				block("port", "https", attr("port", cty.NumberIntVal(443)), attr("protocol", cty.StringVal("tcp"))),
+			),
+			then: decodedService{
+				ID:          "billing",
+				Image:       "billing:latest",
+				Replicas:    3,
+				Tags:        []string{"a", "b"},
+				Env:         map[string]string{"LOG_LEVEL": "debug"},
+				Metadata:    cty.NumberIntVal(1),
+				HealthCheck: &decodedServiceHealthCheck{Path: "/health"},
+				Ports: []decodedServicePort{
+					{Name: "http", Port: 80},
+					{Name: "https", Port: 443, Protocol: "tcp"},
+				},
+			},
+			thenError: require.NoError,
+		},
+		{
+			name:  "GIVEN a unit without optional attributes and blocks THEN leave them empty",
+			given: newUnit(attr("image", cty.StringVal("billing:latest"))),
+			then: decodedService{
+				ID:    "billing",
+				Image: "billing:latest",
+			},
+			thenError: require.NoError,
+		},
+		{
+			name: "GIVEN invalid fields THEN return an error for each of them",
+			given: newUnit(
+				attr("replicas", cty.StringVal("many")),
+				block("health_check", ""),
+				block("health_check", ""),
+				block("port", "http", attr("port", cty.True)),
+			),
+			thenError: testutils.RequireErrorWithCode(specterutils.GenericUnitDecodingFailedErrorCode),
+			thenErrorText: []string{
+				`unit "billing" at "/specs/billing.hcl": image: missing required attribute`,
+				`unit "billing" at "/specs/billing.hcl": replicas: a number is required`,
+				`unit "billing" at "/specs/billing.hcl": health_check: at most one "health_check" block is allowed, got 2`,
+				`unit "billing" at "/specs/billing.hcl": port[0].port: number required`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got decodedService
+			err := specterutils.DecodeGenericUnit(tt.given, &got)
+			tt.thenError(t, err)
+			if tt.thenErrorText != nil {
+				var group errors.Group
+				require.True(t, errors.As(err, &group))
+				var messages []string
+				for _, e := range group.Errors {
+					messages = append(messages, e.Error())
+				}
+				assert.Equal(t, tt.thenErrorText, messages)
+			}
+			if err == nil {
+				assert.Equal(t, tt.then, got)
+			}
+		})
+	}
+
+	t.Run("GIVEN a target that is not a pointer to a struct THEN return an error", func(t *testing.T) {
+		err := specterutils.DecodeGenericUnit(newUnit(), decodedService{})
+		testutils.RequireErrorWithCode(specterutils.GenericUnitDecodingFailedErrorCode)(t, err)
+	})
+}
@@ -0,0 +1,494 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+const UnitMutationFailedErrorCode = "specter.unit_mutation_failed"
+
+// UnitAttributePathSeparator separates the segments of the path of an attribute, e.g. "env.name".
+const UnitAttributePathSeparator = "."
+
+// UnitAttributePathLabelSeparator separates the type of block from its label in a segment of the path of an attribute,
+// e.g. "env:prod.name" designates the attribute "name" of the block `env "prod" {}`.
+const UnitAttributePathLabelSeparator = ":"
+
+// UnitMutation represents a change to a GenericUnit. Mutations are applied to units in memory, and are written back
+// to the sources of the units by a UnitSourceRewriter.
+//
+// The attributes of nested blocks are designated by paths such as "env.name" or "env:prod.name" (see
+// UnitAttributePathLabelSeparator). For sources without blocks such as YAML, a segment designates the key of a mapping,
+// and "env:prod" designates the key "prod" of the mapping "env".
+type UnitMutation interface {
+	// Apply applies the mutation to a unit in memory.
+	Apply(u *GenericUnit) error
+}
+
+// SetAttributeMutation sets the value of an attribute, adding it if it does not exist.
+type SetAttributeMutation struct {
+	Path  []string
+	Value cty.Value
+}
+
+func SetUnitAttribute(path string, value cty.Value) SetAttributeMutation {
+	return SetAttributeMutation{Path: parseUnitAttributePath(path), Value: value}
+}
+
+func (m SetAttributeMutation) Apply(u *GenericUnit) error {
+	return setUnitAttribute(u, m.Path, &m.Value)
+}
+
+// RemoveAttributeMutation removes an attribute.
+type RemoveAttributeMutation struct {
+	Path []string
+}
+
+func RemoveUnitAttribute(path string) RemoveAttributeMutation {
+	return RemoveAttributeMutation{Path: parseUnitAttributePath(path)}
+}
+
+func (m RemoveAttributeMutation) Apply(u *GenericUnit) error {
+	return setUnitAttribute(u, m.Path, nil)
+}
+
+// AddBlockMutation adds a nested block to a unit or to one of its blocks designated by Path.
+// For sources without blocks, the block is added as a mapping under the key Type, nested under its Labels.
+type AddBlockMutation struct {
+	Path       []string
+	Type       string
+	Labels     []string
+	Attributes map[string]cty.Value
+}
+
+func AddUnitBlock(path string, typ string, labels []string, attributes map[string]cty.Value) AddBlockMutation {
+	return AddBlockMutation{Path: parseUnitAttributePath(path), Type: typ, Labels: labels, Attributes: attributes}
+}
+
+func (m AddBlockMutation) Apply(u *GenericUnit) error {
+	var attrs []GenericUnitAttribute
+	var err error
+	if u.source.Format == HCLSourceFormat {
+		attrs, err = addGenericUnitBlock(u.Attributes, m)
+	} else {
+		value := cty.EmptyObjectVal
+		if len(m.Attributes) != 0 {
+			value = cty.ObjectVal(m.Attributes)
+		}
+		keys := append(append(unitAttributeKeys(m.Path), m.Type), m.Labels...)
+		attrs, err = setGenericUnitValue(u.Attributes, keys, &value, true)
+	}
+	if err != nil {
+		return newUnitMutationError(u, err)
+	}
+	u.Attributes = attrs
+	return nil
+}
+
+// attributeNames returns the names of the attributes of the block in a stable order.
+func (m AddBlockMutation) attributeNames() []string {
+	var names []string
+	for name := range m.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RenameUnitMutation changes the ID of a unit.
+type RenameUnitMutation struct {
+	ID specter.UnitID
+}
+
+func RenameUnit(id specter.UnitID) RenameUnitMutation {
+	return RenameUnitMutation{ID: id}
+}
+
+func (m RenameUnitMutation) Apply(u *GenericUnit) error {
+	u.UnitID = m.ID
+	return nil
+}
+
+// ChangeUnitKindMutation changes the kind of a unit.
+type ChangeUnitKindMutation struct {
+	Kind specter.UnitKind
+}
+
+func ChangeUnitKind(kind specter.UnitKind) ChangeUnitKindMutation {
+	return ChangeUnitKindMutation{Kind: kind}
+}
+
+func (m ChangeUnitKindMutation) Apply(u *GenericUnit) error {
+	u.typ = m.Kind
+	return nil
+}
+
+// UnitMutations are the mutations to apply to a unit.
+type UnitMutations struct {
+	Unit      *GenericUnit
+	Mutations []UnitMutation
+}
+
+// UnitSourceRewriter writes the mutations of units back to their source.
+type UnitSourceRewriter interface {
+	SupportsSource(s specter.Source) bool
+
+	// Rewrite returns the data of a source once the mutations of its units are applied to it. The units must have been
+	// loaded from the data of the source, so that they can be located in it.
+	Rewrite(s specter.Source, changes []UnitMutations) ([]byte, error)
+}
+
+// UnitMutator applies mutations to units and writes them back to their sources through a FileSystem.
+// It can be used by migration tooling, e.g. to bump the version of every service. Sources modified since their units
+// were loaded are not written back to.
+type UnitMutator struct {
+	FileSystem specter.FileSystem
+
+	// Rewriters used to write the mutations back to the sources.
+	Rewriters []UnitSourceRewriter
+}
+
+// NewUnitMutator returns a UnitMutator writing back to HCL and YAML sources unless other rewriters are provided.
+func NewUnitMutator(fs specter.FileSystem, rewriters ...UnitSourceRewriter) *UnitMutator {
+	if len(rewriters) == 0 {
+		rewriters = []UnitSourceRewriter{NewHCLUnitSourceRewriter(), NewYAMLUnitSourceRewriter()}
+	}
+	return &UnitMutator{FileSystem: fs, Rewriters: rewriters}
+}
+
+// Apply applies mutations to units and writes them back to their sources. It returns the locations of the rewritten
+// sources. The mutations of the units of a source are only applied in memory once the source was rewritten successfully.
+// Since the positions of the units in their sources change, units should be reloaded for further mutations.
+func (m UnitMutator) Apply(changes ...UnitMutations) ([]string, error) {
+	var locations []string
+	bySource := map[string][]UnitMutations{}
+	for _, c := range changes {
+		location := c.Unit.Source().Location
+		if _, ok := bySource[location]; !ok {
+			locations = append(locations, location)
+		}
+		bySource[location] = append(bySource[location], c)
+	}
+
+	errs := errors.NewGroup(UnitMutationFailedErrorCode)
+	var rewritten []string
+	for _, location := range locations {
+		if err := m.apply(bySource[location]); err != nil {
+			errs = errs.Append(err)
+			continue
+		}
+		rewritten = append(rewritten, location)
+	}
+
+	return rewritten, errors.GroupOrNil(errs)
+}
+
+func (m UnitMutator) apply(changes []UnitMutations) error {
+	src := changes[0].Unit.Source()
+	data, err := m.FileSystem.ReadFile(src.Location)
+	if err != nil {
+		return errors.WrapWithMessage(err, UnitMutationFailedErrorCode, fmt.Sprintf("failed reading source %q", src.Location))
+	}
+	// The units are located in the source by their range, writing back to a source modified since the units were
+	// loaded would overwrite its changes, or mutate the wrong parts of it.
+	if src.Data == nil {
+		src.Data = data
+	} else if !bytes.Equal(src.Data, data) {
+		return errors.NewWithMessage(
+			UnitMutationFailedErrorCode,
+			fmt.Sprintf("cannot write back to source %q, it was modified since it was loaded", src.Location),
+		)
+	}
+
+	// Apply the mutations to copies of the units first, so that invalid mutations prevent writing to the source.
+	mutated := make([]GenericUnit, len(changes))
+	for i, c := range changes {
+		mutated[i] = *c.Unit
+		for _, mutation := range c.Mutations {
+			if err := mutation.Apply(&mutated[i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	rewriter := m.rewriter(src)
+	if rewriter == nil {
+		return errors.NewWithMessage(
+			UnitMutationFailedErrorCode,
+			fmt.Sprintf("cannot write back to source %q, unsupported format %q", src.Location, src.Format),
+		)
+	}
+
+	data, err = rewriter.Rewrite(src, changes)
+	if err != nil {
+		return err
+	}
+
+	var perm fs.FileMode = 0644
+	if stat, err := m.FileSystem.StatPath(src.Location); err == nil {
+		perm = stat.Mode().Perm()
+	}
+	if err := m.FileSystem.WriteFile(src.Location, data, perm); err != nil {
+		return errors.WrapWithMessage(err, UnitMutationFailedErrorCode, fmt.Sprintf("failed writing source %q", src.Location))
+	}
+
+	for i, c := range changes {
+		*c.Unit = mutated[i]
+		c.Unit.source.Data = data
+	}
+
+	return nil
+}
+
+func (m UnitMutator) rewriter(s specter.Source) UnitSourceRewriter {
+	for _, r := range m.Rewriters {
+		if r.SupportsSource(s) {
+			return r
+		}
+	}
+	return nil
+}
+
+func parseUnitAttributePath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, UnitAttributePathSeparator)
+}
+
+// parseUnitAttributePathSegment returns the type of block and the optional label designated by a segment of a path.
+func parseUnitAttributePathSegment(segment string) (string, string) {
+	typ, label, _ := strings.Cut(segment, UnitAttributePathLabelSeparator)
+	return typ, label
+}
+
+func newUnitMutationError(u *GenericUnit, err error) error {
+	return errors.WrapWithMessage(
+		err,
+		UnitMutationFailedErrorCode,
+		fmt.Sprintf("failed mutating unit %q of kind %q at %q", u.ID(), u.Kind(), specter.UnitLocation(u)),
+	)
+}
+
+// setUnitAttribute sets the value of the attribute of a unit at a given path, or removes it when the value is nil.
+// The path designates nested blocks for HCL units, and the keys of nested objects otherwise.
+func setUnitAttribute(u *GenericUnit, path []string, value *cty.Value) error {
+	var attrs []GenericUnitAttribute
+	var err error
+	if u.source.Format == HCLSourceFormat {
+		attrs, err = setGenericUnitAttribute(u.Attributes, path, value)
+	} else {
+		attrs, err = setGenericUnitValue(u.Attributes, unitAttributeKeys(path), value, false)
+	}
+	if err != nil {
+		return newUnitMutationError(u, err)
+	}
+	u.Attributes = attrs
+	return nil
+}
+
+// unitAttributeKeys returns the keys of the nested objects designated by the segments of a path, where "env:prod"
+// designates the key "prod" of the object "env".
+func unitAttributeKeys(path []string) []string {
+	var keys []string
+	for _, segment := range path {
+		typ, label := parseUnitAttributePathSegment(segment)
+		keys = append(keys, typ)
+		if label != "" {
+			keys = append(keys, label)
+		}
+	}
+	return keys
+}
+
+// setGenericUnitValue sets the value of an attribute designated by keys of nested objects, or removes it when the value
+// is nil. Missing objects are created if requested. It returns a copy of the attributes.
+func setGenericUnitValue(attrs []GenericUnitAttribute, keys []string, value *cty.Value, create bool) ([]GenericUnitAttribute, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("attribute path should not be empty")
+	}
+	attrs = append([]GenericUnitAttribute(nil), attrs...)
+
+	index := -1
+	for i, a := range attrs {
+		if _, ok := a.Value.(GenericValue); ok && a.Name == keys[0] {
+			index = i
+			break
+		}
+	}
+
+	if len(keys) == 1 {
+		switch {
+		case value == nil && index == -1:
+			return nil, errors.New(fmt.Sprintf("attribute %q not found", keys[0]))
+		case value == nil:
+			return append(attrs[:index], attrs[index+1:]...), nil
+		case index == -1:
+			return append(attrs, GenericUnitAttribute{Name: keys[0], Value: GenericValue{*value}}), nil
+		}
+		attrs[index].Value = GenericValue{*value}
+		return attrs, nil
+	}
+
+	if index == -1 {
+		if !create {
+			return nil, errors.New(fmt.Sprintf("attribute %q not found", keys[0]))
+		}
+		attrs = append(attrs, GenericUnitAttribute{Name: keys[0], Value: GenericValue{cty.EmptyObjectVal}})
+		index = len(attrs) - 1
+	}
+
+	nested, err := setCtyObjectAttribute(attrs[index].Value.(GenericValue).Value, keys[1:], value, create)
+	if err != nil {
+		return nil, errors.WrapWithMessage(err, UnitMutationFailedErrorCode, fmt.Sprintf("invalid attribute %q", keys[0]))
+	}
+	attrs[index].Value = GenericValue{nested}
+	return attrs, nil
+}
+
+// setGenericUnitAttribute sets the value of the attribute at a given path of nested blocks, or removes it when the
+// value is nil. It returns a copy of the attributes.
+func setGenericUnitAttribute(attrs []GenericUnitAttribute, path []string, value *cty.Value) ([]GenericUnitAttribute, error) {
+	if len(path) == 0 {
+		return nil, errors.New("attribute path should not be empty")
+	}
+	attrs = append([]GenericUnitAttribute(nil), attrs...)
+
+	if len(path) == 1 {
+		for i, a := range attrs {
+			if _, ok := a.Value.(ObjectValue); ok || a.Name != path[0] {
+				continue
+			}
+			if value == nil {
+				return append(attrs[:i], attrs[i+1:]...), nil
+			}
+			attrs[i].Value = GenericValue{*value}
+			return attrs, nil
+		}
+
+		if value == nil {
+			return nil, errors.New(fmt.Sprintf("attribute %q not found", path[0]))
+		}
+		return append(attrs, GenericUnitAttribute{Name: path[0], Value: GenericValue{*value}}), nil
+	}
+
+	typ, label := parseUnitAttributePathSegment(path[0])
+	for i, a := range attrs {
+		switch v := a.Value.(type) {
+		case ObjectValue:
+			if string(v.Type) != typ || (label != "" && a.Name != label) {
+				continue
+			}
+			nested, err := setGenericUnitAttribute(v.Attributes, path[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			attrs[i].Value = ObjectValue{Type: v.Type, Attributes: nested}
+			return attrs, nil
+
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("block %q not found", path[0]))
+}
+
+// setCtyObjectAttribute sets the value of the attribute at a given path of nested objects, or removes it when the value
+// is nil. Missing objects are created if requested.
+func setCtyObjectAttribute(obj cty.Value, path []string, value *cty.Value, create bool) (cty.Value, error) {
+	if obj.IsNull() || !obj.IsKnown() || !(obj.Type().IsObjectType() || obj.Type().IsMapType()) {
+		return cty.NilVal, errors.New("value is not an object")
+	}
+
+	values := obj.AsValueMap()
+	if values == nil {
+		values = map[string]cty.Value{}
+	}
+
+	if len(path) == 1 {
+		if value == nil {
+			if _, ok := values[path[0]]; !ok {
+				return cty.NilVal, errors.New(fmt.Sprintf("attribute %q not found", path[0]))
+			}
+			delete(values, path[0])
+		} else {
+			values[path[0]] = *value
+		}
+	} else {
+		nested, ok := values[path[0]]
+		if !ok && !create {
+			return cty.NilVal, errors.New(fmt.Sprintf("attribute %q not found", path[0]))
+		}
+		if !ok {
+			nested = cty.EmptyObjectVal
+		}
+		v, err := setCtyObjectAttribute(nested, path[1:], value, create)
+		if err != nil {
+			return cty.NilVal, err
+		}
+		values[path[0]] = v
+	}
+
+	if len(values) == 0 {
+		return cty.EmptyObjectVal, nil
+	}
+	return cty.ObjectVal(values), nil
+}
+
+// addGenericUnitBlock adds a block to the nested block designated by the path of a mutation.
+// It returns a copy of the attributes.
+func addGenericUnitBlock(attrs []GenericUnitAttribute, m AddBlockMutation) ([]GenericUnitAttribute, error) {
+	attrs = append([]GenericUnitAttribute(nil), attrs...)
+
+	if len(m.Path) == 0 {
+		var blockAttrs []GenericUnitAttribute
+		for _, name := range m.attributeNames() {
+			blockAttrs = append(blockAttrs, GenericUnitAttribute{Name: name, Value: GenericValue{m.Attributes[name]}})
+		}
+		name := ""
+		if len(m.Labels) != 0 {
+			name = m.Labels[0]
+		}
+		return append(attrs, GenericUnitAttribute{
+			Name:  name,
+			Value: ObjectValue{Type: AttributeType(m.Type), Attributes: blockAttrs},
+		}), nil
+	}
+
+	typ, label := parseUnitAttributePathSegment(m.Path[0])
+	for i, a := range attrs {
+		v, ok := a.Value.(ObjectValue)
+		if !ok || string(v.Type) != typ || (label != "" && a.Name != label) {
+			continue
+		}
+		nested := m
+		nested.Path = m.Path[1:]
+		nestedAttrs, err := addGenericUnitBlock(v.Attributes, nested)
+		if err != nil {
+			return nil, err
+		}
+		attrs[i].Value = ObjectValue{Type: v.Type, Attributes: nestedAttrs}
+		return attrs, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("block %q not found", m.Path[0]))
+}
@@ -0,0 +1,416 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"path/filepath"
+	"testing"
+)
+
+// loadGenericUnits loads the generic units of a file of a specter.MemoryFileSystem.
+func loadGenericUnits(t *testing.T, fs *specter.MemoryFileSystem, path string, data string) []*specterutils.GenericUnit {
+	t.Helper()
+	require.NoError(t, fs.Mkdir(filepath.Dir(path), 0755))
+	require.NoError(t, fs.WriteFile(path, []byte(data), 0600))
+
+	sources, err := specter.NewFileSystemSourceLoader(fs).Load(path)
+	require.NoError(t, err)
+	require.Len(t, sources, 1)
+
+	hclLoader := specterutils.NewHCLGenericUnitLoader()
+	hclLoader.DeferUnitReferences = true
+	var loader specter.UnitLoader = hclLoader
+	if sources[0].Format == specterutils.YAMLSourceFormat {
+		loader = specterutils.NewYAMLGenericUnitLoader()
+	}
+	loaded, err := loader.Load(sources[0])
+	require.NoError(t, err)
+
+	var units []*specterutils.GenericUnit
+	for _, u := range loaded {
+		units = append(units, u.(*specterutils.GenericUnit))
+	}
+	return units
+}
+
+func TestUnitMutator_Apply_HCL(t *testing.T) {
+	const given = `# Billing services.
+service "billing" {
+  # The image to deploy.
+  image   = "billing:1.0" // pinned
+  version = "1.0.0"
+  legacy  = true
+
+  env "prod" {
+    replicas = 2
+  }
+}
+
+service "orders" {
+  version = "1.0.0"
+}
+`
+
+	tests := []struct {
+		name      string
+		when      map[specter.UnitID][]specterutils.UnitMutation
+		then      string
+		thenError require.ErrorAssertionFunc
+	}{
+		{
+			name: "GIVEN attribute mutations WHEN applied THEN should rewrite the source preserving comments",
+			when: map[specter.UnitID][]specterutils.UnitMutation{
+				"billing": {
+					specterutils.SetUnitAttribute("image", cty.StringVal("billing:2.0")),
+					specterutils.SetUnitAttribute("version", cty.StringVal("2.0.0")),
+					specterutils.SetUnitAttribute("env:prod.replicas", cty.NumberIntVal(3)),
+					specterutils.RemoveUnitAttribute("legacy"),
+				},
+				"orders": {
+					specterutils.SetUnitAttribute("version", cty.StringVal("2.0.0")),
+				},
+			},
+			then: `# Billing services.
+service "billing" {
+  # The image to deploy.
+  image   = "billing:2.0" // pinned
+  version = "2.0.0"
+
+  env "prod" {
+    replicas = 3
+  }
+}
+
+service "orders" {
+  version = "2.0.0"
+}
+`,
+		},
+		{
+			name: "GIVEN structural mutations WHEN applied THEN should rewrite the source",
+			when: map[specter.UnitID][]specterutils.UnitMutation{
+				"orders": {
+					specterutils.RenameUnit("ordering"),
+					specterutils.ChangeUnitKind("api"),
+					specterutils.AddUnitBlock("", "env", []string{"staging"}, map[string]cty.Value{
+						"replicas": cty.NumberIntVal(1),
+					}),
+				},
+			},
+			then: `# Billing services.
+service "billing" {
+  # The image to deploy.
+  image   = "billing:1.0" // pinned
+  version = "1.0.0"
+  legacy  = true
+
+  env "prod" {
+    replicas = 2
+  }
+}
+
+api "ordering" {
+  version = "1.0.0"
+  env "staging" {
+    replicas = 1
+  }
+}
+`,
+		},
+		{
+			name: "GIVEN a mutation of a missing block WHEN applied THEN should return an error and leave the source unchanged",
+			when: map[specter.UnitID][]specterutils.UnitMutation{
+				"orders": {
+					specterutils.SetUnitAttribute("version", cty.StringVal("2.0.0")),
+					specterutils.SetUnitAttribute("env:prod.replicas", cty.NumberIntVal(3)),
+				},
+			},
+			then:      given,
+			thenError: testutils.RequireErrorWithCode(specterutils.UnitMutationFailedErrorCode),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &specter.MemoryFileSystem{}
+			units := loadGenericUnits(t, fs, "/specs/services.hcl", given)
+
+			var changes []specterutils.UnitMutations
+			for _, u := range units {
+				if mutations, ok := tt.when[u.ID()]; ok {
+					changes = append(changes, specterutils.UnitMutations{Unit: u, Mutations: mutations})
+				}
+			}
+
+			_, err := specterutils.NewUnitMutator(fs).Apply(changes...)
+			if tt.thenError != nil {
+				tt.thenError(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			data, err := fs.ReadFile("/specs/services.hcl")
+			require.NoError(t, err)
+			assert.Equal(t, tt.then, string(data))
+		})
+	}
+}
+
+func TestUnitMutator_Apply_HCL_preservesUnchangedFormatting(t *testing.T) {
+	const given = `service "billing" {
+  image="billing:1.0"
+  version = "1.0.0"
+  tags    =   ["a","b"]
+}
+`
+	fs := &specter.MemoryFileSystem{}
+	units := loadGenericUnits(t, fs, "/specs/services.hcl", given)
+
+	_, err := specterutils.NewUnitMutator(fs).Apply(specterutils.UnitMutations{
+		Unit: units[0],
+		Mutations: []specterutils.UnitMutation{
+			specterutils.SetUnitAttribute("version", cty.StringVal("2.0.0")),
+			specterutils.AddUnitBlock("", "env", []string{"prod"}, map[string]cty.Value{"replicas": cty.NumberIntVal(1)}),
+		},
+	})
+	require.NoError(t, err)
+
+	data, err := fs.ReadFile("/specs/services.hcl")
+	require.NoError(t, err)
+	assert.Equal(t, `service "billing" {
+  image="billing:1.0"
+  version = "2.0.0"
+  tags    =   ["a","b"]
+  env "prod" {
+    replicas = 1
+  }
+}
+`, string(data))
+}
+
+func TestUnitMutator_Apply_YAML(t *testing.T) {
+	const given = `# Billing service.
+kind: service
+id: billing
+version: 1.0.0 # current
+env:
+  prod:
+    replicas: 2
+legacy: true
+---
+kind: service
+id: orders
+version: 1.0.0
+`
+
+	fs := &specter.MemoryFileSystem{}
+	units := loadGenericUnits(t, fs, "/specs/services.yaml", given)
+	require.Len(t, units, 2)
+
+	rewritten, err := specterutils.NewUnitMutator(fs).Apply(
+		specterutils.UnitMutations{Unit: units[0], Mutations: []specterutils.UnitMutation{
+			specterutils.SetUnitAttribute("version", cty.StringVal("2.0.0")),
+			specterutils.SetUnitAttribute("env:prod.replicas", cty.NumberIntVal(3)),
+			specterutils.RemoveUnitAttribute("legacy"),
+			specterutils.AddUnitBlock("env", "staging", nil, map[string]cty.Value{"replicas": cty.NumberIntVal(1)}),
+		}},
+		specterutils.UnitMutations{Unit: units[1], Mutations: []specterutils.UnitMutation{
+			specterutils.RenameUnit("ordering"),
+			specterutils.ChangeUnitKind("api"),
+		}},
+	)
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/specs/services.yaml"}, rewritten)
+
+	data, err := fs.ReadFile("/specs/services.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, `# Billing service.
+kind: service
+id: billing
+version: 2.0.0 # current
+env:
+  prod:
+    replicas: 3
+  staging:
+    replicas: 1
+---
+kind: api
+id: ordering
+version: 1.0.0
+`, string(data))
+
+	// The units should be mutated in memory as well, and reloading the source should yield the same attributes.
+	reloaded := loadGenericUnits(t, fs, "/specs/services.yaml", string(data))
+	assert.Equal(t, specter.UnitID("ordering"), units[1].ID())
+	assert.Equal(t, specter.UnitKind("api"), units[1].Kind())
+	assert.Equal(t, withoutSourceRanges(unitsOf(reloaded)), withoutSourceRanges(unitsOf(units)))
+}
+
+func TestUnitMutator_Apply_YAML_mixedStyles(t *testing.T) {
+	const given = `kind: "service"
+id: 'billing'
+env:
+    prod: {replicas: 2}
+tags: [a, b]
+--- # Orders service.
+kind: "service"
+id: 'orders'
+env:
+    prod: {replicas: 2}
+tags: [a, b]
+`
+
+	fs := &specter.MemoryFileSystem{}
+	units := loadGenericUnits(t, fs, "/specs/services.yaml", given)
+	require.Len(t, units, 2)
+
+	_, err := specterutils.NewUnitMutator(fs).Apply(specterutils.UnitMutations{
+		Unit:      units[1],
+		Mutations: []specterutils.UnitMutation{specterutils.SetUnitAttribute("env:prod.replicas", cty.NumberIntVal(3))},
+	})
+	require.NoError(t, err)
+
+	// The document of the mutated unit is normalized, while the other one is left untouched.
+	data, err := fs.ReadFile("/specs/services.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, `kind: "service"
+id: 'billing'
+env:
+    prod: {replicas: 2}
+tags: [a, b]
+---
+# Orders service.
+kind: "service"
+id: 'orders'
+env:
+  prod: {replicas: 3}
+tags: [a, b]
+`, string(data))
+}
+
+func TestUnitMutator_Apply_modifiedSource(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	units := loadGenericUnits(t, fs, "/specs/services.yaml", "kind: service\nid: billing\n")
+	require.NoError(t, fs.WriteFile("/specs/services.yaml", []byte("kind: service\nid: billing\nimage: billing:2.0\n"), 0600))
+
+	_, err := specterutils.NewUnitMutator(fs).Apply(specterutils.UnitMutations{
+		Unit:      units[0],
+		Mutations: []specterutils.UnitMutation{specterutils.SetUnitAttribute("version", cty.StringVal("2.0.0"))},
+	})
+	testutils.RequireErrorWithCode(specterutils.UnitMutationFailedErrorCode)(t, err)
+	assert.ErrorContains(t, err, "was modified since it was loaded")
+
+	data, err := fs.ReadFile("/specs/services.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "kind: service\nid: billing\nimage: billing:2.0\n", string(data))
+}
+
+func TestUnitMutator_Apply_YAML_values(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	units := loadGenericUnits(t, fs, "/specs/services.yaml", "kind: service\nid: billing\n")
+
+	bigInt, err := cty.ParseNumberVal("123456789012345678901234567890")
+	require.NoError(t, err)
+
+	_, err = specterutils.NewUnitMutator(fs).Apply(specterutils.UnitMutations{
+		Unit: units[0],
+		Mutations: []specterutils.UnitMutation{
+			specterutils.SetUnitAttribute("quota", bigInt),
+			specterutils.SetUnitAttribute("ratio", cty.NumberFloatVal(0.25)),
+			specterutils.SetUnitAttribute("enabled", cty.StringVal("true")),
+			specterutils.SetUnitAttribute("labels", cty.ObjectVal(map[string]cty.Value{
+				"tier":  cty.StringVal("backend"),
+				"ports": cty.TupleVal([]cty.Value{cty.NumberIntVal(80), cty.NullVal(cty.Number)}),
+			})),
+		},
+	})
+	require.NoError(t, err)
+
+	data, err := fs.ReadFile("/specs/services.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, `kind: service
+id: billing
+quota: 123456789012345678901234567890
+ratio: 0.25
+enabled: "true"
+labels:
+  ports:
+    - 80
+    - null
+  tier: backend
+`, string(data))
+
+	reloaded := loadGenericUnits(t, fs, "/specs/services.yaml", string(data))
+	assert.True(t, reloaded[0].Attribute("quota").Value.(specterutils.GenericValue).Equals(bigInt).True())
+
+	_, err = specterutils.NewUnitMutator(fs).Apply(specterutils.UnitMutations{
+		Unit:      units[0],
+		Mutations: []specterutils.UnitMutation{specterutils.SetUnitAttribute("quota", cty.UnknownVal(cty.Number))},
+	})
+	testutils.RequireErrorWithCode(specterutils.UnitMutationFailedErrorCode)(t, err)
+}
+
+func TestGenericUnitMutations_Apply(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	units := loadGenericUnits(t, fs, "/specs/billing.hcl", `service "billing" {
+  version = "1.0.0"
+  env "prod" {
+    replicas = 2
+  }
+}
+`)
+	u := units[0]
+
+	require.NoError(t, specterutils.SetUnitAttribute("env:prod.replicas", cty.NumberIntVal(3)).Apply(u))
+	require.NoError(t, specterutils.RemoveUnitAttribute("version").Apply(u))
+	require.NoError(t, specterutils.AddUnitBlock("", "env", []string{"staging"}, map[string]cty.Value{
+		"replicas": cty.NumberIntVal(1),
+	}).Apply(u))
+
+	assert.False(t, u.HasAttribute("version"))
+	require.Len(t, u.Attributes, 2)
+	assert.Equal(t, specterutils.ObjectValue{
+		Type: "env",
+		Attributes: []specterutils.GenericUnitAttribute{
+			{Name: "replicas", Value: specterutils.GenericValue{Value: cty.NumberIntVal(3)}, Range: u.Attributes[0].Value.(specterutils.ObjectValue).Attributes[0].Range},
+		},
+	}, u.Attributes[0].Value)
+	assert.Equal(t, specterutils.GenericUnitAttribute{
+		Name: "staging",
+		Value: specterutils.ObjectValue{
+			Type: "env",
+			Attributes: []specterutils.GenericUnitAttribute{
+				{Name: "replicas", Value: specterutils.GenericValue{Value: cty.NumberIntVal(1)}},
+			},
+		},
+	}, u.Attributes[1])
+
+	err := specterutils.RemoveUnitAttribute("missing").Apply(u)
+	testutils.RequireErrorWithCode(specterutils.UnitMutationFailedErrorCode)(t, err)
+}
+
+func unitsOf(units []*specterutils.GenericUnit) []specter.Unit {
+	var result []specter.Unit
+	for _, u := range units {
+		result = append(result, u)
+	}
+	return result
+}
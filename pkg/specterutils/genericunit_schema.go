@@ -0,0 +1,502 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const GenericUnitSchemaViolationErrorCode = "specter.generic_unit.schema_violation"
+
+// UnitSchema describes the attributes and nested blocks expected from the GenericUnit of a given kind.
+// Attributes and nested blocks that are not described by the schema are not allowed.
+type UnitSchema struct {
+	Kind        specter.UnitKind
+	Description string
+	Attributes  []AttributeSchema
+	Blocks      []BlockSchema
+}
+
+// AttributeSchema describes an attribute of a unit or of a nested block.
+type AttributeSchema struct {
+	Name        string
+	Description string
+
+	// Type of the attribute. Values are accepted if they can be converted to this type.
+	// Defaults to cty.DynamicPseudoType, which accepts any value.
+	Type cty.Type
+
+	Required bool
+
+	// Enum restricts the values of the attribute to a list of allowed values.
+	Enum []cty.Value
+
+	// Pattern is a regular expression that the values of a string attribute must match.
+	Pattern *regexp.Regexp
+}
+
+// BlockSchema describes a nested block of a unit or of another nested block.
+type BlockSchema struct {
+	Type        AttributeType
+	Description string
+
+	// Labeled indicates if blocks of this type must have a label (e.g. endpoint "http" {}) or must not have one.
+	Labeled bool
+
+	// Required indicates if at least one block of this type must be defined.
+	Required bool
+
+	// MaxItems is the maximum number of blocks of this type. Zero means no limit.
+	MaxItems int
+
+	Attributes []AttributeSchema
+	Blocks     []BlockSchema
+}
+
+// UnitSchemaRegistry holds the UnitSchema of unit kinds.
+type UnitSchemaRegistry struct {
+	schemas map[specter.UnitKind]UnitSchema
+}
+
+func NewUnitSchemaRegistry(schemas ...UnitSchema) *UnitSchemaRegistry {
+	r := &UnitSchemaRegistry{schemas: map[specter.UnitKind]UnitSchema{}}
+	for _, s := range schemas {
+		r.Register(s)
+	}
+	return r
+}
+
+// Register registers the schema of a unit kind, replacing any schema previously registered for this kind.
+func (r *UnitSchemaRegistry) Register(s UnitSchema) {
+	r.schemas[s.Kind] = s
+}
+
+// Schema returns the schema of a unit kind or false if none was registered.
+func (r *UnitSchemaRegistry) Schema(kind specter.UnitKind) (UnitSchema, bool) {
+	s, ok := r.schemas[kind]
+	return s, ok
+}
+
+// Schemas returns the registered schemas sorted by kind.
+func (r *UnitSchemaRegistry) Schemas() []UnitSchema {
+	schemas := make([]UnitSchema, 0, len(r.schemas))
+	for _, s := range r.schemas {
+		schemas = append(schemas, s)
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Kind < schemas[j].Kind })
+	return schemas
+}
+
+var _ specter.UnitPreprocessor = UnitSchemaValidator{}
+var _ UnitLinter = UnitSchemaValidator{}
+
+// UnitSchemaValidator validates GenericUnit against the UnitSchema of their kind. It can be used both as a UnitLinter
+// and as a specter.UnitPreprocessor failing the pipeline on violations.
+// Units that are not GenericUnit or whose kind has no schema are ignored.
+//
+// Since YAML and JSON sources cannot express blocks, their nested blocks are attributes named after the type of the
+// blocks holding an object, a list of objects, or objects by label when the blocks are labeled.
+//
+// The violations returned by Preprocess keep the range of their LinterResult, as a specter.HasSourceRange.
+type UnitSchemaValidator struct {
+	// Registry of the schemas to validate units against. A validator without a registry fails as a
+	// specter.UnitPreprocessor and reports no results as a UnitLinter.
+	Registry *UnitSchemaRegistry
+
+	// Severity of the violations when used as a UnitLinter. Defaults to ErrorSeverity.
+	Severity LinterResultSeverity
+}
+
+func NewUnitSchemaValidator(registry *UnitSchemaRegistry) *UnitSchemaValidator {
+	return &UnitSchemaValidator{Registry: registry, Severity: ErrorSeverity}
+}
+
+func (v UnitSchemaValidator) Name() string {
+	return "unit_schema_validator"
+}
+
+func (v UnitSchemaValidator) Preprocess(_ specter.PipelineContext, units []specter.Unit) ([]specter.Unit, error) {
+	if v.Registry == nil {
+		return nil, errors.NewWithMessage(GenericUnitSchemaViolationErrorCode, "no unit schema registry configured")
+	}
+
+	errs := errors.NewGroup(GenericUnitSchemaViolationErrorCode)
+	for _, r := range v.validate(units, ErrorSeverity) {
+		errs = errs.Append(errors.Wrap(r, GenericUnitSchemaViolationErrorCode))
+	}
+	if errs.HasErrors() {
+		return nil, errs
+	}
+	return units, nil
+}
+
+func (v UnitSchemaValidator) Lint(units specter.UnitGroup) LinterResultSet {
+	severity := v.Severity
+	if severity == "" {
+		severity = ErrorSeverity
+	}
+	return v.validate(units, severity)
+}
+
+func (v UnitSchemaValidator) validate(units []specter.Unit, severity LinterResultSeverity) LinterResultSet {
+	if v.Registry == nil {
+		return nil
+	}
+
+	var results LinterResultSet
+	for _, u := range units {
+		gu, ok := u.(*GenericUnit)
+		if !ok {
+			continue
+		}
+		schema, ok := v.Registry.Schema(gu.Kind())
+		if !ok {
+			continue
+		}
+
+		val := unitSchemaValidation{unit: gu, severity: severity}
+		val.validateBody(gu.Attributes, schema.Attributes, schema.Blocks, gu.Range, "")
+		results = append(results, val.results...)
+	}
+	return results
+}
+
+// unitSchemaValidation accumulates the violations of a unit.
+type unitSchemaValidation struct {
+	unit     *GenericUnit
+	severity LinterResultSeverity
+	results  LinterResultSet
+}
+
+// validateBody validates the attributes of a unit or nested block defined in a given range. The path is the path of
+// the nested block, used in messages.
+func (v *unitSchemaValidation) validateBody(
+	attrs []GenericUnitAttribute,
+	attrSchemas []AttributeSchema,
+	blockSchemas []BlockSchema,
+	bodyRange specter.SourceRange,
+	path string,
+) {
+	attrsByName := map[string]AttributeSchema{}
+	var attrNames []string
+	for _, s := range attrSchemas {
+		attrsByName[s.Name] = s
+		attrNames = append(attrNames, s.Name)
+	}
+
+	blocksByType := map[AttributeType]BlockSchema{}
+	var blockTypes []string
+	for _, s := range blockSchemas {
+		blocksByType[s.Type] = s
+		blockTypes = append(blockTypes, string(s.Type))
+	}
+
+	defined := map[string]bool{}
+	blockCounts := map[AttributeType]int{}
+
+	for _, a := range attrs {
+		switch value := a.Value.(type) {
+		case ObjectValue:
+			s, ok := blocksByType[value.Type]
+			if !ok {
+				v.report(a.Range, bodyRange, "unknown block type %q%s", joinSchemaPath(path, string(value.Type)), didYouMean(string(value.Type), blockTypes))
+				continue
+			}
+			blockCounts[value.Type]++
+			v.validateBlock(a, value, s, bodyRange, path)
+
+		case GenericValue:
+			s, ok := attrsByName[a.Name]
+			if !ok {
+				if bs, ok := blocksByType[AttributeType(a.Name)]; ok {
+					// YAML and JSON sources define nested blocks as object values rather than as ObjectValue.
+					// Values that are not yet known, such as references to other units, cannot be validated.
+					if !value.Value.IsWhollyKnown() {
+						blockCounts[bs.Type]++
+						continue
+					}
+					blocks, valid := v.blocksOfValue(a, value.Value, bs, bodyRange, path)
+					if !valid {
+						continue
+					}
+					blockCounts[bs.Type] += len(blocks)
+					for _, b := range blocks {
+						v.validateBlock(b, b.Value.(ObjectValue), bs, bodyRange, path)
+					}
+					continue
+				}
+				v.report(a.Range, bodyRange, "unknown attribute %q%s", joinSchemaPath(path, a.Name), didYouMean(a.Name, attrNames))
+				continue
+			}
+			defined[a.Name] = true
+			v.validateAttribute(a, value.Value, s, bodyRange, path)
+		}
+	}
+
+	for _, s := range attrSchemas {
+		if s.Required && !defined[s.Name] {
+			v.report(bodyRange, bodyRange, "missing required attribute %q", joinSchemaPath(path, s.Name))
+		}
+	}
+
+	for _, s := range blockSchemas {
+		count := blockCounts[s.Type]
+		if s.Required && count == 0 {
+			v.report(bodyRange, bodyRange, "missing required block %q", joinSchemaPath(path, string(s.Type)))
+		}
+		if s.MaxItems > 0 && count > s.MaxItems {
+			v.report(bodyRange, bodyRange, "too many blocks %q, expected at most %d but got %d", joinSchemaPath(path, string(s.Type)), s.MaxItems, count)
+		}
+	}
+}
+
+func (v *unitSchemaValidation) validateBlock(
+	a GenericUnitAttribute,
+	value ObjectValue,
+	s BlockSchema,
+	parentRange specter.SourceRange,
+	path string,
+) {
+	blockPath := joinSchemaPath(path, string(value.Type))
+	if a.Name != "" {
+		blockPath = fmt.Sprintf("%s[%q]", blockPath, a.Name)
+	}
+
+	if s.Labeled && a.Name == "" {
+		v.report(a.Range, parentRange, "block %q should have a label", blockPath)
+	}
+	if !s.Labeled && a.Name != "" {
+		v.report(a.Range, parentRange, "block %q should not have a label", blockPath)
+	}
+
+	blockRange := a.Range
+	if blockRange.IsZero() {
+		blockRange = parentRange
+	}
+	v.validateBody(value.Attributes, s.Attributes, s.Blocks, blockRange, blockPath)
+}
+
+// blocksOfValue returns the nested blocks defined by the value of an attribute named after a block type, as defined in
+// YAML and JSON sources: an object by label for labeled blocks, otherwise an object or a list of objects.
+// It reports a violation and returns false when the value does not have this shape.
+func (v *unitSchemaValidation) blocksOfValue(
+	a GenericUnitAttribute,
+	value cty.Value,
+	s BlockSchema,
+	parentRange specter.SourceRange,
+	path string,
+) ([]GenericUnitAttribute, bool) {
+	name := joinSchemaPath(path, string(s.Type))
+	if value.IsNull() {
+		return nil, true
+	}
+
+	t := value.Type()
+	var blocks []GenericUnitAttribute
+	switch {
+	case s.Labeled && (t.IsObjectType() || t.IsMapType()):
+		for it := value.ElementIterator(); it.Next(); {
+			label, body := it.Element()
+			if !isCtyObjectValue(body) {
+				v.report(a.Range, parentRange, "block %q should be an object", fmt.Sprintf("%s[%q]", name, label.AsString()))
+				return nil, false
+			}
+			blocks = append(blocks, newGenericValueBlock(a, label.AsString(), s.Type, body))
+		}
+	case s.Labeled:
+		v.report(a.Range, parentRange, "block %q should be an object of blocks by label", name)
+		return nil, false
+	case t.IsObjectType() || t.IsMapType():
+		blocks = append(blocks, newGenericValueBlock(a, "", s.Type, value))
+	case t.IsTupleType() || t.IsListType() || t.IsSetType():
+		for it := value.ElementIterator(); it.Next(); {
+			_, body := it.Element()
+			if !isCtyObjectValue(body) {
+				v.report(a.Range, parentRange, "block %q should be an object or a list of objects", name)
+				return nil, false
+			}
+			blocks = append(blocks, newGenericValueBlock(a, "", s.Type, body))
+		}
+	default:
+		v.report(a.Range, parentRange, "block %q should be an object or a list of objects", name)
+		return nil, false
+	}
+
+	return blocks, true
+}
+
+// newGenericValueBlock converts an object value defining a nested block to an attribute holding an ObjectValue, as
+// loaded from HCL sources. Its attributes are located at the range of the attribute defining the block.
+func newGenericValueBlock(a GenericUnitAttribute, label string, typ AttributeType, body cty.Value) GenericUnitAttribute {
+	block := ObjectValue{Type: typ}
+	for it := body.ElementIterator(); it.Next(); {
+		k, v := it.Element()
+		block.Attributes = append(block.Attributes, GenericUnitAttribute{
+			Name:  k.AsString(),
+			Value: GenericValue{v},
+			Range: a.Range,
+		})
+	}
+	return GenericUnitAttribute{Name: label, Value: block, Range: a.Range}
+}
+
+func isCtyObjectValue(v cty.Value) bool {
+	return !v.IsNull() && (v.Type().IsObjectType() || v.Type().IsMapType())
+}
+
+func (v *unitSchemaValidation) validateAttribute(
+	a GenericUnitAttribute,
+	value cty.Value,
+	s AttributeSchema,
+	parentRange specter.SourceRange,
+	path string,
+) {
+	name := joinSchemaPath(path, a.Name)
+
+	// Values that are not yet known, such as references to other units, cannot be validated.
+	if !value.IsWhollyKnown() {
+		return
+	}
+	if value.IsNull() {
+		if s.Required {
+			v.report(a.Range, parentRange, "attribute %q should not be null", name)
+		}
+		return
+	}
+
+	if s.Type != cty.NilType && s.Type != cty.DynamicPseudoType {
+		converted, err := convert.Convert(value, s.Type)
+		if err != nil {
+			v.report(a.Range, parentRange, "attribute %q should be of type %s: %s", name, s.Type.FriendlyName(), err)
+			return
+		}
+		value = converted
+	}
+
+	if len(s.Enum) != 0 {
+		allowed := false
+		var values []string
+		for _, e := range s.Enum {
+			values = append(values, formatCtyValue(e))
+			if e.Type().Equals(value.Type()) && e.Equals(value).True() {
+				allowed = true
+			}
+		}
+		if !allowed {
+			v.report(a.Range, parentRange, "attribute %q should be one of %s but got %s", name, strings.Join(values, ", "), formatCtyValue(value))
+		}
+	}
+
+	if s.Pattern != nil {
+		if value.Type() != cty.String {
+			v.report(a.Range, parentRange, "attribute %q should be a string matching %q", name, s.Pattern.String())
+			return
+		}
+		if !s.Pattern.MatchString(value.AsString()) {
+			v.report(a.Range, parentRange, "attribute %q should match %q but got %q", name, s.Pattern.String(), value.AsString())
+		}
+	}
+}
+
+// report adds a violation located at a given range, or at a fallback range when it is unknown.
+func (v *unitSchemaValidation) report(r specter.SourceRange, fallback specter.SourceRange, format string, args ...any) {
+	if r.IsZero() {
+		r = fallback
+	}
+	location := v.unit.Source().Location
+	if !r.IsZero() {
+		location = r.String()
+	}
+
+	v.results = append(v.results, LinterResult{
+		Severity: v.severity,
+		Message: fmt.Sprintf(
+			"unit %q of kind %q at %q: %s",
+			v.unit.ID(),
+			v.unit.Kind(),
+			location,
+			fmt.Sprintf(format, args...),
+		),
+		Range: r,
+	})
+}
+
+func joinSchemaPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// formatCtyValue formats a value for messages using its JSON representation, e.g. "a", 3 or true.
+func formatCtyValue(v cty.Value) string {
+	data, err := ctyjson.Marshal(v, v.Type())
+	if err != nil {
+		return v.GoString()
+	}
+	return string(data)
+}
+
+// didYouMean returns a suggestion for the closest candidate to a misspelled name, or an empty string if none
+// is close enough.
+func didYouMean(name string, candidates []string) string {
+	best := ""
+	bestDistance := 0
+	for _, c := range candidates {
+		d := levenshteinDistance(name, c)
+		if best == "" || d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	if best == "" || bestDistance > 2 {
+		return ""
+	}
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+func levenshteinDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,350 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"context"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var _ specter.UnitPreprocessor = (*specterutils.UnitSchemaValidator)(nil)
+var _ specterutils.UnitLinter = (*specterutils.UnitSchemaValidator)(nil)
+
+func serviceUnitSchema() specterutils.UnitSchema {
+	return specterutils.UnitSchema{
+		Kind: "service",
+		Attributes: []specterutils.AttributeSchema{
+			{Name: "description", Type: cty.String},
+			{Name: "image", Type: cty.String, Required: true, Pattern: regexp.MustCompile(`^[a-z]+:[0-9.]+$`)},
+			{Name: "replicas", Type: cty.Number},
+			{Name: "tier", Type: cty.String, Enum: []cty.Value{cty.StringVal("frontend"), cty.StringVal("backend")}},
+		},
+		Blocks: []specterutils.BlockSchema{
+			{
+				Type:    "endpoint",
+				Labeled: true,
+				Attributes: []specterutils.AttributeSchema{
+					{Name: "port", Type: cty.Number, Required: true},
+				},
+			},
+			{
+				Type:     "healthcheck",
+				MaxItems: 1,
+				Attributes: []specterutils.AttributeSchema{
+					{Name: "path", Type: cty.String},
+				},
+			},
+		},
+	}
+}
+
+func TestUnitSchemaValidator_Lint(t *testing.T) {
+	tests := []struct {
+		name  string
+		given string
+		then  []string
+	}{
+		{
+			name: "GIVEN a unit matching its schema THEN return no results",
+			given: `
+service "billing" {
+  description = "Handles invoices."
+  image = "billing:1.0.0"
+  replicas = 2
+  tier = "backend"
+
+  endpoint "http" {
+    port = 80
+  }
+
+  healthcheck {
+    path = "/health"
+  }
+}
+`,
+			then: nil,
+		},
+		{
+			name: "GIVEN a misspelled attribute THEN report it with a suggestion",
+			given: `
+service "billing" {
+  image = "billing:1.0.0"
+  imagee = "billing:1.0.0"
+}
+`,
+			then: []string{`unit "billing" of kind "service" at "specs.hcl:4:3": unknown attribute "imagee", did you mean "image"?`},
+		},
+		{
+			name: "GIVEN a missing required attribute THEN report it at the unit",
+			given: `
+service "billing" {
+  replicas = 2
+}
+`,
+			then: []string{`unit "billing" of kind "service" at "specs.hcl:2:1": missing required attribute "image"`},
+		},
+		{
+			name: "GIVEN attributes with invalid values THEN report them",
+			given: `
+service "billing" {
+  image = "Billing"
+  replicas = "two"
+  tier = "database"
+}
+`,
+			then: []string{
+				`at "specs.hcl:3:3": attribute "image" should match "^[a-z]+:[0-9.]+$" but got "Billing"`,
+				`at "specs.hcl:4:3": attribute "replicas" should be of type number`,
+				`at "specs.hcl:5:3": attribute "tier" should be one of "frontend", "backend" but got "database"`,
+			},
+		},
+		{
+			name: "GIVEN invalid nested blocks THEN report them",
+			given: `
+service "billing" {
+  image = "billing:1.0.0"
+
+  endpoint {
+    port = 80
+  }
+
+  endpoint "grpc" {}
+
+  healthcheck {}
+  healthcheck {}
+
+  database "main" {}
+}
+`,
+			then: []string{
+				`at "specs.hcl:5:3": block "endpoint" should have a label`,
+				`at "specs.hcl:9:3": missing required attribute "endpoint[\"grpc\"].port"`,
+				`at "specs.hcl:14:3": unknown block type "database"`,
+				`at "specs.hcl:2:1": too many blocks "healthcheck", expected at most 1 but got 2`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			units, err := specterutils.NewHCLGenericUnitLoader().Load(specter.Source{
+				Location: "specs.hcl",
+				Data:     []byte(tt.given),
+				Format:   specterutils.HCLSourceFormat,
+			})
+			require.NoError(t, err)
+
+			v := specterutils.NewUnitSchemaValidator(specterutils.NewUnitSchemaRegistry(serviceUnitSchema()))
+			results := v.Lint(units)
+
+			var messages []string
+			for _, r := range results {
+				assert.Equal(t, specterutils.ErrorSeverity, r.Severity)
+				assert.False(t, r.Range.IsZero())
+				messages = append(messages, r.Message)
+			}
+			require.Len(t, messages, len(tt.then), "%v", messages)
+			for _, expected := range tt.then {
+				assert.True(t, containsSubstring(messages, expected), "expected a message containing %q in %v", expected, messages)
+			}
+		})
+	}
+}
+
+func TestUnitSchemaValidator_Lint_enumOfNumbers(t *testing.T) {
+	schema := specterutils.UnitSchema{
+		Kind: "service",
+		Attributes: []specterutils.AttributeSchema{
+			{Name: "replicas", Type: cty.Number, Enum: []cty.Value{cty.NumberIntVal(1), cty.NumberIntVal(3)}},
+		},
+	}
+	units, err := specterutils.NewHCLGenericUnitLoader().Load(specter.Source{
+		Location: "specs.hcl",
+		Data:     []byte("service \"billing\" {\n  replicas = 2\n}\n"),
+		Format:   specterutils.HCLSourceFormat,
+	})
+	require.NoError(t, err)
+
+	results := specterutils.NewUnitSchemaValidator(specterutils.NewUnitSchemaRegistry(schema)).Lint(units)
+	require.Len(t, results, 1)
+	assert.Contains(t, results[0].Message, `attribute "replicas" should be one of 1, 3 but got 2`)
+}
+
+func TestUnitSchemaValidator_Lint_YAMLBlocks(t *testing.T) {
+	tests := []struct {
+		name  string
+		given string
+		then  []string
+	}{
+		{
+			name: "GIVEN nested blocks defined as objects THEN validate them as blocks",
+			given: `
+kind: service
+id: billing
+image: billing:1.0.0
+endpoint:
+  http:
+    port: 80
+  grpc:
+    port: 9090
+healthcheck:
+  path: /health
+`,
+			then: nil,
+		},
+		{
+			name: "GIVEN invalid nested blocks defined as objects THEN report them",
+			given: `
+kind: service
+id: billing
+image: billing:1.0.0
+endpoint:
+  http:
+    host: localhost
+  grpc: 9090
+healthcheck:
+  - path: /health
+  - path: /ready
+`,
+			then: []string{
+				`block "endpoint[\"grpc\"]" should be an object`,
+				`too many blocks "healthcheck", expected at most 1 but got 2`,
+			},
+		},
+		{
+			name: "GIVEN labeled blocks not defined by label THEN report them",
+			given: `
+kind: service
+id: billing
+image: billing:1.0.0
+endpoint:
+  - port: 80
+`,
+			then: []string{`block "endpoint" should be an object of blocks by label`},
+		},
+		{
+			name: "GIVEN nested blocks with invalid attributes THEN report them",
+			given: `
+kind: service
+id: billing
+image: billing:1.0.0
+endpoint:
+  http:
+    host: localhost
+`,
+			then: []string{
+				`unknown attribute "endpoint[\"http\"].host"`,
+				`missing required attribute "endpoint[\"http\"].port"`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			units, err := specterutils.NewYAMLGenericUnitLoader().Load(specter.Source{
+				Location: "specs.yaml",
+				Data:     []byte(tt.given),
+				Format:   specterutils.YAMLSourceFormat,
+			})
+			require.NoError(t, err)
+
+			v := specterutils.NewUnitSchemaValidator(specterutils.NewUnitSchemaRegistry(serviceUnitSchema()))
+			var messages []string
+			for _, r := range v.Lint(units) {
+				messages = append(messages, r.Message)
+			}
+			require.Len(t, messages, len(tt.then), "%v", messages)
+			for _, expected := range tt.then {
+				assert.True(t, containsSubstring(messages, expected), "expected a message containing %q in %v", expected, messages)
+			}
+		})
+	}
+}
+
+func TestUnitSchemaValidator_Preprocess(t *testing.T) {
+	src := specter.Source{Location: "specs.hcl"}
+	billing := specterutils.NewGenericUnit("billing", "service", src)
+	billing.Attributes = []specterutils.GenericUnitAttribute{
+		{Name: "imagee", Value: specterutils.GenericValue{Value: cty.StringVal("billing:1.0.0")}},
+	}
+	units := []specter.Unit{
+		billing,
+		// Units without schemas are ignored.
+		specterutils.NewGenericUnit("main", "database", src),
+		testutils.NewUnitStub("shipping", "service", src),
+	}
+
+	v := specterutils.NewUnitSchemaValidator(specterutils.NewUnitSchemaRegistry(serviceUnitSchema()))
+	ctx := specter.PipelineContext{Context: context.Background()}
+
+	_, err := v.Preprocess(ctx, units)
+	testutils.RequireErrorWithCode(specterutils.GenericUnitSchemaViolationErrorCode)(t, err)
+	assert.ErrorContains(t, err, `unit "billing" of kind "service" at "specs.hcl": unknown attribute "imagee"`)
+
+	billing.Attributes[0].Name = "image"
+	got, err := v.Preprocess(ctx, units)
+	require.NoError(t, err)
+	assert.Equal(t, units, got)
+}
+
+func TestUnitSchemaValidator_Preprocess_keepsRanges(t *testing.T) {
+	units, err := specterutils.NewHCLGenericUnitLoader().Load(specter.Source{
+		Location: "specs.hcl",
+		Data:     []byte("service \"billing\" {\n  imagee = \"billing:1.0.0\"\n}\n"),
+		Format:   specterutils.HCLSourceFormat,
+	})
+	require.NoError(t, err)
+
+	v := specterutils.NewUnitSchemaValidator(specterutils.NewUnitSchemaRegistry(serviceUnitSchema()))
+	_, err = v.Preprocess(specter.PipelineContext{Context: context.Background()}, units)
+	testutils.RequireErrorWithCode(specterutils.GenericUnitSchemaViolationErrorCode)(t, err)
+
+	var ranges []string
+	for _, e := range specterutils.FlattenErrors(err) {
+		var r specter.HasSourceRange
+		require.True(t, errors.As(e, &r), "%v should have a source range", e)
+		ranges = append(ranges, r.SourceRange().String())
+	}
+	assert.ElementsMatch(t, []string{"specs.hcl:2:3", "specs.hcl:1:1"}, ranges)
+}
+
+func TestUnitSchemaValidator_zeroValue(t *testing.T) {
+	units := []specter.Unit{specterutils.NewGenericUnit("billing", "service", specter.Source{Location: "specs.hcl"})}
+
+	v := specterutils.UnitSchemaValidator{}
+	assert.Empty(t, v.Lint(units))
+
+	_, err := v.Preprocess(specter.PipelineContext{Context: context.Background()}, units)
+	testutils.RequireErrorWithCode(specterutils.GenericUnitSchemaViolationErrorCode)(t, err)
+
+	v.Registry = specterutils.NewUnitSchemaRegistry(serviceUnitSchema())
+	assert.NotEmpty(t, v.Lint(units))
+}
+
+func containsSubstring(values []string, substr string) bool {
+	for _, v := range values {
+		if strings.Contains(v, substr) {
+			return true
+		}
+	}
+	return false
+}
@@ -15,8 +15,10 @@
 package specterutils_test
 
 import (
+	"github.com/morebec/specter/pkg/specter"
 	"github.com/morebec/specter/pkg/specterutils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/zclconf/go-cty/cty"
 	"testing"
 )
@@ -153,3 +155,102 @@ func TestObjectValue_String(t *testing.T) {
 	}}
 	assert.Equal(t, "ObjectValue{Kind: hello, Attributes: [{hello world}]}", o.String())
 }
+
+// withoutSourceRanges returns copies of units where the source ranges of GenericUnit and their attributes are cleared
+// in order to compare units regardless of the exact position where they are defined.
+func withoutSourceRanges(units []specter.Unit) []specter.Unit {
+	var clearAttributes func(attrs []specterutils.GenericUnitAttribute) []specterutils.GenericUnitAttribute
+	clearAttributes = func(attrs []specterutils.GenericUnitAttribute) []specterutils.GenericUnitAttribute {
+		if attrs == nil {
+			return nil
+		}
+		cleared := make([]specterutils.GenericUnitAttribute, len(attrs))
+		for i, a := range attrs {
+			a.Range = specter.SourceRange{}
+			if obj, ok := a.Value.(specterutils.ObjectValue); ok {
+				obj.Attributes = clearAttributes(obj.Attributes)
+				a.Value = obj
+			}
+			cleared[i] = a
+		}
+		return cleared
+	}
+
+	if units == nil {
+		return nil
+	}
+	cleared := make([]specter.Unit, len(units))
+	for i, u := range units {
+		gu, ok := u.(*specterutils.GenericUnit)
+		if !ok {
+			cleared[i] = u
+			continue
+		}
+		c := *gu
+		c.Range = specter.SourceRange{}
+		c.Attributes = clearAttributes(gu.Attributes)
+		cleared[i] = &c
+	}
+	return cleared
+}
+
+func TestGenericUnitLoaders_SourceRanges(t *testing.T) {
+	tests := []struct {
+		name              string
+		givenLoader       specter.UnitLoader
+		givenSource       specter.Source
+		thenUnitRange     string
+		thenAttributeName string
+		thenAttrRange     string
+	}{
+		{
+			name:        "GIVEN an HCL source THEN units and attributes should have the range of their definition",
+			givenLoader: specterutils.NewHCLGenericUnitLoader(),
+			givenSource: specter.Source{
+				Location: "/specs/billing.hcl",
+				Data:     []byte("\nservice \"billing\" {\n  image = \"billing:1.0.0\"\n}\n"),
+				Format:   specterutils.HCLSourceFormat,
+			},
+			thenUnitRange:     "/specs/billing.hcl:2:1",
+			thenAttributeName: "image",
+			thenAttrRange:     "/specs/billing.hcl:3:3",
+		},
+		{
+			name:        "GIVEN a YAML source THEN units and attributes should have the range of their definition",
+			givenLoader: specterutils.NewYAMLGenericUnitLoader(),
+			givenSource: specter.Source{
+				Location: "/specs/billing.yaml",
+				Data:     []byte("---\nkind: service\nid: billing\nimage: billing:1.0.0\n"),
+				Format:   specterutils.YAMLSourceFormat,
+			},
+			thenUnitRange:     "/specs/billing.yaml:2:1",
+			thenAttributeName: "image",
+			thenAttrRange:     "/specs/billing.yaml:4:1",
+		},
+		{
+			name:        "GIVEN a JSON source THEN units and attributes should have the range of their definition",
+			givenLoader: specterutils.NewJSONGenericUnitLoader(),
+			givenSource: specter.Source{
+				Location: "/specs/billing.json",
+				Data:     []byte("[\n  {\n    \"kind\": \"service\",\n    \"id\": \"billing\",\n    \"image\": \"billing:1.0.0\"\n  }\n]"),
+				Format:   specterutils.JSONSourceFormat,
+			},
+			thenUnitRange:     "/specs/billing.json:2:3",
+			thenAttributeName: "image",
+			thenAttrRange:     "/specs/billing.json:5:5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			units, err := tt.givenLoader.Load(tt.givenSource)
+			require.NoError(t, err)
+			require.Len(t, units, 1)
+
+			assert.Equal(t, tt.thenUnitRange, specter.UnitLocation(units[0]))
+
+			attr := units[0].(*specterutils.GenericUnit).Attribute(tt.thenAttributeName)
+			require.NotNil(t, attr)
+			assert.Equal(t, tt.thenAttrRange, attr.SourceRange().String())
+		})
+	}
+}
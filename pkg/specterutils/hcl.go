@@ -17,16 +17,17 @@ package specterutils
 import (
 	"fmt"
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
 	"github.com/hashicorp/hcl/v2/hclparse"
-	"github.com/hashicorp/hcl/v2/hclsimple"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/morebec/go-errors/errors"
 	"github.com/morebec/specter/pkg/specter"
 	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/function"
 )
 
 const (
-	HCLSourceFormat specter.SourceFormat = "hcl"
+	HCLSourceFormat = specter.HCLSourceFormat
 )
 
 const InvalidHCLErrorCode = "specter.spec_loading.invalid_hcl"
@@ -39,8 +40,41 @@ func NewHCLGenericUnitLoader() *HCLGenericUnitLoader {
 }
 
 // HCLGenericUnitLoader this UnitLoader loads Units as GenericUnit.
+//
+// Sources can declare variable, locals and const blocks to be used in expressions. These block types are reserved:
+// they are not loaded as units, and are reported as errors when they are not valid declarations.
+// When DeferUnitReferences is enabled, attributes referencing other units (e.g. "image = service.base.image") are left
+// unknown until they are evaluated by an HCLReferenceResolutionProcessor, once all sources are loaded.
+//
+// Sources can also import the units of other sources with import blocks, optionally prefixing the IDs of the imported
+// units with a namespace (e.g. "shared/money"), which are referenced with the index syntax (e.g.
+// type["shared/money"].currency). Imported sources are loaded with the SourceLoaders of the loader.
 type HCLGenericUnitLoader struct {
+	// Deprecated: the Parser is no longer used to load sources since it caches files by name, which returns stale
+	// content when a loader is reused for sources whose data changed. It is kept for compatibility.
 	hclparse.Parser
+
+	// Variables are the values supplied for the variables declared in sources.
+	Variables map[string]cty.Value
+
+	// Functions are the functions available to expressions. Defaults to HCLStdlibFunctions when nil.
+	Functions map[string]function.Function
+
+	// SourceLoaders are used to load the sources imported by import blocks, usually the ones of the pipeline.
+	// They are consulted in order, as a specter.SourceLoaderRegistry.
+	SourceLoaders []specter.SourceLoader
+
+	// UnsupportedImportLocationPolicy defines how to report imported locations that no SourceLoaders supports.
+	// Defaults to specter.StrictnessError.
+	UnsupportedImportLocationPolicy specter.StrictnessPolicy
+
+	// Logger reports the warnings of sources, which do not fail loading. Warnings are discarded when nil.
+	Logger specter.Logger
+
+	// DeferUnitReferences defers the evaluation of attributes referencing other units to an
+	// HCLReferenceResolutionProcessor, which should then be registered. When disabled, such references are reported
+	// as unknown variables.
+	DeferUnitReferences bool
 }
 
 func (l HCLGenericUnitLoader) SupportsSource(s specter.Source) bool {
@@ -48,10 +82,6 @@ func (l HCLGenericUnitLoader) SupportsSource(s specter.Source) bool {
 }
 
 func (l HCLGenericUnitLoader) Load(s specter.Source) ([]specter.Unit, error) {
-	ctx := &hcl.EvalContext{
-		Variables: map[string]cty.Value{},
-	}
-
 	// Although the caller is responsible for calling HCLGenericUnitLoader.SupportsSource, guard against it.
 	if !l.SupportsSource(s) {
 		return nil, errors.NewWithMessage(
@@ -64,14 +94,26 @@ func (l HCLGenericUnitLoader) Load(s specter.Source) ([]specter.Unit, error) {
 		)
 	}
 
-	file, diags := l.ParseHCL(s.Data, s.Location)
+	return l.load(s, "", newHCLImportResolver(l.SourceLoaders, l.UnsupportedImportLocationPolicy, l.Logger, s))
+}
+
+// load loads the units of a source, prefixing their IDs with a namespace, along with the units it imports.
+func (l HCLGenericUnitLoader) load(s specter.Source, namespace string, imports *hclImportResolver) ([]specter.Unit, error) {
+	// Parse without the hclparse.Parser since it caches files by name, which would return stale content when a
+	// loader is reused for sources whose data changed.
+	file, diags := hclsyntax.ParseConfig(s.Data, s.Location, hcl.InitialPos)
 	if diags != nil && diags.HasErrors() {
-		return nil, errors.Wrap(diags, InvalidHCLErrorCode)
+		return nil, newHCLDiagnosticsError(InvalidHCLErrorCode, diags, s.Data)
 	}
 
 	var units []specter.Unit
 
-	body := file.Body.(*hclsyntax.Body)
+	ctx, body, ctxDiags := newHCLEvalContext(file.Body.(*hclsyntax.Body), l.Variables, l.Functions)
+	diags = diags.Extend(ctxDiags)
+
+	imported, body, importDiags := imports.resolve(s, ctx, body, namespace)
+	diags = diags.Extend(importDiags)
+
 	for _, block := range body.Blocks {
 		// Ensure there is at least one label for the block
 		if len(block.Labels) == 0 || block.Labels[0] == "" {
@@ -87,59 +129,90 @@ func (l HCLGenericUnitLoader) Load(s specter.Source) ([]specter.Unit, error) {
 			)
 		}
 
+		unit := &GenericUnit{
+			UnitID: specter.UnitID(hclNamespacedID(namespace, block.Labels[0])),
+			typ:    specter.UnitKind(block.Type),
+			source: s,
+			Range:  newSourceRange(block.Range()),
+		}
+
 		// Extract Attributes in block.
-		specAttributes, attrDiags := l.extractAttributesFromBlock(ctx, block)
-		if attrDiags != nil {
-			diags = diags.Extend(attrDiags)
+		specAttributes, attrDiags := l.extractAttributesFromBlock(ctx, block, unit, nil)
+		diags = diags.Extend(attrDiags)
+		if attrDiags.HasErrors() {
 			continue
-			//return nil, errors.WrapWithMessage(
-			//	err,
-			//	InvalidHCLErrorCode,
-			//	fmt.Sprintf(
-			//		"invalid unit source %q at line %d:%d for block %q",
-			//		u.Location,
-			//		block.Range().Start.Line,
-			//		block.Range().Start.Column,
-			//		block.Kind,
-			//	),
-			//)
 		}
 
-		// Create unit and add to list
-		units = append(units, &GenericUnit{
-			UnitID:     specter.UnitID(block.Labels[0]),
-			typ:        specter.UnitKind(block.Type),
-			source:     s,
-			Attributes: specAttributes,
-		})
+		// Add unit to list
+		unit.Attributes = specAttributes
+		units = append(units, unit)
 	}
 
-	group := errors.NewGroup(InvalidHCLErrorCode)
-	if diags != nil && diags.HasErrors() {
-		for _, d := range diags.Errs() {
-			group = group.Append(d)
+	logHCLWarnings(l.Logger, diags, s.Data)
+	errs := appendHCLDiagnostics(errors.NewGroup(InvalidHCLErrorCode), InvalidHCLErrorCode, diags, s.Data)
+
+	for _, imp := range imported {
+		importedUnits, err := imports.load(imp, func(s specter.Source, namespace string) ([]specter.Unit, error) {
+			return l.load(s, namespace, imports)
+		})
+		if err != nil {
+			errs = errs.Append(err)
+			continue
 		}
+		units = append(units, importedUnits...)
 	}
 
-	return units, errors.GroupOrNil(group)
+	return units, errors.GroupOrNil(errs)
+}
+
+// unitReferences returns the references to other units of an expression whose evaluation is deferred.
+func (l HCLGenericUnitLoader) unitReferences(ctx *hcl.EvalContext, expr hcl.Expression) []UnitReference {
+	if !l.DeferUnitReferences {
+		return nil
+	}
+	return hclUnitReferences(ctx, expr)
 }
 
-func (l HCLGenericUnitLoader) extractAttributesFromBlock(ctx *hcl.EvalContext, block *hclsyntax.Block) ([]GenericUnitAttribute, hcl.Diagnostics) {
+// extractAttributesFromBlock extracts the attributes of a block of a unit located at a given path of attribute indexes.
+// Attributes referencing other units are deferred to be evaluated by an HCLReferenceResolutionProcessor when
+// DeferUnitReferences is enabled.
+func (l HCLGenericUnitLoader) extractAttributesFromBlock(
+	ctx *hcl.EvalContext,
+	block *hclsyntax.Block,
+	unit *GenericUnit,
+	path []int,
+) ([]GenericUnitAttribute, hcl.Diagnostics) {
 	var attrs []GenericUnitAttribute
 
 	var diags hcl.Diagnostics
 
 	// Detect attributes in current block.
 	for _, a := range block.Body.Attributes {
+		if refs := l.unitReferences(ctx, a.Expr); len(refs) != 0 {
+			unit.references = append(unit.references, refs...)
+			unit.deferred = append(unit.deferred, hclDeferredAttribute{
+				path: append(append([]int(nil), path...), len(attrs)),
+				expr: a.Expr,
+				ctx:  ctx,
+			})
+			attrs = append(attrs, GenericUnitAttribute{
+				Name:  a.Name,
+				Value: GenericValue{cty.DynamicVal},
+				Range: newSourceRange(a.SrcRange),
+			})
+			continue
+		}
+
 		value, d := a.Expr.Value(ctx)
-		if d != nil && d.HasErrors() {
-			diags = append(diags, d...)
+		diags = append(diags, d...)
+		if d.HasErrors() {
 			continue
 		}
 
 		attrs = append(attrs, GenericUnitAttribute{
 			Name:  a.Name,
 			Value: GenericValue{value},
+			Range: newSourceRange(a.SrcRange),
 		})
 	}
 
@@ -150,9 +223,9 @@ func (l HCLGenericUnitLoader) extractAttributesFromBlock(ctx *hcl.EvalContext, b
 			bName = b.Labels[0]
 		}
 
-		bAttrs, d := l.extractAttributesFromBlock(ctx, b)
+		bAttrs, d := l.extractAttributesFromBlock(ctx, b, unit, append(append([]int(nil), path...), len(attrs)))
+		diags = append(diags, d...)
 		if d.HasErrors() {
-			diags = append(diags, d...)
 			continue
 		}
 
@@ -162,12 +235,22 @@ func (l HCLGenericUnitLoader) extractAttributesFromBlock(ctx *hcl.EvalContext, b
 				Type:       AttributeType(b.Type),
 				Attributes: bAttrs,
 			},
+			Range: newSourceRange(b.Range()),
 		})
 	}
 
 	return attrs, diags
 }
 
+// newSourceRange converts an hcl.Range to a specter.SourceRange.
+func newSourceRange(r hcl.Range) specter.SourceRange {
+	return specter.SourceRange{
+		Filename: r.Filename,
+		Start:    specter.SourcePos{Line: r.Start.Line, Column: r.Start.Column, Byte: r.Start.Byte},
+		End:      specter.SourcePos{Line: r.End.Line, Column: r.End.Column, Byte: r.End.Byte},
+	}
+}
+
 type HCLUnitLoaderFileConfigurationProvider func() HCLFileConfig
 
 // HCLFileConfig interface that is to be implemented to define the structure of HCL unit files.
@@ -175,28 +258,46 @@ type HCLFileConfig interface {
 	Units(specter.Source) []specter.Unit
 }
 
-// HCLVariableConfig represents a block configuration that allows defining variables.
+// HCLVariableConfig represents the configuration of a const block allowing to define variables.
+// Const blocks are evaluated by the HCL loaders before decoding, so file configurations do not need to declare them.
 type HCLVariableConfig struct {
-	Name        string    `hcl:"FilePath,label"`
+	Name        string    `hcl:"name,label"`
 	Description string    `hcl:"description,optional"`
 	Value       cty.Value `hcl:"value"`
 }
 
 // HCLUnitLoader this loader allows to load Units to typed structs by providing a HCLFileConfig.
+//
+// Sources can declare variable, locals and const blocks to be used in expressions. These blocks are not decoded
+// into the HCLFileConfig.
+//
+// Sources can also import the units of other sources with import blocks. Since the IDs of typed units cannot be
+// changed, imports cannot be namespaced.
 type HCLUnitLoader struct {
 	// represents the structure of a file that this HCL loader should support.
-	parser             *hclparse.Parser
 	fileConfigProvider HCLUnitLoaderFileConfigurationProvider
-	evalCtx            *hcl.EvalContext
+
+	// Variables are the values supplied for the variables declared in sources.
+	Variables map[string]cty.Value
+
+	// Functions are the functions available to expressions. Defaults to HCLStdlibFunctions when nil.
+	Functions map[string]function.Function
+
+	// SourceLoaders are used to load the sources imported by import blocks, usually the ones of the pipeline.
+	// They are consulted in order, as a specter.SourceLoaderRegistry.
+	SourceLoaders []specter.SourceLoader
+
+	// UnsupportedImportLocationPolicy defines how to report imported locations that no SourceLoaders supports.
+	// Defaults to specter.StrictnessError.
+	UnsupportedImportLocationPolicy specter.StrictnessPolicy
+
+	// Logger reports the warnings of sources, which do not fail loading. Warnings are discarded when nil.
+	Logger specter.Logger
 }
 
 func NewHCLUnitLoader(fileConfigProvider HCLUnitLoaderFileConfigurationProvider) *HCLUnitLoader {
 	return &HCLUnitLoader{
 		fileConfigProvider: fileConfigProvider,
-		evalCtx: &hcl.EvalContext{
-			Variables: map[string]cty.Value{},
-		},
-		parser: hclparse.NewParser(),
 	}
 }
 
@@ -213,37 +314,65 @@ func (l HCLUnitLoader) Load(s specter.Source) ([]specter.Unit, error) {
 		)
 	}
 
-	ctx := l.evalCtx
-	//// Parse const blocks to add them as Variables in the context.
-	//var diags hcl.Diagnostics
-	//var parsedFile *hcl.File
-	//parsedFile, diags = l.parser.ParseHCL(u.Data, u.Location)
-	//
-	//body := parsedFile.Body.(*hclsyntax.Body)
-	//for _, b := range body.Blocks {
-	//	if b.Kind == "const" {
-	//		v, d := b.Body.Attributes["value"].Expr.Value(ctx)
-	//		if d != nil && d.HasErrors() {
-	//			diags = append(diags, d...)
-	//		} else {
-	//			ctx.Variables[b.Labels[0]] = v
-	//		}
-	//	}
-	//}
+	return l.load(s, newHCLImportResolver(l.SourceLoaders, l.UnsupportedImportLocationPolicy, l.Logger, s))
+}
 
+// load loads the units of a source along with the units it imports.
+func (l HCLUnitLoader) load(s specter.Source, imports *hclImportResolver) ([]specter.Unit, error) {
 	if len(s.Data) == 0 {
 		return nil, nil
 	}
 
+	file, diags := hclsyntax.ParseConfig(s.Data, s.Location, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, newHCLDiagnosticsError(InvalidHCLErrorCode, diags, s.Data)
+	}
+	logHCLWarnings(l.Logger, diags, s.Data)
+
+	ctx, body, diags := newHCLEvalContext(file.Body.(*hclsyntax.Body), l.Variables, l.Functions)
+	if diags.HasErrors() {
+		return nil, newHCLDiagnosticsError(InvalidHCLErrorCode, diags, s.Data)
+	}
+	logHCLWarnings(l.Logger, diags, s.Data)
+
+	imported, body, diags := imports.resolve(s, ctx, body, "")
+	for _, imp := range imported {
+		if imp.namespace != "" {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Unsupported import namespace",
+				Detail:   "Imports cannot be namespaced when loading units to typed structs.",
+				Subject:  imp.blockRange.Ptr(),
+			})
+		}
+	}
+	if diags.HasErrors() {
+		return nil, newHCLDiagnosticsError(InvalidHCLErrorCode, diags, s.Data)
+	}
+	logHCLWarnings(l.Logger, diags, s.Data)
+
 	// Decode config file
 	fileConf := l.fileConfigProvider()
-	err := hclsimple.Decode(s.Location, s.Data, ctx, fileConf)
+	diags = gohcl.DecodeBody(body, ctx, fileConf)
+	if diags.HasErrors() {
+		return nil, newHCLDiagnosticsError(InvalidHCLErrorCode, diags, s.Data)
+	}
+	logHCLWarnings(l.Logger, diags, s.Data)
+	units := fileConf.Units(s)
 
-	if err != nil {
-		return nil, errors.Wrap(err, InvalidHCLErrorCode)
+	errs := errors.NewGroup(InvalidHCLErrorCode)
+	for _, imp := range imported {
+		importedUnits, err := imports.load(imp, func(s specter.Source, _ string) ([]specter.Unit, error) {
+			return l.load(s, imports)
+		})
+		if err != nil {
+			errs = errs.Append(err)
+			continue
+		}
+		units = append(units, importedUnits...)
 	}
 
-	return fileConf.Units(s), nil
+	return units, errors.GroupOrNil(errs)
 }
 
 func (l HCLUnitLoader) SupportsSource(s specter.Source) bool {
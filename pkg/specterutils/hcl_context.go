@@ -0,0 +1,414 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+	"sort"
+	"strings"
+)
+
+// The types of blocks declaring values are reserved: units cannot be of these kinds, and blocks of these types that
+// are not valid declarations are reported as errors rather than loaded as units.
+const (
+	// HCLVariableBlockType is the type of the blocks declaring variables, accessible in expressions as "var.<name>".
+	HCLVariableBlockType = "variable"
+
+	// HCLLocalsBlockType is the type of the blocks defining local values, accessible in expressions as "local.<name>".
+	HCLLocalsBlockType = "locals"
+
+	// HCLConstBlockType is the type of the blocks defining constants, accessible in expressions as "<name>".
+	HCLConstBlockType = "const"
+)
+
+// HCLVariableEnvPrefix is the prefix of the environment variables considered by HCLVariablesFromEnv.
+const HCLVariableEnvPrefix = "SPECTER_VAR_"
+
+var hclVariableBlockSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "description"},
+		{Name: "default"},
+	},
+}
+
+var hclConstBlockSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "description"},
+		{Name: "value", Required: true},
+	},
+}
+
+// HCLStdlibFunctions returns the functions of the cty standard library made available to HCL expressions
+// under their usual names (e.g. upper, join, format, merge).
+func HCLStdlibFunctions() map[string]function.Function {
+	return map[string]function.Function{
+		"abs":             stdlib.AbsoluteFunc,
+		"ceil":            stdlib.CeilFunc,
+		"chomp":           stdlib.ChompFunc,
+		"chunklist":       stdlib.ChunklistFunc,
+		"coalesce":        stdlib.CoalesceFunc,
+		"coalescelist":    stdlib.CoalesceListFunc,
+		"compact":         stdlib.CompactFunc,
+		"concat":          stdlib.ConcatFunc,
+		"contains":        stdlib.ContainsFunc,
+		"csvdecode":       stdlib.CSVDecodeFunc,
+		"distinct":        stdlib.DistinctFunc,
+		"element":         stdlib.ElementFunc,
+		"flatten":         stdlib.FlattenFunc,
+		"floor":           stdlib.FloorFunc,
+		"format":          stdlib.FormatFunc,
+		"formatdate":      stdlib.FormatDateFunc,
+		"formatlist":      stdlib.FormatListFunc,
+		"indent":          stdlib.IndentFunc,
+		"index":           stdlib.IndexFunc,
+		"int":             stdlib.IntFunc,
+		"join":            stdlib.JoinFunc,
+		"jsondecode":      stdlib.JSONDecodeFunc,
+		"jsonencode":      stdlib.JSONEncodeFunc,
+		"keys":            stdlib.KeysFunc,
+		"length":          stdlib.LengthFunc,
+		"log":             stdlib.LogFunc,
+		"lookup":          stdlib.LookupFunc,
+		"lower":           stdlib.LowerFunc,
+		"max":             stdlib.MaxFunc,
+		"merge":           stdlib.MergeFunc,
+		"min":             stdlib.MinFunc,
+		"parseint":        stdlib.ParseIntFunc,
+		"pow":             stdlib.PowFunc,
+		"range":           stdlib.RangeFunc,
+		"regex":           stdlib.RegexFunc,
+		"regexall":        stdlib.RegexAllFunc,
+		"regex_replace":   stdlib.RegexReplaceFunc,
+		"replace":         stdlib.ReplaceFunc,
+		"reverse":         stdlib.ReverseListFunc,
+		"setintersection": stdlib.SetIntersectionFunc,
+		"setproduct":      stdlib.SetProductFunc,
+		"setsubtract":     stdlib.SetSubtractFunc,
+		"setunion":        stdlib.SetUnionFunc,
+		"signum":          stdlib.SignumFunc,
+		"slice":           stdlib.SliceFunc,
+		"sort":            stdlib.SortFunc,
+		"split":           stdlib.SplitFunc,
+		"strlen":          stdlib.StrlenFunc,
+		"strrev":          stdlib.ReverseFunc,
+		"substr":          stdlib.SubstrFunc,
+		"timeadd":         stdlib.TimeAddFunc,
+		"title":           stdlib.TitleFunc,
+		"tobool":          stdlib.MakeToFunc(cty.Bool),
+		"tolist":          stdlib.MakeToFunc(cty.List(cty.DynamicPseudoType)),
+		"tomap":           stdlib.MakeToFunc(cty.Map(cty.DynamicPseudoType)),
+		"tonumber":        stdlib.MakeToFunc(cty.Number),
+		"toset":           stdlib.MakeToFunc(cty.Set(cty.DynamicPseudoType)),
+		"tostring":        stdlib.MakeToFunc(cty.String),
+		"trim":            stdlib.TrimFunc,
+		"trimprefix":      stdlib.TrimPrefixFunc,
+		"trimspace":       stdlib.TrimSpaceFunc,
+		"trimsuffix":      stdlib.TrimSuffixFunc,
+		"upper":           stdlib.UpperFunc,
+		"values":          stdlib.ValuesFunc,
+		"zipmap":          stdlib.ZipmapFunc,
+	}
+}
+
+// MergeHCLVariables merges sets of variables, values of later sets taking precedence over earlier ones.
+// This can be used to combine variables supplied from multiple origins such as files, env vars and the caller.
+func MergeHCLVariables(sets ...map[string]cty.Value) map[string]cty.Value {
+	merged := map[string]cty.Value{}
+	for _, set := range sets {
+		for k, v := range set {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// HCLVariablesFromEnv returns the variables defined in a list of "KEY=value" environment entries (e.g. os.Environ())
+// whose key starts with a given prefix. The prefix is stripped from the names of the variables and their values are
+// strings, converted to the type of the default value of their declaration when loaded.
+// For example, with the prefix HCLVariableEnvPrefix, "SPECTER_VAR_image_tag=1.0.0" defines the variable "image_tag".
+func HCLVariablesFromEnv(prefix string, environ []string) map[string]cty.Value {
+	vars := map[string]cty.Value{}
+	for _, entry := range environ {
+		key, value, found := strings.Cut(entry, "=")
+		if !found || !strings.HasPrefix(key, prefix) || key == prefix {
+			continue
+		}
+		vars[strings.TrimPrefix(key, prefix)] = cty.StringVal(value)
+	}
+	return vars
+}
+
+// ParseHCLVariables parses the variables defined as top-level attributes of an HCL document, similar to tfvars files:
+//
+//	image_tag = "1.0.0"
+//	replicas  = 3
+func ParseHCLVariables(data []byte, filename string) (map[string]cty.Value, error) {
+	file, diags := hclsyntax.ParseConfig(data, filename, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, newHCLDiagnosticsError(InvalidHCLErrorCode, diags, data)
+	}
+
+	attrs, diags := file.Body.JustAttributes()
+	if diags.HasErrors() {
+		return nil, newHCLDiagnosticsError(InvalidHCLErrorCode, diags, data)
+	}
+
+	ctx := &hcl.EvalContext{Functions: HCLStdlibFunctions()}
+	vars := map[string]cty.Value{}
+	for name, attr := range attrs {
+		v, d := attr.Expr.Value(ctx)
+		diags = diags.Extend(d)
+		vars[name] = v
+	}
+	if diags.HasErrors() {
+		return nil, newHCLDiagnosticsError(InvalidHCLErrorCode, diags, data)
+	}
+
+	return vars, nil
+}
+
+// LoadHCLVariablesFile loads the variables of an HCL variables file using a specter.FileSystem.
+// See ParseHCLVariables for the format of the file.
+func LoadHCLVariablesFile(fs specter.FileSystem, path string) (map[string]cty.Value, error) {
+	data, err := fs.ReadFile(path)
+	if err != nil {
+		return nil, errors.WrapWithMessage(err, errors.InternalErrorCode, fmt.Sprintf("failed loading variables file %q", path))
+	}
+	return ParseHCLVariables(data, path)
+}
+
+// newHCLEvalContext builds the evaluation context of an HCL file from its variable, locals and const blocks, and
+// returns it along with the remaining body of the file, stripped of these blocks.
+//
+// Variables are resolved from the values supplied externally, falling back to their default value.
+// Locals and constants can reference variables, functions and each other regardless of their order of declaration.
+func newHCLEvalContext(
+	body *hclsyntax.Body,
+	variables map[string]cty.Value,
+	functions map[string]function.Function,
+) (*hcl.EvalContext, *hclsyntax.Body, hcl.Diagnostics) {
+	if functions == nil {
+		functions = HCLStdlibFunctions()
+	}
+
+	ctx := &hcl.EvalContext{
+		Variables: map[string]cty.Value{},
+		Functions: functions,
+	}
+
+	var diags hcl.Diagnostics
+	vars := MergeHCLVariables(variables)
+	var pending []*hclPendingValue
+	remaining := &hclsyntax.Body{
+		Attributes: body.Attributes,
+		SrcRange:   body.SrcRange,
+		EndRange:   body.EndRange,
+	}
+
+	for _, block := range body.Blocks {
+		switch block.Type {
+		case HCLVariableBlockType:
+			diags = diags.Extend(resolveHCLVariable(ctx, block, vars))
+
+		case HCLLocalsBlockType:
+			if len(block.Labels) != 0 {
+				diags = diags.Append(&hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Unexpected locals label",
+					Detail:   fmt.Sprintf("A %s block should not have labels, the block type is reserved for local values.", HCLLocalsBlockType),
+					Subject:  block.LabelRanges[0].Ptr(),
+				})
+				continue
+			}
+			// Nested blocks are reported as errors.
+			if _, d := block.Body.JustAttributes(); d.HasErrors() {
+				diags = diags.Extend(d)
+				continue
+			}
+			for _, attr := range block.Body.Attributes {
+				pending = append(pending, &hclPendingValue{namespace: "local", name: attr.Name, expr: attr.Expr})
+			}
+
+		case HCLConstBlockType:
+			if len(block.Labels) != 1 || block.Labels[0] == "" {
+				diags = diags.Append(&hcl.Diagnostic{
+					Severity: hcl.DiagError,
+					Summary:  "Missing constant name",
+					Detail:   "A const block should have exactly one label: the name of the constant.",
+					Subject:  block.DefRange().Ptr(),
+				})
+				continue
+			}
+			content, d := block.Body.Content(hclConstBlockSchema)
+			diags = diags.Extend(d)
+			if d.HasErrors() {
+				continue
+			}
+			pending = append(pending, &hclPendingValue{name: block.Labels[0], expr: content.Attributes["value"].Expr})
+
+		default:
+			remaining.Blocks = append(remaining.Blocks, block)
+		}
+	}
+
+	ctx.Variables["var"] = hclObjectVal(vars)
+	diags = diags.Extend(evaluateHCLPendingValues(ctx, pending))
+
+	return ctx, remaining, diags
+}
+
+func resolveHCLVariable(ctx *hcl.EvalContext, block *hclsyntax.Block, vars map[string]cty.Value) hcl.Diagnostics {
+	if len(block.Labels) != 1 || block.Labels[0] == "" {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Missing variable name",
+			Detail:   "A variable block should have exactly one label: the name of the variable.",
+			Subject:  block.DefRange().Ptr(),
+		}}
+	}
+	name := block.Labels[0]
+
+	content, diags := block.Body.Content(hclVariableBlockSchema)
+	if diags.HasErrors() {
+		return diags
+	}
+
+	defaultAttr, hasDefault := content.Attributes["default"]
+	var defaultValue cty.Value
+	if hasDefault {
+		// Defaults can only rely on functions, since they are resolved before any other value.
+		var d hcl.Diagnostics
+		defaultValue, d = defaultAttr.Expr.Value(&hcl.EvalContext{Functions: ctx.Functions})
+		diags = diags.Extend(d)
+		if d.HasErrors() {
+			return diags
+		}
+	}
+
+	value, supplied := vars[name]
+	switch {
+	case supplied && hasDefault && !defaultValue.IsNull() && defaultValue.Type().IsPrimitiveType():
+		converted, err := convert.Convert(value, defaultValue.Type())
+		if err != nil {
+			return diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid value for variable",
+				Detail:   fmt.Sprintf("The value supplied for variable %q is invalid: %s.", name, err),
+				Subject:  block.DefRange().Ptr(),
+			})
+		}
+		vars[name] = converted
+	case supplied:
+		// Keep the supplied value as is.
+	case hasDefault:
+		vars[name] = defaultValue
+	default:
+		return diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "No value for required variable",
+			Detail:   fmt.Sprintf("The variable %q has no default value and no value was supplied for it.", name),
+			Subject:  block.DefRange().Ptr(),
+		})
+	}
+
+	return diags
+}
+
+// hclPendingValue represents a local or constant value that remains to be evaluated.
+type hclPendingValue struct {
+	// namespace under which the value is exposed, or empty for top-level values (constants).
+	namespace string
+	name      string
+	expr      hcl.Expression
+	diags     hcl.Diagnostics
+}
+
+// evaluateHCLPendingValues evaluates values that can reference each other by evaluating them in passes until no
+// more progress can be made. Values that could not be evaluated are reported using the diagnostics of their last
+// evaluation attempt.
+func evaluateHCLPendingValues(ctx *hcl.EvalContext, pending []*hclPendingValue) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+
+	// Process values in order of declaration for predictable diagnostics.
+	sort.SliceStable(pending, func(i, j int) bool {
+		ri, rj := pending[i].expr.Range(), pending[j].expr.Range()
+		return ri.Start.Byte < rj.Start.Byte
+	})
+
+	defined := map[string]hcl.Range{}
+	var unique []*hclPendingValue
+	for _, p := range pending {
+		key := p.namespace + "." + p.name
+		if previous, ok := defined[key]; ok {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Duplicate value definition",
+				Detail:   fmt.Sprintf("The value %q was already defined at %s.", p.name, previous),
+				Subject:  p.expr.Range().Ptr(),
+			})
+			continue
+		}
+		defined[key] = p.expr.Range()
+		unique = append(unique, p)
+	}
+	pending = unique
+
+	locals := map[string]cty.Value{}
+	ctx.Variables["local"] = cty.EmptyObjectVal
+
+	for len(pending) != 0 {
+		var next []*hclPendingValue
+		for _, p := range pending {
+			v, d := p.expr.Value(ctx)
+			if d.HasErrors() {
+				p.diags = d
+				next = append(next, p)
+				continue
+			}
+
+			if p.namespace == "local" {
+				locals[p.name] = v
+				ctx.Variables["local"] = hclObjectVal(locals)
+			} else {
+				ctx.Variables[p.name] = v
+			}
+		}
+
+		if len(next) == len(pending) {
+			for _, p := range next {
+				diags = diags.Extend(p.diags)
+			}
+			break
+		}
+		pending = next
+	}
+
+	return diags
+}
+
+func hclObjectVal(values map[string]cty.Value) cty.Value {
+	if len(values) == 0 {
+		return cty.EmptyObjectVal
+	}
+	return cty.ObjectVal(values)
+}
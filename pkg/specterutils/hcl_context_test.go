@@ -0,0 +1,283 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"testing"
+)
+
+func TestHCLGenericUnitLoader_Load_evaluationContext(t *testing.T) {
+	tests := []struct {
+		name          string
+		given         string
+		givenVars     map[string]cty.Value
+		then          map[string]cty.Value
+		thenError     require.ErrorAssertionFunc
+		thenErrorText string
+	}{
+		{
+			name: "GIVEN variables with defaults THEN use the defaults",
+			given: `
+variable "tag" {
+	description = "Tag of the image."
+	default = "1.0.0"
+}
+
+service "billing" {
+	image = "billing:${var.tag}"
+}
+`,
+			then:      map[string]cty.Value{"image": cty.StringVal("billing:1.0.0")},
+			thenError: require.NoError,
+		},
+		{
+			name: "GIVEN supplied variables THEN they take precedence over defaults",
+			given: `
+variable "tag" {
+	default = "1.0.0"
+}
+
+variable "replicas" {
+	default = 1
+}
+
+service "billing" {
+	image = "billing:${var.tag}"
+	replicas = var.replicas + 1
+}
+`,
+			givenVars: map[string]cty.Value{
+				"tag":      cty.StringVal("2.0.0"),
+				"replicas": cty.StringVal("2"),
+			},
+			then: map[string]cty.Value{
+				"image":    cty.StringVal("billing:2.0.0"),
+				"replicas": cty.NumberIntVal(3),
+			},
+			thenError: require.NoError,
+		},
+		{
+			name: "GIVEN a variable without value THEN return an error",
+			given: `
+variable "tag" {}
+
+service "billing" {
+	image = "billing:latest"
+}
+`,
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidHCLErrorCode),
+			thenErrorText: `The variable "tag" has no default value and no value was supplied for it.`,
+		},
+		{
+			name: "GIVEN locals and consts referencing each other out of order THEN evaluate them",
+			given: `
+service "billing" {
+	image = local.image
+	host = upper(HOST)
+}
+
+locals {
+	image = "${local.registry}/billing:${TAG}"
+	registry = "registry.example.com"
+}
+
+const "TAG" {
+	value = "1.0.0"
+}
+
+const "HOST" {
+	description = "Host of the service."
+	value = join(".", ["billing", local.domain])
+}
+
+locals {
+	domain = "example.com"
+}
+`,
+			then: map[string]cty.Value{
+				"image": cty.StringVal("registry.example.com/billing:1.0.0"),
+				"host":  cty.StringVal("BILLING.EXAMPLE.COM"),
+			},
+			thenError: require.NoError,
+		},
+		{
+			name: "GIVEN locals referencing each other in a cycle THEN return an error",
+			given: `
+locals {
+	a = local.b
+	b = local.a
+}
+`,
+			thenError: testutils.RequireErrorWithCode(specterutils.InvalidHCLErrorCode),
+		},
+		{
+			name: "GIVEN duplicate locals THEN return an error",
+			given: `
+locals {
+	a = 1
+}
+
+locals {
+	a = 2
+}
+`,
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidHCLErrorCode),
+			thenErrorText: "Duplicate value definition",
+		},
+		{
+			name: "GIVEN a unit of a reserved block type THEN return an error",
+			given: `
+locals "billing" {
+	image = "billing:1.0"
+}
+`,
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidHCLErrorCode),
+			thenErrorText: "the block type is reserved for local values",
+		},
+		{
+			name: "GIVEN locals with nested blocks THEN return an error",
+			given: `
+locals {
+	a = 1
+	endpoint "http" {}
+}
+`,
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidHCLErrorCode),
+			thenErrorText: "Unexpected \"endpoint\" block",
+		},
+		{
+			name: "GIVEN stdlib functions THEN they are available",
+			given: `
+service "billing" {
+	labels = merge({team = "finance"}, {tier = "backend"})
+	name = format("%s-%d", "billing", 2)
+}
+`,
+			then: map[string]cty.Value{
+				"labels": cty.ObjectVal(map[string]cty.Value{
+					"team": cty.StringVal("finance"),
+					"tier": cty.StringVal("backend"),
+				}),
+				"name": cty.StringVal("billing-2"),
+			},
+			thenError: require.NoError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := specterutils.NewHCLGenericUnitLoader()
+			l.Variables = tt.givenVars
+
+			units, err := l.Load(specter.Source{
+				Location: "/specs/billing.hcl",
+				Data:     []byte(tt.given),
+				Format:   specterutils.HCLSourceFormat,
+			})
+			tt.thenError(t, err)
+			if tt.thenErrorText != "" {
+				assert.ErrorContains(t, err, tt.thenErrorText)
+			}
+			if tt.then == nil {
+				return
+			}
+
+			require.Len(t, units, 1, "variable, locals and const blocks should not be loaded as units")
+			u := units[0].(*specterutils.GenericUnit)
+			for name, expected := range tt.then {
+				require.True(t, u.HasAttribute(name), "attribute %q not found", name)
+				actual := u.Attribute(name).Value.(specterutils.GenericValue).Value
+				assert.True(t, expected.Equals(actual).True(), "expected %#v, got %#v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestHCLUnitLoader_Load_evaluationContext(t *testing.T) {
+	l := specterutils.NewHCLUnitLoader(func() specterutils.HCLFileConfig {
+		return &HclConfigMock{}
+	})
+	l.Variables = map[string]cty.Value{"password": cty.StringVal("password")}
+
+	src := specter.Source{
+		Format:   specterutils.HCLSourceFormat,
+		Location: "/path/to/file.hcl",
+		Data: []byte(`
+variable "password" {}
+
+const "VERSION" {
+	value = "1.0.0"
+}
+
+service "specter" {
+	image = lower("SPECTER:${VERSION}")
+	environment "dev" {
+		MYSQL_ROOT_PASSWORD = var.password
+	}
+}
+`),
+	}
+
+	units, err := l.Load(src)
+	require.NoError(t, err)
+	assert.Equal(t, []specter.Unit{(&HclConfigMock{}).expectedUnit(src)}, units)
+}
+
+func TestHCLVariablesFromEnv(t *testing.T) {
+	vars := specterutils.HCLVariablesFromEnv(specterutils.HCLVariableEnvPrefix, []string{
+		"SPECTER_VAR_tag=1.0.0",
+		"SPECTER_VAR_query=a=b",
+		"SPECTER_VAR_=ignored",
+		"HOME=/root",
+	})
+
+	assert.Equal(t, map[string]cty.Value{
+		"tag":   cty.StringVal("1.0.0"),
+		"query": cty.StringVal("a=b"),
+	}, vars)
+}
+
+func TestLoadHCLVariablesFile(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	require.NoError(t, fs.WriteFile("/prod.specvars", []byte(`
+tag = "1.0.0"
+hosts = ["a", upper("b")]
+`), 0644))
+	require.NoError(t, fs.WriteFile("/invalid.specvars", []byte(`
+service "billing" {}
+`), 0644))
+
+	vars, err := specterutils.LoadHCLVariablesFile(fs, "/prod.specvars")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]cty.Value{
+		"tag":   cty.StringVal("1.0.0"),
+		"hosts": cty.TupleVal([]cty.Value{cty.StringVal("a"), cty.StringVal("B")}),
+	}, vars)
+
+	_, err = specterutils.LoadHCLVariablesFile(fs, "/invalid.specvars")
+	testutils.RequireErrorWithCode(specterutils.InvalidHCLErrorCode)(t, err)
+
+	_, err = specterutils.LoadHCLVariablesFile(fs, "/missing.specvars")
+	require.Error(t, err)
+
+	merged := specterutils.MergeHCLVariables(vars, map[string]cty.Value{"tag": cty.StringVal("2.0.0")})
+	assert.Equal(t, cty.StringVal("2.0.0"), merged["tag"])
+	assert.Equal(t, vars["hosts"], merged["hosts"])
+}
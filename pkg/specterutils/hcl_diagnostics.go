@@ -0,0 +1,231 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"io"
+	"strings"
+)
+
+type HCLDiagnosticSeverity string
+
+const (
+	HCLDiagnosticError   HCLDiagnosticSeverity = "error"
+	HCLDiagnosticWarning HCLDiagnosticSeverity = "warning"
+)
+
+// HCLDiagnostic is an error representing an hcl.Diagnostic. Unlike the hcl.Diagnostic it keeps the snippet of
+// source code where the problem occurred, so that it can be reported to users without access to the source, or
+// rendered with an HCLDiagnosticRenderer.
+type HCLDiagnostic struct {
+	Severity HCLDiagnosticSeverity
+	Summary  string
+	Detail   string
+
+	// Range of the source where the problem occurred. It is zero when unknown.
+	Range specter.SourceRange
+
+	// Snippet contains the complete lines of source code covered by the Range.
+	Snippet string
+}
+
+func (d HCLDiagnostic) Error() string {
+	msg := d.Summary
+	if d.Detail != "" {
+		msg = fmt.Sprintf("%s; %s", msg, d.Detail)
+	}
+	if d.Range.IsZero() {
+		return msg
+	}
+	return fmt.Sprintf("%s: %s", d.Range, msg)
+}
+
+func (d HCLDiagnostic) SourceRange() specter.SourceRange {
+	return d.Range
+}
+
+// newHCLDiagnostic converts an hcl.Diagnostic to an HCLDiagnostic, using the data of the source in which it
+// occurred to extract its snippet.
+func newHCLDiagnostic(d *hcl.Diagnostic, data []byte) HCLDiagnostic {
+	diag := HCLDiagnostic{
+		Severity: HCLDiagnosticError,
+		Summary:  d.Summary,
+		Detail:   d.Detail,
+	}
+	if d.Severity == hcl.DiagWarning {
+		diag.Severity = HCLDiagnosticWarning
+	}
+	if d.Subject != nil {
+		diag.Range = newSourceRange(*d.Subject)
+		diag.Snippet = sourceSnippet(data, diag.Range)
+	}
+	return diag
+}
+
+// newHCLDiagnosticsError returns a group of the error diagnostics converted to HCLDiagnostic each wrapped with the
+// given code, or nil if there are no errors.
+func newHCLDiagnosticsError(code string, diags hcl.Diagnostics, data []byte) error {
+	return errors.GroupOrNil(appendHCLDiagnostics(errors.NewGroup(code), code, diags, data))
+}
+
+// appendHCLDiagnostics appends the error diagnostics converted to HCLDiagnostic each wrapped with the given code
+// to a group. Warnings are not errors and should be reported with logHCLWarnings.
+func appendHCLDiagnostics(group errors.Group, code string, diags hcl.Diagnostics, data []byte) errors.Group {
+	for _, d := range diags {
+		if d.Severity != hcl.DiagError {
+			continue
+		}
+		group = group.Append(errors.Wrap(newHCLDiagnostic(d, data), code))
+	}
+	return group
+}
+
+// logHCLWarnings reports the warning diagnostics converted to HCLDiagnostic to a logger, if any.
+func logHCLWarnings(logger specter.Logger, diags hcl.Diagnostics, data []byte) {
+	if logger == nil {
+		return
+	}
+	for _, d := range diags {
+		if d.Severity != hcl.DiagWarning {
+			continue
+		}
+		logger.Warning(newHCLDiagnostic(d, data).Error())
+	}
+}
+
+// sourceSnippet returns the complete lines of data covered by a range.
+func sourceSnippet(data []byte, r specter.SourceRange) string {
+	if r.Start.Line <= 0 {
+		return ""
+	}
+	end := r.End.Line
+	if end < r.Start.Line {
+		end = r.Start.Line
+	}
+
+	lines := strings.Split(string(data), "\n")
+	if r.Start.Line > len(lines) {
+		return ""
+	}
+	if end > len(lines) {
+		end = len(lines)
+	}
+	return strings.Join(lines[r.Start.Line-1:end], "\n")
+}
+
+// HCLDiagnosticsFromError returns all the HCLDiagnostic contained in an error, including the ones of
+// errors.Group or wrapped errors.
+func HCLDiagnosticsFromError(err error) []HCLDiagnostic {
+	var diags []HCLDiagnostic
+	for _, e := range FlattenErrors(err) {
+		var d HCLDiagnostic
+		if errors.As(e, &d) {
+			diags = append(diags, d)
+		}
+	}
+	return diags
+}
+
+// HCLDiagnosticRenderer renders the HCLDiagnostic of errors in a human-readable form pointing to the exact location
+// of the problem in the source code, e.g.:
+//
+//	Error: Unsupported argument
+//
+//	  on specs.hcl line 3, in service "billing":
+//	   3:   imag = "billing:1.0.0"
+//
+//	An argument named "imag" is not expected here.
+type HCLDiagnosticRenderer struct {
+	// Sources in which the diagnostics occurred. When the source of a diagnostic is not available,
+	// its Snippet is rendered instead.
+	Sources []specter.Source
+
+	// Color indicates if the output should contain terminal colors.
+	Color bool
+
+	// Width at which the details are wrapped. Zero means no wrapping.
+	Width uint
+}
+
+func NewHCLDiagnosticRenderer(sources []specter.Source, color bool) *HCLDiagnosticRenderer {
+	return &HCLDiagnosticRenderer{Sources: sources, Color: color}
+}
+
+// Render writes the HCLDiagnostic contained in an error to a writer. Errors that are not diagnostics are
+// rendered with their message only.
+func (r HCLDiagnosticRenderer) Render(w io.Writer, err error) error {
+	files := map[string]*hcl.File{}
+	for _, s := range r.Sources {
+		file, _ := hclsyntax.ParseConfig(s.Data, s.Location, hcl.InitialPos)
+		if file == nil {
+			file = &hcl.File{}
+		}
+		file.Bytes = s.Data
+		files[s.Location] = file
+	}
+
+	writer := hcl.NewDiagnosticTextWriter(w, files, r.Width, r.Color)
+	for _, e := range FlattenErrors(err) {
+		var d HCLDiagnostic
+		if !errors.As(e, &d) {
+			d = HCLDiagnostic{Severity: HCLDiagnosticError, Summary: e.Error()}
+		}
+
+		diag := d.hclDiagnostic()
+		if _, ok := files[d.Range.Filename]; !ok && d.Snippet != "" {
+			// Rebuild the lines of the source from the snippet, so it can be rendered at the same position.
+			data := []byte(strings.Repeat("\n", d.Range.Start.Line-1) + d.Snippet)
+			diag.Subject.Start.Byte = lineColumnToOffset(data, d.Range.Start.Line, d.Range.Start.Column)
+			diag.Subject.End.Byte = lineColumnToOffset(data, d.Range.End.Line, d.Range.End.Column)
+			if err := hcl.NewDiagnosticTextWriter(w, map[string]*hcl.File{
+				d.Range.Filename: {Bytes: data},
+			}, r.Width, r.Color).WriteDiagnostic(diag); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := writer.WriteDiagnostic(diag); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hclDiagnostic converts this diagnostic back to an hcl.Diagnostic.
+func (d HCLDiagnostic) hclDiagnostic() *hcl.Diagnostic {
+	diag := &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  d.Summary,
+		Detail:   d.Detail,
+	}
+	if d.Severity == HCLDiagnosticWarning {
+		diag.Severity = hcl.DiagWarning
+	}
+	if !d.Range.IsZero() {
+		diag.Subject = &hcl.Range{
+			Filename: d.Range.Filename,
+			Start:    hcl.Pos{Line: d.Range.Start.Line, Column: d.Range.Start.Column, Byte: d.Range.Start.Byte},
+			End:      hcl.Pos{Line: d.Range.End.Line, Column: d.Range.End.Column, Byte: d.Range.End.Byte},
+		}
+	}
+	return diag
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"bytes"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var _ error = specterutils.HCLDiagnostic{}
+var _ specter.HasSourceRange = specterutils.HCLDiagnostic{}
+
+func loadInvalidHCLSource(t *testing.T) (specter.Source, error) {
+	src := specter.Source{
+		Location: "specs.hcl",
+		Data: []byte(`
+service "billing" {
+  image = "billing:1.0.0"
+  replicas = var.missing
+}
+`),
+		Format: specterutils.HCLSourceFormat,
+	}
+	_, err := specterutils.NewHCLGenericUnitLoader().Load(src)
+	require.Error(t, err)
+	return src, err
+}
+
+func TestHCLDiagnosticsFromError(t *testing.T) {
+	_, err := loadInvalidHCLSource(t)
+
+	assert.True(t, errors.HasCode(err, specterutils.InvalidHCLErrorCode))
+
+	diags := specterutils.HCLDiagnosticsFromError(err)
+	require.Len(t, diags, 1)
+	assert.Equal(t, specterutils.HCLDiagnosticError, diags[0].Severity)
+	assert.Equal(t, "Unsupported attribute", diags[0].Summary)
+	assert.Contains(t, diags[0].Detail, `"missing"`)
+	assert.Equal(t, "specs.hcl:4:17", diags[0].Range.String())
+	assert.Equal(t, "  replicas = var.missing", diags[0].Snippet)
+
+	assert.Nil(t, specterutils.HCLDiagnosticsFromError(errors.New("not a diagnostic")))
+}
+
+func TestLogHCLWarnings(t *testing.T) {
+	data := []byte("service \"billing\" {\n  image = \"billing:1.0.0\"\n}\n")
+	subject := &hcl.Range{
+		Filename: "specs.hcl",
+		Start:    hcl.Pos{Line: 2, Column: 3, Byte: 22},
+		End:      hcl.Pos{Line: 2, Column: 8, Byte: 27},
+	}
+	diags := hcl.Diagnostics{
+		{Severity: hcl.DiagWarning, Summary: "Deprecated attribute", Detail: "Use tag instead.", Subject: subject},
+		{Severity: hcl.DiagError, Summary: "Unsupported attribute", Subject: subject},
+	}
+
+	buffer := &bytes.Buffer{}
+	logger := specter.NewDefaultLogger(specter.DefaultLoggerConfig{DisableColors: true, Writer: buffer})
+	specterutils.LogHCLWarnings(logger, diags, data)
+
+	assert.Contains(t, buffer.String(), "specs.hcl:2:3: Deprecated attribute; Use tag instead.")
+	assert.NotContains(t, buffer.String(), "Unsupported attribute")
+
+	// Without a logger, warnings are discarded.
+	assert.NotPanics(t, func() { specterutils.LogHCLWarnings(nil, diags, data) })
+}
+
+func TestHCLDiagnosticRenderer_Render(t *testing.T) {
+	src, err := loadInvalidHCLSource(t)
+
+	tests := []struct {
+		name       string
+		given      *specterutils.HCLDiagnosticRenderer
+		givenError error
+		then       []string
+		thenNot    []string
+	}{
+		{
+			name:       "GIVEN the source of a diagnostic THEN render it with its snippet and context",
+			given:      specterutils.NewHCLDiagnosticRenderer([]specter.Source{src}, false),
+			givenError: err,
+			then: []string{
+				"Error: Unsupported attribute\n",
+				"  on specs.hcl line 4, in service \"billing\":\n",
+				"   4:   replicas = var.missing\n",
+			},
+			thenNot: []string{"\x1b["},
+		},
+		{
+			name:       "GIVEN color is enabled THEN render with terminal colors",
+			given:      specterutils.NewHCLDiagnosticRenderer([]specter.Source{src}, true),
+			givenError: err,
+			then:       []string{"\x1b[31mError\x1b[0m: Unsupported attribute"},
+		},
+		{
+			name:       "GIVEN the source of a diagnostic is not available THEN render its snippet",
+			given:      specterutils.NewHCLDiagnosticRenderer(nil, false),
+			givenError: err,
+			then: []string{
+				"  on specs.hcl line 4:\n",
+				"   4:   replicas = var.missing\n",
+			},
+			thenNot: []string{"source code not available"},
+		},
+		{
+			name:       "GIVEN an error that is not a diagnostic THEN render its message",
+			given:      specterutils.NewHCLDiagnosticRenderer(nil, false),
+			givenError: errors.NewWithMessage("some_code", "something went wrong"),
+			then:       []string{"Error: something went wrong"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			require.NoError(t, tt.given.Render(&buffer, tt.givenError))
+
+			for _, expected := range tt.then {
+				assert.Contains(t, buffer.String(), expected)
+			}
+			for _, unexpected := range tt.thenNot {
+				assert.NotContains(t, buffer.String(), unexpected)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"io/fs"
+	"path/filepath"
+	"sort"
+)
+
+const HCLFormattingFailedErrorCode = "specter.hcl_formatting_failed"
+
+// HCLFileExtension is the extension of the files formatted by an HCLFormatter when walking directories.
+const HCLFileExtension = ".hcl"
+
+// HCLFormatter canonicalizes the formatting of HCL unit files, similarly to "terraform fmt".
+//
+// On top of the canonical formatting of hclwrite, the attributes of the blocks of unit kinds having an attribute order
+// are reordered. Attributes are only reordered within groups of consecutive attributes, so that blank lines and nested
+// blocks added by authors to separate concerns are kept. Comments directly above an attribute move along with it.
+type HCLFormatter struct {
+	// FileSystem used to read and write the formatted files.
+	FileSystem specter.FileSystem
+
+	// AttributeOrders are the orders of the attributes of the blocks of unit kinds. Attributes not part of the order of
+	// a kind are placed after the ones that are, in their original order.
+	AttributeOrders map[specter.UnitKind][]string
+}
+
+func NewHCLFormatter(fs specter.FileSystem) *HCLFormatter {
+	return &HCLFormatter{
+		FileSystem:      fs,
+		AttributeOrders: map[specter.UnitKind][]string{},
+	}
+}
+
+// WithAttributeOrder configures the order of the attributes of the blocks of a unit kind.
+func (f *HCLFormatter) WithAttributeOrder(kind specter.UnitKind, attributes ...string) *HCLFormatter {
+	if f.AttributeOrders == nil {
+		f.AttributeOrders = map[specter.UnitKind][]string{}
+	}
+	f.AttributeOrders[kind] = attributes
+	return f
+}
+
+// Format returns the canonical formatting of HCL data. The filename is only used to report errors.
+func (f HCLFormatter) Format(data []byte, filename string) ([]byte, error) {
+	file, diags := hclsyntax.ParseConfig(data, filename, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, newHCLDiagnosticsError(InvalidHCLErrorCode, diags, data)
+	}
+
+	if len(f.AttributeOrders) != 0 {
+		data = f.orderAttributes(data, file.Body.(*hclsyntax.Body))
+	}
+
+	return hclwrite.Format(data), nil
+}
+
+// FormatFiles formats the HCL files at some locations, walking directories, and rewrites the ones that were not
+// formatted. It returns the paths of the rewritten files.
+func (f HCLFormatter) FormatFiles(locations ...string) ([]string, error) {
+	return f.formatFiles(locations, true)
+}
+
+// CheckFiles returns the paths of the HCL files at some locations that are not formatted, without rewriting them.
+// It is intended to be used by continuous integration to reject unformatted files.
+func (f HCLFormatter) CheckFiles(locations ...string) ([]string, error) {
+	return f.formatFiles(locations, false)
+}
+
+func (f HCLFormatter) formatFiles(locations []string, write bool) ([]string, error) {
+	errs := errors.NewGroup(HCLFormattingFailedErrorCode)
+
+	paths, err := f.files(locations)
+	if err != nil {
+		errs = errs.Append(err)
+	}
+
+	var unformatted []string
+	for _, path := range paths {
+		changed, err := f.formatFile(path, write)
+		if err != nil {
+			errs = errs.Append(err)
+			continue
+		}
+		if changed {
+			unformatted = append(unformatted, path)
+		}
+	}
+
+	return unformatted, errors.GroupOrNil(errs)
+}
+
+// formatFile formats a file, rewriting it if requested, and indicates if it was not formatted.
+func (f HCLFormatter) formatFile(path string, write bool) (bool, error) {
+	data, err := f.FileSystem.ReadFile(path)
+	if err != nil {
+		return false, errors.WrapWithMessage(err, HCLFormattingFailedErrorCode, fmt.Sprintf("failed reading file %q", path))
+	}
+
+	formatted, err := f.Format(data, path)
+	if err != nil {
+		return false, err
+	}
+
+	if bytes.Equal(data, formatted) {
+		return false, nil
+	}
+
+	if !write {
+		return true, nil
+	}
+
+	var perm fs.FileMode = 0644
+	if stat, err := f.FileSystem.StatPath(path); err == nil {
+		perm = stat.Mode().Perm()
+	}
+	if err := f.FileSystem.WriteFile(path, formatted, perm); err != nil {
+		return false, errors.WrapWithMessage(err, HCLFormattingFailedErrorCode, fmt.Sprintf("failed writing file %q", path))
+	}
+
+	return true, nil
+}
+
+// files returns the paths of the files at some locations. Directories are walked for files with the HCLFileExtension,
+// while files are returned regardless of their extension.
+func (f HCLFormatter) files(locations []string) ([]string, error) {
+	var paths []string
+	for _, location := range locations {
+		stat, err := f.FileSystem.StatPath(location)
+		if err != nil {
+			return nil, errors.WrapWithMessage(err, HCLFormattingFailedErrorCode, fmt.Sprintf("failed reading %q", location))
+		}
+
+		if !stat.IsDir() {
+			paths = append(paths, location)
+			continue
+		}
+
+		err = f.FileSystem.WalkDir(location, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && filepath.Ext(path) == HCLFileExtension {
+				paths = append(paths, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.WrapWithMessage(err, HCLFormattingFailedErrorCode, fmt.Sprintf("failed reading directory %q", location))
+		}
+	}
+
+	return paths, nil
+}
+
+// hclTextEdit replaces the bytes of a range of data with some text.
+type hclTextEdit struct {
+	start, end int
+	text       []byte
+}
+
+// orderAttributes reorders the attributes of the blocks of the unit kinds having an attribute order.
+func (f HCLFormatter) orderAttributes(data []byte, body *hclsyntax.Body) []byte {
+	var edits []hclTextEdit
+	for _, block := range body.Blocks {
+		order, ok := f.AttributeOrders[specter.UnitKind(block.Type)]
+		if !ok {
+			continue
+		}
+		edits = append(edits, orderedAttributeEdits(data, block.Body, order)...)
+	}
+
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start > edits[j].start
+	})
+
+	result := append([]byte(nil), data...)
+	for _, e := range edits {
+		result = append(result[:e.start], append(append([]byte(nil), e.text...), result[e.end:]...)...)
+	}
+	return result
+}
+
+// orderedAttributeEdits returns the edits ordering the groups of consecutive attributes of a body.
+func orderedAttributeEdits(data []byte, body *hclsyntax.Body, order []string) []hclTextEdit {
+	rank := map[string]int{}
+	for i, name := range order {
+		rank[name] = i
+	}
+	rankOf := func(name string) int {
+		if r, ok := rank[name]; ok {
+			return r
+		}
+		return len(order)
+	}
+
+	type chunk struct {
+		name       string
+		start, end int
+	}
+
+	var chunks []chunk
+	for _, attr := range body.Attributes {
+		chunks = append(chunks, chunk{
+			name:  attr.Name,
+			start: attributeChunkStart(data, attr.SrcRange.Start.Byte),
+			end:   lineEnd(data, attr.SrcRange.End.Byte),
+		})
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].start < chunks[j].start
+	})
+
+	var edits []hclTextEdit
+	for i := 0; i < len(chunks); {
+		// Find the group of attributes that directly follow each other.
+		j := i + 1
+		for j < len(chunks) && chunks[j].start == chunks[j-1].end {
+			j++
+		}
+
+		group := append([]chunk(nil), chunks[i:j]...)
+		sort.SliceStable(group, func(a, b int) bool {
+			return rankOf(group[a].name) < rankOf(group[b].name)
+		})
+
+		var text []byte
+		for _, c := range group {
+			text = append(text, data[c.start:c.end]...)
+		}
+		if start, end := chunks[i].start, chunks[j-1].end; !bytes.Equal(text, data[start:end]) {
+			edits = append(edits, hclTextEdit{start: start, end: end, text: text})
+		}
+
+		i = j
+	}
+
+	return edits
+}
+
+// attributeChunkStart returns the start of the line of an attribute, including the comment lines directly above it.
+func attributeChunkStart(data []byte, offset int) int {
+	start := lineStart(data, offset)
+	for start > 0 {
+		prev := lineStart(data, start-1)
+		line := bytes.TrimSpace(data[prev:start])
+		if !bytes.HasPrefix(line, []byte("#")) && !bytes.HasPrefix(line, []byte("//")) {
+			break
+		}
+		start = prev
+	}
+	return start
+}
+
+func lineStart(data []byte, offset int) int {
+	return bytes.LastIndexByte(data[:offset], '\n') + 1
+}
+
+// lineEnd returns the offset following the end of the line containing an offset, including its line feed.
+func lineEnd(data []byte, offset int) int {
+	if i := bytes.IndexByte(data[offset:], '\n'); i >= 0 {
+		return offset + i + 1
+	}
+	return len(data)
+}
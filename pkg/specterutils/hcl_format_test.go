@@ -0,0 +1,192 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHCLFormatter_Format(t *testing.T) {
+	tests := []struct {
+		name       string
+		givenOrder map[specter.UnitKind][]string
+		when       string
+		then       string
+		thenError  require.ErrorAssertionFunc
+	}{
+		{
+			name: "GIVEN unformatted HCL WHEN formatted THEN should return canonical formatting",
+			when: `service "billing" {
+image="billing:1.0"
+    replicas   = 2
+}
+`,
+			then: `service "billing" {
+  image    = "billing:1.0"
+  replicas = 2
+}
+`,
+		},
+		{
+			name:       "GIVEN an attribute order WHEN formatted THEN should reorder the attributes of the units of the kind",
+			givenOrder: map[specter.UnitKind][]string{"service": {"image", "replicas"}},
+			when: `service "billing" {
+  port = 8080
+  # The number of instances.
+  replicas = 2
+  image = "billing:1.0" // pinned
+}
+
+worker "mailer" {
+  replicas = 1
+  image = "mailer:1.0"
+}
+`,
+			then: `service "billing" {
+  image = "billing:1.0" // pinned
+  # The number of instances.
+  replicas = 2
+  port     = 8080
+}
+
+worker "mailer" {
+  replicas = 1
+  image    = "mailer:1.0"
+}
+`,
+		},
+		{
+			name:       "GIVEN groups of attributes WHEN formatted THEN should only reorder attributes within their group",
+			givenOrder: map[specter.UnitKind][]string{"service": {"image", "replicas", "port"}},
+			when: `service "billing" {
+  replicas = 2
+  image = "billing:1.0"
+
+  port = 8080
+  env {
+    name = "prod"
+  }
+  replicas_max = 4
+}
+`,
+			then: `service "billing" {
+  image    = "billing:1.0"
+  replicas = 2
+
+  port = 8080
+  env {
+    name = "prod"
+  }
+  replicas_max = 4
+}
+`,
+		},
+		{
+			name: "GIVEN invalid HCL WHEN formatted THEN should return an error",
+			when: `service "billing" {`,
+			thenError: func(t require.TestingT, err error, i ...interface{}) {
+				testutils.RequireErrorWithCode(specterutils.InvalidHCLErrorCode)(t, err, i...)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := specterutils.NewHCLFormatter(&specter.MemoryFileSystem{})
+			for kind, order := range tt.givenOrder {
+				f.WithAttributeOrder(kind, order...)
+			}
+
+			got, err := f.Format([]byte(tt.when), "billing.hcl")
+			if tt.thenError != nil {
+				tt.thenError(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.then, string(got))
+		})
+	}
+}
+
+func TestHCLFormatter_FormatFiles(t *testing.T) {
+	const formatted = "service \"billing\" {\n  image = \"billing:1.0\"\n}\n"
+	const unformatted = "service \"orders\" {\nimage=\"orders:1.0\"\n}\n"
+
+	givenFileSystem := func(t *testing.T) *specter.MemoryFileSystem {
+		fs := &specter.MemoryFileSystem{}
+		require.NoError(t, fs.Mkdir("/specs/nested", 0755))
+		require.NoError(t, fs.WriteFile("/specs/billing.hcl", []byte(formatted), 0644))
+		require.NoError(t, fs.WriteFile("/specs/nested/orders.hcl", []byte(unformatted), 0600))
+		require.NoError(t, fs.WriteFile("/specs/README.md", []byte("image=1"), 0644))
+		return fs
+	}
+
+	t.Run("GIVEN unformatted files WHEN checked THEN should report them without rewriting them", func(t *testing.T) {
+		fs := givenFileSystem(t)
+		f := specterutils.NewHCLFormatter(fs)
+
+		got, err := f.CheckFiles("/specs")
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/specs/nested/orders.hcl"}, got)
+		data, err := fs.ReadFile("/specs/nested/orders.hcl")
+		require.NoError(t, err)
+		assert.Equal(t, unformatted, string(data))
+	})
+
+	t.Run("GIVEN unformatted files WHEN formatted THEN should rewrite them", func(t *testing.T) {
+		fs := givenFileSystem(t)
+		f := specterutils.NewHCLFormatter(fs)
+
+		got, err := f.FormatFiles("/specs")
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/specs/nested/orders.hcl"}, got)
+		data, err := fs.ReadFile("/specs/nested/orders.hcl")
+		require.NoError(t, err)
+		assert.Equal(t, "service \"orders\" {\n  image = \"orders:1.0\"\n}\n", string(data))
+		stat, err := fs.StatPath("/specs/nested/orders.hcl")
+		require.NoError(t, err)
+		assert.Equal(t, "-rw-------", stat.Mode().Perm().String())
+
+		got, err = f.CheckFiles("/specs")
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("GIVEN an invalid file WHEN formatted THEN should format the other files and return an error", func(t *testing.T) {
+		fs := givenFileSystem(t)
+		require.NoError(t, fs.WriteFile("/specs/invalid.hcl", []byte(`service "invalid" {`), 0644))
+		f := specterutils.NewHCLFormatter(fs)
+
+		got, err := f.FormatFiles("/specs")
+
+		testutils.RequireErrorWithCode(specterutils.HCLFormattingFailedErrorCode)(t, err)
+		assert.Equal(t, []string{"/specs/nested/orders.hcl"}, got)
+	})
+
+	t.Run("GIVEN a missing location WHEN formatted THEN should return an error", func(t *testing.T) {
+		f := specterutils.NewHCLFormatter(givenFileSystem(t))
+
+		_, err := f.FormatFiles("/missing")
+
+		testutils.RequireErrorWithCode(specterutils.HCLFormattingFailedErrorCode)(t, err)
+	})
+}
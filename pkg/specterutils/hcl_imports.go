@@ -0,0 +1,291 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
+	"path/filepath"
+	"strings"
+)
+
+// HCLImportBlockType is the type of the blocks importing the units of other sources, e.g.:
+//
+//	import "../shared/types.hcl" {
+//	  namespace = "shared"
+//	}
+const HCLImportBlockType = "import"
+
+// HCLImportNamespaceSeparator separates the namespace of an import from the IDs of the units it imports,
+// e.g. "shared/money". It cannot appear in HCL identifiers so that namespaced IDs are not mistaken for attribute
+// traversals: imported units are referenced with the index syntax, e.g. type["shared/money"].currency.
+const HCLImportNamespaceSeparator = "/"
+
+var hclImportBlockSchema = &hcl.BodySchema{
+	Attributes: []hcl.AttributeSchema{
+		{Name: "namespace"},
+	},
+}
+
+// hclImport represents a source imported by an import block.
+type hclImport struct {
+	source    specter.Source
+	namespace string
+
+	// blockRange is the range of the import block definition.
+	blockRange hcl.Range
+}
+
+// hclImportResolver resolves the sources imported by HCL sources through source loaders, consulted as a
+// specter.SourceLoaderRegistry. Locations that no loader supports are reported according to a specter.StrictnessPolicy.
+// It detects circular imports and skips sources that were already imported in the same namespace.
+type hclImportResolver struct {
+	loaders *specter.SourceLoaderRegistry
+
+	// unsupportedLocationPolicy defines how to report locations that no loader supports.
+	unsupportedLocationPolicy specter.StrictnessPolicy
+	logger                    specter.Logger
+
+	// stack of the locations of the sources currently being loaded.
+	stack []string
+
+	// imported contains the keys of the sources already imported by namespace and location.
+	imported map[string]struct{}
+}
+
+func newHCLImportResolver(
+	loaders []specter.SourceLoader,
+	unsupportedLocationPolicy specter.StrictnessPolicy,
+	logger specter.Logger,
+	root specter.Source,
+) *hclImportResolver {
+	return &hclImportResolver{
+		loaders:                   specter.NewSourceLoaderRegistryOf(loaders...),
+		unsupportedLocationPolicy: unsupportedLocationPolicy,
+		logger:                    logger,
+		stack:                     []string{root.Location},
+		imported:                  map[string]struct{}{hclImportKey("", root.Location): {}},
+	}
+}
+
+// load loads an imported source with a load function while keeping track of the sources being loaded.
+func (r *hclImportResolver) load(
+	imp hclImport,
+	load func(s specter.Source, namespace string) ([]specter.Unit, error),
+) ([]specter.Unit, error) {
+	r.stack = append(r.stack, imp.source.Location)
+	defer func() { r.stack = r.stack[:len(r.stack)-1] }()
+
+	return load(imp.source, imp.namespace)
+}
+
+// resolve resolves the sources imported by the import blocks of the body of a source and returns them along with
+// the remaining body, stripped of these blocks.
+func (r *hclImportResolver) resolve(
+	s specter.Source,
+	ctx *hcl.EvalContext,
+	body *hclsyntax.Body,
+	namespace string,
+) ([]hclImport, *hclsyntax.Body, hcl.Diagnostics) {
+	remaining := &hclsyntax.Body{
+		Attributes: body.Attributes,
+		SrcRange:   body.SrcRange,
+		EndRange:   body.EndRange,
+	}
+
+	var imports []hclImport
+	var diags hcl.Diagnostics
+	for _, block := range body.Blocks {
+		if block.Type != HCLImportBlockType {
+			remaining.Blocks = append(remaining.Blocks, block)
+			continue
+		}
+
+		blockImports, d := r.resolveBlock(s, ctx, block, namespace)
+		diags = diags.Extend(d)
+		imports = append(imports, blockImports...)
+	}
+
+	return imports, remaining, diags
+}
+
+func (r *hclImportResolver) resolveBlock(
+	s specter.Source,
+	ctx *hcl.EvalContext,
+	block *hclsyntax.Block,
+	namespace string,
+) ([]hclImport, hcl.Diagnostics) {
+	if len(block.Labels) != 1 || block.Labels[0] == "" {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Missing import location",
+			Detail:   "An import block should have exactly one label: the location of the source to import.",
+			Subject:  block.DefRange().Ptr(),
+		}}
+	}
+
+	content, diags := block.Body.Content(hclImportBlockSchema)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	if attr, ok := content.Attributes["namespace"]; ok {
+		v, d := attr.Expr.Value(ctx)
+		diags = diags.Extend(d)
+		if d.HasErrors() {
+			return nil, diags
+		}
+		if v.IsNull() || !v.IsKnown() || v.Type() != cty.String || v.AsString() == "" {
+			return nil, diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid import namespace",
+				Detail:   "The namespace of an import should be a non-empty string.",
+				Subject:  attr.Expr.Range().Ptr(),
+			})
+		}
+		namespace = hclNamespacedID(namespace, v.AsString())
+	}
+
+	location := resolveHCLImportLocation(s.Location, block.Labels[0])
+	sources, err := r.loaders.Load(location)
+	if errors.HasCode(err, specter.UnsupportedSourceLocationErrorCode) {
+		err = r.unsupportedLocationPolicy.Apply(r.logger, errors.WrapWithMessage(
+			err,
+			specter.UnsupportedSourceLocationErrorCode,
+			fmt.Sprintf("cannot import %q from %q", block.Labels[0], s.Location),
+		))
+		if err == nil {
+			return nil, diags
+		}
+	}
+	if err != nil {
+		return nil, diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Failed to import source",
+			Detail:   fmt.Sprintf("The source %q could not be imported: %s.", block.Labels[0], err.Error()),
+			Subject:  block.LabelRanges[0].Ptr(),
+		})
+	}
+
+	var imports []hclImport
+	for _, src := range sources {
+		if src.Format != HCLSourceFormat {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Unsupported import format",
+				Detail:   fmt.Sprintf("The source %q has the format %q, only HCL sources can be imported.", src.Location, src.Format),
+				Subject:  block.LabelRanges[0].Ptr(),
+			})
+			continue
+		}
+
+		if r.isLoading(src.Location) {
+			diags = diags.Append(&hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Circular import",
+				Detail: fmt.Sprintf(
+					"The source %q cannot be imported since it is already being imported: %s.",
+					src.Location,
+					strings.Join(append(append([]string(nil), r.stack...), src.Location), " -> "),
+				),
+				Subject: block.LabelRanges[0].Ptr(),
+			})
+			continue
+		}
+
+		key := hclImportKey(namespace, src.Location)
+		if _, ok := r.imported[key]; ok {
+			continue
+		}
+		r.imported[key] = struct{}{}
+
+		imports = append(imports, hclImport{source: src, namespace: namespace, blockRange: block.DefRange()})
+	}
+
+	return imports, diags
+}
+
+func (r *hclImportResolver) isLoading(location string) bool {
+	for _, l := range r.stack {
+		if l == location {
+			return true
+		}
+	}
+	return false
+}
+
+func hclImportKey(namespace, location string) string {
+	return namespace + "\x00" + location
+}
+
+// hclNamespacedID prefixes an ID with a namespace, if any.
+func hclNamespacedID(namespace, id string) string {
+	if namespace == "" {
+		return id
+	}
+	return namespace + HCLImportNamespaceSeparator + id
+}
+
+// resolveHCLImportLocation resolves the location of an import relative to the location of the importing source,
+// when both are plain paths.
+func resolveHCLImportLocation(importer string, location string) string {
+	if specter.SourceLocationScheme(location) != specter.NoSourceLocationScheme || filepath.IsAbs(location) {
+		return location
+	}
+	if specter.SourceLocationScheme(importer) != specter.NoSourceLocationScheme || importer == "" {
+		return location
+	}
+	return filepath.Join(filepath.Dir(importer), location)
+}
+
+var _ specter.UnitPreprocessor = HCLImportDeduplicationProcessor{}
+
+// HCLImportDeduplicationProcessor is a specter.UnitPreprocessor removing the units that were loaded more than once,
+// which happens when multiple sources import the same source, or when an imported source is also loaded directly
+// by the pipeline. Units are considered duplicates when they have the same kind, ID and source location.
+type HCLImportDeduplicationProcessor struct{}
+
+func NewHCLImportDeduplicationProcessor() *HCLImportDeduplicationProcessor {
+	return &HCLImportDeduplicationProcessor{}
+}
+
+func (p HCLImportDeduplicationProcessor) Name() string {
+	return "hcl_import_deduplication_processor"
+}
+
+func (p HCLImportDeduplicationProcessor) Preprocess(_ specter.PipelineContext, units []specter.Unit) ([]specter.Unit, error) {
+	type unitKey struct {
+		kind     specter.UnitKind
+		id       specter.UnitID
+		location string
+	}
+
+	var deduplicated []specter.Unit
+	seen := map[unitKey]struct{}{}
+	for _, u := range units {
+		key := unitKey{kind: u.Kind(), id: u.ID(), location: u.Source().Location}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		deduplicated = append(deduplicated, u)
+	}
+
+	return deduplicated, nil
+}
@@ -0,0 +1,329 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"context"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"path/filepath"
+	"testing"
+)
+
+var _ specter.UnitPreprocessor = (*specterutils.HCLImportDeduplicationProcessor)(nil)
+
+func newHCLImportsFileSystem(t *testing.T, files map[string]string) *specter.MemoryFileSystem {
+	fs := &specter.MemoryFileSystem{}
+	for path, data := range files {
+		require.NoError(t, fs.Mkdir(filepath.Dir(path), 0755))
+		require.NoError(t, fs.WriteFile(path, []byte(data), 0644))
+	}
+	return fs
+}
+
+func TestHCLGenericUnitLoader_Load_Imports(t *testing.T) {
+	tests := []struct {
+		name          string
+		given         map[string]string
+		givenPolicy   specter.StrictnessPolicy
+		then          []string
+		thenError     require.ErrorAssertionFunc
+		thenErrorText string
+	}{
+		{
+			name: "GIVEN an import relative to the source THEN load the imported units",
+			given: map[string]string{
+				"/specs/billing/service.hcl": `
+import "../shared/types.hcl" {}
+
+service "billing" {}
+`,
+				"/specs/shared/types.hcl": `
+type "money" {}
+`,
+			},
+			then:      []string{"billing", "money"},
+			thenError: require.NoError,
+		},
+		{
+			name: "GIVEN a namespaced import THEN prefix the IDs of the imported units",
+			given: map[string]string{
+				"/specs/billing/service.hcl": `
+import "/specs/shared/types.hcl" {
+  namespace = "shared"
+}
+
+service "billing" {}
+`,
+				"/specs/shared/types.hcl": `
+import "more.hcl" {
+  namespace = "more"
+}
+
+type "money" {}
+`,
+				"/specs/shared/more.hcl": `
+type "currency" {}
+`,
+			},
+			then:      []string{"billing", "shared/money", "shared/more/currency"},
+			thenError: require.NoError,
+		},
+		{
+			name: "GIVEN the same source imported multiple times THEN load its units once",
+			given: map[string]string{
+				"/specs/billing/service.hcl": `
+import "/specs/shared/money.hcl" {}
+import "/specs/shared/invoice.hcl" {}
+
+service "billing" {}
+`,
+				"/specs/shared/invoice.hcl": `
+import "money.hcl" {}
+
+type "invoice" {}
+`,
+				"/specs/shared/money.hcl": `
+type "money" {}
+`,
+			},
+			then:      []string{"billing", "money", "invoice"},
+			thenError: require.NoError,
+		},
+		{
+			name: "GIVEN circular imports THEN return an error",
+			given: map[string]string{
+				"/specs/billing/service.hcl": `
+import "/specs/shared/types.hcl" {}
+
+service "billing" {}
+`,
+				"/specs/shared/types.hcl": `
+import "/specs/billing/service.hcl" {}
+
+type "money" {}
+`,
+			},
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidHCLErrorCode),
+			thenErrorText: "Circular import",
+		},
+		{
+			name: "GIVEN an import of a location that does not exist THEN return an error",
+			given: map[string]string{
+				"/specs/billing/service.hcl": `
+import "missing.hcl" {}
+
+service "billing" {}
+`,
+			},
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidHCLErrorCode),
+			thenErrorText: `no source loader supports location "/specs/billing/missing.hcl"`,
+		},
+		{
+			name: "GIVEN an import of a location that does not exist and an ignore policy THEN skip it",
+			given: map[string]string{
+				"/specs/billing/service.hcl": `
+import "missing.hcl" {}
+
+service "billing" {}
+`,
+			},
+			givenPolicy: specter.StrictnessIgnore,
+			then:        []string{"billing"},
+			thenError:   require.NoError,
+		},
+		{
+			name: "GIVEN an invalid imported source THEN return its error",
+			given: map[string]string{
+				"/specs/billing/service.hcl": `
+import "types.hcl" {}
+`,
+				"/specs/billing/types.hcl": `
+type "money" {
+`,
+			},
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidHCLErrorCode),
+			thenErrorText: "/specs/billing/types.hcl:2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := newHCLImportsFileSystem(t, tt.given)
+			sourceLoader := specter.NewFileSystemSourceLoader(fs)
+			sources, err := sourceLoader.Load("/specs/billing/service.hcl")
+			require.NoError(t, err)
+
+			l := specterutils.NewHCLGenericUnitLoader()
+			l.SourceLoaders = []specter.SourceLoader{sourceLoader}
+			l.UnsupportedImportLocationPolicy = tt.givenPolicy
+
+			units, err := l.Load(sources[0])
+			tt.thenError(t, err)
+			if tt.thenErrorText != "" {
+				assert.ErrorContains(t, err, tt.thenErrorText)
+			}
+			if tt.then == nil {
+				return
+			}
+
+			var ids []string
+			for _, u := range units {
+				ids = append(ids, string(u.ID()))
+			}
+			assert.Equal(t, tt.then, ids)
+		})
+	}
+}
+
+func TestHCLGenericUnitLoader_Load_ImportsThroughRegistry(t *testing.T) {
+	fs := newHCLImportsFileSystem(t, map[string]string{
+		"/specs/billing.hcl": `
+import "file:///specs/shared.hcl" {}
+import "inline:type \"tax\" {}" {}
+
+service "billing" {}
+`,
+		"/specs/shared.hcl": `
+type "money" {}
+`,
+	})
+	registry := specter.NewDefaultSourceLoaderRegistry(fs)
+	l := specterutils.NewHCLGenericUnitLoader()
+	l.SourceLoaders = []specter.SourceLoader{registry}
+
+	sources, err := registry.Load("/specs/billing.hcl")
+	require.NoError(t, err)
+	units, err := l.Load(sources[0])
+	require.NoError(t, err)
+
+	var ids []specter.UnitID
+	for _, u := range units {
+		ids = append(ids, u.ID())
+	}
+	assert.Equal(t, []specter.UnitID{"billing", "money", "tax"}, ids)
+}
+
+func TestHCLGenericUnitLoader_Load_NamespacedImportReferences(t *testing.T) {
+	fs := newHCLImportsFileSystem(t, map[string]string{
+		"/specs/billing.hcl": `
+import "shared.hcl" {
+  namespace = "shared"
+}
+
+service "billing" {
+  currency = type["shared/money"].currency
+}
+`,
+		"/specs/shared.hcl": `
+type "money" {
+  currency = "CAD"
+}
+`,
+	})
+	sourceLoader := specter.NewFileSystemSourceLoader(fs)
+	l := specterutils.NewHCLGenericUnitLoader()
+	l.SourceLoaders = []specter.SourceLoader{sourceLoader}
+	l.DeferUnitReferences = true
+
+	sources, err := sourceLoader.Load("/specs/billing.hcl")
+	require.NoError(t, err)
+	units, err := l.Load(sources[0])
+	require.NoError(t, err)
+
+	assert.Equal(t, []specter.UnitID{"shared/money"}, specterutils.HCLReferenceDependencyProvider{}.Provide(units[0]))
+
+	units, err = specterutils.NewHCLReferenceResolutionProcessor().Preprocess(
+		specter.PipelineContext{Context: context.Background()},
+		units,
+	)
+	require.NoError(t, err)
+
+	billing := units[0].(*specterutils.GenericUnit)
+	assert.Equal(t, specter.UnitID("billing"), billing.ID())
+	assert.Equal(t, specterutils.GenericValue{Value: cty.StringVal("CAD")}, billing.Attribute("currency").Value)
+}
+
+type hclImportsFileConfigMock struct {
+	Services []struct {
+		Name string `hcl:"name,label"`
+	} `hcl:"service,block"`
+}
+
+func (c *hclImportsFileConfigMock) Units(s specter.Source) []specter.Unit {
+	var units []specter.Unit
+	for _, svc := range c.Services {
+		units = append(units, testutils.NewUnitStub(specter.UnitID(svc.Name), "service", s))
+	}
+	return units
+}
+
+func TestHCLUnitLoader_Load_Imports(t *testing.T) {
+	fs := newHCLImportsFileSystem(t, map[string]string{
+		"/specs/service.hcl": `
+import "types.hcl" {}
+
+service "billing" {}
+`,
+		"/specs/types.hcl": `
+service "shipping" {}
+`,
+		"/specs/namespaced.hcl": `
+import "types.hcl" {
+  namespace = "shared"
+}
+`,
+	})
+	sourceLoader := specter.NewFileSystemSourceLoader(fs)
+
+	l := specterutils.NewHCLUnitLoader(func() specterutils.HCLFileConfig {
+		return &hclImportsFileConfigMock{}
+	})
+	l.SourceLoaders = []specter.SourceLoader{sourceLoader}
+
+	sources, err := sourceLoader.Load("/specs/service.hcl")
+	require.NoError(t, err)
+	units, err := l.Load(sources[0])
+	require.NoError(t, err)
+	require.Len(t, units, 2)
+	assert.Equal(t, specter.UnitID("billing"), units[0].ID())
+	assert.Equal(t, specter.UnitID("shipping"), units[1].ID())
+
+	sources, err = sourceLoader.Load("/specs/namespaced.hcl")
+	require.NoError(t, err)
+	_, err = l.Load(sources[0])
+	testutils.RequireErrorWithCode(specterutils.InvalidHCLErrorCode)(t, err)
+	assert.ErrorContains(t, err, "Unsupported import namespace")
+}
+
+func TestHCLImportDeduplicationProcessor_Preprocess(t *testing.T) {
+	shared := specter.Source{Location: "/specs/shared.hcl"}
+	other := specter.Source{Location: "/specs/other.hcl"}
+
+	units := []specter.Unit{
+		testutils.NewUnitStub("money", "type", shared),
+		testutils.NewUnitStub("billing", "service", other),
+		testutils.NewUnitStub("money", "type", shared),
+		testutils.NewUnitStub("money", "type", other),
+	}
+
+	p := specterutils.NewHCLImportDeduplicationProcessor()
+	got, err := p.Preprocess(specter.PipelineContext{Context: context.Background()}, units)
+	require.NoError(t, err)
+	assert.Equal(t, []specter.Unit{units[0], units[1], units[3]}, got)
+}
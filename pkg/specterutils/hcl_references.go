@@ -0,0 +1,255 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const HCLReferenceResolutionFailedErrorCode = "specter.hcl.reference_resolution_failed"
+
+// UnitReference represents a reference made by a unit to another unit, such as "service.base" in the
+// HCL expression "service.base.image".
+type UnitReference struct {
+	Kind  specter.UnitKind
+	ID    specter.UnitID
+	Range hcl.Range
+}
+
+// hclDeferredAttribute represents an attribute of a GenericUnit whose expression references other units and can only
+// be evaluated once all units are loaded.
+type hclDeferredAttribute struct {
+	// path of the attribute as indexes in the attributes of the unit and its nested blocks.
+	path []int
+	expr hcl.Expression
+	ctx  *hcl.EvalContext
+}
+
+// hclUnitReferences returns the references to other units made by an expression. Any traversal whose root is not
+// a variable of the evaluation context, such as "service.base.image", is considered a reference to a unit of
+// kind "service" with the ID "base".
+func hclUnitReferences(ctx *hcl.EvalContext, expr hcl.Expression) []UnitReference {
+	var refs []UnitReference
+	for _, traversal := range expr.Variables() {
+		if _, ok := ctx.Variables[traversal.RootName()]; ok {
+			continue
+		}
+		if len(traversal) < 2 {
+			continue
+		}
+
+		var id string
+		switch step := traversal[1].(type) {
+		case hcl.TraverseAttr:
+			id = step.Name
+		case hcl.TraverseIndex:
+			if step.Key.Type() != cty.String || !step.Key.IsKnown() || step.Key.IsNull() {
+				continue
+			}
+			id = step.Key.AsString()
+		default:
+			continue
+		}
+
+		refs = append(refs, UnitReference{
+			Kind:  specter.UnitKind(traversal.RootName()),
+			ID:    specter.UnitID(id),
+			Range: traversal.SourceRange(),
+		})
+	}
+	return refs
+}
+
+var _ specter.UnitPreprocessor = HCLReferenceResolutionProcessor{}
+
+// HCLReferenceResolutionProcessor is a specter.UnitPreprocessor that evaluates the attributes of GenericUnit loaded
+// by HCLGenericUnitLoader that reference other units, e.g. "image = service.base.image" or
+// "depends_on = [database.main]". The loader should have HCLGenericUnitLoader.DeferUnitReferences enabled.
+//
+// Units are exposed to expressions by kind and ID, as objects of their attributes along with their "id".
+// Since referenced attributes can themselves reference other units, evaluation is performed in multiple passes until
+// all references are resolved. References to units that do not exist or circular references result in an error.
+//
+// It should be registered before any other preprocessor relying on the values of attributes, such as
+// a DependencyResolutionProcessor using the HCLReferenceDependencyProvider.
+type HCLReferenceResolutionProcessor struct{}
+
+func NewHCLReferenceResolutionProcessor() *HCLReferenceResolutionProcessor {
+	return &HCLReferenceResolutionProcessor{}
+}
+
+func (p HCLReferenceResolutionProcessor) Name() string {
+	return "hcl_reference_resolution_processor"
+}
+
+func (p HCLReferenceResolutionProcessor) Preprocess(_ specter.PipelineContext, units []specter.Unit) ([]specter.Unit, error) {
+	type pendingAttribute struct {
+		unit  *GenericUnit
+		attr  hclDeferredAttribute
+		diags hcl.Diagnostics
+	}
+
+	var genericUnits []*GenericUnit
+	var pending []*pendingAttribute
+	for _, u := range units {
+		gu, ok := u.(*GenericUnit)
+		if !ok {
+			continue
+		}
+		genericUnits = append(genericUnits, gu)
+		for _, d := range gu.deferred {
+			pending = append(pending, &pendingAttribute{unit: gu, attr: d})
+		}
+	}
+
+	if len(pending) == 0 {
+		return units, nil
+	}
+
+	unitsCtx := &hcl.EvalContext{Variables: hclUnitsVariables(genericUnits)}
+
+	for len(pending) != 0 {
+		// Units are exposed with the values resolved by the previous pass.
+		var next []*pendingAttribute
+		for _, p := range pending {
+			// Values of the file in which the attribute was defined take precedence over units.
+			ctx := unitsCtx.NewChild()
+			ctx.Variables = p.attr.ctx.Variables
+			ctx.Functions = p.attr.ctx.Functions
+
+			v, diags := p.attr.expr.Value(ctx)
+			if diags.HasErrors() || !v.IsWhollyKnown() {
+				p.diags = diags
+				next = append(next, p)
+				continue
+			}
+
+			p.unit.setAttributeValueAt(p.attr.path, v)
+		}
+
+		if len(next) == len(pending) {
+			group := errors.NewGroup(HCLReferenceResolutionFailedErrorCode)
+			for _, p := range next {
+				if !p.diags.HasErrors() {
+					p.diags = p.diags.Append(&hcl.Diagnostic{
+						Severity: hcl.DiagError,
+						Summary:  "Unresolvable reference",
+						Detail:   "The value of this expression depends on a circular reference between units.",
+						Subject:  p.attr.expr.Range().Ptr(),
+					})
+				}
+				for _, d := range p.diags {
+					if d.Severity != hcl.DiagError {
+						continue
+					}
+					group = group.Append(errors.Wrap(newHCLDiagnostic(d, p.unit.source.Data), HCLReferenceResolutionFailedErrorCode))
+				}
+			}
+			return nil, group
+		}
+		pending = next
+		unitsCtx.Variables = hclUnitsVariables(genericUnits)
+	}
+
+	for _, gu := range genericUnits {
+		gu.deferred = nil
+	}
+
+	return units, nil
+}
+
+// hclUnitsVariables returns the variables exposing units to expressions, by kind then by ID.
+func hclUnitsVariables(units []*GenericUnit) map[string]cty.Value {
+	byKind := map[string]map[string]cty.Value{}
+	for _, u := range units {
+		kind := string(u.Kind())
+		if byKind[kind] == nil {
+			byKind[kind] = map[string]cty.Value{}
+		}
+		values := hclAttributesObject(u.Attributes)
+		if _, ok := values["id"]; !ok {
+			values["id"] = cty.StringVal(string(u.ID()))
+		}
+		byKind[kind][string(u.ID())] = hclObjectVal(values)
+	}
+
+	vars := map[string]cty.Value{}
+	for kind, ids := range byKind {
+		vars[kind] = hclObjectVal(ids)
+	}
+	return vars
+}
+
+// hclAttributesObject converts attributes to the attributes of a cty object. Nested blocks are exposed by type, then by
+// label when they have one.
+func hclAttributesObject(attrs []GenericUnitAttribute) map[string]cty.Value {
+	values := map[string]cty.Value{}
+	labeled := map[string]map[string]cty.Value{}
+	for _, a := range attrs {
+		switch v := a.Value.(type) {
+		case GenericValue:
+			values[a.Name] = v.Value
+		case ObjectValue:
+			obj := hclObjectVal(hclAttributesObject(v.Attributes))
+			if a.Name == "" {
+				values[string(v.Type)] = obj
+				continue
+			}
+			if labeled[string(v.Type)] == nil {
+				labeled[string(v.Type)] = map[string]cty.Value{}
+			}
+			labeled[string(v.Type)][a.Name] = obj
+		}
+	}
+	for typ, blocks := range labeled {
+		values[typ] = hclObjectVal(blocks)
+	}
+	return values
+}
+
+var _ DependencyProvider = HCLReferenceDependencyProvider{}
+
+// HCLReferenceDependencyProvider is a DependencyProvider that considers units referenced by the attributes of
+// a GenericUnit as its dependencies.
+type HCLReferenceDependencyProvider struct{}
+
+func (p HCLReferenceDependencyProvider) Supports(u specter.Unit) bool {
+	gu, ok := u.(*GenericUnit)
+	return ok && len(gu.references) != 0
+}
+
+func (p HCLReferenceDependencyProvider) Provide(u specter.Unit) []specter.UnitID {
+	gu, ok := u.(*GenericUnit)
+	if !ok {
+		return nil
+	}
+
+	var deps []specter.UnitID
+	seen := map[specter.UnitID]struct{}{}
+	for _, ref := range gu.references {
+		if ref.ID == gu.ID() {
+			continue
+		}
+		if _, ok := seen[ref.ID]; ok {
+			continue
+		}
+		seen[ref.ID] = struct{}{}
+		deps = append(deps, ref.ID)
+	}
+	return deps
+}
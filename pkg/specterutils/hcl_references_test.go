@@ -0,0 +1,244 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"context"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"sort"
+	"testing"
+)
+
+var _ specter.UnitPreprocessor = (*specterutils.HCLReferenceResolutionProcessor)(nil)
+var _ specterutils.DependencyProvider = specterutils.HCLReferenceDependencyProvider{}
+
+func loadHCLUnits(t *testing.T, files map[string]string) []specter.Unit {
+	l := specterutils.NewHCLGenericUnitLoader()
+	l.DeferUnitReferences = true
+	var units []specter.Unit
+	for _, location := range []string{"/specs/a.hcl", "/specs/b.hcl"} {
+		data, ok := files[location]
+		if !ok {
+			continue
+		}
+		loaded, err := l.Load(specter.Source{Location: location, Data: []byte(data), Format: specterutils.HCLSourceFormat})
+		require.NoError(t, err)
+		units = append(units, loaded...)
+	}
+	return units
+}
+
+func TestHCLReferenceResolutionProcessor_Preprocess(t *testing.T) {
+	tests := []struct {
+		name          string
+		given         map[string]string
+		then          map[specter.UnitID]map[string]cty.Value
+		thenError     require.ErrorAssertionFunc
+		thenErrorText string
+	}{
+		{
+			name: "GIVEN references across files THEN resolve them",
+			given: map[string]string{
+				"/specs/a.hcl": `
+service "billing" {
+	image = service.base.image
+	url = "https://${service.base.host}/billing"
+	depends_on = [database.main.id]
+}
+`,
+				"/specs/b.hcl": `
+locals {
+	registry = "registry.example.com"
+}
+
+service "base" {
+	image = "${local.registry}/base:1.0.0"
+	host = database.main.host
+}
+
+database "main" {
+	host = "db.example.com"
+}
+`,
+			},
+			then: map[specter.UnitID]map[string]cty.Value{
+				"billing": {
+					"image":      cty.StringVal("registry.example.com/base:1.0.0"),
+					"url":        cty.StringVal("https://db.example.com/billing"),
+					"depends_on": cty.TupleVal([]cty.Value{cty.StringVal("main")}),
+				},
+				"base": {
+					"host": cty.StringVal("db.example.com"),
+				},
+			},
+			thenError: require.NoError,
+		},
+		{
+			name: "GIVEN a reference to an attribute of a nested block THEN resolve it",
+			given: map[string]string{
+				"/specs/a.hcl": `
+service "billing" {
+	port = service.base.endpoint.http.port
+}
+
+service "base" {
+	endpoint "http" {
+		port = 80
+	}
+}
+`,
+			},
+			then: map[specter.UnitID]map[string]cty.Value{
+				"billing": {"port": cty.NumberIntVal(80)},
+			},
+			thenError: require.NoError,
+		},
+		{
+			name: "GIVEN a reference to a unit that does not exist THEN return an error",
+			given: map[string]string{
+				"/specs/a.hcl": `
+service "billing" {
+	image = service.missing.image
+}
+`,
+			},
+			thenError: testutils.RequireErrorWithCode(specterutils.HCLReferenceResolutionFailedErrorCode),
+		},
+		{
+			name: "GIVEN circular references THEN return an error",
+			given: map[string]string{
+				"/specs/a.hcl": `
+service "a" {
+	image = service.b.image
+}
+
+service "b" {
+	image = service.a.image
+}
+`,
+			},
+			thenError:     testutils.RequireErrorWithCode(specterutils.HCLReferenceResolutionFailedErrorCode),
+			thenErrorText: "Unresolvable reference",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			units := loadHCLUnits(t, tt.given)
+
+			p := specterutils.NewHCLReferenceResolutionProcessor()
+			got, err := p.Preprocess(specter.PipelineContext{Context: context.Background()}, units)
+			tt.thenError(t, err)
+			if tt.thenErrorText != "" {
+				assert.ErrorContains(t, err, tt.thenErrorText)
+			}
+			if tt.then == nil {
+				return
+			}
+
+			byID := map[specter.UnitID]*specterutils.GenericUnit{}
+			for _, u := range got {
+				byID[u.ID()] = u.(*specterutils.GenericUnit)
+			}
+			for id, attrs := range tt.then {
+				for name, expected := range attrs {
+					actual := byID[id].Attribute(name).Value.(specterutils.GenericValue).Value
+					assert.True(t, expected.Equals(actual).True(), "%s.%s: expected %#v, got %#v", id, name, expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestHCLReferenceDependencyProvider(t *testing.T) {
+	units := loadHCLUnits(t, map[string]string{
+		"/specs/a.hcl": `
+service "billing" {
+	image = service.base.image
+	database = database.main.host
+	url = service.base.url
+}
+`,
+		"/specs/b.hcl": `
+service "base" {
+	image = "base:1.0.0"
+	url = "https://base"
+}
+
+database "main" {
+	host = "db.example.com"
+}
+`,
+	})
+
+	provider := specterutils.HCLReferenceDependencyProvider{}
+	assert.True(t, provider.Supports(units[0]))
+	assert.False(t, provider.Supports(units[1]))
+	assert.Equal(t, []specter.UnitID{"base", "main"}, sortedUnitIDs(provider.Provide(units[0])))
+
+	refs := units[0].(*specterutils.GenericUnit).References()
+	require.NotEmpty(t, refs)
+	assert.Equal(t, "/specs/a.hcl", refs[0].Range.Filename)
+
+	ctx := specter.PipelineContext{Context: context.Background()}
+	resolved, err := specterutils.NewHCLReferenceResolutionProcessor().Preprocess(ctx, units)
+	require.NoError(t, err)
+	resolved, err = specterutils.NewDependencyResolutionProcessor(provider).Preprocess(ctx, resolved)
+	require.NoError(t, err)
+
+	var ids []specter.UnitID
+	for _, u := range resolved {
+		ids = append(ids, u.ID())
+	}
+	assert.Equal(t, specter.UnitID("billing"), ids[len(ids)-1], "billing should come after its dependencies")
+}
+
+func TestHCLGenericUnitLoader_Load_unitReferences(t *testing.T) {
+	src := specter.Source{
+		Location: "/specs/a.hcl",
+		Data:     []byte("service \"billing\" {\n\timage = servce.base.image\n}\n"),
+		Format:   specterutils.HCLSourceFormat,
+	}
+
+	t.Run("GIVEN references are not deferred WHEN loading a reference THEN should report an unknown variable", func(t *testing.T) {
+		_, err := specterutils.NewHCLGenericUnitLoader().Load(src)
+		testutils.RequireErrorWithCode(specterutils.InvalidHCLErrorCode)(t, err)
+		assert.ErrorContains(t, err, "Unknown variable")
+	})
+
+	t.Run("GIVEN references are deferred WHEN loading a reference THEN should leave it to the resolution", func(t *testing.T) {
+		l := specterutils.NewHCLGenericUnitLoader()
+		l.DeferUnitReferences = true
+		units, err := l.Load(src)
+		require.NoError(t, err)
+
+		_, err = specterutils.NewHCLReferenceResolutionProcessor().Preprocess(
+			specter.PipelineContext{Context: context.Background()},
+			units,
+		)
+		testutils.RequireErrorWithCode(specterutils.HCLReferenceResolutionFailedErrorCode)(t, err)
+		assert.ErrorContains(t, err, "Unknown variable")
+	})
+}
+
+func sortedUnitIDs(ids []specter.UnitID) []specter.UnitID {
+	sorted := append([]specter.UnitID(nil), ids...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
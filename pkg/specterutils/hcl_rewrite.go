@@ -0,0 +1,199 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+)
+
+var _ UnitSourceRewriter = HCLUnitSourceRewriter{}
+
+// HCLUnitSourceRewriter is a UnitSourceRewriter for HCL sources relying on hclwrite, preserving the comments and the
+// layout of the sources.
+//
+// Units are located in a source by their range, and otherwise by their kind and ID. Since the ID of the units of
+// namespaced imports differ from their label, renaming them sets the label to the new ID as is.
+type HCLUnitSourceRewriter struct{}
+
+func NewHCLUnitSourceRewriter() HCLUnitSourceRewriter {
+	return HCLUnitSourceRewriter{}
+}
+
+func (r HCLUnitSourceRewriter) SupportsSource(s specter.Source) bool {
+	return s.Format == HCLSourceFormat
+}
+
+func (r HCLUnitSourceRewriter) Rewrite(s specter.Source, changes []UnitMutations) ([]byte, error) {
+	syntaxFile, diags := hclsyntax.ParseConfig(s.Data, s.Location, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, newHCLDiagnosticsError(InvalidHCLErrorCode, diags, s.Data)
+	}
+	file, diags := hclwrite.ParseConfig(s.Data, s.Location, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, newHCLDiagnosticsError(InvalidHCLErrorCode, diags, s.Data)
+	}
+
+	syntaxBlocks := syntaxFile.Body.(*hclsyntax.Body).Blocks
+	blocks := file.Body().Blocks()
+
+	// Record the spacing of the tokens of the source to only format the tokens created by the mutations.
+	spacing := map[*hclwrite.Token]int{}
+	for _, t := range file.BuildTokens(nil) {
+		spacing[t] = t.SpacesBefore
+	}
+
+	errs := errors.NewGroup(UnitMutationFailedErrorCode)
+	for _, c := range changes {
+		block := hclUnitBlock(c.Unit, syntaxBlocks, blocks)
+		if block == nil {
+			errs = errs.Append(errors.NewWithMessage(
+				UnitMutationFailedErrorCode,
+				fmt.Sprintf("unit %q of kind %q not found in source %q", c.Unit.ID(), c.Unit.Kind(), s.Location),
+			))
+			continue
+		}
+
+		for _, m := range c.Mutations {
+			if err := applyHCLUnitMutation(block, m); err != nil {
+				errs = errs.Append(newUnitMutationError(c.Unit, err))
+			}
+		}
+	}
+	if err := errors.GroupOrNil(errs); err != nil {
+		return nil, err
+	}
+
+	return formatHCLCreatedTokens(file, spacing), nil
+}
+
+// formatHCLCreatedTokens returns the content of a file in which only the tokens created since the spacing of its
+// tokens was recorded are formatted, leaving the layout of the other tokens as is.
+func formatHCLCreatedTokens(file *hclwrite.File, spacing map[*hclwrite.Token]int) []byte {
+	// hclwrite formats all the tokens of a file in place when writing it.
+	_ = file.Bytes()
+
+	tokens := file.BuildTokens(nil)
+	for _, t := range tokens {
+		if spaces, ok := spacing[t]; ok {
+			t.SpacesBefore = spaces
+		}
+	}
+
+	var buf bytes.Buffer
+	_, _ = tokens.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// hclUnitBlock returns the block of a unit. The hclwrite blocks are located through their hclsyntax counterpart since
+// they do not expose their range.
+func hclUnitBlock(u *GenericUnit, syntaxBlocks hclsyntax.Blocks, blocks []*hclwrite.Block) *hclwrite.Block {
+	if len(syntaxBlocks) != len(blocks) {
+		return nil
+	}
+
+	if !u.Range.IsZero() {
+		for i, b := range syntaxBlocks {
+			if b.Range().Start.Byte == u.Range.Start.Byte {
+				return blocks[i]
+			}
+		}
+		return nil
+	}
+
+	for _, b := range blocks {
+		if b.Type() == string(u.Kind()) && len(b.Labels()) != 0 && b.Labels()[0] == string(u.ID()) {
+			return b
+		}
+	}
+	return nil
+}
+
+func applyHCLUnitMutation(block *hclwrite.Block, m UnitMutation) error {
+	switch m := m.(type) {
+	case SetAttributeMutation:
+		if len(m.Path) == 0 {
+			return errors.New("attribute path should not be empty")
+		}
+		body, err := hclNestedBody(block.Body(), m.Path[:len(m.Path)-1])
+		if err != nil {
+			return err
+		}
+		body.SetAttributeValue(m.Path[len(m.Path)-1], m.Value)
+
+	case RemoveAttributeMutation:
+		if len(m.Path) == 0 {
+			return errors.New("attribute path should not be empty")
+		}
+		body, err := hclNestedBody(block.Body(), m.Path[:len(m.Path)-1])
+		if err != nil {
+			return err
+		}
+		if body.RemoveAttribute(m.Path[len(m.Path)-1]) == nil {
+			return errors.New(fmt.Sprintf("attribute %q not found", m.Path[len(m.Path)-1]))
+		}
+
+	case AddBlockMutation:
+		body, err := hclNestedBody(block.Body(), m.Path)
+		if err != nil {
+			return err
+		}
+		nested := body.AppendNewBlock(m.Type, m.Labels)
+		for _, name := range m.attributeNames() {
+			nested.Body().SetAttributeValue(name, m.Attributes[name])
+		}
+
+	case RenameUnitMutation:
+		labels := block.Labels()
+		if len(labels) == 0 {
+			labels = []string{""}
+		}
+		labels[0] = string(m.ID)
+		block.SetLabels(labels)
+
+	case ChangeUnitKindMutation:
+		block.SetType(string(m.Kind))
+
+	default:
+		return errors.New(fmt.Sprintf("unsupported mutation %T", m))
+	}
+
+	return nil
+}
+
+// hclNestedBody returns the body of the nested block designated by a path.
+func hclNestedBody(body *hclwrite.Body, path []string) (*hclwrite.Body, error) {
+	for _, segment := range path {
+		typ, label := parseUnitAttributePathSegment(segment)
+
+		var found *hclwrite.Block
+		for _, b := range body.Blocks() {
+			if b.Type() == typ && (label == "" || (len(b.Labels()) != 0 && b.Labels()[0] == label)) {
+				found = b
+				break
+			}
+		}
+		if found == nil {
+			return nil, errors.New(fmt.Sprintf("block %q not found", segment))
+		}
+		body = found.Body()
+	}
+	return body, nil
+}
@@ -193,6 +193,7 @@ specType "specName" {
 			}
 
 			require.Len(t, actualUnits, len(tt.then.expectedUnits))
+			actualUnits = withoutSourceRanges(actualUnits)
 			for i := range tt.then.expectedUnits {
 				assert.Equal(t, tt.then.expectedUnits[i], actualUnits[i])
 			}
@@ -0,0 +1,316 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+const (
+	JSONSourceFormat = specter.JSONSourceFormat
+)
+
+const InvalidJSONErrorCode = "specter.spec_loading.invalid_json"
+
+const (
+	// JSONUnitKindKey is the key of a JSON object holding the kind of the unit it defines.
+	JSONUnitKindKey = "kind"
+
+	// JSONUnitIDKey is the key of a JSON object holding the ID of the unit it defines.
+	JSONUnitIDKey = "id"
+)
+
+// NewJSONGenericUnitLoader this UnitLoader will load all Units to instances of GenericUnit.
+func NewJSONGenericUnitLoader() *JSONGenericUnitLoader {
+	return &JSONGenericUnitLoader{}
+}
+
+// JSONGenericUnitLoader this UnitLoader loads Units as GenericUnit from JSON sources.
+//
+// A source is either a single object defining a unit or an array of such objects. Units are defined using the keys
+// "kind" and "id", all other keys become attributes of the unit with their value converted to a cty.Value:
+//
+//	[
+//	  {"kind": "service", "id": "billing", "replicas": 3},
+//	  {"kind": "service", "id": "shipping", "replicas": 1}
+//	]
+type JSONGenericUnitLoader struct{}
+
+func (l JSONGenericUnitLoader) SupportsSource(s specter.Source) bool {
+	return s.Format == JSONSourceFormat
+}
+
+func (l JSONGenericUnitLoader) Load(s specter.Source) ([]specter.Unit, error) {
+	// Although the caller is responsible for calling JSONGenericUnitLoader.SupportsSource, guard against it.
+	if !l.SupportsSource(s) {
+		return nil, errors.NewWithMessage(
+			specter.UnsupportedSourceErrorCode,
+			fmt.Sprintf(
+				"invalid unit source %q, unsupported format %q",
+				s.Location,
+				s.Format,
+			),
+		)
+	}
+
+	data := bytes.TrimSpace(s.Data)
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	if !json.Valid(s.Data) {
+		// Decode to obtain a descriptive error with the offset of the syntax error.
+		var v any
+		return nil, newInvalidJSONError(s, json.Unmarshal(s.Data, &v))
+	}
+
+	// Keep track of the offset of every object to report errors at their position in the source.
+	var objects []json.RawMessage
+	var offsets []int64
+	if data[0] == '[' {
+		decoder := json.NewDecoder(bytes.NewReader(s.Data))
+		if _, err := decoder.Token(); err != nil {
+			return nil, newInvalidJSONError(s, err)
+		}
+		for decoder.More() {
+			offset := skipJSONSeparators(s.Data, decoder.InputOffset())
+			var raw json.RawMessage
+			if err := decoder.Decode(&raw); err != nil {
+				return nil, newInvalidJSONError(s, err)
+			}
+			objects = append(objects, raw)
+			offsets = append(offsets, offset)
+		}
+	} else {
+		objects = append(objects, json.RawMessage(data))
+		offsets = append(offsets, skipJSONSeparators(s.Data, 0))
+	}
+
+	var units []specter.Unit
+	group := errors.NewGroup(InvalidJSONErrorCode)
+	for i, raw := range objects {
+		unit, err := l.unitFromObject(s, raw, offsets[i])
+		if err != nil {
+			group = group.Append(err)
+			continue
+		}
+		units = append(units, unit)
+	}
+
+	return units, errors.GroupOrNil(group)
+}
+
+// unitFromObject converts a JSON object to a GenericUnit while preserving the order of its keys.
+func (l JSONGenericUnitLoader) unitFromObject(s specter.Source, raw json.RawMessage, offset int64) (*GenericUnit, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	tok, err := decoder.Token()
+	if err != nil {
+		return nil, newInvalidJSONError(s, err)
+	}
+	if tok != json.Delim('{') {
+		return nil, newInvalidJSONErrorAt(s, offset, "unit definitions should be objects")
+	}
+
+	unit := &GenericUnit{source: s, Range: newJSONSourceRange(s, offset, offset+int64(len(raw)))}
+	var kind, id string
+	for decoder.More() {
+		keyOffset := offset + skipJSONSeparators(raw, decoder.InputOffset())
+		tok, err := decoder.Token()
+		if err != nil {
+			return nil, newInvalidJSONError(s, err)
+		}
+		key := tok.(string)
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, newInvalidJSONError(s, err)
+		}
+
+		switch key {
+		case JSONUnitKindKey:
+			if err := json.Unmarshal(value, &kind); err != nil {
+				return nil, newInvalidJSONErrorAt(s, offset, fmt.Sprintf("%q should be a string", JSONUnitKindKey))
+			}
+		case JSONUnitIDKey:
+			if err := json.Unmarshal(value, &id); err != nil {
+				return nil, newInvalidJSONErrorAt(s, offset, fmt.Sprintf("%q should be a string", JSONUnitIDKey))
+			}
+		default:
+			v, err := jsonToCtyValue(value)
+			if err != nil {
+				return nil, newInvalidJSONErrorAt(s, offset, err.Error())
+			}
+			unit.Attributes = append(unit.Attributes, GenericUnitAttribute{
+				Name:  key,
+				Value: GenericValue{v},
+				Range: newJSONSourceRange(s, keyOffset, offset+decoder.InputOffset()),
+			})
+		}
+	}
+
+	if kind == "" {
+		return nil, newInvalidJSONErrorAt(s, offset, fmt.Sprintf("object should contain a %q key", JSONUnitKindKey))
+	}
+	if id == "" {
+		return nil, newInvalidJSONErrorAt(
+			s,
+			offset,
+			fmt.Sprintf("object of kind %q should contain an %q key", kind, JSONUnitIDKey),
+		)
+	}
+
+	unit.typ = specter.UnitKind(kind)
+	unit.UnitID = specter.UnitID(id)
+
+	return unit, nil
+}
+
+// jsonToCtyValue converts a JSON value to its cty.Value equivalent.
+// Objects are converted to cty objects and arrays to tuples, like their HCL counterparts.
+func jsonToCtyValue(data []byte) (cty.Value, error) {
+	if string(bytes.TrimSpace(data)) == "null" {
+		return cty.NullVal(cty.DynamicPseudoType), nil
+	}
+
+	t, err := ctyjson.ImpliedType(data)
+	if err != nil {
+		return cty.NilVal, err
+	}
+
+	return ctyjson.Unmarshal(data, t)
+}
+
+type JSONUnitLoaderFileConfigurationProvider func() JSONFileConfig
+
+// JSONFileConfig interface that is to be implemented to define the structure of JSON unit files.
+type JSONFileConfig interface {
+	Units(specter.Source) []specter.Unit
+}
+
+// JSONUnitLoader this loader allows to load Units to typed structs by providing a JSONFileConfig.
+//
+// Sources are decoded using encoding/json and its struct tags. Like HCLUnitLoader, decoding is strict: keys that
+// do not correspond to a field of the JSONFileConfig result in an error.
+type JSONUnitLoader struct {
+	fileConfigProvider JSONUnitLoaderFileConfigurationProvider
+}
+
+func NewJSONUnitLoader(fileConfigProvider JSONUnitLoaderFileConfigurationProvider) *JSONUnitLoader {
+	return &JSONUnitLoader{
+		fileConfigProvider: fileConfigProvider,
+	}
+}
+
+func (l JSONUnitLoader) Load(s specter.Source) ([]specter.Unit, error) {
+	// Although the caller is responsible for calling JSONUnitLoader.SupportsSource, guard against it.
+	if !l.SupportsSource(s) {
+		return nil, errors.NewWithMessage(
+			specter.UnsupportedSourceErrorCode,
+			fmt.Sprintf(
+				"invalid unit source %q, unsupported format %q",
+				s.Location,
+				s.Format,
+			),
+		)
+	}
+
+	if len(bytes.TrimSpace(s.Data)) == 0 {
+		return nil, nil
+	}
+
+	fileConf := l.fileConfigProvider()
+
+	decoder := json.NewDecoder(bytes.NewReader(s.Data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(fileConf); err != nil {
+		return nil, newInvalidJSONError(s, err)
+	}
+
+	return fileConf.Units(s), nil
+}
+
+func (l JSONUnitLoader) SupportsSource(s specter.Source) bool {
+	return s.Format == JSONSourceFormat
+}
+
+// newInvalidJSONError wraps an error returned by encoding/json, including its position in the source when available.
+func newInvalidJSONError(s specter.Source, err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		// The offset of a syntax error is the one after the offending byte.
+		return newInvalidJSONErrorAt(s, syntaxErr.Offset-1, syntaxErr.Error())
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return newInvalidJSONErrorAt(s, typeErr.Offset, typeErr.Error())
+	}
+
+	return errors.WrapWithMessage(err, InvalidJSONErrorCode, fmt.Sprintf("invalid unit source %q", s.Location))
+}
+
+func newInvalidJSONErrorAt(s specter.Source, offset int64, msg string) error {
+	line, column := offsetToLineColumn(s.Data, offset)
+	return errors.NewWithMessage(
+		InvalidJSONErrorCode,
+		fmt.Sprintf(
+			"invalid unit source %q at line %d:%d, %s",
+			s.Location,
+			line,
+			column,
+			msg,
+		),
+	)
+}
+
+// newJSONSourceRange returns the range of a source between two byte offsets.
+func newJSONSourceRange(s specter.Source, start int64, end int64) specter.SourceRange {
+	startLine, startColumn := offsetToLineColumn(s.Data, start)
+	endLine, endColumn := offsetToLineColumn(s.Data, end)
+	return specter.SourceRange{
+		Filename: s.Location,
+		Start:    specter.SourcePos{Line: startLine, Column: startColumn, Byte: int(start)},
+		End:      specter.SourcePos{Line: endLine, Column: endColumn, Byte: int(end)},
+	}
+}
+
+// skipJSONSeparators returns the offset of the first byte at or after an offset that is not whitespace or a comma.
+func skipJSONSeparators(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n,"), data[offset]) != -1 {
+		offset++
+	}
+	return offset
+}
+
+// offsetToLineColumn converts a byte offset in some data to a 1-based line and column.
+func offsetToLineColumn(data []byte, offset int64) (line int, column int) {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = int(offset) - (bytes.LastIndexByte(before, '\n') + 1) + 1
+	return line, column
+}
@@ -0,0 +1,215 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"testing"
+)
+
+var _ specter.UnitLoader = (*specterutils.JSONGenericUnitLoader)(nil)
+var _ specter.UnitLoader = (*specterutils.JSONUnitLoader)(nil)
+
+func newJSONSource(data string) specter.Source {
+	return specter.Source{
+		Location: "specs.json",
+		Data:     []byte(data),
+		Format:   specterutils.JSONSourceFormat,
+	}
+}
+
+func TestJSONGenericUnitLoader_Load(t *testing.T) {
+	tests := []struct {
+		name          string
+		given         specter.Source
+		then          func(s specter.Source) []specter.Unit
+		thenError     require.ErrorAssertionFunc
+		thenErrorText string
+	}{
+		{
+			name:      "GIVEN an empty source THEN return nil",
+			given:     newJSONSource(``),
+			then:      func(specter.Source) []specter.Unit { return nil },
+			thenError: require.NoError,
+		},
+		{
+			name:      "GIVEN an unsupported format THEN return an error",
+			given:     specter.Source{Format: "txt"},
+			thenError: testutils.RequireErrorWithCode(specter.UnsupportedSourceErrorCode),
+		},
+		{
+			name: "GIVEN a single object THEN return its unit",
+			given: newJSONSource(`{
+  "kind": "service",
+  "id": "billing",
+  "description": "Handles invoices.",
+  "replicas": 3,
+  "enabled": true,
+  "owner": null,
+  "ports": [80, 443],
+  "env": {"LOG_LEVEL": "debug"}
+}`),
+			then: func(s specter.Source) []specter.Unit {
+				u := specterutils.NewGenericUnit("billing", "service", s)
+				u.Attributes = []specterutils.GenericUnitAttribute{
+					{Name: "description", Value: specterutils.GenericValue{Value: cty.StringVal("Handles invoices.")}},
+					{Name: "replicas", Value: specterutils.GenericValue{Value: cty.MustParseNumberVal("3")}},
+					{Name: "enabled", Value: specterutils.GenericValue{Value: cty.True}},
+					{Name: "owner", Value: specterutils.GenericValue{Value: cty.NullVal(cty.DynamicPseudoType)}},
+					{Name: "ports", Value: specterutils.GenericValue{Value: cty.TupleVal([]cty.Value{
+						cty.MustParseNumberVal("80"),
+						cty.MustParseNumberVal("443"),
+					})}},
+					{Name: "env", Value: specterutils.GenericValue{Value: cty.ObjectVal(map[string]cty.Value{
+						"LOG_LEVEL": cty.StringVal("debug"),
+					})}},
+				}
+				return []specter.Unit{u}
+			},
+			thenError: require.NoError,
+		},
+		{
+			name: "GIVEN an array of objects THEN return a unit per object",
+			given: newJSONSource(`[
+  {"kind": "service", "id": "billing"},
+  {"kind": "service", "id": "shipping"}
+]`),
+			then: func(s specter.Source) []specter.Unit {
+				return []specter.Unit{
+					specterutils.NewGenericUnit("billing", "service", s),
+					specterutils.NewGenericUnit("shipping", "service", s),
+				}
+			},
+			thenError: require.NoError,
+		},
+		{
+			name:          "GIVEN an unparsable source THEN return an error with its position",
+			given:         newJSONSource("{\n  \"kind\": \"service\",\n  \"id\": \n}"),
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidJSONErrorCode),
+			thenErrorText: `invalid unit source "specs.json" at line 4:1`,
+		},
+		{
+			name:          "GIVEN an object without kind THEN return an error with its position",
+			given:         newJSONSource("[\n  {\"kind\": \"service\", \"id\": \"billing\"},\n  {\"id\": \"shipping\"}\n]"),
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidJSONErrorCode),
+			thenErrorText: `at line 3:3, object should contain a "kind" key`,
+		},
+		{
+			name:          "GIVEN an object without id THEN return an error",
+			given:         newJSONSource(`{"kind": "service"}`),
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidJSONErrorCode),
+			thenErrorText: `object of kind "service" should contain an "id" key`,
+		},
+		{
+			name:          "GIVEN an array of non objects THEN return an error",
+			given:         newJSONSource(`["service"]`),
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidJSONErrorCode),
+			thenErrorText: "unit definitions should be objects",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := specterutils.NewJSONGenericUnitLoader()
+			units, err := l.Load(tt.given)
+			tt.thenError(t, err)
+			if tt.thenErrorText != "" {
+				assert.ErrorContains(t, err, tt.thenErrorText)
+			}
+			if tt.then != nil {
+				assert.Equal(t, tt.then(tt.given), withoutSourceRanges(units))
+			}
+		})
+	}
+}
+
+type jsonFileConfigMock struct {
+	Services []struct {
+		ID       string `json:"id"`
+		Replicas int    `json:"replicas"`
+	} `json:"services"`
+}
+
+func (c *jsonFileConfigMock) Units(s specter.Source) []specter.Unit {
+	var units []specter.Unit
+	for _, svc := range c.Services {
+		units = append(units, testutils.NewUnitStub(specter.UnitID(svc.ID), "service", s))
+	}
+	return units
+}
+
+func TestJSONUnitLoader_Load(t *testing.T) {
+	tests := []struct {
+		name          string
+		given         specter.Source
+		then          func(s specter.Source) []specter.Unit
+		thenError     require.ErrorAssertionFunc
+		thenErrorText string
+	}{
+		{
+			name:      "GIVEN an empty source THEN return nil",
+			given:     newJSONSource(` `),
+			then:      func(specter.Source) []specter.Unit { return nil },
+			thenError: require.NoError,
+		},
+		{
+			name:      "GIVEN an unsupported format THEN return an error",
+			given:     specter.Source{Format: "txt"},
+			thenError: testutils.RequireErrorWithCode(specter.UnsupportedSourceErrorCode),
+		},
+		{
+			name:  "GIVEN a valid source THEN return the units of the file config",
+			given: newJSONSource(`{"services": [{"id": "billing", "replicas": 2}, {"id": "shipping"}]}`),
+			then: func(s specter.Source) []specter.Unit {
+				return []specter.Unit{
+					testutils.NewUnitStub("billing", "service", s),
+					testutils.NewUnitStub("shipping", "service", s),
+				}
+			},
+			thenError: require.NoError,
+		},
+		{
+			name:          "GIVEN an invalid type THEN return an error with its position",
+			given:         newJSONSource("{\"services\": [\n  {\"id\": \"billing\", \"replicas\": \"two\"}\n]}"),
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidJSONErrorCode),
+			thenErrorText: `invalid unit source "specs.json" at line 2:`,
+		},
+		{
+			name:          "GIVEN an unknown key THEN return an error",
+			given:         newJSONSource(`{"servicez": []}`),
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidJSONErrorCode),
+			thenErrorText: `unknown field "servicez"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := specterutils.NewJSONUnitLoader(func() specterutils.JSONFileConfig {
+				return &jsonFileConfigMock{}
+			})
+			units, err := l.Load(tt.given)
+			tt.thenError(t, err)
+			if tt.thenErrorText != "" {
+				assert.ErrorContains(t, err, tt.thenErrorText)
+			}
+			if tt.then != nil {
+				assert.Equal(t, tt.then(tt.given), units)
+			}
+		})
+	}
+}
@@ -0,0 +1,468 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"encoding/json"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JSONSchemaDraft is the JSON Schema dialect of the documents generated by specter.
+const JSONSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+const JSONSchemaGenerationFailedErrorCode = "specter.json_schema.generation_failed"
+
+// JSONSchema represents a JSON Schema document or subschema.
+type JSONSchema struct {
+	Schema      string `json:"$schema,omitempty"`
+	ID          string `json:"$id,omitempty"`
+	Ref         string `json:"$ref,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// Type is the JSON type of the values, e.g. "string" or "object". Empty means any type.
+	Type string `json:"type,omitempty"`
+
+	Properties           map[string]*JSONSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties *JSONSchema            `json:"additionalProperties,omitempty"`
+
+	// Closed indicates that no properties other than Properties are allowed, which corresponds to
+	// "additionalProperties": false.
+	Closed bool `json:"-"`
+
+	Items    *JSONSchema `json:"items,omitempty"`
+	MinItems *int        `json:"minItems,omitempty"`
+	MaxItems *int        `json:"maxItems,omitempty"`
+
+	Enum    []any  `json:"enum,omitempty"`
+	Const   any    `json:"const,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+
+	OneOf []*JSONSchema `json:"oneOf,omitempty"`
+
+	Defs map[string]*JSONSchema `json:"$defs,omitempty"`
+}
+
+func (s *JSONSchema) MarshalJSON() ([]byte, error) {
+	type jsonSchema JSONSchema
+	if !s.Closed || s.AdditionalProperties != nil {
+		return json.Marshal((*jsonSchema)(s))
+	}
+
+	return json.Marshal(struct {
+		*jsonSchema
+		AdditionalProperties bool `json:"additionalProperties"`
+	}{
+		jsonSchema:           (*jsonSchema)(s),
+		AdditionalProperties: false,
+	})
+}
+
+// UnitSchemasJSONSchema returns a JSON Schema document describing the sources of the YAML and JSON generic unit
+// loaders for units of kinds with a UnitSchema. Sources can either contain a single unit or an array of units.
+//
+// Nested blocks are described as properties named after their type containing an object, an array of objects
+// when multiple blocks are allowed, or objects by label when blocks are labeled. Blocks allowed at most once can
+// either be an object or an array of at most one object. This is the shape of nested blocks accepted by the
+// UnitSchemaValidator, so that the units of documents valid against this schema pass validation.
+func UnitSchemasJSONSchema(id string, schemas ...UnitSchema) *JSONSchema {
+	doc := &JSONSchema{
+		Schema: JSONSchemaDraft,
+		ID:     id,
+		Defs:   map[string]*JSONSchema{},
+	}
+
+	unit := &JSONSchema{}
+	for _, s := range schemas {
+		doc.Defs[string(s.Kind)] = UnitSchemaJSONSchema(s)
+		unit.OneOf = append(unit.OneOf, &JSONSchema{Ref: "#/$defs/" + string(s.Kind)})
+	}
+	doc.Defs["unit"] = unit
+
+	doc.OneOf = []*JSONSchema{
+		{Ref: "#/$defs/unit"},
+		{Type: "array", Items: &JSONSchema{Ref: "#/$defs/unit"}},
+	}
+
+	return doc
+}
+
+// UnitSchemaJSONSchema returns the JSON Schema of the units of a kind as defined in YAML or JSON sources.
+func UnitSchemaJSONSchema(s UnitSchema) *JSONSchema {
+	schema := jsonSchemaOfBody(s.Attributes, s.Blocks)
+	schema.Title = string(s.Kind)
+	schema.Description = s.Description
+
+	schema.Properties[YAMLUnitKindKey] = &JSONSchema{Type: "string", Const: string(s.Kind)}
+	schema.Properties[YAMLUnitIDKey] = &JSONSchema{Type: "string", Description: "ID of the unit."}
+	schema.Required = append([]string{YAMLUnitKindKey, YAMLUnitIDKey}, schema.Required...)
+
+	return schema
+}
+
+func jsonSchemaOfBody(attrs []AttributeSchema, blocks []BlockSchema) *JSONSchema {
+	schema := &JSONSchema{
+		Type:       "object",
+		Properties: map[string]*JSONSchema{},
+		Closed:     true,
+	}
+
+	for _, a := range attrs {
+		schema.Properties[a.Name] = jsonSchemaOfAttribute(a)
+		if a.Required {
+			schema.Required = append(schema.Required, a.Name)
+		}
+	}
+
+	for _, b := range blocks {
+		block := jsonSchemaOfBody(b.Attributes, b.Blocks)
+		block.Description = b.Description
+
+		var prop *JSONSchema
+		switch {
+		case b.Labeled:
+			prop = &JSONSchema{Type: "object", AdditionalProperties: block}
+		case b.MaxItems == 1:
+			maxItems := 1
+			prop = &JSONSchema{OneOf: []*JSONSchema{
+				block,
+				{Type: "array", Items: block, MaxItems: &maxItems},
+			}}
+		default:
+			prop = &JSONSchema{Type: "array", Items: block}
+			if b.MaxItems > 1 {
+				maxItems := b.MaxItems
+				prop.MaxItems = &maxItems
+			}
+		}
+		schema.Properties[string(b.Type)] = prop
+
+		if b.Required {
+			schema.Required = append(schema.Required, string(b.Type))
+		}
+	}
+
+	return schema
+}
+
+func jsonSchemaOfAttribute(a AttributeSchema) *JSONSchema {
+	schema := jsonSchemaOfCtyType(a.Type)
+	schema.Description = a.Description
+	if a.Pattern != nil {
+		schema.Pattern = a.Pattern.String()
+	}
+	for _, v := range a.Enum {
+		schema.Enum = append(schema.Enum, ctyValueToJSONValue(v))
+	}
+	return schema
+}
+
+func jsonSchemaOfCtyType(t cty.Type) *JSONSchema {
+	switch {
+	case t == cty.NilType || t == cty.DynamicPseudoType:
+		return &JSONSchema{}
+	case t == cty.String:
+		return &JSONSchema{Type: "string"}
+	case t == cty.Number:
+		return &JSONSchema{Type: "number"}
+	case t == cty.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case t.IsListType() || t.IsSetType():
+		return &JSONSchema{Type: "array", Items: jsonSchemaOfCtyType(t.ElementType())}
+	case t.IsTupleType():
+		return &JSONSchema{Type: "array"}
+	case t.IsMapType():
+		return &JSONSchema{Type: "object", AdditionalProperties: jsonSchemaOfCtyType(t.ElementType())}
+	case t.IsObjectType():
+		schema := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+		for name, at := range t.AttributeTypes() {
+			schema.Properties[name] = jsonSchemaOfCtyType(at)
+			if !t.AttributeOptional(name) {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		sort.Strings(schema.Required)
+		return schema
+	default:
+		return &JSONSchema{}
+	}
+}
+
+// ctyValueToJSONValue converts a cty value to its JSON counterpart, e.g. string, float64 or bool.
+func ctyValueToJSONValue(v cty.Value) any {
+	data, err := ctyjson.Marshal(v, v.Type())
+	if err != nil {
+		return nil
+	}
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil
+	}
+	return value
+}
+
+// HCLFileConfigJSONSchema returns a JSON Schema document describing the JSON syntax of HCL, of the files decoded to an
+// HCLFileConfig by an HCLUnitLoader. The schema is derived from the "hcl" struct tags of the file config, which must be
+// a struct or a pointer to a struct.
+//
+// As per the JSON syntax of HCL, labeled blocks are described as objects by label, e.g.
+// {"service": {"billing": {"image": "billing:1.0.0"}}}. Recursive blocks refer to the schema of their struct, defined
+// once in the "$defs" of the document.
+func HCLFileConfigJSONSchema(id string, config HCLFileConfig) (*JSONSchema, error) {
+	t := reflect.TypeOf(config)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, errors.NewWithMessage(
+			JSONSchemaGenerationFailedErrorCode,
+			"HCL file config should be a struct or a pointer to a struct, got "+t.String(),
+		)
+	}
+
+	g := &hclJSONSchemaGenerator{
+		root:     t,
+		visiting: map[reflect.Type]bool{},
+		refs:     map[reflect.Type]string{},
+		defs:     map[string]*JSONSchema{},
+	}
+	schema := g.structSchema(t)
+	schema.Schema = JSONSchemaDraft
+	schema.ID = id
+	if len(g.defs) != 0 {
+		schema.Defs = g.defs
+	}
+	return schema, nil
+}
+
+// hclJSONSchemaGenerator generates the schemas of structs decoded by gohcl. Structs nested in themselves are
+// referenced rather than expanded, to support recursive blocks.
+type hclJSONSchemaGenerator struct {
+	root reflect.Type
+
+	// visiting contains the structs whose schema is being generated.
+	visiting map[reflect.Type]bool
+
+	// refs contains the names of the definitions of the referenced structs.
+	refs map[reflect.Type]string
+	defs map[string]*JSONSchema
+}
+
+// structSchema returns the schema of the body of a struct decoded by gohcl, or a reference to it when it is nested
+// in itself.
+func (g *hclJSONSchemaGenerator) structSchema(t reflect.Type) *JSONSchema {
+	if g.visiting[t] {
+		if t == g.root {
+			return &JSONSchema{Ref: "#"}
+		}
+		return &JSONSchema{Ref: "#/$defs/" + g.defName(t)}
+	}
+	g.visiting[t] = true
+	defer delete(g.visiting, t)
+
+	schema := &JSONSchema{
+		Type:       "object",
+		Properties: map[string]*JSONSchema{},
+		Closed:     true,
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("hcl")
+		if !ok {
+			continue
+		}
+		name, kind, _ := strings.Cut(tag, ",")
+
+		switch kind {
+		case "", "attr":
+			schema.Properties[name] = jsonSchemaOfGoType(field.Type)
+			schema.Required = append(schema.Required, name)
+		case "optional":
+			schema.Properties[name] = jsonSchemaOfGoType(field.Type)
+		case "block":
+			prop, required := g.blockSchema(field.Type)
+			schema.Properties[name] = prop
+			if required {
+				schema.Required = append(schema.Required, name)
+			}
+		case "remain":
+			schema.Closed = false
+		}
+	}
+
+	if name, ok := g.refs[t]; ok {
+		g.defs[name] = schema
+	}
+	return schema
+}
+
+// defName returns the name of the definition of a struct, unique within the document.
+func (g *hclJSONSchemaGenerator) defName(t reflect.Type) string {
+	if name, ok := g.refs[t]; ok {
+		return name
+	}
+
+	base := t.Name()
+	if base == "" {
+		base = "block"
+	}
+	name := base
+	for i := 2; ; i++ {
+		taken := false
+		for _, n := range g.refs {
+			if n == name {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			break
+		}
+		name = base + strconv.Itoa(i)
+	}
+	g.refs[t] = name
+	return name
+}
+
+// blockSchema returns the schema of a block field of a struct decoded by gohcl, and whether it is required.
+func (g *hclJSONSchemaGenerator) blockSchema(t reflect.Type) (*JSONSchema, bool) {
+	required := true
+	multiple := false
+	for {
+		switch t.Kind() {
+		case reflect.Ptr:
+			required = false
+			t = t.Elem()
+			continue
+		case reflect.Slice:
+			required = false
+			multiple = true
+			t = t.Elem()
+			continue
+		}
+		break
+	}
+
+	body := g.structSchema(t)
+
+	// Labels are not part of the body, they are the keys of nested objects.
+	schema := body
+	for i := t.NumField() - 1; i >= 0; i-- {
+		tag := t.Field(i).Tag.Get("hcl")
+		name, kind, _ := strings.Cut(tag, ",")
+		if kind != "label" {
+			continue
+		}
+		schema = &JSONSchema{Type: "object", Description: "Blocks by " + name + ".", AdditionalProperties: schema}
+	}
+
+	if multiple && schema == body {
+		schema = &JSONSchema{Type: "array", Items: body}
+	}
+
+	return schema, required
+}
+
+func jsonSchemaOfGoType(t reflect.Type) *JSONSchema {
+	if t == ctyValueType {
+		return &JSONSchema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchemaOfGoType(t.Elem())
+	case reflect.String:
+		return &JSONSchema{Type: "string"}
+	case reflect.Bool:
+		return &JSONSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: "array", Items: jsonSchemaOfGoType(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: "object", AdditionalProperties: jsonSchemaOfGoType(t.Elem())}
+	case reflect.Struct:
+		schema := &JSONSchema{Type: "object", Properties: map[string]*JSONSchema{}}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("cty"), ",")
+			if name == "" {
+				continue
+			}
+			schema.Properties[name] = jsonSchemaOfGoType(field.Type)
+			if opts != "optional" {
+				schema.Required = append(schema.Required, name)
+			}
+		}
+		return schema
+	default:
+		return &JSONSchema{}
+	}
+}
+
+var _ specter.UnitProcessor = JSONSchemaProcessor{}
+
+// JSONSchemaProcessor is a specter.UnitProcessor emitting a JSON Schema document describing the units of kinds with a
+// UnitSchema as a specter.FileArtifact, so that it can be used by editors to provide autocompletion and validation.
+type JSONSchemaProcessor struct {
+	// Registry of the schemas of the units described by the generated document.
+	Registry *UnitSchemaRegistry
+
+	// Path of the generated document.
+	Path string
+
+	// ID of the generated document, usually the URL where it is published.
+	ID string
+}
+
+func NewJSONSchemaProcessor(registry *UnitSchemaRegistry, path string) *JSONSchemaProcessor {
+	return &JSONSchemaProcessor{Registry: registry, Path: path}
+}
+
+func (p JSONSchemaProcessor) Name() string {
+	return "json_schema_processor"
+}
+
+func (p JSONSchemaProcessor) Process(_ specter.UnitProcessingContext) ([]specter.Artifact, error) {
+	if p.Registry == nil {
+		return nil, errors.NewWithMessage(JSONSchemaGenerationFailedErrorCode, "no unit schema registry configured")
+	}
+
+	data, err := json.MarshalIndent(UnitSchemasJSONSchema(p.ID, p.Registry.Schemas()...), "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, JSONSchemaGenerationFailedErrorCode)
+	}
+
+	return []specter.Artifact{
+		&specter.FileArtifact{
+			Path:      p.Path,
+			Data:      data,
+			FileMode:  0644,
+			WriteMode: specter.RecreateMode,
+		},
+	}, nil
+}
@@ -0,0 +1,414 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var _ specter.UnitProcessor = (*specterutils.JSONSchemaProcessor)(nil)
+
+func TestUnitSchemaJSONSchema(t *testing.T) {
+	schema := specterutils.UnitSchemaJSONSchema(serviceUnitSchema())
+
+	data, err := json.Marshal(schema)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+  "title": "service",
+  "type": "object",
+  "properties": {
+    "kind": {"type": "string", "const": "service"},
+    "id": {"type": "string", "description": "ID of the unit."},
+    "description": {"type": "string"},
+    "image": {"type": "string", "pattern": "^[a-z]+:[0-9.]+$"},
+    "replicas": {"type": "number"},
+    "tier": {"type": "string", "enum": ["frontend", "backend"]},
+    "endpoint": {
+      "type": "object",
+      "additionalProperties": {
+        "type": "object",
+        "properties": {"port": {"type": "number"}},
+        "required": ["port"],
+        "additionalProperties": false
+      }
+    },
+    "healthcheck": {
+      "oneOf": [
+        {
+          "type": "object",
+          "properties": {"path": {"type": "string"}},
+          "additionalProperties": false
+        },
+        {
+          "type": "array",
+          "items": {
+            "type": "object",
+            "properties": {"path": {"type": "string"}},
+            "additionalProperties": false
+          },
+          "maxItems": 1
+        }
+      ]
+    }
+  },
+  "required": ["kind", "id", "image"],
+  "additionalProperties": false
+}`, string(data))
+}
+
+func TestUnitSchemasJSONSchema(t *testing.T) {
+	schema := specterutils.UnitSchemasJSONSchema(
+		"https://example.com/specs.schema.json",
+		serviceUnitSchema(),
+		specterutils.UnitSchema{
+			Kind: "database",
+			Attributes: []specterutils.AttributeSchema{
+				{Name: "tables", Type: cty.List(cty.String)},
+				{Name: "options", Type: cty.Map(cty.Bool)},
+			},
+			Blocks: []specterutils.BlockSchema{
+				{Type: "replica", MaxItems: 3},
+			},
+		},
+	)
+
+	assert.Equal(t, specterutils.JSONSchemaDraft, schema.Schema)
+	assert.Equal(t, "https://example.com/specs.schema.json", schema.ID)
+	require.Len(t, schema.OneOf, 2)
+	assert.Equal(t, "#/$defs/unit", schema.OneOf[0].Ref)
+	assert.Equal(t, "array", schema.OneOf[1].Type)
+	assert.Len(t, schema.Defs["unit"].OneOf, 2)
+
+	database := schema.Defs["database"]
+	require.NotNil(t, database)
+	assert.Equal(t, &specterutils.JSONSchema{Type: "array", Items: &specterutils.JSONSchema{Type: "string"}}, database.Properties["tables"])
+	assert.Equal(t, &specterutils.JSONSchema{Type: "object", AdditionalProperties: &specterutils.JSONSchema{Type: "boolean"}}, database.Properties["options"])
+	assert.Equal(t, "array", database.Properties["replica"].Type)
+	assert.Equal(t, 3, *database.Properties["replica"].MaxItems)
+}
+
+func TestUnitSchemasJSONSchema_agreesWithUnitSchemaValidator(t *testing.T) {
+	schemas := []specterutils.UnitSchema{
+		serviceUnitSchema(),
+		{
+			Kind: "database",
+			Blocks: []specterutils.BlockSchema{
+				{
+					Type:     "replica",
+					MaxItems: 3,
+					Attributes: []specterutils.AttributeSchema{
+						{Name: "host", Type: cty.String, Required: true},
+					},
+				},
+			},
+		},
+	}
+	schema := specterutils.UnitSchemasJSONSchema("https://example.com/specs.schema.json", schemas...)
+	validator := specterutils.NewUnitSchemaValidator(specterutils.NewUnitSchemaRegistry(schemas...))
+
+	tests := []struct {
+		name  string
+		given string
+		then  bool
+	}{
+		{
+			name: "GIVEN a document valid against the JSON schema THEN it should pass the validator",
+			given: `[
+  {
+    "kind": "service",
+    "id": "billing",
+    "image": "billing:1.0.0",
+    "replicas": 2,
+    "tier": "backend",
+    "endpoint": {"http": {"port": 80}, "grpc": {"port": 9090}},
+    "healthcheck": {"path": "/health"}
+  },
+  {
+    "kind": "database",
+    "id": "main",
+    "replica": [{"host": "a.example.com"}, {"host": "b.example.com"}]
+  }
+]`,
+			then: true,
+		},
+		{
+			name: "GIVEN a block allowed once as a list of one block THEN it should pass both",
+			given: `{
+  "kind": "service",
+  "id": "billing",
+  "image": "billing:1.0.0",
+  "healthcheck": [{"path": "/health"}]
+}`,
+			then: true,
+		},
+		{
+			name: "GIVEN a block allowed once as a list of two blocks THEN it should fail both",
+			given: `{
+  "kind": "service",
+  "id": "billing",
+  "image": "billing:1.0.0",
+  "healthcheck": [{"path": "/health"}, {"path": "/ready"}]
+}`,
+			then: false,
+		},
+		{
+			name: "GIVEN a document invalid against the JSON schema THEN it should fail the validator",
+			given: `{
+  "kind": "service",
+  "id": "billing",
+  "image": "billing:1.0.0",
+  "endpoint": {"http": {"host": "localhost"}}
+}`,
+			then: false,
+		},
+		{
+			name: "GIVEN too many blocks THEN it should fail both",
+			given: `{
+  "kind": "database",
+  "id": "main",
+  "replica": [{"host": "a"}, {"host": "b"}, {"host": "c"}, {"host": "d"}]
+}`,
+			then: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc any
+			require.NoError(t, json.Unmarshal([]byte(tt.given), &doc))
+			assert.Equal(t, tt.then, jsonSchemaAccepts(schema, schema, doc))
+
+			units, err := specterutils.NewJSONGenericUnitLoader().Load(specter.Source{
+				Location: "specs.json",
+				Data:     []byte(tt.given),
+				Format:   specterutils.JSONSourceFormat,
+			})
+			require.NoError(t, err)
+			results := validator.Lint(units)
+			assert.Equal(t, tt.then, len(results) == 0, "%v", results)
+		})
+	}
+}
+
+// jsonSchemaAccepts checks a value decoded by encoding/json against the subset of JSON Schema generated by specter.
+func jsonSchemaAccepts(root *specterutils.JSONSchema, s *specterutils.JSONSchema, v any) bool {
+	if s.Ref == "#" {
+		return jsonSchemaAccepts(root, root, v)
+	}
+	if s.Ref != "" {
+		return jsonSchemaAccepts(root, root.Defs[strings.TrimPrefix(s.Ref, "#/$defs/")], v)
+	}
+	if len(s.OneOf) != 0 {
+		matches := 0
+		for _, o := range s.OneOf {
+			if jsonSchemaAccepts(root, o, v) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return false
+		}
+	}
+
+	if s.Const != nil && !reflect.DeepEqual(s.Const, v) {
+		return false
+	}
+	if len(s.Enum) != 0 {
+		found := false
+		for _, e := range s.Enum {
+			found = found || reflect.DeepEqual(e, v)
+		}
+		if !found {
+			return false
+		}
+	}
+
+	switch s.Type {
+	case "string":
+		str, ok := v.(string)
+		return ok && (s.Pattern == "" || regexp.MustCompile(s.Pattern).MatchString(str))
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "array":
+		items, ok := v.([]any)
+		if !ok || (s.MaxItems != nil && len(items) > *s.MaxItems) || (s.MinItems != nil && len(items) < *s.MinItems) {
+			return false
+		}
+		for _, item := range items {
+			if s.Items != nil && !jsonSchemaAccepts(root, s.Items, item) {
+				return false
+			}
+		}
+		return true
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return false
+		}
+		for _, r := range s.Required {
+			if _, ok := obj[r]; !ok {
+				return false
+			}
+		}
+		for k, value := range obj {
+			switch {
+			case s.Properties[k] != nil:
+				ok = jsonSchemaAccepts(root, s.Properties[k], value)
+			case s.AdditionalProperties != nil:
+				ok = jsonSchemaAccepts(root, s.AdditionalProperties, value)
+			default:
+				ok = !s.Closed
+			}
+			if !ok {
+				return false
+			}
+		}
+		return true
+	}
+
+	return true
+}
+
+type jsonSchemaFileConfigMock struct {
+	Services []struct {
+		Name     string   `hcl:"name,label"`
+		Image    string   `hcl:"image"`
+		Replicas int      `hcl:"replicas,optional"`
+		Ports    []uint16 `hcl:"ports,optional"`
+		Build    *struct {
+			Context string `hcl:"context"`
+		} `hcl:"build,block"`
+	} `hcl:"service,block"`
+	Settings struct {
+		Debug bool `hcl:"debug,optional"`
+	} `hcl:"settings,block"`
+}
+
+func (m *jsonSchemaFileConfigMock) Units(specter.Source) []specter.Unit { return nil }
+
+func TestHCLFileConfigJSONSchema(t *testing.T) {
+	schema, err := specterutils.HCLFileConfigJSONSchema("https://example.com/hcl.schema.json", &jsonSchemaFileConfigMock{})
+	require.NoError(t, err)
+
+	data, err := json.Marshal(schema)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$id": "https://example.com/hcl.schema.json",
+  "type": "object",
+  "properties": {
+    "service": {
+      "type": "object",
+      "description": "Blocks by name.",
+      "additionalProperties": {
+        "type": "object",
+        "properties": {
+          "image": {"type": "string"},
+          "replicas": {"type": "integer"},
+          "ports": {"type": "array", "items": {"type": "integer"}},
+          "build": {
+            "type": "object",
+            "properties": {"context": {"type": "string"}},
+            "required": ["context"],
+            "additionalProperties": false
+          }
+        },
+        "required": ["image"],
+        "additionalProperties": false
+      }
+    },
+    "settings": {
+      "type": "object",
+      "properties": {"debug": {"type": "boolean"}},
+      "additionalProperties": false
+    }
+  },
+  "required": ["settings"],
+  "additionalProperties": false
+}`, string(data))
+}
+
+type jsonSchemaRecursiveBlockMock struct {
+	Name     string                         `hcl:"name,label"`
+	Children []jsonSchemaRecursiveBlockMock `hcl:"menu,block"`
+}
+
+type jsonSchemaRecursiveFileConfigMock struct {
+	Menus []jsonSchemaRecursiveBlockMock      `hcl:"menu,block"`
+	Files []jsonSchemaRecursiveFileConfigMock `hcl:"include,block"`
+}
+
+func (m *jsonSchemaRecursiveFileConfigMock) Units(specter.Source) []specter.Unit { return nil }
+
+func TestHCLFileConfigJSONSchema_recursiveBlocks(t *testing.T) {
+	schema, err := specterutils.HCLFileConfigJSONSchema("https://example.com/hcl.schema.json", &jsonSchemaRecursiveFileConfigMock{})
+	require.NoError(t, err)
+
+	_, err = json.Marshal(schema)
+	require.NoError(t, err)
+
+	menu := schema.Properties["menu"].AdditionalProperties
+	require.NotNil(t, menu)
+	assert.Equal(t, "#/$defs/jsonSchemaRecursiveBlockMock", menu.Properties["menu"].AdditionalProperties.Ref)
+	assert.Same(t, menu, schema.Defs["jsonSchemaRecursiveBlockMock"])
+	assert.Equal(t, "#", schema.Properties["include"].Items.Ref)
+
+	var doc any
+	require.NoError(t, json.Unmarshal([]byte(`{
+  "menu": {"main": {"menu": {"billing": {"menu": {"invoices": {}}}}}},
+  "include": [{"menu": {"other": {}}}]
+}`), &doc))
+	assert.True(t, jsonSchemaAccepts(schema, schema, doc))
+	require.NoError(t, json.Unmarshal([]byte(`{"menu": {"main": {"menu": {"billing": {"unknown": true}}}}}`), &doc))
+	assert.False(t, jsonSchemaAccepts(schema, schema, doc))
+}
+
+func TestJSONSchemaProcessor_Process(t *testing.T) {
+	p := specterutils.NewJSONSchemaProcessor(specterutils.NewUnitSchemaRegistry(serviceUnitSchema()), "/schemas/specs.schema.json")
+	p.ID = "https://example.com/specs.schema.json"
+
+	artifacts, err := p.Process(specter.UnitProcessingContext{Context: context.Background()})
+	require.NoError(t, err)
+	require.Len(t, artifacts, 1)
+
+	file, ok := artifacts[0].(*specter.FileArtifact)
+	require.True(t, ok)
+	assert.Equal(t, "/schemas/specs.schema.json", file.Path)
+	assert.Equal(t, specter.RecreateMode, file.WriteMode)
+
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(file.Data, &doc))
+	assert.Equal(t, "https://example.com/specs.schema.json", doc["$id"])
+	assert.Contains(t, doc["$defs"], "service")
+}
+
+func TestJSONSchemaProcessor_Process_zeroValue(t *testing.T) {
+	_, err := specterutils.JSONSchemaProcessor{Path: "/schemas/specs.schema.json"}.Process(specter.UnitProcessingContext{Context: context.Background()})
+	testutils.RequireErrorWithCode(specterutils.JSONSchemaGenerationFailedErrorCode)(t, err)
+}
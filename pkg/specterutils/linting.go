@@ -96,6 +96,11 @@ func (l LintingProcessor) handleLinterResultSet(lr LinterResultSet) error {
 	return err
 }
 
+// Lint lints units with the linters of this processor, allowing it to be used as a UnitLinter.
+func (l LintingProcessor) Lint(units specter.UnitGroup) LinterResultSet {
+	return l.lintUnits(units)
+}
+
 func (l LintingProcessor) lintUnits(units []specter.Unit) LinterResultSet {
 	linter := CompositeUnitLinter(l.linters...)
 
@@ -110,6 +115,18 @@ func GetLintingResultsFromContext(ctx specter.UnitProcessingContext) LinterResul
 type LinterResult struct {
 	Severity LinterResultSeverity
 	Message  string
+
+	// Range of the source the result relates to, when known.
+	Range specter.SourceRange
+}
+
+// Error returns the message of the result, so that it can be reported as an error keeping its Range.
+func (r LinterResult) Error() string {
+	return r.Message
+}
+
+func (r LinterResult) SourceRange() specter.SourceRange {
+	return r.Range
 }
 
 // LinterResultSet represents a set of LinterResult.
@@ -190,7 +207,8 @@ func UnitsMustHaveIDs(severity LinterResultSeverity) UnitLinterFunc {
 			if u.ID() == UndefinedUnitID {
 				result = append(result, LinterResult{
 					Severity: severity,
-					Message:  fmt.Sprintf("a unit of kind %q has no ID at %q", u.Kind(), u.Source().Location),
+					Message:  fmt.Sprintf("a unit of kind %q has no ID at %q", u.Kind(), specter.UnitLocation(u)),
+					Range:    specter.UnitSourceRange(u),
 				})
 			}
 		}
@@ -211,9 +229,9 @@ func UnitsIDsMustBeUnique(severity LinterResultSeverity) UnitLinterFunc {
 
 		for _, u := range units {
 			if _, found := encounteredIDs[u.ID()]; found {
-				encounteredIDs[u.ID()] = append(encounteredIDs[u.ID()], u.Source().Location)
+				encounteredIDs[u.ID()] = append(encounteredIDs[u.ID()], specter.UnitLocation(u))
 			} else {
-				encounteredIDs[u.ID()] = []string{u.Source().Location}
+				encounteredIDs[u.ID()] = []string{specter.UnitLocation(u)}
 			}
 		}
 
@@ -0,0 +1,414 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const InvalidVersionErrorCode = "specter.invalid_version"
+
+const InvalidVersionConstraintErrorCode = "specter.invalid_version_constraint"
+
+// semVerRegexp matches semantic versions as per https://semver.org/spec/v2.0.0.html, with an optional "v" prefix.
+var semVerRegexp = regexp.MustCompile(
+	`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+		`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+		`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`,
+)
+
+// SemVer represents a semantic version, e.g. "1.2.3-beta.1+build.5".
+type SemVer struct {
+	Major      uint64
+	Minor      uint64
+	Patch      uint64
+	Prerelease []string
+	Build      []string
+}
+
+// ParseSemVer parses a semantic version, optionally prefixed with "v".
+func ParseSemVer(v string) (SemVer, error) {
+	m := semVerRegexp.FindStringSubmatch(v)
+	if m == nil {
+		return SemVer{}, errors.NewWithMessage(InvalidVersionErrorCode, fmt.Sprintf("invalid semantic version %q", v))
+	}
+
+	var sv SemVer
+	var err error
+	if sv.Major, err = strconv.ParseUint(m[1], 10, 64); err != nil {
+		return SemVer{}, errors.WrapWithMessage(err, InvalidVersionErrorCode, fmt.Sprintf("invalid semantic version %q", v))
+	}
+	if sv.Minor, err = strconv.ParseUint(m[2], 10, 64); err != nil {
+		return SemVer{}, errors.WrapWithMessage(err, InvalidVersionErrorCode, fmt.Sprintf("invalid semantic version %q", v))
+	}
+	if sv.Patch, err = strconv.ParseUint(m[3], 10, 64); err != nil {
+		return SemVer{}, errors.WrapWithMessage(err, InvalidVersionErrorCode, fmt.Sprintf("invalid semantic version %q", v))
+	}
+	if m[4] != "" {
+		sv.Prerelease = strings.Split(m[4], ".")
+	}
+	if m[5] != "" {
+		sv.Build = strings.Split(m[5], ".")
+	}
+
+	return sv, nil
+}
+
+func (v SemVer) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if len(v.Prerelease) != 0 {
+		s += "-" + strings.Join(v.Prerelease, ".")
+	}
+	if len(v.Build) != 0 {
+		s += "+" + strings.Join(v.Build, ".")
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 when this version has a lower, equal or greater precedence than another one.
+// As per the specification, build metadata is ignored.
+func (v SemVer) Compare(o SemVer) int {
+	if c := compareUint(v.Major, o.Major); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Minor, o.Minor); c != 0 {
+		return c
+	}
+	if c := compareUint(v.Patch, o.Patch); c != 0 {
+		return c
+	}
+
+	// A version without prerelease has a greater precedence than one with a prerelease.
+	switch {
+	case len(v.Prerelease) == 0 && len(o.Prerelease) == 0:
+		return 0
+	case len(v.Prerelease) == 0:
+		return 1
+	case len(o.Prerelease) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(v.Prerelease) && i < len(o.Prerelease); i++ {
+		if c := comparePrereleaseIdentifiers(v.Prerelease[i], o.Prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareUint(uint64(len(v.Prerelease)), uint64(len(o.Prerelease)))
+}
+
+// IsPrerelease indicates if this version is a prerelease, e.g. "2.0.0-rc.1".
+func (v SemVer) IsPrerelease() bool {
+	return len(v.Prerelease) != 0
+}
+
+func comparePrereleaseIdentifiers(a, b string) int {
+	an, aErr := strconv.ParseUint(a, 10, 64)
+	bn, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareUint(an, bn)
+	case aErr == nil:
+		// Numeric identifiers have a lower precedence than alphanumeric ones.
+		return -1
+	case bErr == nil:
+		return 1
+	default:
+		return strings.Compare(a, b)
+	}
+}
+
+func compareUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// VersionConstraint is a range of semantic versions, e.g. "^1.2", "~1.2.3" or ">=2.0 <3".
+//
+// A constraint is made of comparators separated by spaces or commas that must all be satisfied. Alternatives can be
+// separated by "||". The supported comparators are:
+//   - "=1.2.3", "!=1.2.3", ">1.2.3", ">=1.2.3", "<1.2.3" and "<=1.2.3";
+//   - "1.2.3" for an exact version, or a partial version such as "1.2", "1.2.x" or "*" for any matching version;
+//   - "^1.2.3" for versions not changing the leftmost non-zero component, i.e. ">=1.2.3 <2.0.0";
+//   - "~1.2.3" for patch updates, i.e. ">=1.2.3 <1.3.0".
+//
+// Prereleases only satisfy a constraint if one of its comparators has a prerelease on the same major, minor and patch.
+type VersionConstraint struct {
+	raw    string
+	ranges [][]versionComparator
+}
+
+type versionComparator struct {
+	op      string
+	version SemVer
+}
+
+// ParseVersionConstraint parses a VersionConstraint.
+func ParseVersionConstraint(c string) (VersionConstraint, error) {
+	constraint := VersionConstraint{raw: strings.TrimSpace(c)}
+
+	for _, alternative := range strings.Split(c, "||") {
+		terms := strings.FieldsFunc(alternative, func(r rune) bool {
+			return r == ' ' || r == ','
+		})
+		terms = joinVersionOperators(terms)
+		if len(terms) == 0 {
+			return VersionConstraint{}, errors.NewWithMessage(
+				InvalidVersionConstraintErrorCode,
+				fmt.Sprintf("invalid version constraint %q, empty range", c),
+			)
+		}
+
+		var comparators []versionComparator
+		for _, term := range terms {
+			termComparators, err := parseVersionConstraintTerm(term)
+			if err != nil {
+				return VersionConstraint{}, errors.WrapWithMessage(
+					err,
+					InvalidVersionConstraintErrorCode,
+					fmt.Sprintf("invalid version constraint %q", c),
+				)
+			}
+			comparators = append(comparators, termComparators...)
+		}
+		constraint.ranges = append(constraint.ranges, comparators)
+	}
+
+	return constraint, nil
+}
+
+// joinVersionOperators joins the operators separated from their version by a space, e.g. ">= 1.2".
+func joinVersionOperators(terms []string) []string {
+	var joined []string
+	for i := 0; i < len(terms); i++ {
+		term := terms[i]
+		if strings.Trim(term, "<>=!^~") == "" && i+1 < len(terms) {
+			term += terms[i+1]
+			i++
+		}
+		joined = append(joined, term)
+	}
+	return joined
+}
+
+// parseVersionConstraintTerm parses a term of a constraint into primitive comparators.
+func parseVersionConstraintTerm(term string) ([]versionComparator, error) {
+	op := ""
+	for _, candidate := range []string{">=", "<=", "!=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(term, candidate) {
+			op = candidate
+			break
+		}
+	}
+
+	v, parts, err := parsePartialSemVer(strings.TrimPrefix(term, op))
+	if err != nil {
+		return nil, err
+	}
+
+	// Upper bound of a partial version, e.g. "1.3.0-0" for "1.2". The "-0" prerelease is the lowest
+	// version of a major, minor and patch, so that "<1.3.0-0" excludes the prereleases of 1.3.0.
+	next := func(parts int) SemVer {
+		switch parts {
+		case 0:
+			return SemVer{}
+		case 1:
+			return SemVer{Major: v.Major + 1, Prerelease: []string{"0"}}
+		case 2:
+			return SemVer{Major: v.Major, Minor: v.Minor + 1, Prerelease: []string{"0"}}
+		default:
+			return SemVer{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1, Prerelease: []string{"0"}}
+		}
+	}
+
+	switch op {
+	case "", "=":
+		if parts == 0 {
+			return []versionComparator{{op: ">=", version: SemVer{}}}, nil
+		}
+		if parts == 3 {
+			return []versionComparator{{op: "=", version: v}}, nil
+		}
+		return []versionComparator{{op: ">=", version: v}, {op: "<", version: next(parts)}}, nil
+
+	case "!=":
+		if parts != 3 {
+			return nil, errors.New(fmt.Sprintf("operator %q requires a complete version", op))
+		}
+		return []versionComparator{{op: "!=", version: v}}, nil
+
+	case ">":
+		if parts == 0 {
+			return []versionComparator{{op: "<", version: SemVer{}}}, nil
+		}
+		if parts == 3 {
+			return []versionComparator{{op: ">", version: v}}, nil
+		}
+		return []versionComparator{{op: ">=", version: next(parts)}}, nil
+
+	case ">=":
+		return []versionComparator{{op: ">=", version: v}}, nil
+
+	case "<":
+		return []versionComparator{{op: "<", version: v}}, nil
+
+	case "<=":
+		if parts == 0 {
+			return []versionComparator{{op: ">=", version: SemVer{}}}, nil
+		}
+		if parts == 3 {
+			return []versionComparator{{op: "<=", version: v}}, nil
+		}
+		return []versionComparator{{op: "<", version: next(parts)}}, nil
+
+	case "^":
+		if parts == 0 {
+			return []versionComparator{{op: ">=", version: SemVer{}}}, nil
+		}
+		var upper SemVer
+		switch {
+		case v.Major != 0 || parts == 1:
+			upper = next(1)
+		case v.Minor != 0 || parts == 2:
+			upper = next(2)
+		default:
+			upper = next(3)
+		}
+		return []versionComparator{{op: ">=", version: v}, {op: "<", version: upper}}, nil
+
+	case "~":
+		if parts == 0 {
+			return []versionComparator{{op: ">=", version: SemVer{}}}, nil
+		}
+		upper := next(2)
+		if parts == 1 {
+			upper = next(1)
+		}
+		return []versionComparator{{op: ">=", version: v}, {op: "<", version: upper}}, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("unsupported operator %q", op))
+}
+
+// parsePartialSemVer parses a version that can omit its minor and patch, or use wildcards ("x", "X" or "*") for them.
+// It returns the version along with the number of components specified.
+func parsePartialSemVer(v string) (SemVer, int, error) {
+	v = strings.TrimPrefix(v, "v")
+	if v == "" {
+		return SemVer{}, 0, errors.New("missing version")
+	}
+
+	if sv, err := ParseSemVer(v); err == nil {
+		return sv, 3, nil
+	}
+
+	components := strings.Split(v, ".")
+	if len(components) > 3 {
+		return SemVer{}, 0, errors.New(fmt.Sprintf("invalid version %q", v))
+	}
+
+	var numbers []uint64
+	for _, c := range components {
+		if c == "x" || c == "X" || c == "*" {
+			break
+		}
+		n, err := strconv.ParseUint(c, 10, 64)
+		if err != nil {
+			return SemVer{}, 0, errors.New(fmt.Sprintf("invalid version %q", v))
+		}
+		numbers = append(numbers, n)
+	}
+	if len(numbers) == 3 {
+		return SemVer{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, 3, nil
+	}
+
+	var sv SemVer
+	if len(numbers) > 0 {
+		sv.Major = numbers[0]
+	}
+	if len(numbers) > 1 {
+		sv.Minor = numbers[1]
+	}
+	return sv, len(numbers), nil
+}
+
+// Check indicates if a version satisfies this constraint.
+func (c VersionConstraint) Check(v SemVer) bool {
+	for _, comparators := range c.ranges {
+		if versionSatisfiesRange(v, comparators) {
+			return true
+		}
+	}
+	return false
+}
+
+func versionSatisfiesRange(v SemVer, comparators []versionComparator) bool {
+	for _, c := range comparators {
+		if !c.check(v) {
+			return false
+		}
+	}
+
+	if !v.IsPrerelease() {
+		return true
+	}
+
+	// Prereleases are only allowed when explicitly targeted by the range, e.g. ">=2.0.0-rc.1".
+	for _, c := range comparators {
+		if c.version.IsPrerelease() && !isLowestPrerelease(c.version) &&
+			c.version.Major == v.Major && c.version.Minor == v.Minor && c.version.Patch == v.Patch {
+			return true
+		}
+	}
+	return false
+}
+
+// isLowestPrerelease indicates if a version is the "-0" prerelease used as an upper bound of partial versions.
+func isLowestPrerelease(v SemVer) bool {
+	return len(v.Prerelease) == 1 && v.Prerelease[0] == "0"
+}
+
+func (c versionComparator) check(v SemVer) bool {
+	cmp := v.Compare(c.version)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	default:
+		return false
+	}
+}
+
+func (c VersionConstraint) String() string {
+	return c.raw
+}
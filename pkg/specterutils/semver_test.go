@@ -0,0 +1,162 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseSemVer(t *testing.T) {
+	tests := []struct {
+		name      string
+		given     string
+		then      specterutils.SemVer
+		thenError require.ErrorAssertionFunc
+	}{
+		{
+			name:  "GIVEN a version WHEN parsed THEN should return its components",
+			given: "1.2.3",
+			then:  specterutils.SemVer{Major: 1, Minor: 2, Patch: 3},
+		},
+		{
+			name:  "GIVEN a version with a prefix, prerelease and build WHEN parsed THEN should return its components",
+			given: "v2.0.0-rc.1+build.5",
+			then: specterutils.SemVer{
+				Major:      2,
+				Prerelease: []string{"rc", "1"},
+				Build:      []string{"build", "5"},
+			},
+		},
+		{
+			name:      "GIVEN a partial version WHEN parsed THEN should return an error",
+			given:     "1.2",
+			thenError: testutils.RequireErrorWithCode(specterutils.InvalidVersionErrorCode),
+		},
+		{
+			name:      "GIVEN a version with leading zeros WHEN parsed THEN should return an error",
+			given:     "01.2.3",
+			thenError: testutils.RequireErrorWithCode(specterutils.InvalidVersionErrorCode),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := specterutils.ParseSemVer(tt.given)
+			if tt.thenError != nil {
+				tt.thenError(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.then, got)
+			assert.Equal(t, tt.given[len(tt.given)-len(got.String()):], got.String())
+		})
+	}
+}
+
+func TestSemVer_Compare(t *testing.T) {
+	// Ordered by precedence as per the specification.
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.0.1",
+		"1.1.0",
+		"2.0.0",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, err := specterutils.ParseSemVer(ordered[i])
+			require.NoError(t, err)
+			b, err := specterutils.ParseSemVer(ordered[j])
+			require.NoError(t, err)
+
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			assert.Equal(t, expected, a.Compare(b), "%s <=> %s", ordered[i], ordered[j])
+		}
+	}
+
+	a, _ := specterutils.ParseSemVer("1.0.0+build.1")
+	b, _ := specterutils.ParseSemVer("1.0.0+build.2")
+	assert.Equal(t, 0, a.Compare(b))
+}
+
+func TestVersionConstraint_Check(t *testing.T) {
+	tests := []struct {
+		constraint string
+		satisfied  []string
+		violated   []string
+	}{
+		{constraint: "1.2.3", satisfied: []string{"1.2.3"}, violated: []string{"1.2.4", "1.2.3-rc.1"}},
+		{constraint: "1.2", satisfied: []string{"1.2.0", "1.2.9"}, violated: []string{"1.3.0", "1.1.9"}},
+		{constraint: "1.x", satisfied: []string{"1.0.0", "1.9.9"}, violated: []string{"2.0.0", "0.9.0"}},
+		{constraint: "*", satisfied: []string{"0.0.1", "9.0.0"}, violated: []string{"1.0.0-rc.1"}},
+		{constraint: "!=1.2.3", satisfied: []string{"1.2.4"}, violated: []string{"1.2.3"}},
+		{constraint: ">1.2", satisfied: []string{"1.3.0"}, violated: []string{"1.2.9"}},
+		{constraint: "<=1.2", satisfied: []string{"1.2.9"}, violated: []string{"1.3.0"}},
+		{constraint: "^1.2", satisfied: []string{"1.2.0", "1.9.0"}, violated: []string{"1.1.9", "2.0.0", "2.0.0-rc.1"}},
+		{constraint: "^2", satisfied: []string{"2.0.0", "2.5.1"}, violated: []string{"1.9.9", "3.0.0"}},
+		{constraint: "^0.2.3", satisfied: []string{"0.2.3", "0.2.9"}, violated: []string{"0.3.0", "0.2.2"}},
+		{constraint: "^0.0.3", satisfied: []string{"0.0.3"}, violated: []string{"0.0.4"}},
+		{constraint: "~1.2.3", satisfied: []string{"1.2.3", "1.2.9"}, violated: []string{"1.3.0"}},
+		{constraint: "~1", satisfied: []string{"1.9.0"}, violated: []string{"2.0.0"}},
+		{constraint: "^*", satisfied: []string{"0.0.1", "1.2.3", "9.0.0"}, violated: []string{"1.0.0-rc.1"}},
+		{constraint: "~*", satisfied: []string{"0.0.1", "1.2.3", "9.0.0"}, violated: []string{"1.0.0-rc.1"}},
+		{constraint: "^x", satisfied: []string{"0.1.0", "5.0.0"}},
+		{constraint: ">=2.0 <3", satisfied: []string{"2.0.0", "2.9.9"}, violated: []string{"1.9.9", "3.0.0"}},
+		{constraint: ">= 2.0, < 3", satisfied: []string{"2.1.0"}, violated: []string{"3.0.0"}},
+		{constraint: "^1 || ^3", satisfied: []string{"1.5.0", "3.0.0"}, violated: []string{"2.0.0"}},
+		{constraint: ">=2.0.0-rc.1", satisfied: []string{"2.0.0-rc.2", "2.0.0", "2.1.0"}, violated: []string{"2.1.0-rc.1", "2.0.0-beta"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			c, err := specterutils.ParseVersionConstraint(tt.constraint)
+			require.NoError(t, err)
+			assert.Equal(t, tt.constraint, c.String())
+
+			for _, v := range tt.satisfied {
+				sv, err := specterutils.ParseSemVer(v)
+				require.NoError(t, err)
+				assert.True(t, c.Check(sv), "%q should satisfy %q", v, tt.constraint)
+			}
+			for _, v := range tt.violated {
+				sv, err := specterutils.ParseSemVer(v)
+				require.NoError(t, err)
+				assert.False(t, c.Check(sv), "%q should not satisfy %q", v, tt.constraint)
+			}
+		})
+	}
+}
+
+func TestParseVersionConstraint_invalid(t *testing.T) {
+	for _, c := range []string{"", "^", ">=a.b", "1.2.3.4", "!=1.2", "^1 ||", "=>1"} {
+		t.Run(c, func(t *testing.T) {
+			_, err := specterutils.ParseVersionConstraint(c)
+			testutils.RequireErrorWithCode(specterutils.InvalidVersionConstraintErrorCode)(t, err)
+		})
+	}
+}
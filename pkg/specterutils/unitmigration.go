@@ -0,0 +1,343 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const UnitMigrationFailedErrorCode = "specter.unit_migration_failed"
+
+// DefaultSchemaVersionAttribute is the attribute of units holding the version of the schema they are written in.
+const DefaultSchemaVersionAttribute = "schema_version"
+
+// Migration upgrades units of a kind written in an older version of the schema of their DSL, e.g. by renaming
+// attributes or restructuring blocks.
+type Migration interface {
+	// Kind of the units to migrate.
+	Kind() specter.UnitKind
+
+	// Version of the schema the units are written in once migrated.
+	Version() UnitVersion
+
+	// Migrate returns the mutations upgrading a unit written in the previous version of the schema to the Version.
+	Migrate(u *GenericUnit) ([]UnitMutation, error)
+}
+
+// NewMigration returns a Migration relying on a func.
+func NewMigration(kind specter.UnitKind, version UnitVersion, migrate func(u *GenericUnit) ([]UnitMutation, error)) Migration {
+	return migrationAdapter{kind: kind, version: version, migrate: migrate}
+}
+
+type migrationAdapter struct {
+	kind    specter.UnitKind
+	version UnitVersion
+	migrate func(u *GenericUnit) ([]UnitMutation, error)
+}
+
+func (m migrationAdapter) Kind() specter.UnitKind {
+	return m.kind
+}
+
+func (m migrationAdapter) Version() UnitVersion {
+	return m.version
+}
+
+func (m migrationAdapter) Migrate(u *GenericUnit) ([]UnitMutation, error) {
+	if m.migrate == nil {
+		return nil, nil
+	}
+	return m.migrate(u)
+}
+
+// MigrationProcessor is a specter.UnitPreprocessor applying the pending migrations of GenericUnit in memory, in the
+// order of their version. A migration is pending when its version is greater than the schema version of a unit,
+// as found in its VersionAttribute. Units without a schema version are considered written in the oldest version.
+// Schema versions are made of dot separated non-negative integers compared numerically, such as "2" or "1.3";
+// other versions result in an error.
+// Once migrated, the schema version of a unit is set to the version of its last migration.
+//
+// The schema version is distinct from the version of a unit, as provided by HasVersion or the DefaultVersionAttribute:
+// the version of a unit describes the unit itself, e.g. the version of an API, and changes with its content, while the
+// schema version describes the syntax of the DSL it is written in. Upgrading the DSL does not change what the units
+// describe, so it must not change their version, which is what CheckVersionBumps and the changelog rely on.
+//
+// In write-back mode, the migrations are also persisted to the sources of the units by a UnitMutator. The sources are
+// first written to an in-memory specter.OverlayFileSystem that is only committed once all of them could be rewritten,
+// so that a failing migration does not leave the sources partially migrated.
+type MigrationProcessor struct {
+	Migrations []Migration
+
+	// VersionAttribute is the attribute holding the schema version of units. Defaults to DefaultSchemaVersionAttribute.
+	VersionAttribute string
+
+	// Mutator persists the migrations to the sources of the units when set.
+	Mutator *UnitMutator
+}
+
+func NewMigrationProcessor(migrations ...Migration) *MigrationProcessor {
+	return &MigrationProcessor{
+		Migrations:       migrations,
+		VersionAttribute: DefaultSchemaVersionAttribute,
+	}
+}
+
+// WithWriteBack enables the write-back mode, persisting the migrations to the sources of the units through a
+// specter.FileSystem.
+func (p *MigrationProcessor) WithWriteBack(fs specter.FileSystem) *MigrationProcessor {
+	p.Mutator = NewUnitMutator(fs)
+	return p
+}
+
+func (p MigrationProcessor) Name() string {
+	return "migration_processor"
+}
+
+func (p MigrationProcessor) Preprocess(_ specter.PipelineContext, units []specter.Unit) ([]specter.Unit, error) {
+	errs := errors.NewGroup(UnitMigrationFailedErrorCode)
+
+	var changes []UnitMutations
+	for _, u := range units {
+		gu, ok := u.(*GenericUnit)
+		if !ok {
+			continue
+		}
+
+		mutations, err := p.migrate(gu)
+		if err != nil {
+			errs = errs.Append(err)
+			continue
+		}
+		if len(mutations) != 0 {
+			changes = append(changes, UnitMutations{Unit: gu, Mutations: mutations})
+		}
+	}
+	if err := errors.GroupOrNil(errs); err != nil {
+		return nil, err
+	}
+
+	if p.Mutator != nil {
+		if err := p.writeBack(changes); err != nil {
+			return nil, errors.WrapWithMessage(err, UnitMigrationFailedErrorCode, "failed writing migrated units")
+		}
+		return units, nil
+	}
+
+	for _, c := range changes {
+		for _, m := range c.Mutations {
+			if err := m.Apply(c.Unit); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return units, nil
+}
+
+// writeBack applies the mutations of the migrations to the units and writes them to their sources, only once all sources
+// could be rewritten.
+func (p MigrationProcessor) writeBack(changes []UnitMutations) error {
+	overlay := specter.NewOverlayFileSystem(p.Mutator.FileSystem, nil)
+	mutator := *p.Mutator
+	mutator.FileSystem = overlay
+
+	if _, err := mutator.Apply(changes...); err != nil {
+		return err
+	}
+
+	return overlay.Commit()
+}
+
+// migrate returns the mutations of the pending migrations of a unit. The migrations are applied to a copy of the
+// unit, so that each migration is based on the result of the previous ones.
+func (p MigrationProcessor) migrate(u *GenericUnit) ([]UnitMutation, error) {
+	pending, err := p.pendingMigrations(u)
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	migrated := *u
+	var mutations []UnitMutation
+	for _, m := range pending {
+		migrationMutations, err := m.Migrate(&migrated)
+		if err != nil {
+			return nil, errors.WrapWithMessage(
+				err,
+				UnitMigrationFailedErrorCode,
+				fmt.Sprintf(
+					"failed migrating unit %q of kind %q at %q to version %q",
+					u.ID(),
+					u.Kind(),
+					specter.UnitLocation(u),
+					m.Version(),
+				),
+			)
+		}
+
+		migrationMutations = append(migrationMutations, SetUnitAttribute(p.versionAttribute(), schemaVersionValue(&migrated, p.versionAttribute(), m.Version())))
+		for _, mutation := range migrationMutations {
+			if err := mutation.Apply(&migrated); err != nil {
+				return nil, errors.WrapWithMessage(
+					err,
+					UnitMigrationFailedErrorCode,
+					fmt.Sprintf("failed migrating unit %q of kind %q to version %q", u.ID(), u.Kind(), m.Version()),
+				)
+			}
+		}
+		mutations = append(mutations, migrationMutations...)
+	}
+
+	return mutations, nil
+}
+
+// pendingMigrations returns the migrations of the kind of a unit having a version greater than its schema version,
+// ordered by version.
+func (p MigrationProcessor) pendingMigrations(u *GenericUnit) ([]Migration, error) {
+	var current []uint64
+	if v := SchemaVersionOf(u, p.versionAttribute()); v != "" {
+		parsed, err := parseSchemaVersion(v)
+		if err != nil {
+			return nil, errors.WrapWithMessage(
+				err,
+				UnitMigrationFailedErrorCode,
+				fmt.Sprintf("unit %q of kind %q at %q has an invalid schema version", u.ID(), u.Kind(), specter.UnitLocation(u)),
+			)
+		}
+		current = parsed
+	}
+
+	type pendingMigration struct {
+		migration Migration
+		version   []uint64
+	}
+	var pending []pendingMigration
+	for _, m := range p.Migrations {
+		if m.Kind() != u.Kind() {
+			continue
+		}
+		version, err := parseSchemaVersion(m.Version())
+		if err != nil {
+			return nil, errors.WrapWithMessage(
+				err,
+				UnitMigrationFailedErrorCode,
+				fmt.Sprintf("migration of kind %q has an invalid version", m.Kind()),
+			)
+		}
+		if current == nil || compareSchemaVersions(version, current) > 0 {
+			pending = append(pending, pendingMigration{migration: m, version: version})
+		}
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		return compareSchemaVersions(pending[i].version, pending[j].version) < 0
+	})
+
+	migrations := make([]Migration, 0, len(pending))
+	for _, m := range pending {
+		migrations = append(migrations, m.migration)
+	}
+	return migrations, nil
+}
+
+func (p MigrationProcessor) versionAttribute() string {
+	if p.VersionAttribute == "" {
+		return DefaultSchemaVersionAttribute
+	}
+	return p.VersionAttribute
+}
+
+// SchemaVersionOf returns the schema version of a unit held by an attribute, or an empty version if it has none.
+func SchemaVersionOf(u *GenericUnit, attribute string) UnitVersion {
+	attr := u.Attribute(attribute)
+	if attr == nil {
+		return ""
+	}
+
+	v, ok := attr.Value.(GenericValue)
+	if !ok || v.IsNull() || !v.IsKnown() {
+		return ""
+	}
+
+	switch v.Type() {
+	case cty.String:
+		return UnitVersion(v.AsString())
+	case cty.Number:
+		return UnitVersion(v.AsBigFloat().Text('f', -1))
+	default:
+		return ""
+	}
+}
+
+// schemaVersionValue returns the value of a schema version, as a number if the unit already uses numbers.
+func schemaVersionValue(u *GenericUnit, attribute string, version UnitVersion) cty.Value {
+	if attr := u.Attribute(attribute); attr != nil {
+		if v, ok := attr.Value.(GenericValue); ok && v.Type() == cty.Number {
+			if n, err := cty.ParseNumberVal(string(version)); err == nil {
+				return n
+			}
+		}
+	}
+	return cty.StringVal(string(version))
+}
+
+// parseSchemaVersion parses a schema version made of dot separated non-negative integers, such as "2" or "1.3".
+func parseSchemaVersion(v UnitVersion) ([]uint64, error) {
+	if v == "" {
+		return nil, errors.New("schema version should not be empty")
+	}
+
+	var segments []uint64
+	for _, s := range strings.Split(string(v), ".") {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf(
+				"invalid schema version %q, expected dot separated non-negative integers such as \"2\" or \"1.3\"",
+				v,
+			))
+		}
+		segments = append(segments, n)
+	}
+	return segments, nil
+}
+
+// compareSchemaVersions compares two parsed schema versions segment by segment, missing segments being zero,
+// so that "1" and "1.0" are equal.
+func compareSchemaVersions(a, b []uint64) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y uint64
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
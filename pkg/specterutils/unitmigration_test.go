@@ -0,0 +1,252 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"context"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"testing"
+)
+
+// renameImageMigration renames the attribute "image_name" of services to "image".
+func renameImageMigration(version specterutils.UnitVersion) specterutils.Migration {
+	return specterutils.NewMigration("service", version, func(u *specterutils.GenericUnit) ([]specterutils.UnitMutation, error) {
+		attr := u.Attribute("image_name")
+		if attr == nil {
+			return nil, nil
+		}
+		return []specterutils.UnitMutation{
+			specterutils.SetUnitAttribute("image", attr.Value.(specterutils.GenericValue).Value),
+			specterutils.RemoveUnitAttribute("image_name"),
+		}, nil
+	})
+}
+
+// replicasBlockMigration moves the attribute "replicas" of services to a "scaling" block.
+func replicasBlockMigration(version specterutils.UnitVersion) specterutils.Migration {
+	return specterutils.NewMigration("service", version, func(u *specterutils.GenericUnit) ([]specterutils.UnitMutation, error) {
+		attr := u.Attribute("replicas")
+		if attr == nil {
+			return nil, nil
+		}
+		return []specterutils.UnitMutation{
+			specterutils.AddUnitBlock("", "scaling", nil, map[string]cty.Value{
+				"min": attr.Value.(specterutils.GenericValue).Value,
+			}),
+			specterutils.RemoveUnitAttribute("replicas"),
+		}, nil
+	})
+}
+
+func TestMigrationProcessor_Preprocess(t *testing.T) {
+	tests := []struct {
+		name           string
+		given          string
+		givenMigration []specterutils.Migration
+		thenAttributes map[string]cty.Value
+		thenBlock      bool
+		thenError      require.ErrorAssertionFunc
+	}{
+		{
+			name: "GIVEN a unit without a schema version WHEN preprocessed THEN should apply all migrations in the order of their version",
+			given: `service "billing" {
+  image_name = "billing:1.0"
+  replicas = 2
+}`,
+			givenMigration: []specterutils.Migration{replicasBlockMigration("10"), renameImageMigration("9")},
+			thenAttributes: map[string]cty.Value{
+				"image":          cty.StringVal("billing:1.0"),
+				"schema_version": cty.StringVal("10"),
+			},
+			thenBlock: true,
+		},
+		{
+			name: "GIVEN a unit with a schema version WHEN preprocessed THEN should only apply pending migrations",
+			given: `service "billing" {
+  schema_version = 9
+  image_name = "billing:1.0"
+  replicas = 2
+}`,
+			givenMigration: []specterutils.Migration{renameImageMigration("9"), replicasBlockMigration("10")},
+			thenAttributes: map[string]cty.Value{
+				"image_name":     cty.StringVal("billing:1.0"),
+				"schema_version": cty.NumberIntVal(10),
+			},
+			thenBlock: true,
+		},
+		{
+			name: "GIVEN an up to date unit WHEN preprocessed THEN should not change it",
+			given: `service "billing" {
+  schema_version = "10"
+  replicas = 2
+}`,
+			givenMigration: []specterutils.Migration{renameImageMigration("9"), replicasBlockMigration("10")},
+			thenAttributes: map[string]cty.Value{
+				"replicas":       cty.NumberIntVal(2),
+				"schema_version": cty.StringVal("10"),
+			},
+		},
+		{
+			name: "GIVEN migrations of another kind WHEN preprocessed THEN should not change the unit",
+			given: `worker "billing" {
+  image_name = "billing:1.0"
+}`,
+			givenMigration: []specterutils.Migration{renameImageMigration("9")},
+			thenAttributes: map[string]cty.Value{
+				"image_name": cty.StringVal("billing:1.0"),
+			},
+		},
+		{
+			name: "GIVEN multi-segment versions WHEN preprocessed THEN should compare their segments numerically",
+			given: `service "billing" {
+  schema_version = "1.9"
+  image_name = "billing:1.0"
+  replicas = 2
+}`,
+			givenMigration: []specterutils.Migration{replicasBlockMigration("1.10"), renameImageMigration("1.9.0")},
+			thenAttributes: map[string]cty.Value{
+				"image_name":     cty.StringVal("billing:1.0"),
+				"schema_version": cty.StringVal("1.10"),
+			},
+			thenBlock: true,
+		},
+		{
+			name: "GIVEN a unit with an invalid schema version WHEN preprocessed THEN should return an error",
+			given: `service "billing" {
+  schema_version = "v2"
+}`,
+			givenMigration: []specterutils.Migration{renameImageMigration("3")},
+			thenError:      testutils.RequireErrorWithCode(specterutils.UnitMigrationFailedErrorCode),
+		},
+		{
+			name: "GIVEN a migration with an invalid version WHEN preprocessed THEN should return an error",
+			given: `service "billing" {
+  schema_version = "2"
+}`,
+			givenMigration: []specterutils.Migration{renameImageMigration("3-beta")},
+			thenError:      testutils.RequireErrorWithCode(specterutils.UnitMigrationFailedErrorCode),
+		},
+		{
+			name: "GIVEN a failing migration WHEN preprocessed THEN should return an error",
+			given: `service "billing" {
+  image_name = "billing:1.0"
+}`,
+			givenMigration: []specterutils.Migration{
+				specterutils.NewMigration("service", "2", func(u *specterutils.GenericUnit) ([]specterutils.UnitMutation, error) {
+					return nil, errors.New("unsupported image")
+				}),
+			},
+			thenError: testutils.RequireErrorWithCode(specterutils.UnitMigrationFailedErrorCode),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := &specter.MemoryFileSystem{}
+			units := loadGenericUnits(t, fs, "/specs/billing.hcl", tt.given)
+
+			p := specterutils.NewMigrationProcessor(tt.givenMigration...)
+			got, err := p.Preprocess(specter.PipelineContext{Context: context.Background()}, unitsOf(units))
+			if tt.thenError != nil {
+				tt.thenError(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, got, 1)
+
+			u := got[0].(*specterutils.GenericUnit)
+			attributes := map[string]cty.Value{}
+			hasBlock := false
+			for _, a := range u.Attributes {
+				if v, ok := a.Value.(specterutils.GenericValue); ok {
+					attributes[a.Name] = v.Value
+				} else {
+					hasBlock = true
+				}
+			}
+			require.Len(t, attributes, len(tt.thenAttributes))
+			for name, value := range tt.thenAttributes {
+				require.Contains(t, attributes, name)
+				assert.True(t, value.Equals(attributes[name]).True(), "attribute %q: %#v", name, attributes[name])
+			}
+			assert.Equal(t, tt.thenBlock, hasBlock)
+		})
+	}
+}
+
+func TestMigrationProcessor_Preprocess_writeBack(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	units := loadGenericUnits(t, fs, "/specs/billing.hcl", `# Billing service.
+service "billing" {
+  image_name = "billing:1.0" // pinned
+  replicas   = 2
+}
+`)
+
+	p := specterutils.NewMigrationProcessor(renameImageMigration("1"), replicasBlockMigration("2")).WithWriteBack(fs)
+	_, err := p.Preprocess(specter.PipelineContext{Context: context.Background()}, unitsOf(units))
+	require.NoError(t, err)
+
+	data, err := fs.ReadFile("/specs/billing.hcl")
+	require.NoError(t, err)
+	assert.Equal(t, `# Billing service.
+service "billing" {
+  image          = "billing:1.0"
+  schema_version = "2"
+  scaling {
+    min = 2
+  }
+}
+`, string(data))
+	assert.Equal(t, specterutils.UnitVersion("2"), specterutils.SchemaVersionOf(units[0], specterutils.DefaultSchemaVersionAttribute))
+
+	// Once migrated, the units should no longer have pending migrations.
+	units = loadGenericUnits(t, fs, "/specs/billing.hcl", string(data))
+	_, err = p.Preprocess(specter.PipelineContext{Context: context.Background()}, unitsOf(units))
+	require.NoError(t, err)
+	reread, err := fs.ReadFile("/specs/billing.hcl")
+	require.NoError(t, err)
+	assert.Equal(t, string(data), string(reread))
+}
+
+func TestMigrationProcessor_Preprocess_writeBackFailure(t *testing.T) {
+	const billing = `service "billing" {
+  image_name = "billing:1.0"
+}
+`
+	fs := &specter.MemoryFileSystem{}
+	units := loadGenericUnits(t, fs, "/specs/billing.hcl", billing)
+	units = append(units, loadGenericUnits(t, fs, "/specs/orders.hcl", `service "orders" {
+  image_name = "orders:1.0"
+}
+`)...)
+
+	// Modifying a source after loading its units prevents writing back to it.
+	require.NoError(t, fs.WriteFile("/specs/orders.hcl", []byte(`service "orders" {}`), 0600))
+
+	p := specterutils.NewMigrationProcessor(renameImageMigration("1")).WithWriteBack(fs)
+	_, err := p.Preprocess(specter.PipelineContext{Context: context.Background()}, unitsOf(units))
+	testutils.RequireErrorWithCode(specterutils.UnitMigrationFailedErrorCode)(t, err)
+
+	// The sources that could be rewritten should be left untouched as well.
+	data, err := fs.ReadFile("/specs/billing.hcl")
+	require.NoError(t, err)
+	assert.Equal(t, billing, string(data))
+}
@@ -0,0 +1,522 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const UnitSnapshotStoreFailedErrorCode = "specter.unit_snapshot_store_failed"
+
+const UnitSnapshotNotFoundErrorCode = "specter.unit_snapshot_not_found"
+
+// LatestUnitSnapshotKey is the label of the snapshot of the last successful run.
+const LatestUnitSnapshotKey = "latest"
+
+// DefaultUnitSnapshotDirectory is the directory in which the FileSystemUnitSnapshotStore saves snapshots by default.
+const DefaultUnitSnapshotDirectory = ".specter/snapshots"
+
+const unitSnapshotFileExtension = ".json"
+
+// unitSnapshotKeyRegexp restricts keys to names that can safely be used as file names.
+var unitSnapshotKeyRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// UnitSnapshot represents the units of a run, saved under a key such as a run ID or a label like LatestUnitSnapshotKey.
+type UnitSnapshot struct {
+	Key       string
+	CreatedAt time.Time
+	Units     specter.UnitGroup
+}
+
+// UnitSnapshotStore stores snapshots of units, so that later runs or tools can compare the current units against the
+// units of a previous run without reading their old sources again.
+type UnitSnapshotStore interface {
+	// Save saves a snapshot under its key, replacing any snapshot with the same key.
+	Save(ctx context.Context, snapshot UnitSnapshot) error
+
+	// Load loads the snapshot saved under a key, or returns an error with code UnitSnapshotNotFoundErrorCode.
+	Load(ctx context.Context, key string) (UnitSnapshot, error)
+
+	// Keys returns the keys of the saved snapshots, sorted.
+	Keys(ctx context.Context) ([]string, error)
+}
+
+// NewUnitSnapshotRunID returns a run ID for a snapshot created at a given time, such that run IDs sort chronologically.
+func NewUnitSnapshotRunID(t time.Time) string {
+	return t.UTC().Format("20060102T150405.000000000Z")
+}
+
+var _ UnitSnapshotStore = FileSystemUnitSnapshotStore{}
+
+// FileSystemUnitSnapshotStore is a UnitSnapshotStore saving each snapshot as a JSON file named after its key in a
+// directory of a specter.FileSystem.
+//
+// Only units of type *GenericUnit are supported. Their attributes, source location, format and range are saved, while
+// the data of their source and their references to other units are not. Attributes referencing other units are saved
+// with their resolved value, as evaluated by an HCLReferenceResolutionProcessor.
+//
+// Units of other types and attributes whose references were not resolved cannot be saved. They are skipped with a
+// warning when a Logger is configured, and fail the save otherwise.
+//
+// When the FileSystem is a specter.FileRenamer, snapshots are written to a temporary file of the directory renamed
+// over the snapshot file, so that an interrupted save does not corrupt the previous snapshot.
+type FileSystemUnitSnapshotStore struct {
+	FileSystem specter.FileSystem
+
+	// Directory in which the snapshots are saved. Defaults to DefaultUnitSnapshotDirectory.
+	Directory string
+
+	// Logger reports the units and attributes skipped from snapshots.
+	Logger specter.Logger
+}
+
+func NewFileSystemUnitSnapshotStore(fs specter.FileSystem, directory string) FileSystemUnitSnapshotStore {
+	return FileSystemUnitSnapshotStore{FileSystem: fs, Directory: directory}
+}
+
+func (s FileSystemUnitSnapshotStore) Save(ctx context.Context, snapshot UnitSnapshot) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := s.path(snapshot.Key)
+	if err != nil {
+		return err
+	}
+
+	repr, err := newUnitSnapshotRepresentation(snapshot, s.Logger)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(repr, "", "  ")
+	if err != nil {
+		return errors.WrapWithMessage(err, UnitSnapshotStoreFailedErrorCode, fmt.Sprintf("failed encoding snapshot %q", snapshot.Key))
+	}
+
+	if err := s.FileSystem.Mkdir(s.directory(), 0755); err != nil {
+		return errors.WrapWithMessage(err, UnitSnapshotStoreFailedErrorCode, fmt.Sprintf("failed creating snapshot directory %q", s.directory()))
+	}
+	if err := s.writeFile(path, data); err != nil {
+		return errors.WrapWithMessage(err, UnitSnapshotStoreFailedErrorCode, fmt.Sprintf("failed saving snapshot %q", snapshot.Key))
+	}
+
+	return nil
+}
+
+// writeFile writes the data of a snapshot file, atomically when supported by the FileSystem.
+func (s FileSystemUnitSnapshotStore) writeFile(path string, data []byte) error {
+	renamer, ok := s.FileSystem.(specter.FileRenamer)
+	if !ok {
+		return s.FileSystem.WriteFile(path, data, 0644)
+	}
+
+	tmp := filepath.Join(filepath.Dir(path), fmt.Sprintf(".%s.%d.tmp", filepath.Base(path), time.Now().UnixNano()))
+	if err := s.FileSystem.WriteFile(tmp, data, 0644); err != nil {
+		_ = s.FileSystem.Remove(tmp)
+		return err
+	}
+	if err := renamer.Rename(tmp, path); err != nil {
+		_ = s.FileSystem.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (s FileSystemUnitSnapshotStore) Load(ctx context.Context, key string) (UnitSnapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return UnitSnapshot{}, err
+	}
+	path, err := s.path(key)
+	if err != nil {
+		return UnitSnapshot{}, err
+	}
+
+	data, err := s.FileSystem.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return UnitSnapshot{}, errors.WrapWithMessage(err, UnitSnapshotNotFoundErrorCode, fmt.Sprintf("snapshot %q not found", key))
+		}
+		return UnitSnapshot{}, errors.WrapWithMessage(err, UnitSnapshotStoreFailedErrorCode, fmt.Sprintf("failed loading snapshot %q", key))
+	}
+
+	var repr unitSnapshotRepresentation
+	if err := json.Unmarshal(data, &repr); err != nil {
+		return UnitSnapshot{}, errors.WrapWithMessage(err, UnitSnapshotStoreFailedErrorCode, fmt.Sprintf("failed decoding snapshot %q", key))
+	}
+
+	snapshot, err := repr.snapshot()
+	if err != nil {
+		return UnitSnapshot{}, errors.WrapWithMessage(err, UnitSnapshotStoreFailedErrorCode, fmt.Sprintf("failed decoding snapshot %q", key))
+	}
+	return snapshot, nil
+}
+
+func (s FileSystemUnitSnapshotStore) Keys(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := s.FileSystem.StatPath(s.directory()); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, errors.WrapWithMessage(err, UnitSnapshotStoreFailedErrorCode, "failed listing snapshots")
+	}
+
+	var keys []string
+	err := s.FileSystem.WalkDir(s.directory(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != s.directory() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if key := strings.TrimSuffix(d.Name(), unitSnapshotFileExtension); key != d.Name() && unitSnapshotKeyRegexp.MatchString(key) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WrapWithMessage(err, UnitSnapshotStoreFailedErrorCode, "failed listing snapshots")
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s FileSystemUnitSnapshotStore) directory() string {
+	if s.Directory == "" {
+		return DefaultUnitSnapshotDirectory
+	}
+	return s.Directory
+}
+
+func (s FileSystemUnitSnapshotStore) path(key string) (string, error) {
+	if !unitSnapshotKeyRegexp.MatchString(key) {
+		return "", errors.NewWithMessage(
+			UnitSnapshotStoreFailedErrorCode,
+			fmt.Sprintf("invalid snapshot key %q, expected letters, digits, dots, dashes or underscores", key),
+		)
+	}
+	return filepath.Join(s.directory(), key+unitSnapshotFileExtension), nil
+}
+
+type unitSnapshotRepresentation struct {
+	Key       string                   `json:"key"`
+	CreatedAt time.Time                `json:"createdAt"`
+	Units     []unitSnapshotUnitRecord `json:"units"`
+}
+
+type unitSnapshotUnitRecord struct {
+	ID         specter.UnitID                `json:"id"`
+	Kind       specter.UnitKind              `json:"kind"`
+	Location   string                        `json:"location,omitempty"`
+	Format     specter.SourceFormat          `json:"format,omitempty"`
+	Range      *specter.SourceRange          `json:"range,omitempty"`
+	Attributes []unitSnapshotAttributeRecord `json:"attributes,omitempty"`
+}
+
+// unitSnapshotAttributeRecord represents a GenericUnitAttribute, either a value along with its cty type, or an object.
+type unitSnapshotAttributeRecord struct {
+	Name       string                        `json:"name"`
+	Range      *specter.SourceRange          `json:"range,omitempty"`
+	Type       json.RawMessage               `json:"type,omitempty"`
+	Value      json.RawMessage               `json:"value,omitempty"`
+	ObjectType AttributeType                 `json:"objectType,omitempty"`
+	Attributes []unitSnapshotAttributeRecord `json:"attributes,omitempty"`
+}
+
+// newUnitSnapshotRepresentation returns the representation of a snapshot. Unsupported units and unresolved attributes
+// are skipped with a warning when a logger is provided, or reported as errors otherwise.
+func newUnitSnapshotRepresentation(snapshot UnitSnapshot, logger specter.Logger) (unitSnapshotRepresentation, error) {
+	repr := unitSnapshotRepresentation{
+		Key:       snapshot.Key,
+		CreatedAt: snapshot.CreatedAt,
+		Units:     []unitSnapshotUnitRecord{},
+	}
+
+	errs := errors.NewGroup(UnitSnapshotStoreFailedErrorCode)
+	for _, u := range snapshot.Units {
+		gu, ok := u.(*GenericUnit)
+		if !ok {
+			if logger != nil {
+				logger.Warning(fmt.Sprintf(
+					"skipping unit %q of kind %q from snapshot %q: unsupported type %T",
+					u.ID(),
+					u.Kind(),
+					snapshot.Key,
+					u,
+				))
+				continue
+			}
+			errs = errs.Append(errors.NewWithMessage(
+				UnitSnapshotStoreFailedErrorCode,
+				fmt.Sprintf("unit %q of kind %q has an unsupported type %T", u.ID(), u.Kind(), u),
+			))
+			continue
+		}
+
+		attributes, unresolved, err := newUnitSnapshotAttributeRecords("", gu.Attributes, logger != nil)
+		if err != nil {
+			errs = errs.Append(errors.WrapWithMessage(
+				err,
+				UnitSnapshotStoreFailedErrorCode,
+				fmt.Sprintf("failed encoding unit %q of kind %q at %q", gu.ID(), gu.Kind(), specter.UnitLocation(gu)),
+			))
+			continue
+		}
+		for _, path := range unresolved {
+			logger.Warning(fmt.Sprintf(
+				"skipping attribute %q of unit %q of kind %q at %q from snapshot %q: its references to other units were not resolved",
+				path,
+				gu.ID(),
+				gu.Kind(),
+				specter.UnitLocation(gu),
+				snapshot.Key,
+			))
+		}
+
+		repr.Units = append(repr.Units, unitSnapshotUnitRecord{
+			ID:         gu.ID(),
+			Kind:       gu.Kind(),
+			Location:   gu.Source().Location,
+			Format:     gu.Source().Format,
+			Range:      snapshotSourceRange(gu.Range),
+			Attributes: attributes,
+		})
+	}
+
+	return repr, errors.GroupOrNil(errs)
+}
+
+// newUnitSnapshotAttributeRecords returns the records of attributes. Attributes with unknown values, such as
+// attributes referencing other units that were not resolved, are reported as errors unless skipUnknown is set,
+// in which case their paths are returned.
+func newUnitSnapshotAttributeRecords(
+	prefix string,
+	attrs []GenericUnitAttribute,
+	skipUnknown bool,
+) (records []unitSnapshotAttributeRecord, skipped []string, err error) {
+	for _, a := range attrs {
+		record := unitSnapshotAttributeRecord{Name: a.Name, Range: snapshotSourceRange(a.Range)}
+		path := genericUnitAttributePathSegment(a)
+		if prefix != "" {
+			path = prefix + UnitAttributePathSeparator + path
+		}
+
+		switch v := a.Value.(type) {
+		case GenericValue:
+			if v.Value == cty.NilVal {
+				break
+			}
+			if !v.IsWhollyKnown() {
+				if skipUnknown {
+					skipped = append(skipped, path)
+					continue
+				}
+				return nil, nil, errors.New(fmt.Sprintf("attribute %q has an unknown value", path))
+			}
+			typ, err := ctyjson.MarshalType(v.Type())
+			if err != nil {
+				return nil, nil, err
+			}
+			value, err := ctyjson.Marshal(v.Value, v.Type())
+			if err != nil {
+				return nil, nil, err
+			}
+			record.Type, record.Value = typ, value
+
+		case ObjectValue:
+			nested, nestedSkipped, err := newUnitSnapshotAttributeRecords(path, v.Attributes, skipUnknown)
+			if err != nil {
+				return nil, nil, err
+			}
+			record.ObjectType = v.Type
+			record.Attributes = nested
+			skipped = append(skipped, nestedSkipped...)
+
+		default:
+			return nil, nil, errors.New(fmt.Sprintf("attribute %q has an unsupported value type %T", path, a.Value))
+		}
+
+		records = append(records, record)
+	}
+	return records, skipped, nil
+}
+
+func (r unitSnapshotRepresentation) snapshot() (UnitSnapshot, error) {
+	snapshot := UnitSnapshot{Key: r.Key, CreatedAt: r.CreatedAt}
+	for _, record := range r.Units {
+		u := NewGenericUnit(record.ID, record.Kind, specter.Source{Location: record.Location, Format: record.Format})
+		if record.Range != nil {
+			u.Range = *record.Range
+		}
+
+		attributes, err := unitSnapshotAttributes(record.Attributes)
+		if err != nil {
+			return UnitSnapshot{}, errors.WrapWithMessage(
+				err,
+				UnitSnapshotStoreFailedErrorCode,
+				fmt.Sprintf("failed decoding unit %q of kind %q", record.ID, record.Kind),
+			)
+		}
+		u.Attributes = attributes
+
+		snapshot.Units = append(snapshot.Units, u)
+	}
+	return snapshot, nil
+}
+
+func unitSnapshotAttributes(records []unitSnapshotAttributeRecord) ([]GenericUnitAttribute, error) {
+	var attrs []GenericUnitAttribute
+	for _, record := range records {
+		attr := GenericUnitAttribute{Name: record.Name}
+		if record.Range != nil {
+			attr.Range = *record.Range
+		}
+
+		switch {
+		case record.ObjectType != "":
+			nested, err := unitSnapshotAttributes(record.Attributes)
+			if err != nil {
+				return nil, err
+			}
+			attr.Value = ObjectValue{Type: record.ObjectType, Attributes: nested}
+
+		case len(record.Type) != 0:
+			typ, err := ctyjson.UnmarshalType(record.Type)
+			if err != nil {
+				return nil, errors.WrapWithMessage(err, UnitSnapshotStoreFailedErrorCode, fmt.Sprintf("invalid type of attribute %q", record.Name))
+			}
+			value, err := ctyjson.Unmarshal(record.Value, typ)
+			if err != nil {
+				return nil, errors.WrapWithMessage(err, UnitSnapshotStoreFailedErrorCode, fmt.Sprintf("invalid value of attribute %q", record.Name))
+			}
+			attr.Value = GenericValue{value}
+
+		default:
+			attr.Value = GenericValue{}
+		}
+
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
+}
+
+func snapshotSourceRange(r specter.SourceRange) *specter.SourceRange {
+	if r.IsZero() {
+		return nil
+	}
+	return &r
+}
+
+var _ specter.ArtifactProcessor = UnitSnapshotProcessor{}
+
+// UnitSnapshotProcessor is a specter.ArtifactProcessor saving the units of a run to a UnitSnapshotStore under a set of
+// Labels and, optionally, under a run ID. It should be registered last, so that snapshots are only saved once all the
+// artifacts of a run were successfully processed.
+type UnitSnapshotProcessor struct {
+	Store UnitSnapshotStore
+
+	// Labels under which the snapshot is saved. Defaults to LatestUnitSnapshotKey.
+	Labels []string
+
+	// SaveRunID indicates if the snapshot should also be saved under a run ID, as returned by NewUnitSnapshotRunID.
+	SaveRunID bool
+
+	TimeProvider specter.TimeProvider
+}
+
+func NewUnitSnapshotProcessor(store UnitSnapshotStore, labels ...string) *UnitSnapshotProcessor {
+	if len(labels) == 0 {
+		labels = []string{LatestUnitSnapshotKey}
+	}
+	return &UnitSnapshotProcessor{
+		Store:        store,
+		Labels:       labels,
+		TimeProvider: specter.CurrentTimeProvider,
+	}
+}
+
+// WithRunID also saves the snapshots under a run ID.
+func (p *UnitSnapshotProcessor) WithRunID() *UnitSnapshotProcessor {
+	p.SaveRunID = true
+	return p
+}
+
+func (p UnitSnapshotProcessor) Name() string {
+	return "unit_snapshot_processor"
+}
+
+func (p UnitSnapshotProcessor) Process(ctx specter.ArtifactProcessingContext) error {
+	now := time.Now()
+	if p.TimeProvider != nil {
+		now = p.TimeProvider()
+	}
+
+	keys := append([]string(nil), p.Labels...)
+	if len(keys) == 0 {
+		keys = append(keys, LatestUnitSnapshotKey)
+	}
+	if p.SaveRunID {
+		keys = append(keys, NewUnitSnapshotRunID(now))
+	}
+
+	for _, key := range keys {
+		if err := p.Store.Save(ctx, UnitSnapshot{Key: key, CreatedAt: now, Units: ctx.Units}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var _ UnitBaselineProvider = UnitSnapshotBaseline{}
+
+// UnitSnapshotBaseline is a UnitBaselineProvider providing the units of a snapshot of a UnitSnapshotStore, such as the
+// one saved under LatestUnitSnapshotKey by a UnitSnapshotProcessor. When the snapshot does not exist, as on a first
+// run, the baseline has no units.
+type UnitSnapshotBaseline struct {
+	Store UnitSnapshotStore
+	Key   string
+}
+
+func (b UnitSnapshotBaseline) BaselineUnits(ctx context.Context) (specter.UnitGroup, error) {
+	key := b.Key
+	if key == "" {
+		key = LatestUnitSnapshotKey
+	}
+
+	snapshot, err := b.Store.Load(ctx, key)
+	if err != nil {
+		if errors.HasCode(err, UnitSnapshotNotFoundErrorCode) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return snapshot.Units, nil
+}
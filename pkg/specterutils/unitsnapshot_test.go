@@ -0,0 +1,209 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/fs"
+	"testing"
+	"time"
+)
+
+var _ specter.ArtifactProcessor = (*specterutils.UnitSnapshotProcessor)(nil)
+
+func TestFileSystemUnitSnapshotStore_SaveLoad(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	units := loadGenericUnits(t, fs, "/specs/services.hcl", `service "billing" {
+  version = "1.2.0"
+  replicas = 2.5
+  tags = ["payments", "core"]
+  labels = { team = "billing" }
+  enabled = true
+
+  env "prod" {
+    replicas = 3
+  }
+}
+`)
+	units = append(units, loadGenericUnits(t, fs, "/specs/orders.yaml", "kind: service\nid: orders\nversion: 1.0.0\n")...)
+
+	store := specterutils.NewFileSystemUnitSnapshotStore(fs, "/snapshots")
+	createdAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, store.Save(context.Background(), specterutils.UnitSnapshot{
+		Key:       "latest",
+		CreatedAt: createdAt,
+		Units:     unitsOf(units),
+	}))
+
+	snapshot, err := store.Load(context.Background(), "latest")
+	require.NoError(t, err)
+	assert.Equal(t, "latest", snapshot.Key)
+	assert.True(t, createdAt.Equal(snapshot.CreatedAt))
+	require.Len(t, snapshot.Units, 2)
+
+	rule := specterutils.NewGenericUnitChangeRule()
+	rule.IgnoredAttributes = []string{}
+	for i, u := range snapshot.Units {
+		restored := u.(*specterutils.GenericUnit)
+		assert.Equal(t, units[i].ID(), restored.ID())
+		assert.Equal(t, units[i].Kind(), restored.Kind())
+		assert.Equal(t, units[i].Source().Location, restored.Source().Location)
+		assert.Equal(t, units[i].Source().Format, restored.Source().Format)
+		assert.Equal(t, units[i].Range, restored.Range)
+
+		changes, err := rule.Compare(units[i], restored)
+		require.NoError(t, err)
+		assert.Empty(t, changes)
+	}
+
+	billing := snapshot.Units[0].(*specterutils.GenericUnit)
+	assert.Equal(t, units[0].Attribute("tags").Range, billing.Attribute("tags").Range)
+	assert.Equal(t, "1.2.0", billing.Attribute("version").Value.String())
+
+	keys, err := store.Keys(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"latest"}, keys)
+}
+
+func TestFileSystemUnitSnapshotStore_errors(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	store := specterutils.NewFileSystemUnitSnapshotStore(fs, "/snapshots")
+
+	keys, err := store.Keys(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	_, err = store.Load(context.Background(), "latest")
+	testutils.RequireErrorWithCode(specterutils.UnitSnapshotNotFoundErrorCode)(t, err)
+
+	err = store.Save(context.Background(), specterutils.UnitSnapshot{Key: "../escape"})
+	testutils.RequireErrorWithCode(specterutils.UnitSnapshotStoreFailedErrorCode)(t, err)
+
+	err = store.Save(context.Background(), specterutils.UnitSnapshot{
+		Key:   "latest",
+		Units: specter.UnitGroup{&mockUnit{name: "billing", kind: "service"}},
+	})
+	testutils.RequireErrorWithCode(specterutils.UnitSnapshotStoreFailedErrorCode)(t, err)
+}
+
+// renameFailingFileSystem is a specter.MemoryFileSystem failing to rename files.
+type renameFailingFileSystem struct {
+	*specter.MemoryFileSystem
+}
+
+func (renameFailingFileSystem) Rename(string, string) error {
+	return errors.New("rename failed")
+}
+
+func TestFileSystemUnitSnapshotStore_Save_atomic(t *testing.T) {
+	mfs := &specter.MemoryFileSystem{}
+	units := unitsOf(loadGenericUnits(t, mfs, "/specs/services.hcl", `service "billing" {}`))
+
+	store := specterutils.NewFileSystemUnitSnapshotStore(mfs, "/snapshots")
+	require.NoError(t, store.Save(context.Background(), specterutils.UnitSnapshot{Key: "latest", Units: units}))
+
+	// A save failing to replace the snapshot file should leave the previous snapshot intact.
+	failing := specterutils.NewFileSystemUnitSnapshotStore(renameFailingFileSystem{mfs}, "/snapshots")
+	err := failing.Save(context.Background(), specterutils.UnitSnapshot{Key: "latest"})
+	testutils.RequireErrorWithCode(specterutils.UnitSnapshotStoreFailedErrorCode)(t, err)
+
+	snapshot, err := store.Load(context.Background(), "latest")
+	require.NoError(t, err)
+	require.Len(t, snapshot.Units, 1)
+
+	// No temporary file should be left behind.
+	var files []string
+	require.NoError(t, mfs.WalkDir("/snapshots", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, path)
+		}
+		return err
+	}))
+	assert.Equal(t, []string{"/snapshots/latest.json"}, files)
+}
+
+func TestFileSystemUnitSnapshotStore_Save_mixedUnits(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	units := unitsOf(loadGenericUnits(t, fs, "/specs/services.hcl", `service "billing" {
+  image = service.base.image
+  replicas = 2
+
+  env "prod" {
+    region = service.base.region
+  }
+}
+`))
+	units = append(units, &mockUnit{name: "shipping", kind: "service"})
+
+	buffer := &bytes.Buffer{}
+	store := specterutils.NewFileSystemUnitSnapshotStore(fs, "/snapshots")
+	store.Logger = specter.NewDefaultLogger(specter.DefaultLoggerConfig{DisableColors: true, Writer: buffer})
+	require.NoError(t, store.Save(context.Background(), specterutils.UnitSnapshot{Key: "latest", Units: units}))
+
+	snapshot, err := store.Load(context.Background(), "latest")
+	require.NoError(t, err)
+	require.Len(t, snapshot.Units, 1)
+	billing := snapshot.Units[0].(*specterutils.GenericUnit)
+	assert.Equal(t, specter.UnitID("billing"), billing.ID())
+	assert.Nil(t, billing.Attribute("image"))
+	assert.NotNil(t, billing.Attribute("replicas"))
+
+	logs := buffer.String()
+	assert.Contains(t, logs, `skipping unit "shipping" of kind "service" from snapshot "latest": unsupported type`)
+	assert.Contains(t, logs, `skipping attribute "image" of unit "billing" of kind "service"`)
+	assert.Contains(t, logs, `skipping attribute "env:prod.region" of unit "billing" of kind "service"`)
+
+	// Without a logger, the units that cannot be saved fail the save.
+	store.Logger = nil
+	err = store.Save(context.Background(), specterutils.UnitSnapshot{Key: "latest", Units: units})
+	testutils.RequireErrorWithCode(specterutils.UnitSnapshotStoreFailedErrorCode)(t, err)
+}
+
+func TestUnitSnapshotProcessor_Process(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	units := loadGenericUnits(t, fs, "/specs/services.hcl", `service "billing" {
+  version = "1.0.0"
+}
+`)
+	store := specterutils.NewFileSystemUnitSnapshotStore(fs, "/snapshots")
+
+	// Before the first run, the baseline should have no units.
+	baseline := specterutils.UnitSnapshotBaseline{Store: store}
+	previous, err := baseline.BaselineUnits(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, previous)
+
+	p := specterutils.NewUnitSnapshotProcessor(store, "latest", "release-1").WithRunID()
+	p.TimeProvider = func() time.Time {
+		return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	}
+	require.NoError(t, p.Process(specter.ArtifactProcessingContext{Context: context.Background(), Units: unitsOf(units)}))
+
+	keys, err := store.Keys(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"20240301T120000.000000000Z", "latest", "release-1"}, keys)
+
+	previous, err = baseline.BaselineUnits(context.Background())
+	require.NoError(t, err)
+	require.Len(t, previous, 1)
+	assert.Equal(t, specter.UnitID("billing"), previous[0].ID())
+}
@@ -17,10 +17,46 @@ package specterutils
 import (
 	"fmt"
 	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
 )
 
+// DefaultVersionAttribute is the attribute of a GenericUnit holding its version.
+const DefaultVersionAttribute = "version"
+
 type UnitVersion string
 
+// SemVer parses this version as a semantic version.
+func (v UnitVersion) SemVer() (SemVer, error) {
+	return ParseSemVer(string(v))
+}
+
+// Compare returns -1, 0 or 1 when this version has a lower, equal or greater precedence than another one.
+// An error is returned if either version is not a valid semantic version.
+func (v UnitVersion) Compare(o UnitVersion) (int, error) {
+	a, err := v.SemVer()
+	if err != nil {
+		return 0, err
+	}
+	b, err := o.SemVer()
+	if err != nil {
+		return 0, err
+	}
+	return a.Compare(b), nil
+}
+
+// Satisfies indicates if this version satisfies a VersionConstraint such as "^1.2" or ">=2.0 <3".
+func (v UnitVersion) Satisfies(constraint string) (bool, error) {
+	sv, err := v.SemVer()
+	if err != nil {
+		return false, err
+	}
+	c, err := ParseVersionConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+	return c.Check(sv), nil
+}
+
 type HasVersion interface {
 	specter.Unit
 
@@ -45,9 +81,92 @@ func HasVersionMustHaveAVersionLinter(severity LinterResultSeverity) UnitLinter
 
 			r = append(r, LinterResult{
 				Severity: severity,
-				Message:  fmt.Sprintf("unit %q at %q should have a version", unit.ID(), unit.Source().Location),
+				Message:  fmt.Sprintf("unit %q at %q should have a version", unit.ID(), specter.UnitLocation(unit)),
+			})
+		}
+		return r
+	})
+}
+
+// UnitVersionOf returns the version of a unit, either from HasVersion or from the DefaultVersionAttribute of a
+// GenericUnit. It returns false when the unit has no version.
+func UnitVersionOf(u specter.Unit) (UnitVersion, bool) {
+	if v, ok := u.(HasVersion); ok {
+		return v.Version(), v.Version() != ""
+	}
+
+	gu, ok := u.(*GenericUnit)
+	if !ok {
+		return "", false
+	}
+	attr := gu.Attribute(DefaultVersionAttribute)
+	if attr == nil {
+		return "", false
+	}
+	v, ok := attr.Value.(GenericValue)
+	if !ok || v.IsNull() || !v.IsKnown() || v.Type() != cty.String || v.AsString() == "" {
+		return "", false
+	}
+	return UnitVersion(v.AsString()), true
+}
+
+// UnitVersionsMustBeSemVerLinter ensures that the versions of units are valid semantic versions.
+// Units without a version are ignored.
+func UnitVersionsMustBeSemVerLinter(severity LinterResultSeverity) UnitLinter {
+	return UnitLinterFunc(func(units specter.UnitGroup) LinterResultSet {
+		var r LinterResultSet
+		for _, u := range units {
+			v, ok := UnitVersionOf(u)
+			if !ok {
+				continue
+			}
+			if _, err := v.SemVer(); err == nil {
+				continue
+			}
+
+			r = append(r, LinterResult{
+				Severity: severity,
+				Message: fmt.Sprintf(
+					"unit %q at %q has an invalid version %q, expected a semantic version such as \"1.2.3\"",
+					u.ID(),
+					specter.UnitLocation(u),
+					v,
+				),
+				Range: specter.UnitSourceRange(u),
 			})
 		}
 		return r
 	})
 }
+
+// DependencyConstraintsMustBeValidLinter ensures that the version constraints of the dependencies of units,
+// as returned by providers, are valid.
+func DependencyConstraintsMustBeValidLinter(severity LinterResultSeverity, providers ...DependencyProvider) UnitLinter {
+	return UnitLinterFunc(func(units specter.UnitGroup) LinterResultSet {
+		var r LinterResultSet
+		for _, u := range units {
+			for _, d := range provideDependencies(u, providers) {
+				_, constraint := ParseUnitDependency(d)
+				if constraint == "" {
+					continue
+				}
+				if _, err := ParseVersionConstraint(constraint); err == nil {
+					continue
+				}
+
+				r = append(r, LinterResult{
+					Severity: severity,
+					Message: fmt.Sprintf(
+						"unit %q at %q has a dependency %q with an invalid version constraint %q",
+						u.ID(),
+						specter.UnitLocation(u),
+						d,
+						constraint,
+					),
+					Range: specter.UnitSourceRange(u),
+				})
+			}
+		}
+		return r
+	})
+}
@@ -17,6 +17,7 @@ package specterutils_test
 import (
 	"github.com/morebec/specter/pkg/specter"
 	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
@@ -134,3 +135,103 @@ func TestHasVersionMustHaveAVersionLinter(t *testing.T) {
 		})
 	}
 }
+
+func TestUnitVersion_Satisfies(t *testing.T) {
+	tests := []struct {
+		name       string
+		given      specterutils.UnitVersion
+		constraint string
+		then       bool
+		thenError  require.ErrorAssertionFunc
+	}{
+		{
+			name:       "GIVEN a version satisfying a constraint THEN should return true",
+			given:      "1.4.0",
+			constraint: "^1.2",
+			then:       true,
+		},
+		{
+			name:       "GIVEN a version not satisfying a constraint THEN should return false",
+			given:      "3.0.0",
+			constraint: ">=2.0 <3",
+			then:       false,
+		},
+		{
+			name:       "GIVEN an invalid version THEN should return an error",
+			given:      "v1",
+			constraint: "^1",
+			thenError:  testutils.RequireErrorWithCode(specterutils.InvalidVersionErrorCode),
+		},
+		{
+			name:       "GIVEN an invalid constraint THEN should return an error",
+			given:      "1.0.0",
+			constraint: "^a",
+			thenError:  testutils.RequireErrorWithCode(specterutils.InvalidVersionConstraintErrorCode),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.given.Satisfies(tt.constraint)
+			if tt.thenError != nil {
+				tt.thenError(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.then, got)
+		})
+	}
+
+	c, err := specterutils.UnitVersion("1.10.0").Compare("1.9.0")
+	require.NoError(t, err)
+	require.Equal(t, 1, c)
+}
+
+func TestUnitVersionsMustBeSemVerLinter(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	units := loadGenericUnits(t, fs, "/specs/services.hcl", `service "billing" {
+  version = "2.1.0"
+}
+
+service "orders" {
+  version = "2.1"
+}
+
+service "shipping" {}
+`)
+
+	results := specterutils.UnitVersionsMustBeSemVerLinter(specterutils.ErrorSeverity).Lint(specter.UnitGroup{
+		units[0],
+		units[1],
+		units[2],
+		&mockUnit{name: "payments", version: "latest"},
+	})
+
+	require.Len(t, results, 2)
+	require.Equal(t, specterutils.LinterResult{
+		Severity: specterutils.ErrorSeverity,
+		Message:  `unit "orders" at "/specs/services.hcl:5:1" has an invalid version "2.1", expected a semantic version such as "1.2.3"`,
+		Range:    units[1].SourceRange(),
+	}, results[0])
+	require.Equal(t, `unit "payments" at "" has an invalid version "latest", expected a semantic version such as "1.2.3"`, results[1].Message)
+}
+
+func TestDependencyConstraintsMustBeValidLinter(t *testing.T) {
+	fs := &specter.MemoryFileSystem{}
+	units := loadGenericUnits(t, fs, "/specs/services.hcl", `service "orders" {
+  depends_on = ["billing@^2", "shipping@latest", "payments"]
+}
+`)
+
+	results := specterutils.DependencyConstraintsMustBeValidLinter(
+		specterutils.WarningSeverity,
+		specterutils.NewDependsOnDependencyProvider(),
+	).Lint(unitsOf(units))
+
+	require.Equal(t, specterutils.LinterResultSet{
+		{
+			Severity: specterutils.WarningSeverity,
+			Message:  `unit "orders" at "/specs/services.hcl:1:1" has a dependency "shipping@latest" with an invalid version constraint "latest"`,
+			Range:    units[0].SourceRange(),
+		},
+	}, results)
+}
@@ -0,0 +1,320 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+	"io"
+	"math"
+	"unicode/utf8"
+)
+
+const (
+	YAMLSourceFormat = specter.YAMLSourceFormat
+)
+
+const InvalidYAMLErrorCode = "specter.spec_loading.invalid_yaml"
+
+const (
+	// YAMLUnitKindKey is the top-level key of a YAML document holding the kind of the unit it defines.
+	YAMLUnitKindKey = "kind"
+
+	// YAMLUnitIDKey is the top-level key of a YAML document holding the ID of the unit it defines.
+	YAMLUnitIDKey = "id"
+)
+
+// NewYAMLGenericUnitLoader this UnitLoader will load all Units to instances of GenericUnit.
+func NewYAMLGenericUnitLoader() *YAMLGenericUnitLoader {
+	return &YAMLGenericUnitLoader{}
+}
+
+// YAMLGenericUnitLoader this UnitLoader loads Units as GenericUnit from YAML sources.
+//
+// Every document of a source (including multi-document sources separated by "---") defines a single unit
+// using the top-level keys "kind" and "id". All other top-level keys become attributes of the unit with their value
+// converted to a cty.Value, so that processors relying on GenericUnit.Attribute work regardless of the format:
+//
+//	kind: service
+//	id: billing
+//	description: Handles invoices.
+//	replicas: 3
+//	ports: [80, 443]
+type YAMLGenericUnitLoader struct{}
+
+func (l YAMLGenericUnitLoader) SupportsSource(s specter.Source) bool {
+	return s.Format == YAMLSourceFormat
+}
+
+func (l YAMLGenericUnitLoader) Load(s specter.Source) ([]specter.Unit, error) {
+	// Although the caller is responsible for calling YAMLGenericUnitLoader.SupportsSource, guard against it.
+	if !l.SupportsSource(s) {
+		return nil, errors.NewWithMessage(
+			specter.UnsupportedSourceErrorCode,
+			fmt.Sprintf(
+				"invalid unit source %q, unsupported format %q",
+				s.Location,
+				s.Format,
+			),
+		)
+	}
+
+	var units []specter.Unit
+	group := errors.NewGroup(InvalidYAMLErrorCode)
+
+	decoder := yaml.NewDecoder(bytes.NewReader(s.Data))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, errors.WrapWithMessage(err, InvalidYAMLErrorCode, fmt.Sprintf("invalid unit source %q", s.Location))
+		}
+
+		// Skip empty documents such as the one following a trailing "---".
+		if len(doc.Content) == 0 || isYAMLNullNode(doc.Content[0]) {
+			continue
+		}
+
+		unit, err := l.unitFromDocument(s, doc.Content[0])
+		if err != nil {
+			group = group.Append(err)
+			continue
+		}
+		units = append(units, unit)
+	}
+
+	return units, errors.GroupOrNil(group)
+}
+
+func (l YAMLGenericUnitLoader) unitFromDocument(s specter.Source, node *yaml.Node) (*GenericUnit, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, newInvalidYAMLError(s, node, "document should be a mapping")
+	}
+
+	unit := &GenericUnit{source: s, Range: newYAMLSourceRange(s, node)}
+	var kindNode, idNode *yaml.Node
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		switch key.Value {
+		case YAMLUnitKindKey:
+			kindNode = value
+		case YAMLUnitIDKey:
+			idNode = value
+		default:
+			v, err := yamlNodeToCtyValue(s, value)
+			if err != nil {
+				return nil, err
+			}
+			unit.Attributes = append(unit.Attributes, GenericUnitAttribute{
+				Name:  key.Value,
+				Value: GenericValue{v},
+				Range: newYAMLSourceRange(s, key),
+			})
+		}
+	}
+
+	if kindNode == nil || !isYAMLStringNode(kindNode) || kindNode.Value == "" {
+		return nil, newInvalidYAMLError(s, node, fmt.Sprintf("document should contain a %q key", YAMLUnitKindKey))
+	}
+	unit.typ = specter.UnitKind(kindNode.Value)
+
+	if idNode == nil || !isYAMLStringNode(idNode) || idNode.Value == "" {
+		return nil, newInvalidYAMLError(
+			s,
+			node,
+			fmt.Sprintf("document of kind %q should contain an %q key", kindNode.Value, YAMLUnitIDKey),
+		)
+	}
+	unit.UnitID = specter.UnitID(idNode.Value)
+
+	return unit, nil
+}
+
+func isYAMLNullNode(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
+
+func isYAMLStringNode(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!str"
+}
+
+// yamlNodeToCtyValue converts a YAML node to its cty.Value equivalent.
+// Mappings are converted to objects and sequences to tuples, like their HCL counterparts.
+func yamlNodeToCtyValue(s specter.Source, node *yaml.Node) (cty.Value, error) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return yamlNodeToCtyValue(s, node.Alias)
+
+	case yaml.ScalarNode:
+		switch node.Tag {
+		case "!!null":
+			return cty.NullVal(cty.DynamicPseudoType), nil
+		case "!!bool":
+			var b bool
+			if err := node.Decode(&b); err != nil {
+				return cty.NilVal, newInvalidYAMLError(s, node, err.Error())
+			}
+			return cty.BoolVal(b), nil
+		case "!!int", "!!float":
+			var i int64
+			if node.Tag == "!!int" && node.Decode(&i) == nil {
+				return cty.NumberIntVal(i), nil
+			}
+			if v, err := cty.ParseNumberVal(node.Value); err == nil {
+				return v, nil
+			}
+			// Fallback for notations not supported by cty such as infinity.
+			var n float64
+			if err := node.Decode(&n); err != nil {
+				return cty.NilVal, newInvalidYAMLError(s, node, err.Error())
+			}
+			if math.IsNaN(n) {
+				return cty.NilVal, newInvalidYAMLError(s, node, "NaN is not a supported number")
+			}
+			return cty.NumberFloatVal(n), nil
+		default:
+			return cty.StringVal(node.Value), nil
+		}
+
+	case yaml.SequenceNode:
+		if len(node.Content) == 0 {
+			return cty.EmptyTupleVal, nil
+		}
+		values := make([]cty.Value, 0, len(node.Content))
+		for _, n := range node.Content {
+			v, err := yamlNodeToCtyValue(s, n)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			values = append(values, v)
+		}
+		return cty.TupleVal(values), nil
+
+	case yaml.MappingNode:
+		attrs := map[string]cty.Value{}
+		if err := yamlMappingToCtyAttributes(s, node, attrs); err != nil {
+			return cty.NilVal, err
+		}
+		if len(attrs) == 0 {
+			return cty.EmptyObjectVal, nil
+		}
+		return cty.ObjectVal(attrs), nil
+
+	default:
+		return cty.NilVal, newInvalidYAMLError(s, node, "unsupported value")
+	}
+}
+
+// yamlMappingToCtyAttributes converts the entries of a YAML mapping node to cty attributes, resolving merge keys ("<<").
+func yamlMappingToCtyAttributes(s specter.Source, node *yaml.Node, attrs map[string]cty.Value) error {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		if key.Kind != yaml.ScalarNode {
+			return newInvalidYAMLError(s, key, "mapping keys should be scalars")
+		}
+
+		if key.Tag == "!!merge" {
+			if err := yamlMergeToCtyAttributes(s, value, attrs); err != nil {
+				return err
+			}
+			continue
+		}
+
+		v, err := yamlNodeToCtyValue(s, value)
+		if err != nil {
+			return err
+		}
+		attrs[key.Value] = v
+	}
+
+	return nil
+}
+
+func yamlMergeToCtyAttributes(s specter.Source, node *yaml.Node, attrs map[string]cty.Value) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		merged := map[string]cty.Value{}
+		if err := yamlMappingToCtyAttributes(s, node, merged); err != nil {
+			return err
+		}
+		// Explicit keys take precedence over merged ones.
+		for k, v := range merged {
+			if _, ok := attrs[k]; !ok {
+				attrs[k] = v
+			}
+		}
+		return nil
+	case yaml.SequenceNode:
+		for _, n := range node.Content {
+			if err := yamlMergeToCtyAttributes(s, n, attrs); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return newInvalidYAMLError(s, node, "merge keys should reference mappings")
+	}
+}
+
+// newYAMLSourceRange returns the range of a YAML node. Since YAML nodes only provide their starting position,
+// the range starts and ends at that position.
+func newYAMLSourceRange(s specter.Source, node *yaml.Node) specter.SourceRange {
+	pos := specter.SourcePos{Line: node.Line, Column: node.Column, Byte: lineColumnToOffset(s.Data, node.Line, node.Column)}
+	return specter.SourceRange{Filename: s.Location, Start: pos, End: pos}
+}
+
+// lineColumnToOffset converts a 1-based line and column (in characters) to a byte offset in some data.
+func lineColumnToOffset(data []byte, line int, column int) int {
+	offset := 0
+	for l := 1; l < line; l++ {
+		i := bytes.IndexByte(data[offset:], '\n')
+		if i == -1 {
+			return len(data)
+		}
+		offset += i + 1
+	}
+
+	for c := 1; c < column && offset < len(data) && data[offset] != '\n'; c++ {
+		_, size := utf8.DecodeRune(data[offset:])
+		offset += size
+	}
+
+	return offset
+}
+
+func newInvalidYAMLError(s specter.Source, node *yaml.Node, msg string) error {
+	return errors.NewWithMessage(
+		InvalidYAMLErrorCode,
+		fmt.Sprintf(
+			"invalid unit source %q at line %d:%d, %s",
+			s.Location,
+			node.Line,
+			node.Column,
+			msg,
+		),
+	)
+}
@@ -0,0 +1,372 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/morebec/go-errors/errors"
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/zclconf/go-cty/cty"
+	"gopkg.in/yaml.v3"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var _ UnitSourceRewriter = YAMLUnitSourceRewriter{}
+
+// YAMLUnitSourceRewriter is a UnitSourceRewriter for YAML sources. The documents of the mutated units are rewritten
+// from their node tree, preserving comments, the order of keys, the quoting of scalars and the style of collections.
+// Since they are re-encoded as a whole, their layout is normalized: indentation is changed to Indent spaces and
+// comments on the document start marker are moved to their own line. The other documents of a source are left
+// untouched.
+//
+// Units are located in a source by their range, and otherwise by their kind and ID.
+type YAMLUnitSourceRewriter struct {
+	// Indent is the number of spaces used for indentation. Defaults to 2.
+	Indent int
+}
+
+func NewYAMLUnitSourceRewriter() YAMLUnitSourceRewriter {
+	return YAMLUnitSourceRewriter{Indent: 2}
+}
+
+func (r YAMLUnitSourceRewriter) SupportsSource(s specter.Source) bool {
+	return s.Format == YAMLSourceFormat
+}
+
+func (r YAMLUnitSourceRewriter) Rewrite(s specter.Source, changes []UnitMutations) ([]byte, error) {
+	var docs []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(s.Data))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, errors.WrapWithMessage(err, InvalidYAMLErrorCode, fmt.Sprintf("invalid unit source %q", s.Location))
+		}
+		docs = append(docs, &doc)
+	}
+
+	errs := errors.NewGroup(UnitMutationFailedErrorCode)
+	mutated := map[*yaml.Node]bool{}
+	for _, c := range changes {
+		doc := yamlUnitDocument(c.Unit, docs)
+		if doc == nil {
+			errs = errs.Append(errors.NewWithMessage(
+				UnitMutationFailedErrorCode,
+				fmt.Sprintf("unit %q of kind %q not found in source %q", c.Unit.ID(), c.Unit.Kind(), s.Location),
+			))
+			continue
+		}
+		mutated[doc] = true
+
+		for _, m := range c.Mutations {
+			if err := applyYAMLUnitMutation(doc.Content[0], m); err != nil {
+				errs = errs.Append(newUnitMutationError(c.Unit, err))
+			}
+		}
+	}
+	if err := errors.GroupOrNil(errs); err != nil {
+		return nil, err
+	}
+
+	indent := r.Indent
+	if indent <= 0 {
+		indent = 2
+	}
+
+	chunks := yamlDocumentChunks(s.Data)
+	if len(chunks) != len(docs) {
+		// The documents could not be delimited in the source, so they are all re-encoded.
+		data, err := encodeYAMLDocuments(docs, indent)
+		if err != nil {
+			return nil, errors.WrapWithMessage(err, UnitMutationFailedErrorCode, fmt.Sprintf("failed encoding source %q", s.Location))
+		}
+		return data, nil
+	}
+
+	var buf bytes.Buffer
+	for i, doc := range docs {
+		if !mutated[doc] {
+			buf.Write(chunks[i])
+			continue
+		}
+
+		if bytes.HasPrefix(chunks[i], []byte(yamlDocumentStartMarker)) {
+			buf.WriteString(yamlDocumentStartMarker + "\n")
+		}
+		data, err := encodeYAMLDocuments([]*yaml.Node{doc}, indent)
+		if err != nil {
+			return nil, errors.WrapWithMessage(err, UnitMutationFailedErrorCode, fmt.Sprintf("failed encoding source %q", s.Location))
+		}
+		buf.Write(data)
+	}
+
+	return buf.Bytes(), nil
+}
+
+const yamlDocumentStartMarker = "---"
+
+// yamlDocumentChunks splits YAML data into the content of its documents, each starting with its document start marker
+// if any. Content preceding the first marker is part of the first document, unless it is blank.
+func yamlDocumentChunks(data []byte) [][]byte {
+	var chunks [][]byte
+	start := 0
+	for offset := 0; offset < len(data); {
+		end := bytes.IndexByte(data[offset:], '\n') + 1
+		if end == 0 {
+			end = len(data) - offset
+		}
+		line := data[offset : offset+end]
+
+		marker := bytes.HasPrefix(line, []byte(yamlDocumentStartMarker)) &&
+			(len(line) == len(yamlDocumentStartMarker) || strings.ContainsRune(" \t\r\n", rune(line[len(yamlDocumentStartMarker)])))
+		if marker && offset != 0 {
+			if chunk := data[start:offset]; len(chunks) != 0 || len(bytes.TrimSpace(chunk)) != 0 {
+				chunks = append(chunks, chunk)
+			}
+			start = offset
+		}
+		offset += end
+	}
+	if start < len(data) {
+		chunks = append(chunks, data[start:])
+	}
+	return chunks
+}
+
+func encodeYAMLDocuments(docs []*yaml.Node, indent int) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(indent)
+	for _, doc := range docs {
+		if err := encoder.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// yamlUnitDocument returns the document defining a unit, whose content is a mapping node.
+func yamlUnitDocument(u *GenericUnit, docs []*yaml.Node) *yaml.Node {
+	for _, doc := range docs {
+		if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+			continue
+		}
+		node := doc.Content[0]
+
+		if !u.Range.IsZero() {
+			if node.Line == u.Range.Start.Line && node.Column == u.Range.Start.Column {
+				return doc
+			}
+			continue
+		}
+
+		kind := yamlMappingValue(node, YAMLUnitKindKey)
+		id := yamlMappingValue(node, YAMLUnitIDKey)
+		if kind != nil && id != nil && kind.Value == string(u.Kind()) && id.Value == string(u.ID()) {
+			return doc
+		}
+	}
+	return nil
+}
+
+func applyYAMLUnitMutation(node *yaml.Node, m UnitMutation) error {
+	switch m := m.(type) {
+	case SetAttributeMutation:
+		if len(m.Path) == 0 {
+			return errors.New("attribute path should not be empty")
+		}
+		keys := unitAttributeKeys(m.Path)
+		mapping, err := yamlNestedMapping(node, keys[:len(keys)-1], false)
+		if err != nil {
+			return err
+		}
+		value, err := ctyValueToYAMLNode(m.Value)
+		if err != nil {
+			return err
+		}
+		setYAMLMappingValue(mapping, keys[len(keys)-1], value)
+
+	case RemoveAttributeMutation:
+		if len(m.Path) == 0 {
+			return errors.New("attribute path should not be empty")
+		}
+		keys := unitAttributeKeys(m.Path)
+		mapping, err := yamlNestedMapping(node, keys[:len(keys)-1], false)
+		if err != nil {
+			return err
+		}
+		if !removeYAMLMappingValue(mapping, keys[len(keys)-1]) {
+			return errors.New(fmt.Sprintf("attribute %q not found", keys[len(keys)-1]))
+		}
+
+	case AddBlockMutation:
+		parent, err := yamlNestedMapping(node, unitAttributeKeys(m.Path), false)
+		if err != nil {
+			return err
+		}
+		keys := append([]string{m.Type}, m.Labels...)
+		mapping, err := yamlNestedMapping(parent, keys[:len(keys)-1], true)
+		if err != nil {
+			return err
+		}
+		if yamlMappingValue(mapping, keys[len(keys)-1]) != nil {
+			return errors.New(fmt.Sprintf("block %q already exists", keys[len(keys)-1]))
+		}
+		block := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, name := range m.attributeNames() {
+			value, err := ctyValueToYAMLNode(m.Attributes[name])
+			if err != nil {
+				return err
+			}
+			setYAMLMappingValue(block, name, value)
+		}
+		setYAMLMappingValue(mapping, keys[len(keys)-1], block)
+
+	case RenameUnitMutation:
+		setYAMLMappingValue(node, YAMLUnitIDKey, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: string(m.ID)})
+
+	case ChangeUnitKindMutation:
+		setYAMLMappingValue(node, YAMLUnitKindKey, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: string(m.Kind)})
+
+	default:
+		return errors.New(fmt.Sprintf("unsupported mutation %T", m))
+	}
+
+	return nil
+}
+
+// yamlNestedMapping returns the mapping node designated by keys, optionally creating the missing ones.
+func yamlNestedMapping(node *yaml.Node, keys []string, create bool) (*yaml.Node, error) {
+	for _, key := range keys {
+		value := yamlMappingValue(node, key)
+		if value == nil {
+			if !create {
+				return nil, errors.New(fmt.Sprintf("attribute %q not found", key))
+			}
+			value = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			setYAMLMappingValue(node, key, value)
+		}
+		if value.Kind == yaml.AliasNode {
+			value = value.Alias
+		}
+		if value.Kind != yaml.MappingNode {
+			return nil, errors.New(fmt.Sprintf("attribute %q is not a mapping", key))
+		}
+		node = value
+	}
+	return node, nil
+}
+
+func yamlMappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// setYAMLMappingValue sets the value of a key of a mapping. The comments of an existing value are preserved.
+func setYAMLMappingValue(node *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != key {
+			continue
+		}
+		old := node.Content[i+1]
+		value.HeadComment, value.LineComment, value.FootComment = old.HeadComment, old.LineComment, old.FootComment
+		node.Content[i+1] = value
+		return
+	}
+
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+func removeYAMLMappingValue(node *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ctyValueToYAMLNode converts a cty value to a YAML node. Numbers are written with their exact representation.
+func ctyValueToYAMLNode(v cty.Value) (*yaml.Node, error) {
+	if !v.IsKnown() {
+		return nil, errors.New("value should be known")
+	}
+	if v.IsNull() {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	}
+
+	t := v.Type()
+	switch {
+	case t == cty.String:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v.AsString()}, nil
+
+	case t == cty.Bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v.True())}, nil
+
+	case t == cty.Number:
+		// Numbers are left untagged so that integers too large for YAML integers are not explicitly tagged.
+		n := v.AsBigFloat()
+		switch {
+		case n.IsInf() && n.Sign() > 0:
+			return &yaml.Node{Kind: yaml.ScalarNode, Value: ".inf"}, nil
+		case n.IsInf():
+			return &yaml.Node{Kind: yaml.ScalarNode, Value: "-.inf"}, nil
+		case n.IsInt():
+			return &yaml.Node{Kind: yaml.ScalarNode, Value: n.Text('f', 0)}, nil
+		default:
+			return &yaml.Node{Kind: yaml.ScalarNode, Value: n.Text('g', -1)}, nil
+		}
+
+	case t.IsListType() || t.IsSetType() || t.IsTupleType():
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for it := v.ElementIterator(); it.Next(); {
+			_, e := it.Element()
+			child, err := ctyValueToYAMLNode(e)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, child)
+		}
+		return node, nil
+
+	case t.IsMapType() || t.IsObjectType():
+		node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for it := v.ElementIterator(); it.Next(); {
+			k, e := it.Element()
+			child, err := ctyValueToYAMLNode(e)
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k.AsString()}, child)
+		}
+		return node, nil
+
+	default:
+		return nil, errors.New(fmt.Sprintf("unsupported value of type %s", t.FriendlyName()))
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 Morébec
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package specterutils_test
+
+import (
+	"github.com/morebec/specter/pkg/specter"
+	"github.com/morebec/specter/pkg/specterutils"
+	"github.com/morebec/specter/pkg/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/zclconf/go-cty/cty"
+	"testing"
+)
+
+var _ specter.UnitLoader = (*specterutils.YAMLGenericUnitLoader)(nil)
+
+func TestYAMLGenericUnitLoader_SupportsSource(t *testing.T) {
+	tests := []struct {
+		name  string
+		given specter.Source
+		then  bool
+	}{
+		{
+			name:  "GIVEN a YAML source THEN return true",
+			given: specter.Source{Format: specterutils.YAMLSourceFormat},
+			then:  true,
+		},
+		{
+			name:  "GIVEN a non YAML source THEN return false",
+			given: specter.Source{Format: specterutils.HCLSourceFormat},
+			then:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := specterutils.NewYAMLGenericUnitLoader()
+			assert.Equal(t, tt.then, l.SupportsSource(tt.given))
+		})
+	}
+}
+
+func TestYAMLGenericUnitLoader_Load(t *testing.T) {
+	newSource := func(data string) specter.Source {
+		return specter.Source{
+			Location: "specs.yaml",
+			Data:     []byte(data),
+			Format:   specterutils.YAMLSourceFormat,
+		}
+	}
+
+	tests := []struct {
+		name          string
+		given         specter.Source
+		then          func(s specter.Source) []specter.Unit
+		thenError     require.ErrorAssertionFunc
+		thenErrorText string
+	}{
+		{
+			name:      "GIVEN an empty source THEN return nil",
+			given:     newSource(``),
+			then:      func(specter.Source) []specter.Unit { return nil },
+			thenError: require.NoError,
+		},
+		{
+			name:      "GIVEN an unsupported format THEN return an error",
+			given:     specter.Source{Format: "txt"},
+			thenError: testutils.RequireErrorWithCode(specter.UnsupportedSourceErrorCode),
+		},
+		{
+			name: "GIVEN a valid source THEN return its unit",
+			given: newSource(`
+kind: service
+id: billing
+description: Handles invoices.
+replicas: 3
+ratio: 0.5
+enabled: true
+owner: ~
+ports: [80, 443]
+env:
+  LOG_LEVEL: debug
+`),
+			then: func(s specter.Source) []specter.Unit {
+				u := specterutils.NewGenericUnit("billing", "service", s)
+				u.Attributes = []specterutils.GenericUnitAttribute{
+					{Name: "description", Value: specterutils.GenericValue{Value: cty.StringVal("Handles invoices.")}},
+					{Name: "replicas", Value: specterutils.GenericValue{Value: cty.NumberIntVal(3)}},
+					{Name: "ratio", Value: specterutils.GenericValue{Value: cty.MustParseNumberVal("0.5")}},
+					{Name: "enabled", Value: specterutils.GenericValue{Value: cty.True}},
+					{Name: "owner", Value: specterutils.GenericValue{Value: cty.NullVal(cty.DynamicPseudoType)}},
+					{Name: "ports", Value: specterutils.GenericValue{Value: cty.TupleVal([]cty.Value{
+						cty.NumberIntVal(80),
+						cty.NumberIntVal(443),
+					})}},
+					{Name: "env", Value: specterutils.GenericValue{Value: cty.ObjectVal(map[string]cty.Value{
+						"LOG_LEVEL": cty.StringVal("debug"),
+					})}},
+				}
+				return []specter.Unit{u}
+			},
+			thenError: require.NoError,
+		},
+		{
+			name: "GIVEN a multi-document source THEN return a unit per document",
+			given: newSource(`
+kind: service
+id: billing
+---
+kind: service
+id: shipping
+---
+`),
+			then: func(s specter.Source) []specter.Unit {
+				return []specter.Unit{
+					specterutils.NewGenericUnit("billing", "service", s),
+					specterutils.NewGenericUnit("shipping", "service", s),
+				}
+			},
+			thenError: require.NoError,
+		},
+		{
+			name: "GIVEN anchors and merge keys THEN resolve them",
+			given: newSource(`
+kind: service
+id: billing
+defaults: &defaults
+  replicas: 1
+  region: ca
+config:
+  <<: *defaults
+  replicas: 2
+`),
+			then: func(s specter.Source) []specter.Unit {
+				u := specterutils.NewGenericUnit("billing", "service", s)
+				u.Attributes = []specterutils.GenericUnitAttribute{
+					{Name: "defaults", Value: specterutils.GenericValue{Value: cty.ObjectVal(map[string]cty.Value{
+						"replicas": cty.NumberIntVal(1),
+						"region":   cty.StringVal("ca"),
+					})}},
+					{Name: "config", Value: specterutils.GenericValue{Value: cty.ObjectVal(map[string]cty.Value{
+						"replicas": cty.NumberIntVal(2),
+						"region":   cty.StringVal("ca"),
+					})}},
+				}
+				return []specter.Unit{u}
+			},
+			thenError: require.NoError,
+		},
+		{
+			name:          "GIVEN an unparsable source THEN return an error",
+			given:         newSource("kind: service\nid: [billing\n"),
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidYAMLErrorCode),
+			thenErrorText: "line 1",
+		},
+		{
+			name:          "GIVEN a document without kind THEN return an error with its position",
+			given:         newSource("---\nid: billing\n"),
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidYAMLErrorCode),
+			thenErrorText: `invalid unit source "specs.yaml" at line 2:1, document should contain a "kind" key`,
+		},
+		{
+			name:          "GIVEN a document without id THEN return an error with its position",
+			given:         newSource("kind: service\nreplicas: 1\n"),
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidYAMLErrorCode),
+			thenErrorText: `at line 1:1, document of kind "service" should contain an "id" key`,
+		},
+		{
+			name:          "GIVEN a document that is not a mapping THEN return an error",
+			given:         newSource("- kind: service\n"),
+			thenError:     testutils.RequireErrorWithCode(specterutils.InvalidYAMLErrorCode),
+			thenErrorText: "document should be a mapping",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := specterutils.NewYAMLGenericUnitLoader()
+			units, err := l.Load(tt.given)
+			tt.thenError(t, err)
+			if tt.thenErrorText != "" {
+				assert.ErrorContains(t, err, tt.thenErrorText)
+			}
+			if tt.then != nil {
+				assert.Equal(t, tt.then(tt.given), withoutSourceRanges(units))
+			}
+		})
+	}
+}